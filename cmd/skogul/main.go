@@ -34,10 +34,13 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"plugin"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/telenornms/skogul"
@@ -64,6 +67,7 @@ var floglevel = flag.String("loglevel", "warn", "Minimum loglevel to display ([e
 var ftimestamp = flag.Bool("timestamp", true, "Include timestamp in log entries")
 var fversion = flag.Bool("version", false, "Print skogul version")
 var fprofile = flag.String("pprof", "", "Enable profiling over HTTP, value is http endpoint, e.g: localhost:6060")
+var fshutdown = flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for receivers to stop and senders to flush data upon SIGTERM/SIGINT before giving up. 0 means wait indefinitely.")
 var fplugins = flag.String("experimental-plugins", "", "Comma-separated list of .so files to load as plugins. This is completely unsupported tech preview to get experience with it.")
 
 // Console width :D
@@ -187,12 +191,20 @@ func main() {
 		}()
 	}
 	log.Info("Starting skogul")
+	if err := c.Open(); err != nil {
+		log.WithError(err).Fatal("Failed to start Skogul")
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	var exitInt = 0
 	var wg sync.WaitGroup
-	for name, r := range c.Receivers {
+	start := func(name string, r *config.Receiver) {
 		wg.Add(1)
-		go func(name string, r *config.Receiver) {
+		go func() {
 			if inerr := r.Receiver.Start(); inerr != nil {
 				exitInt = 1
 				fmt.Printf("Receiver \"%s\" failed: %v\n", name, inerr)
@@ -200,24 +212,61 @@ func main() {
 				fmt.Printf("Receiver \"%s\" returned successfully.\n", name)
 			}
 			wg.Done()
-		}(name, r)
+		}()
+	}
+	for name, r := range c.Receivers {
+		start(name, r)
 	}
 
-	go startStats(c)
+	var current atomic.Pointer[config.Config]
+	current.Store(c)
+	go startStats(&current)
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
 
-	wg.Wait()
-	os.Exit(exitInt)
+	for {
+		select {
+		case <-finished:
+			os.Exit(exitInt)
+		case <-hup:
+			log.WithField("path", configPath).Info("Reloading configuration")
+			// Receivers might be stopped before their replacements
+			// are started, don't let that look like we're done.
+			wg.Add(1)
+			newc, err := config.Reload(configPath, current.Load(), start)
+			wg.Done()
+			if err != nil {
+				log.WithError(err).Error("Reloading configuration failed, keeping the old configuration")
+				continue
+			}
+			current.Store(newc)
+			log.Info("Configuration reloaded")
+		case sig := <-sigs:
+			log.WithField("signal", sig).Infof("Shutting down, waiting up to %v for data to drain", *fshutdown)
+			if err := current.Load().Shutdown(*fshutdown); err != nil {
+				log.WithError(err).Error("Graceful shutdown failed")
+				exitInt = 1
+			}
+			os.Exit(exitInt)
+		}
+	}
 }
 
 // startStats starts a forever-running loop which fetches
-// stats from each module at the configured interval.
-func startStats(c *config.Config) {
+// stats from each module at the configured interval, using the current
+// configuration.
+func startStats(current *atomic.Pointer[config.Config]) {
 	statsLogger := skogul.Logger("main", "stats")
 
 	ticker := time.NewTicker(stats.DefaultInterval)
 
 	for range ticker.C {
 		statsLogger.Trace("Gathering stats")
+		c := current.Load()
 		for _, r := range c.Receivers {
 			stats.Collect(r.Receiver)
 		}
@@ -230,5 +279,10 @@ func startStats(c *config.Config) {
 		for _, s := range c.Senders {
 			stats.Collect(s.Sender)
 		}
+		for _, h := range c.Handlers {
+			if t := h.Handler.Tenants(); t != nil {
+				stats.Collect(t)
+			}
+		}
 	}
 }
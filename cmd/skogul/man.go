@@ -357,6 +357,35 @@ metric remains, the sender will be called. Under normal log levels, this
 filtering is suppressed as long as at least 1 metric is valid, but debug
 logging will reveal it.
 
+Tenancy
+-------
+
+If "tenancy" is set, every metric belongs to a tenant, stored in a metadata
+field ("tenant" by default). The tenant is assigned by the receiver, e.g.
+by the HTTP receiver based on the token used (see "tenanttokens" and
+"tenants" of the HTTP receiver), and is set again after the transformers
+have run, so it can't be changed by them or by clients. The switch sender
+can then route metrics by tenant. Each tenant can be given a quota, in
+metrics per second::
+
+  "handlers": {
+	  "foo": {
+		  "parser": "json",
+		  "tenancy": {
+			  "default": "shared",
+			  "quotas": {
+				  "*": { "rate": 1000, "burst": 5000 }
+			  }
+		  },
+		  "sender": "per_tenant"
+	  }
+  }
+
+The options are "field", "frommetadata" (trust the tenant sent by the
+client when the receiver doesn't assign one), "default", "tenants" (the
+tenants to accept) and "quotas". Per-tenant statistics are included in
+the skogul stats.
+
 JSON parsing
 ------------
 
@@ -86,6 +86,8 @@ used in the future.
 IngorePartialFailures should probably be renamed to removeinvalidmetrics or
 something like that, as that's closer to what it does.
 
+If Tenancy is set, every metric is assigned to a tenant, see Tenancy.
+
 To make it configurable, a HandlerRef should be used.
 */
 type Handler struct {
@@ -93,6 +95,8 @@ type Handler struct {
 	Transformers          []Transformer
 	Sender                Sender
 	IgnorePartialFailures bool
+	Tenancy               *Tenancy
+	tenancy               *Tenancy // Used by TransformAndSendAs if Tenancy isn't set, see Tenants()
 }
 
 // Parser is the interface for parsing arbitrary data into a Container
@@ -100,6 +104,34 @@ type Parser interface {
 	Parse(data []byte) (*Container, error)
 }
 
+/*
+SourceParser is an *optional* interface for parsers that need to know
+where data came from, e.g. to keep state per exporter, as flow protocols
+with templates require. Receivers that know the address of the sender use
+Handler.ParseFrom(), which calls ParseFrom() if the parser implements it,
+and Parse() otherwise. The source is typically the IP address of the
+sender.
+
+Since a SourceParser keeps state, ParseFrom() may return a container
+without metrics if the data only updated that state, e.g. a packet with
+only flow templates. Receivers drop such containers without error.
+*/
+type SourceParser interface {
+	ParseFrom(data []byte, source string) (*Container, error)
+}
+
+/*
+CompressedParser is an *optional* interface for parsers of formats that
+are always compressed, and that decompress the data themselves, so they
+can limit the size. Compression() returns the compression, named as in
+the HTTP Content-Encoding header, e.g. "snappy". Receivers that decompress
+data, e.g. according to Content-Encoding, leave that compression to the
+parser.
+*/
+type CompressedParser interface {
+	Compression() string
+}
+
 /*
 Sender accepts data through Send() - and "sends it off". The canonical
 sender is one that implements a storage backend or outgoing API. E.g.:
@@ -157,6 +189,58 @@ type Verifier interface {
 	Verify() error
 }
 
+/*
+Stopper is an *optional* interface for receivers. If implemented, Stop()
+is called when Skogul is shutting down. The receiver should stop accepting
+new data, finish handling the data it has already accepted, and then let
+Start() return. Stop() should not return until this is done.
+
+Senders can implement Stopper too, if they hold on to resources that must
+be released before a replacement can be set up, such as a listening
+socket. For senders, Stop() is called after Flush(), when Skogul is
+shutting down or the sender is replaced by a configuration reload.
+*/
+type Stopper interface {
+	Stop() error
+}
+
+/*
+Opener is an *optional* interface for senders that need to set up
+resources independently of the data sent to them, such as a listening
+socket to serve data on. If implemented, Open() is called once the
+configuration is taken into use, after the senders it replaces are
+stopped, so it can reuse their resources. Open() must not block.
+*/
+type Opener interface {
+	Open() error
+}
+
+/*
+Reloader is an *optional* interface for receivers. If implemented, it is
+used when the configuration is reloaded and the receiver's configuration -
+or the handler it uses - has changed. Reload() is called on the running
+receiver with the newly configured receiver of the same name. If the
+running receiver is able to adopt the new configuration without
+restarting, e.g. keeping its listening socket, it should do so and return
+nil. Otherwise it should return an error without changing anything, and
+the running receiver will be stopped and replaced instead.
+*/
+type Reloader interface {
+	Reload(r Receiver) error
+}
+
+/*
+Flusher is an *optional* interface for senders that hold on to data
+before passing it on, e.g. the batch sender. If implemented, Flush() is
+called when Skogul is shutting down, after all receivers are stopped. The
+sender should pass on everything it has accepted so far, and should not
+return until the next sender(s) have been issued Send(). Flush() must be
+safe to call even if Send() has never been called.
+*/
+type Flusher interface {
+	Flush() error
+}
+
 /*
 Deprecated is an optional interface for modules which, if implemented,
 allows modules to signal deprecated usage. If implemented, the Deprecated()
@@ -240,6 +324,36 @@ func (h *Handler) Parse(b []byte) (*Container, error) {
 	return c, nil
 }
 
+// ParseFrom parses the bytes into a Container, passing the source of the
+// data on to the parser if it is a SourceParser.
+func (h *Handler) ParseFrom(b []byte, source string) (*Container, error) {
+	sp, ok := h.parser.(SourceParser)
+	if !ok {
+		return h.Parse(b)
+	}
+	c, err := sp.ParseFrom(b, source)
+	if err != nil {
+		return nil, fmt.Errorf("parsing failed: %w", err)
+	}
+	return c, nil
+}
+
+// HasSourceParser returns true if the parser of the handler is a
+// SourceParser.
+func (h *Handler) HasSourceParser() bool {
+	_, ok := h.parser.(SourceParser)
+	return ok
+}
+
+// Compression returns the compression the parser of the handler
+// decompresses itself, if it is a CompressedParser, or "".
+func (h *Handler) Compression() string {
+	if cp, ok := h.parser.(CompressedParser); ok {
+		return cp.Compression()
+	}
+	return ""
+}
+
 // Transform runs all available transformers
 func (h *Handler) Transform(c *Container) error {
 	for _, t := range h.Transformers {
@@ -277,6 +391,9 @@ func (h *Handler) Handle(b []byte) error {
 // TransformAndSend transforms the already parsed container and sends the
 // data off.
 func (h *Handler) TransformAndSend(c *Container) error {
+	if h.Tenancy != nil {
+		return h.Tenancy.transformAndSend(h, c, "")
+	}
 	if err := h.Transform(c); err != nil {
 		return fmt.Errorf("transforming metrics failed: %w", err)
 	}
@@ -286,6 +403,37 @@ func (h *Handler) TransformAndSend(c *Container) error {
 	return nil
 }
 
+// TransformAndSendAs is TransformAndSend for receivers that know which
+// tenant the data belongs to, e.g. based on the credentials used. The
+// tenant is enforced even if the handler has no Tenancy set.
+func (h *Handler) TransformAndSendAs(c *Container, tenant string) error {
+	if tenant == "" {
+		return h.TransformAndSend(c)
+	}
+	t := h.Tenancy
+	if t == nil {
+		tenancyLock.Lock()
+		if h.tenancy == nil {
+			h.tenancy = &Tenancy{handler: h}
+		}
+		t = h.tenancy
+		tenancyLock.Unlock()
+	}
+	return t.transformAndSend(h, c, tenant)
+}
+
+// Tenants returns the Tenancy of the handler, or, if it isn't set, the
+// one keeping the stats of the tenants assigned by receivers through
+// TransformAndSendAs. Returns nil if there is neither.
+func (h *Handler) Tenants() *Tenancy {
+	if h.Tenancy != nil {
+		return h.Tenancy
+	}
+	tenancyLock.Lock()
+	defer tenancyLock.Unlock()
+	return h.tenancy
+}
+
 // Verify the basic integrity of a handler. Quite shallow.
 func (h Handler) Verify() error {
 	if h.parser == nil {
@@ -299,6 +447,11 @@ func (h Handler) Verify() error {
 	if h.Sender == nil {
 		return fmt.Errorf("missing sender")
 	}
+	if h.Tenancy != nil {
+		if err := h.Tenancy.Verify(); err != nil {
+			return fmt.Errorf("invalid tenancy: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -51,24 +51,28 @@ var confLog = skogul.Logger("core", "config")
 type Sender struct {
 	Type   string
 	Sender skogul.Sender `json:"-"`
+	raw    []byte        // canonical JSON of the configuration, used to detect changes on reload
 }
 
 // Parser wraps the skogul.Parser for configuration parsing.
 type Parser struct {
 	Type   string
 	Parser skogul.Parser `json:"-"`
+	raw    []byte        // canonical JSON of the configuration, used to detect changes on reload
 }
 
 // Receiver wraps the skogul.Receiver for configuration parsing.
 type Receiver struct {
 	Type     string
 	Receiver skogul.Receiver `json:"-"`
+	raw      []byte          // canonical JSON of the configuration, used to detect changes on reload
 }
 
 // Encoder wraps the skogul.Encoder module-type for configuration parsing.
 type Encoder struct {
 	Type    string
 	Encoder skogul.Encoder `json:"-"`
+	raw     []byte         // canonical JSON of the configuration, used to detect changes on reload
 }
 
 // Handler wraps skogul.Handler for configuration parsing.
@@ -77,13 +81,16 @@ type Handler struct {
 	Transformers          []*skogul.TransformerRef
 	Sender                skogul.SenderRef
 	IgnorePartialFailures bool
-	Handler               skogul.Handler `json:"-"`
+	Tenancy               *skogul.Tenancy `json:",omitempty"`
+	Handler               skogul.Handler  `json:"-"`
+	resolved              bool            // set when Handler is set up, to avoid touching handlers in use
 }
 
 // Transformer wraps skogul.Transformer
 type Transformer struct {
 	Type        string
 	Transformer skogul.Transformer `json:"-"`
+	raw         []byte             // canonical JSON of the configuration, used to detect changes on reload
 }
 
 // Config encapsulates all configuration for Skogul, and represent the
@@ -95,6 +102,8 @@ type Config struct {
 	Parsers      map[string]*Parser
 	Encoders     map[string]*Encoder
 	Transformers map[string]*Transformer
+	identity     map[interface{}]string // Configured name of each module, see skogul.IdentityOf
+	reused       map[interface{}]bool   // Modules kept running from the previous configuration by Reload, already verified
 }
 
 // UnmarshalJSON picks up the type of the Receiver, instantiates a copy of
@@ -124,6 +133,7 @@ func (t *Transformer) UnmarshalJSON(b []byte) error {
 	// Find superfluous config parameters
 	var jsonConf map[string]interface{}
 	json.Unmarshal(b, &jsonConf) // Assuming this works out well since it did up there ^
+	t.raw, _ = json.Marshal(jsonConf)
 	VerifyOnlyRequiredConfigProps(&jsonConf, "transformer", t.Type, reflect.ValueOf(t.Transformer).Elem().Type())
 	return nil
 }
@@ -172,6 +182,7 @@ func (r *Receiver) UnmarshalJSON(b []byte) error {
 	// Find superfluous config parameters
 	var jsonConf map[string]interface{}
 	json.Unmarshal(b, &jsonConf) // Assuming this works out well since it did up there ^
+	r.raw, _ = json.Marshal(jsonConf)
 	VerifyOnlyRequiredConfigProps(&jsonConf, "receiver", r.Type, reflect.ValueOf(r.Receiver).Elem().Type())
 	return nil
 }
@@ -216,6 +227,7 @@ func (p *Parser) UnmarshalJSON(b []byte) error {
 	// Find superfluous config parameters
 	var jsonConf map[string]interface{}
 	json.Unmarshal(b, &jsonConf) // Assuming this works out well since it did up there ^
+	p.raw, _ = json.Marshal(jsonConf)
 	VerifyOnlyRequiredConfigProps(&jsonConf, "parser", p.Type, reflect.ValueOf(p.Parser).Elem().Type())
 	return nil
 }
@@ -260,6 +272,7 @@ func (e *Encoder) UnmarshalJSON(b []byte) error {
 	// Find superfluous config parameters
 	var jsonConf map[string]interface{}
 	json.Unmarshal(b, &jsonConf) // Assuming this works out well since it did up there ^
+	e.raw, _ = json.Marshal(jsonConf)
 	VerifyOnlyRequiredConfigProps(&jsonConf, "encoder", e.Type, reflect.ValueOf(e.Encoder).Elem().Type())
 	return nil
 }
@@ -304,6 +317,7 @@ func (s *Sender) UnmarshalJSON(b []byte) error {
 	// Find superfluous config parameters
 	var jsonConf map[string]interface{}
 	json.Unmarshal(b, &jsonConf) // Assuming this works out well since it did up there ^
+	s.raw, _ = json.Marshal(jsonConf)
 	VerifyOnlyRequiredConfigProps(&jsonConf, "sender", s.Type, reflect.ValueOf(s.Sender).Elem().Type())
 	return nil
 }
@@ -366,12 +380,28 @@ func printSyntaxError(b []byte, offset int, text string) {
 // globally (unfortunately...), then calling secondPass(), which resolves
 // references and does a final validation.
 func Bytes(b []byte) (*Config, error) {
-	var jsonData map[string]interface{}
+	c, err := firstPassBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return secondPass(c)
+}
+
+// resetMaps clears the global reference maps before a new configuration
+// is parsed.
+func resetMaps() {
 	skogul.HandlerMap = skogul.HandlerMap[0:0]
 	skogul.SenderMap = skogul.SenderMap[0:0]
 	skogul.ParserMap = skogul.ParserMap[0:0]
 	skogul.TransformerMap = skogul.TransformerMap[0:0]
 	skogul.EncoderMap = skogul.EncoderMap[0:0]
+}
+
+// firstPassBytes does the JSON unmarshalling of Bytes(), without
+// resolving references.
+func firstPassBytes(b []byte) (*Config, error) {
+	var jsonData map[string]interface{}
+	resetMaps()
 	if err := json.Unmarshal(b, &jsonData); err != nil {
 		jerr, ok := err.(*json.SyntaxError)
 		if ok {
@@ -384,22 +414,35 @@ func Bytes(b []byte) (*Config, error) {
 	if err := json.Unmarshal(b, &c); err != nil {
 		return nil, fmt.Errorf("valid JSON, but not valid Skogul configuration: %w", err)
 	}
-
-	return secondPass(&c)
+	return &c, nil
 }
 
 // Path opens a path (file or directory) and parses the configuration.
 func Path(path string) (*Config, error) {
+	c, err := firstPassPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return secondPass(c)
+}
+
+// firstPassPath reads a path (file or directory) and does the JSON
+// unmarshalling, without resolving references.
+func firstPassPath(path string) (*Config, error) {
 	stat, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read configuration path: %w", err)
 	}
 
 	if stat.IsDir() {
-		return ReadFiles(path)
+		return firstPassFiles(path)
 	}
 
-	return File(path)
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	return firstPassBytes(dat)
 }
 
 // File opens a config file and parses it, then returns the valid
@@ -432,12 +475,23 @@ func findConfigFiles(path string) ([]string, error) {
 // ReadFiles reads all JSON files (with the .JSON suffix) in a given directory
 // and combines them to a configuration for the program.
 func ReadFiles(p string) (*Config, error) {
+	c, err := firstPassFiles(p)
+	if err != nil {
+		return nil, err
+	}
+	return secondPass(c)
+}
+
+// firstPassFiles does the JSON unmarshalling of ReadFiles(), without
+// resolving references.
+func firstPassFiles(p string) (*Config, error) {
 	files, err := findConfigFiles(p)
 
 	if err != nil {
 		return nil, err
 	}
 
+	resetMaps()
 	config := Config{}
 
 	for _, f := range files {
@@ -458,7 +512,7 @@ func ReadFiles(p string) (*Config, error) {
 		}
 	}
 
-	return secondPass(&config)
+	return &config, nil
 }
 
 // resolveSenders iterates over the skogul.SenderMap and resolves senders,
@@ -485,7 +539,7 @@ func resolveSenders(c *Config) error {
 		if c.Senders[s.Name] == nil {
 			return fmt.Errorf("sender `%s' referenced but not defined", s.Name)
 		}
-		skogul.Identity[c.Senders[s.Name].Sender] = s.Name
+		c.identity[c.Senders[s.Name].Sender] = s.Name
 		s.S = c.Senders[s.Name].Sender
 	}
 	skogul.SenderMap = skogul.SenderMap[0:0]
@@ -515,7 +569,7 @@ func resolveParsers(c *Config) error {
 		if c.Parsers[p.Name] == nil {
 			return fmt.Errorf("parser `%s' referenced but not defined", p.Name)
 		}
-		skogul.Identity[c.Parsers[p.Name].Parser] = p.Name
+		c.identity[c.Parsers[p.Name].Parser] = p.Name
 		p.P = c.Parsers[p.Name].Parser
 	}
 	skogul.ParserMap = skogul.ParserMap[0:0]
@@ -545,7 +599,7 @@ func resolveEncoders(c *Config) error {
 		if c.Encoders[e.Name] == nil {
 			return fmt.Errorf("encoder `%s' referenced but not defined", e.Name)
 		}
-		skogul.Identity[c.Encoders[e.Name].Encoder] = e.Name
+		c.identity[c.Encoders[e.Name].Encoder] = e.Name
 		e.E = c.Encoders[e.Name].Encoder
 	}
 	skogul.EncoderMap = skogul.EncoderMap[0:0]
@@ -559,12 +613,20 @@ func resolveEncoders(c *Config) error {
 //
 // It then zeroes the skogul.HandlerMap
 func resolveHandlers(c *Config) error {
-	for _, h := range c.Handlers {
+	for name, h := range c.Handlers {
+		if h.Tenancy != nil {
+			c.identity[h.Tenancy] = name
+		}
+		c.identity[&h.Handler] = name
+		if h.resolved {
+			continue
+		}
 		logger := confLog.WithField("parser", h.Parser)
 
 		h.Handler.Sender = h.Sender.S
 		h.Handler.Transformers = make([]skogul.Transformer, 0)
 		h.Handler.IgnorePartialFailures = h.IgnorePartialFailures
+		h.Handler.Tenancy = h.Tenancy
 		h.Handler.SetParser(h.Parser.P)
 
 		for _, t := range h.Transformers {
@@ -573,6 +635,7 @@ func resolveHandlers(c *Config) error {
 			skogul.Assert(t.T != nil)
 			h.Handler.Transformers = append(h.Handler.Transformers, t.T)
 		}
+		h.resolved = true
 	}
 	for _, h := range skogul.HandlerMap {
 		if c.Handlers[h.Name] == nil {
@@ -612,7 +675,7 @@ func resolveTransformers(c *Config) error {
 			return fmt.Errorf("transformer `%s' referenced but not defined", t.Name)
 		}
 		skogul.Assert(c.Transformers[t.Name].Transformer != nil)
-		skogul.Identity[c.Transformers[t.Name].Transformer] = t.Name
+		c.identity[c.Transformers[t.Name].Transformer] = t.Name
 		t.T = c.Transformers[t.Name].Transformer
 	}
 	skogul.TransformerMap = skogul.TransformerMap[0:0]
@@ -626,14 +689,25 @@ func resolveTransformers(c *Config) error {
 // over them.
 func identifyReceivers(c *Config) {
 	for idx, name := range c.Receivers {
-		skogul.Identity[name.Receiver] = idx
+		c.identity[name.Receiver] = idx
 	}
 }
 
 // secondPass accepts a parsed configuration as input and resolves the
-// references in it, and verifies basic integrity.
+// references in it, verifies basic integrity, and publishes the names of
+// the modules for skogul.IdentityOf.
 func secondPass(c *Config) (*Config, error) {
-	skogul.Identity = make(map[interface{}]string)
+	if _, err := resolve(c); err != nil {
+		return nil, err
+	}
+	skogul.SetIdentity(c.identity)
+	return c, nil
+}
+
+// resolve is secondPass, without publishing the names of the modules, so
+// the configuration can be adjusted first.
+func resolve(c *Config) (*Config, error) {
+	c.identity = make(map[interface{}]string)
 	identifyReceivers(c)
 	if err := resolveSenders(c); err != nil {
 		return nil, err
@@ -652,12 +726,18 @@ func secondPass(c *Config) (*Config, error) {
 	}
 
 	for idx, h := range c.Handlers {
+		if c.reused[h] {
+			continue
+		}
 		confLog.WithField("handler", idx).Debug("Verifying handler configuration")
 		if err := verifyItem("handler", idx, h.Handler); err != nil {
 			return nil, err
 		}
 	}
 	for idx, t := range c.Transformers {
+		if c.reused[t] {
+			continue
+		}
 		confLog.WithField("transformer", idx).Debug("Verifying transformer configuration")
 		if err := verifyItem("transformer", idx, t.Transformer); err != nil {
 			return nil, err
@@ -665,6 +745,9 @@ func secondPass(c *Config) (*Config, error) {
 		deprecateCheck("transformer", idx, t.Transformer)
 	}
 	for idx, s := range c.Senders {
+		if c.reused[s] {
+			continue
+		}
 		confLog.WithField("sender", idx).Debug("Verifying sender configuration")
 		if err := verifyItem("sender", idx, s.Sender); err != nil {
 			return nil, err
@@ -679,6 +762,9 @@ func secondPass(c *Config) (*Config, error) {
 		deprecateCheck("receiver", idx, r.Receiver)
 	}
 	for idx, e := range c.Encoders {
+		if c.reused[e] {
+			continue
+		}
 		confLog.WithField("encoders", idx).Debug("Verifying encoder configuration")
 		if err := verifyItem("encoder", idx, e.Encoder); err != nil {
 			return nil, err
@@ -686,6 +772,9 @@ func secondPass(c *Config) (*Config, error) {
 		deprecateCheck("encoder", idx, e.Encoder)
 	}
 	for idx, p := range c.Parsers {
+		if c.reused[p] {
+			continue
+		}
 		confLog.WithField("parsers", idx).Debug("Verifying parser configuration")
 		if err := verifyItem("parser", idx, p.Parser); err != nil {
 			return nil, err
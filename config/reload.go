@@ -0,0 +1,364 @@
+/*
+ * skogul, configuration reload
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/telenornms/skogul"
+)
+
+// reuser figures out which modules of a freshly parsed configuration are
+// identical to the ones already running, so the running instances can be
+// kept. A module can only be kept if its own configuration is unchanged
+// AND every module it references can be kept, since the running instance
+// has its references resolved to the old modules.
+type reuser struct {
+	old  *Config
+	c    *Config
+	memo map[reference]bool
+}
+
+// lookup returns the canonical configuration and the configuration
+// structure of a module. raw is nil for modules that are automatically
+// created because they are referenced without being defined.
+func lookup(c *Config, ref reference) (raw []byte, module interface{}, found bool) {
+	switch ref.family {
+	case "sender":
+		if m := c.Senders[ref.name]; m != nil {
+			return m.raw, m.Sender, true
+		}
+	case "transformer":
+		if m := c.Transformers[ref.name]; m != nil {
+			return m.raw, m.Transformer, true
+		}
+	case "parser":
+		if m := c.Parsers[ref.name]; m != nil {
+			return m.raw, m.Parser, true
+		}
+	case "encoder":
+		if m := c.Encoders[ref.name]; m != nil {
+			return m.raw, m.Encoder, true
+		}
+	case "handler":
+		if m := c.Handlers[ref.name]; m != nil {
+			raw, err := json.Marshal(m)
+			skogul.Assert(err == nil, err)
+			return raw, m, true
+		}
+	}
+	return nil, nil, false
+}
+
+// reusable returns true if the running instance of the referenced module
+// can be used in the new configuration.
+func (ru *reuser) reusable(ref reference) bool {
+	if v, ok := ru.memo[ref]; ok {
+		return v
+	}
+	// Assume the best to break reference loops.
+	ru.memo[ref] = true
+	oldRaw, oldModule, oldFound := lookup(ru.old, ref)
+	newRaw, newModule, newFound := lookup(ru.c, ref)
+	v := oldFound
+	if v && newFound {
+		v = oldRaw != nil && bytes.Equal(oldRaw, newRaw)
+	} else if v {
+		// Implicitly created in the new configuration, so the old
+		// one has to be implicit as well.
+		v = oldRaw == nil
+		newModule = oldModule
+	}
+	if v {
+		for _, dep := range findRefs(reflect.ValueOf(newModule), nil) {
+			if !ru.reusable(dep) {
+				v = false
+				break
+			}
+		}
+	}
+	ru.memo[ref] = v
+	return v
+}
+
+// reusableReceiver returns true if the running receiver can be kept as is.
+func (ru *reuser) reusableReceiver(name string) bool {
+	o, n := ru.old.Receivers[name], ru.c.Receivers[name]
+	if o == nil || n == nil || !bytes.Equal(o.raw, n.raw) {
+		return false
+	}
+	for _, dep := range findRefs(reflect.ValueOf(n.Receiver), nil) {
+		if !ru.reusable(dep) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply replaces all reusable modules in the new configuration with the
+// running instances. This has to happen before references are resolved.
+func (ru *reuser) apply() {
+	check := func(family, name string) {
+		ru.reusable(reference{family, name})
+	}
+	for name := range ru.c.Senders {
+		check("sender", name)
+	}
+	for name := range ru.c.Transformers {
+		check("transformer", name)
+	}
+	for name := range ru.c.Parsers {
+		check("parser", name)
+	}
+	for name := range ru.c.Encoders {
+		check("encoder", name)
+	}
+	for name := range ru.c.Handlers {
+		check("handler", name)
+	}
+	for name := range ru.c.Receivers {
+		ru.reusableReceiver(name)
+	}
+	ru.c.reused = make(map[interface{}]bool)
+	for ref, ok := range ru.memo {
+		if !ok {
+			continue
+		}
+		switch ref.family {
+		case "sender":
+			if ru.c.Senders == nil {
+				ru.c.Senders = make(map[string]*Sender)
+			}
+			ru.c.Senders[ref.name] = ru.old.Senders[ref.name]
+			ru.c.reused[ru.c.Senders[ref.name]] = true
+		case "transformer":
+			if ru.c.Transformers == nil {
+				ru.c.Transformers = make(map[string]*Transformer)
+			}
+			ru.c.Transformers[ref.name] = ru.old.Transformers[ref.name]
+			ru.c.reused[ru.c.Transformers[ref.name]] = true
+		case "parser":
+			if ru.c.Parsers == nil {
+				ru.c.Parsers = make(map[string]*Parser)
+			}
+			ru.c.Parsers[ref.name] = ru.old.Parsers[ref.name]
+			ru.c.reused[ru.c.Parsers[ref.name]] = true
+		case "encoder":
+			if ru.c.Encoders == nil {
+				ru.c.Encoders = make(map[string]*Encoder)
+			}
+			ru.c.Encoders[ref.name] = ru.old.Encoders[ref.name]
+			ru.c.reused[ru.c.Encoders[ref.name]] = true
+		case "handler":
+			ru.c.Handlers[ref.name] = ru.old.Handlers[ref.name]
+			ru.c.reused[ru.c.Handlers[ref.name]] = true
+		}
+		confLog.WithField(ref.family, ref.name).Debug("Configuration unchanged, keeping running instance")
+	}
+}
+
+// reachable returns the names of the senders that can be reached from
+// module, through references to other modules in c.
+func reachable(c *Config, module interface{}) map[string]bool {
+	senders := make(map[string]bool)
+	visited := make(map[reference]bool)
+	var visit func(module interface{})
+	visit = func(module interface{}) {
+		for _, ref := range findRefs(reflect.ValueOf(module), nil) {
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+			_, m, found := lookup(c, ref)
+			if !found {
+				continue
+			}
+			if ref.family == "sender" {
+				senders[ref.name] = true
+			}
+			visit(m)
+		}
+	}
+	visit(module)
+	return senders
+}
+
+// checkStuck returns an error if a receiver that has to be changed or
+// removed can't be stopped, and would be left running with senders that
+// are replaced, and thus flushed and stopped. Such receivers would lose
+// all data after the reload. Receivers that implement skogul.Reloader
+// are included, since they are stopped if the reload fails.
+func (ru *reuser) checkStuck() error {
+	names := make([]string, 0, len(ru.old.Receivers))
+	for name := range ru.old.Receivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := ru.old.Receivers[name]
+		if _, ok := o.Receiver.(skogul.Stopper); ok {
+			continue
+		}
+		if ru.c.Receivers[name] != nil && ru.reusableReceiver(name) {
+			continue
+		}
+		senders := make([]string, 0)
+		for sender := range reachable(ru.old, o.Receiver) {
+			if ru.c.Senders[sender] != ru.old.Senders[sender] {
+				senders = append(senders, sender)
+			}
+		}
+		if len(senders) > 0 {
+			sort.Strings(senders)
+			return fmt.Errorf("receiver %s can't be stopped, and would keep sending to replaced senders %v. Restart skogul to apply the change", name, senders)
+		}
+	}
+	return nil
+}
+
+/*
+Reload reads the configuration at path and applies it on top of the
+running configuration old, returning the new configuration.
+
+Modules with unchanged configuration, where all modules they reference are
+also unchanged, are kept as is, so e.g. a batch sender will not lose its
+state. Everything else is replaced by new instances.
+
+Receivers that are unchanged keep running. Changed receivers that
+implement skogul.Reloader are asked to adopt the new configuration, which
+lets e.g. the HTTP receiver keep its listener. Other changed receivers,
+and receivers that are removed, are stopped if they implement
+skogul.Stopper. Those that don't are left running as is, which is only
+allowed if all the senders they use are kept, since replaced senders
+are stopped. Receivers that need to be started are passed to start,
+which is expected to run Start() in a separate go routine. Senders that
+are replaced are flushed if they implement skogul.Flusher, then stopped if
+they implement skogul.Stopper. New senders are then opened, if they
+implement skogul.Opener.
+
+If the new configuration fails to parse or verify, an error is returned
+and nothing is changed.
+*/
+func Reload(path string, old *Config, start func(name string, r *Receiver)) (*Config, error) {
+	c, err := firstPassPath(path)
+	if err != nil {
+		return nil, err
+	}
+	ru := reuser{old: old, c: c, memo: make(map[reference]bool)}
+	ru.apply()
+	if _, err := resolve(c); err != nil {
+		return nil, err
+	}
+	if err := ru.checkStuck(); err != nil {
+		return nil, err
+	}
+
+	var toStart []string
+	for name, r := range c.Receivers {
+		logger := confLog.WithField("receiver", name)
+		o := old.Receivers[name]
+		if o == nil {
+			toStart = append(toStart, name)
+			continue
+		}
+		if ru.reusableReceiver(name) {
+			logger.Debug("Configuration unchanged, keeping running receiver")
+			r.Receiver = o.Receiver
+			c.identity[r.Receiver] = name
+			continue
+		}
+		if rl, ok := o.Receiver.(skogul.Reloader); ok {
+			err := rl.Reload(r.Receiver)
+			if err == nil {
+				logger.Info("Running receiver adopted new configuration")
+				r.Receiver = o.Receiver
+				c.identity[r.Receiver] = name
+				continue
+			}
+			logger.WithError(err).Debug("Running receiver can't adopt new configuration, replacing it")
+		}
+		if s, ok := o.Receiver.(skogul.Stopper); ok {
+			logger.Info("Configuration changed, restarting receiver")
+			if err := s.Stop(); err != nil {
+				logger.WithError(err).Warn("Stopping receiver failed")
+			}
+			toStart = append(toStart, name)
+			continue
+		}
+		logger.Warn("Configuration changed, but receiver can't be stopped. Restart skogul to apply the change.")
+		r.Receiver = o.Receiver
+		r.raw = o.raw
+		c.identity[r.Receiver] = name
+	}
+	for name, o := range old.Receivers {
+		if c.Receivers[name] != nil {
+			continue
+		}
+		logger := confLog.WithField("receiver", name)
+		if s, ok := o.Receiver.(skogul.Stopper); ok {
+			logger.Info("Receiver removed from configuration, stopping it")
+			if err := s.Stop(); err != nil {
+				logger.WithError(err).Warn("Stopping receiver failed")
+			}
+		} else {
+			logger.Warn("Receiver removed from configuration, but can't be stopped. Restart skogul to apply the change.")
+		}
+	}
+
+	// Replaced modules keep their names until they are stopped.
+	names := make(map[interface{}]string, len(old.identity)+len(c.identity))
+	for m, name := range old.identity {
+		names[m] = name
+	}
+	for m, name := range c.identity {
+		names[m] = name
+	}
+	skogul.SetIdentity(names)
+
+	// Nothing will send to the replaced senders anymore, so pass on
+	// whatever they hold on to.
+	for _, name := range old.FlushOrder() {
+		if c.Senders[name] == old.Senders[name] {
+			continue
+		}
+		if f, ok := old.Senders[name].Sender.(skogul.Flusher); ok {
+			confLog.WithField("sender", name).Debug("Flushing replaced sender")
+			if err := f.Flush(); err != nil {
+				confLog.WithField("sender", name).WithError(err).Warn("Flushing replaced sender failed")
+			}
+		}
+	}
+	stopSenders(old, func(name string) bool { return c.Senders[name] != old.Senders[name] })
+	skogul.SetIdentity(c.identity)
+	if err := openSenders(c, func(name string) bool { return c.Senders[name] != old.Senders[name] }); err != nil {
+		confLog.WithError(err).Error("Opening new sender failed")
+	}
+
+	for _, name := range toStart {
+		start(name, c.Receivers[name])
+	}
+	return c, nil
+}
@@ -0,0 +1,329 @@
+/*
+ * skogul, configuration reload tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package config_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/config"
+	"github.com/telenornms/skogul/sender"
+)
+
+const reloadBase = `
+{
+  "receivers": {
+    "udp_same": {
+      "type": "udp",
+      "address": "localhost:5999",
+      "handler": "h1"
+    },
+    "udp_changed": {
+      "type": "udp",
+      "address": "localhost:5998",
+      "handler": "h2"
+    }
+  },
+  "handlers": {
+    "h1": {
+      "parser": "skogul",
+      "sender": "batch"
+    },
+    "h2": {
+      "parser": "skogul",
+      "sender": "out"
+    }
+  },
+  "senders": {
+    "batch": {
+      "type": "batch",
+      "next": "out",
+      "interval": "1h"
+    },
+    "out": {
+      "type": "test"
+    },
+    "other": {
+      "type": "%s"
+    }
+  }
+}`
+
+func writeConfig(t *testing.T, file string, data string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatalf("unable to write test configuration: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "skogul.json")
+	writeConfig(t, file, fmt.Sprintf(reloadBase, "null"))
+	old, err := config.Path(file)
+	if err != nil {
+		t.Fatalf("Path() failed: %v", err)
+	}
+	m := skogul.Metric{}
+	cont := skogul.Container{Metrics: []*skogul.Metric{&m}}
+	old.Senders["batch"].Sender.Send(&cont)
+
+	// Changing the unrelated "other" sender should keep everything else
+	writeConfig(t, file, fmt.Sprintf(reloadBase, "test"))
+	started := make(map[string]bool)
+	start := func(name string, r *config.Receiver) {
+		started[name] = true
+	}
+	c, err := config.Reload(file, old, start)
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if len(started) != 0 {
+		t.Errorf("Reload() started receivers when nothing changed: %v", started)
+	}
+	for _, name := range []string{"batch", "out"} {
+		if c.Senders[name].Sender != old.Senders[name].Sender {
+			t.Errorf("Reload() replaced unchanged sender %s", name)
+		}
+	}
+	if c.Senders["other"].Sender == old.Senders["other"].Sender {
+		t.Errorf("Reload() didn't replace changed sender other")
+	}
+	if c.Receivers["udp_same"].Receiver != old.Receivers["udp_same"].Receiver {
+		t.Errorf("Reload() replaced unchanged receiver")
+	}
+	if skogul.IdentityOf(c.Senders["batch"].Sender) != "batch" {
+		t.Errorf("Reload() lost identity of kept sender")
+	}
+
+	// Changing "out" affects everything using it, directly or not.
+	old = c
+	writeConfig(t, file, strings.Replace(fmt.Sprintf(reloadBase, "test"), `"type": "test"
+    },
+    "other"`, `"type": "null"
+    },
+    "other"`, 1))
+	c, err = config.Reload(file, old, start)
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if !started["udp_same"] || !started["udp_changed"] {
+		t.Errorf("Reload() didn't restart receivers with changed handlers: %v", started)
+	}
+	if c.Senders["batch"].Sender == old.Senders["batch"].Sender {
+		t.Errorf("Reload() kept batch sender with changed next sender")
+	}
+	if c.Senders["other"].Sender != old.Senders["other"].Sender {
+		t.Errorf("Reload() replaced unchanged sender other")
+	}
+	tst := old.Senders["out"].Sender.(*sender.Test)
+	if tst.Received() != 1 {
+		t.Errorf("Reload() didn't flush replaced batch sender, got %d containers", tst.Received())
+	}
+
+	// Broken configuration should leave everything as is
+	writeConfig(t, file, `{ "senders": { "x": { "type": "batch" } } }`)
+	if _, err = config.Reload(file, c, start); err == nil {
+		t.Errorf("Reload() of invalid configuration succeeded")
+	}
+	if skogul.IdentityOf(c.Senders["batch"].Sender) != "batch" {
+		t.Errorf("failed Reload() lost identity of running sender")
+	}
+}
+
+// Running modules look up their names while the configuration is
+// reloaded. Run with -race to be useful.
+func TestReload_identity(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "skogul.json")
+	writeConfig(t, file, fmt.Sprintf(reloadBase, "null"))
+	c, err := config.Path(file)
+	if err != nil {
+		t.Fatalf("Path() failed: %v", err)
+	}
+	batch := c.Senders["batch"].Sender
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if skogul.IdentityOf(batch) != "batch" {
+				t.Errorf("lost identity of running sender during reload")
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		writeConfig(t, file, fmt.Sprintf(reloadBase, []string{"test", "null"}[i%2]))
+		if c, err = config.Reload(file, c, func(string, *config.Receiver) {}); err != nil {
+			t.Fatalf("Reload() failed: %v", err)
+		}
+	}
+	<-done
+}
+
+const reloadStuck = `
+{
+  "receivers": {
+    "tester": {
+      "type": "test",
+      "handler": "h",
+      "delay": "%s"
+    }
+  },
+  "handlers": {
+    "h": {
+      "parser": "skogul",
+      "sender": "batch"
+    }
+  },
+  "senders": {
+    "batch": {
+      "type": "batch",
+      "next": "out",
+      "interval": "1h"
+    },
+    "out": {
+      "type": "%s"
+    },
+    "other": {
+      "type": "%s"
+    }
+  }
+}`
+
+// The test receiver can't be stopped, so it keeps running with its old
+// handler, which must keep working.
+func TestReload_stuckReceiver(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "skogul.json")
+	writeConfig(t, file, fmt.Sprintf(reloadStuck, "1h", "test", "null"))
+	c, err := config.Path(file)
+	if err != nil {
+		t.Fatalf("Path() failed: %v", err)
+	}
+	start := func(string, *config.Receiver) {}
+
+	writeConfig(t, file, fmt.Sprintf(reloadStuck, "1h", "test", "test"))
+	if c, err = config.Reload(file, c, start); err != nil {
+		t.Errorf("Reload() of unrelated sender failed: %v", err)
+	}
+	writeConfig(t, file, fmt.Sprintf(reloadStuck, "2h", "test", "test"))
+	if c, err = config.Reload(file, c, start); err != nil {
+		t.Errorf("Reload() of receiver with unchanged senders failed: %v", err)
+	}
+
+	writeConfig(t, file, fmt.Sprintf(reloadStuck, "2h", "null", "test"))
+	_, err = config.Reload(file, c, start)
+	if err == nil || !strings.Contains(err.Error(), "batch out") {
+		t.Errorf("Reload() replacing senders of a receiver that can't be stopped didn't fail properly: %v", err)
+	}
+	writeConfig(t, file, `{ "senders": { "x": { "type": "test" } } }`)
+	if _, err = config.Reload(file, c, start); err == nil {
+		t.Errorf("Reload() removing a receiver that can't be stopped succeeded")
+	}
+}
+
+const reloadPrometheus = `
+{
+  "senders": {
+    "prom": {
+      "type": "prometheus",
+      "address": "localhost:1373",
+      "prefix": "%s"
+    }
+  }
+}`
+
+// A replaced sender must release its address before the new one opens
+// it.
+func TestReload_open(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "skogul.json")
+	writeConfig(t, file, fmt.Sprintf(reloadPrometheus, "a"))
+	c, err := config.Path(file)
+	if err != nil {
+		t.Fatalf("Path() failed: %v", err)
+	}
+	if err := c.Open(); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer func() { c.Shutdown(0) }()
+	writeConfig(t, file, fmt.Sprintf(reloadPrometheus, "b"))
+	if c, err = config.Reload(file, c, func(string, *config.Receiver) {}); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	now := time.Now()
+	cont := skogul.Container{Metrics: []*skogul.Metric{{Time: &now, Data: map[string]interface{}{"x": 1}}}}
+	if err := c.Senders["prom"].Sender.Send(&cont); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	resp, err := http.Get("http://localhost:1373/metrics")
+	if err != nil {
+		t.Fatalf("Scraping replaced sender failed: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(b), "b_x 1") {
+		t.Errorf("Not served by the new sender:\n%s", b)
+	}
+}
+
+// reloadVerified counts calls to Verify, which must not be repeated for
+// running senders that are kept on reload.
+type reloadVerified struct{}
+
+var reloadVerifies int
+
+func (r *reloadVerified) Send(c *skogul.Container) error { return nil }
+
+func (r *reloadVerified) Verify() error {
+	reloadVerifies++
+	return nil
+}
+
+func TestReload_verify(t *testing.T) {
+	sender.Auto.Add(skogul.Module{
+		Name:  "reload_verified",
+		Alloc: func() interface{} { return &reloadVerified{} },
+		Help:  "Counts calls to Verify.",
+	})
+	file := filepath.Join(t.TempDir(), "skogul.json")
+	writeConfig(t, file, fmt.Sprintf(`{"senders": {"kept": {"type": "reload_verified"}, "other": {"type": "%s"}}}`, "null"))
+	old, err := config.Path(file)
+	if err != nil {
+		t.Fatalf("Path() failed: %v", err)
+	}
+	writeConfig(t, file, fmt.Sprintf(`{"senders": {"kept": {"type": "reload_verified"}, "other": {"type": "%s"}}}`, "test"))
+	c, err := config.Reload(file, old, func(string, *config.Receiver) {})
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if c.Senders["kept"].Sender != old.Senders["kept"].Sender {
+		t.Fatalf("Reload() replaced unchanged sender")
+	}
+	if reloadVerifies != 1 {
+		t.Errorf("Wanted 1 Verify() call, got %d", reloadVerifies)
+	}
+}
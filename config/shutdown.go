@@ -0,0 +1,233 @@
+/*
+ * skogul, graceful shutdown
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var (
+	senderRefType      = reflect.TypeOf(skogul.SenderRef{})
+	handlerRefType     = reflect.TypeOf(skogul.HandlerRef{})
+	transformerRefType = reflect.TypeOf(skogul.TransformerRef{})
+	parserRefType      = reflect.TypeOf(skogul.ParserRef{})
+	encoderRefType     = reflect.TypeOf(skogul.EncoderRef{})
+)
+
+// reference is a named reference from one module to another.
+type reference struct {
+	family string // sender, handler, transformer, parser or encoder
+	name   string
+}
+
+// findRefs finds all references to other modules in the exported fields
+// of v, recursively. Fields that are not part of the configuration
+// (tagged with json:"-") are ignored, and references are not followed.
+func findRefs(v reflect.Value, refs []reference) []reference {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return refs
+		}
+		return findRefs(v.Elem(), refs)
+	case reflect.Struct:
+		family := ""
+		switch v.Type() {
+		case senderRefType:
+			family = "sender"
+		case handlerRefType:
+			family = "handler"
+		case transformerRefType:
+			family = "transformer"
+		case parserRefType:
+			family = "parser"
+		case encoderRefType:
+			family = "encoder"
+		}
+		if family != "" {
+			if name := v.FieldByName("Name").String(); name != "" {
+				refs = append(refs, reference{family, name})
+			}
+			return refs
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() || field.Tag.Get("json") == "-" {
+				continue
+			}
+			refs = findRefs(v.Field(i), refs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			refs = findRefs(v.Index(i), refs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			refs = findRefs(iter.Value(), refs)
+		}
+	}
+	return refs
+}
+
+// FlushOrder returns the names of all configured senders, ordered so that
+// a sender always comes before the senders it passes data on to. This is
+// the order senders have to be flushed in to avoid leaving data behind.
+// Loops in the sender graph are tolerated, but the order within a loop is
+// undefined.
+func (c *Config) FlushOrder() []string {
+	names := make([]string, 0, len(c.Senders))
+	for name := range c.Senders {
+		names = append(names, name)
+	}
+	// Sort first so the result is stable between runs
+	sort.Strings(names)
+
+	visited := make(map[string]bool)
+	order := make([]string, 0, len(names))
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] || c.Senders[name] == nil {
+			return
+		}
+		visited[name] = true
+		for _, ref := range findRefs(reflect.ValueOf(c.Senders[name].Sender), nil) {
+			if ref.family == "sender" {
+				visit(ref.name)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	// order is now downstream-first, reverse it.
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Shutdown stops all receivers that implement skogul.Stopper, then
+// flushes all senders that implement skogul.Flusher, in FlushOrder(), and
+// finally stops the senders that implement skogul.Stopper. If
+// this takes longer than the deadline, Shutdown gives up and returns an
+// error, even if it is still flushing in the background. A deadline of 0
+// means wait indefinitely.
+func (c *Config) Shutdown(deadline time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.shutdown()
+	}()
+	if deadline == 0 {
+		return <-done
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(deadline):
+		return fmt.Errorf("shutdown did not complete within %v, data might be lost", deadline)
+	}
+}
+
+// shutdown does the actual work for Shutdown, without the deadline.
+// Receivers are stopped in parallel, since they are independent of each
+// other, while senders are flushed one by one.
+func (c *Config) shutdown() error {
+	var wg sync.WaitGroup
+	for name, r := range c.Receivers {
+		s, ok := r.Receiver.(skogul.Stopper)
+		if !ok {
+			confLog.WithField("receiver", name).Debug("Receiver can't be stopped, ignoring it")
+			continue
+		}
+		wg.Add(1)
+		go func(name string, s skogul.Stopper) {
+			defer wg.Done()
+			confLog.WithField("receiver", name).Debug("Stopping receiver")
+			if err := s.Stop(); err != nil {
+				confLog.WithField("receiver", name).WithError(err).Warn("Stopping receiver failed")
+			}
+		}(name, s)
+	}
+	wg.Wait()
+
+	var ret error
+	for _, name := range c.FlushOrder() {
+		f, ok := c.Senders[name].Sender.(skogul.Flusher)
+		if !ok {
+			continue
+		}
+		confLog.WithField("sender", name).Debug("Flushing sender")
+		if err := f.Flush(); err != nil {
+			ret = fmt.Errorf("flushing sender `%s' failed: %w", name, err)
+			confLog.WithField("sender", name).WithError(err).Warn("Flushing sender failed")
+		}
+	}
+	stopSenders(c, func(string) bool { return true })
+	return ret
+}
+
+// Open opens all senders that implement skogul.Opener, and should be
+// called before the receivers are started.
+func (c *Config) Open() error {
+	return openSenders(c, func(string) bool { return true })
+}
+
+// openSenders opens the senders of c that implement skogul.Opener and
+// are selected by include, returning the first error.
+func openSenders(c *Config, include func(name string) bool) error {
+	var ret error
+	for _, name := range c.FlushOrder() {
+		o, ok := c.Senders[name].Sender.(skogul.Opener)
+		if !ok || !include(name) {
+			continue
+		}
+		confLog.WithField("sender", name).Debug("Opening sender")
+		if err := o.Open(); err != nil && ret == nil {
+			ret = fmt.Errorf("opening sender `%s' failed: %w", name, err)
+		}
+	}
+	return ret
+}
+
+// stopSenders stops the senders of c that implement skogul.Stopper and
+// are selected by include. Errors are logged, since there is nothing
+// else to be done about them.
+func stopSenders(c *Config, include func(name string) bool) {
+	for _, name := range c.FlushOrder() {
+		s, ok := c.Senders[name].Sender.(skogul.Stopper)
+		if !ok || !include(name) {
+			continue
+		}
+		confLog.WithField("sender", name).Debug("Stopping sender")
+		if err := s.Stop(); err != nil {
+			confLog.WithField("sender", name).WithError(err).Warn("Stopping sender failed")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * skogul, graceful shutdown tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/config"
+	"github.com/telenornms/skogul/sender"
+)
+
+func TestFlushOrder(t *testing.T) {
+	c, err := config.Bytes([]byte(`
+{
+  "senders": {
+    "a_batch": {
+      "type": "batch",
+      "next": "z_detach",
+      "interval": "1h",
+      "threshold": 1000
+    },
+    "z_detach": {
+      "type": "detacher",
+      "next": "m_switch"
+    },
+    "m_switch": {
+      "type": "switch",
+      "map": [
+        { "conditions": [ { "foo": "bar" } ], "next": "b_end" }
+      ],
+      "default": "test"
+    },
+    "b_end": {
+      "type": "test"
+    },
+    "test": {
+      "type": "test"
+    }
+  }
+}`))
+	if err != nil {
+		t.Fatalf("Bytes() failed: %v", err)
+	}
+	order := c.FlushOrder()
+	pos := make(map[string]int)
+	for i, name := range order {
+		pos[name] = i
+	}
+	if len(order) != 5 {
+		t.Errorf("FlushOrder() returned %d senders, wanted 5: %v", len(order), order)
+	}
+	for _, edge := range [][2]string{
+		{"a_batch", "z_detach"},
+		{"z_detach", "m_switch"},
+		{"m_switch", "b_end"},
+		{"m_switch", "test"},
+	} {
+		if pos[edge[0]] > pos[edge[1]] {
+			t.Errorf("FlushOrder() places %s after %s: %v", edge[0], edge[1], order)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c, err := config.Bytes([]byte(`
+{
+  "senders": {
+    "batch": {
+      "type": "batch",
+      "next": "detacher",
+      "interval": "1h",
+      "threshold": 1000
+    },
+    "detacher": {
+      "type": "detacher",
+      "next": "test"
+    },
+    "test": {
+      "type": "test"
+    }
+  }
+}`))
+	if err != nil {
+		t.Fatalf("Bytes() failed: %v", err)
+	}
+	m := skogul.Metric{}
+	cont := skogul.Container{Metrics: []*skogul.Metric{&m}}
+	for i := 0; i < 5; i++ {
+		if err := c.Senders["batch"].Sender.Send(&cont); err != nil {
+			t.Errorf("Send() failed: %v", err)
+		}
+	}
+	tst := c.Senders["test"].Sender.(*sender.Test)
+	if tst.Received() != 0 {
+		t.Errorf("batch passed data on before shutdown, got %d containers", tst.Received())
+	}
+	if err := c.Shutdown(time.Second); err != nil {
+		t.Errorf("Shutdown() failed: %v", err)
+	}
+	if tst.Received() != 1 {
+		t.Errorf("Shutdown() didn't flush batch, wanted 1 container, got %d", tst.Received())
+	}
+}
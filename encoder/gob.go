@@ -7,6 +7,14 @@ import (
 	"github.com/telenornms/skogul"
 )
 
+// Nested data, as produced by e.g. the JSON parser, is stored as interface
+// values, which gob needs to know about up front. The GOB parser imports
+// this package to get the same registration.
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
 type GOB struct{}
 
 // encode the content in the skogul container as a gob format
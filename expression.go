@@ -0,0 +1,177 @@
+/*
+ * skogul, expressions
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package skogul
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+)
+
+/*
+Expression is an expression evaluated against a single metric, written
+in the Common Expression Language (CEL), e.g.:
+
+	metadata.interface.startsWith("ae") && data.in_octets > 1000
+
+The metadata and data of the metric are available as the maps "metadata"
+and "data", and the timestamp as "timestamp". Fields that might be
+missing should be checked with has(), e.g. has(data.in_octets), since
+reading a missing field is an error. Numbers can be compared regardless
+of type, but arithmetic requires the same type on both sides, so numbers
+decoded from JSON, which are floats, should be used with float literals,
+e.g. data.in_octets * 8.0. The CEL string extensions are available, in
+addition to the standard functions such as matches() for regular
+expressions.
+
+The expression is compiled when the configuration is loaded, and is
+written as a plain string in the configuration.
+*/
+type Expression struct {
+	Source  string
+	program cel.Program
+	output  *cel.Type
+}
+
+var (
+	exprEnv     *cel.Env
+	exprEnvErr  error
+	exprEnvOnce sync.Once
+)
+
+func exprInit() {
+	exprEnv, exprEnvErr = cel.NewEnv(
+		cel.Variable("metadata", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("data", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("timestamp", cel.TimestampType),
+		cel.CrossTypeNumericComparisons(true),
+		cel.OptionalTypes(),
+		ext.Strings(),
+	)
+}
+
+// NewExpression compiles an expression.
+func NewExpression(source string) (*Expression, error) {
+	exprEnvOnce.Do(exprInit)
+	if exprEnvErr != nil {
+		return nil, fmt.Errorf("unable to set up expression environment: %w", exprEnvErr)
+	}
+	ast, iss := exprEnv.Compile(source)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, iss.Err())
+	}
+	program, err := exprEnv.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	return &Expression{Source: source, program: program, output: ast.OutputType()}, nil
+}
+
+// IsBool returns true if the expression can evaluate to a boolean, and
+// can be used as a condition.
+func (e *Expression) IsBool() bool {
+	return e.output.IsAssignableType(cel.BoolType)
+}
+
+// eval evaluates the expression against m.
+func (e *Expression) eval(m *Metric) (ref.Val, error) {
+	if e.program == nil {
+		return nil, fmt.Errorf("expression %q is not compiled", e.Source)
+	}
+	vars := map[string]interface{}{
+		"metadata":  m.Metadata,
+		"data":      m.Data,
+		"timestamp": time.Time{},
+	}
+	if m.Metadata == nil {
+		vars["metadata"] = map[string]interface{}{}
+	}
+	if m.Data == nil {
+		vars["data"] = map[string]interface{}{}
+	}
+	if m.Time != nil {
+		vars["timestamp"] = *m.Time
+	}
+	out, _, err := e.program.Eval(vars)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating %q failed: %w", e.Source, err)
+	}
+	return out, nil
+}
+
+// Eval evaluates the expression against m, and returns the result as a
+// regular Go value, e.g. float64, int64, string or map[string]interface{}.
+func (e *Expression) Eval(m *Metric) (interface{}, error) {
+	out, err := e.eval(m)
+	if err != nil {
+		return nil, err
+	}
+	switch out.Type() {
+	case types.ListType:
+		return out.ConvertToNative(reflect.TypeOf([]interface{}{}))
+	case types.MapType:
+		return out.ConvertToNative(reflect.TypeOf(map[string]interface{}{}))
+	case types.NullType:
+		return nil, nil
+	}
+	return out.Value(), nil
+}
+
+// Match evaluates the expression against m, and returns an error if the
+// result isn't a boolean.
+func (e *Expression) Match(m *Metric) (bool, error) {
+	out, err := e.eval(m)
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q returned %s, not bool", e.Source, out.Type().TypeName())
+	}
+	return b, nil
+}
+
+// MarshalJSON returns the source of the expression.
+func (e *Expression) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Source)
+}
+
+// UnmarshalJSON compiles the expression.
+func (e *Expression) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	n, err := NewExpression(s)
+	if err != nil {
+		return err
+	}
+	*e = *n
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+ * skogul, expression tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package skogul_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+func exprMetric() *skogul.Metric {
+	ts := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &skogul.Metric{
+		Time: &ts,
+		Metadata: map[string]interface{}{
+			"device":    "r1",
+			"interface": "ae12",
+		},
+		Data: map[string]interface{}{
+			"in_octets":  1000.0,
+			"out_octets": uint64(500),
+			"errors":     int64(2),
+			"nested":     map[string]interface{}{"a": "b"},
+		},
+	}
+}
+
+func TestExpressionMatch(t *testing.T) {
+	m := exprMetric()
+	cases := []struct {
+		source string
+		want   bool
+	}{
+		{`metadata.device == "r1"`, true},
+		{`metadata.interface.startsWith("ae") && data.in_octets > 500`, true},
+		{`metadata.interface.matches("^et-")`, false},
+		{`data.out_octets > 100 && data.errors < 5`, true},
+		{`has(data.crc) || data.nested.a == "b"`, true},
+		{`has(metadata.site)`, false},
+		{`timestamp.getFullYear() == 2023`, true},
+		{`data.?crc.orValue(0) == 0`, true},
+	}
+	for _, c := range cases {
+		e, err := skogul.NewExpression(c.source)
+		if err != nil {
+			t.Errorf("Compiling %q failed: %v", c.source, err)
+			continue
+		}
+		if !e.IsBool() {
+			t.Errorf("Expected %q to be a condition", c.source)
+		}
+		got, err := e.Match(m)
+		if err != nil {
+			t.Errorf("Matching %q failed: %v", c.source, err)
+		} else if got != c.want {
+			t.Errorf("Expected %q to be %v, got %v", c.source, c.want, got)
+		}
+	}
+
+	e, _ := skogul.NewExpression(`data.crc > 0`)
+	if _, err := e.Match(m); err == nil {
+		t.Errorf("Expected error reading missing field")
+	}
+	e, _ = skogul.NewExpression(`data.in_octets`)
+	if _, err := e.Match(m); err == nil {
+		t.Errorf("Expected error matching non-bool result")
+	}
+	e, _ = skogul.NewExpression(`"x" + "y"`)
+	if e.IsBool() {
+		t.Errorf("Expected string expression not to be a condition")
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	m := exprMetric()
+	cases := []struct {
+		source string
+		want   interface{}
+	}{
+		{`data.in_octets * 8.0`, 8000.0},
+		{`data.out_octets + 1u`, uint64(501)},
+		{`metadata.device + "/" + metadata.interface`, "r1/ae12"},
+		{`metadata.device.upperAscii()`, "R1"},
+	}
+	for _, c := range cases {
+		e, err := skogul.NewExpression(c.source)
+		if err != nil {
+			t.Errorf("Compiling %q failed: %v", c.source, err)
+			continue
+		}
+		got, err := e.Eval(m)
+		if err != nil {
+			t.Errorf("Evaluating %q failed: %v", c.source, err)
+		} else if got != c.want {
+			t.Errorf("Expected %q to be %v (%T), got %v (%T)", c.source, c.want, c.want, got, got)
+		}
+	}
+	e, _ := skogul.NewExpression(`[1, 2]`)
+	if got, err := e.Eval(m); err != nil || len(got.([]interface{})) != 2 {
+		t.Errorf("Expected list of two, got %v (%v)", got, err)
+	}
+}
+
+func TestExpressionJSON(t *testing.T) {
+	var conf struct {
+		If *skogul.Expression
+	}
+	if err := json.Unmarshal([]byte(`{"If": "data.in_octets > 1"}`), &conf); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if ok, err := conf.If.Match(exprMetric()); !ok || err != nil {
+		t.Errorf("Expected unmarshalled expression to match, got %v (%v)", ok, err)
+	}
+	b, err := json.Marshal(conf)
+	var back map[string]string
+	if err == nil {
+		err = json.Unmarshal(b, &back)
+	}
+	if err != nil || back["If"] != "data.in_octets > 1" {
+		t.Errorf("Unexpected marshalled expression %s (%v)", b, err)
+	}
+	if err := json.Unmarshal([]byte(`{"If": "data.in_octets >"}`), &conf); err == nil {
+		t.Errorf("Unmarshalling invalid expression succeeded")
+	}
+}
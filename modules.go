@@ -24,6 +24,8 @@
 package skogul
 
 import (
+	"sync/atomic"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -49,15 +51,40 @@ type Module struct {
 // and transformer.Auto.
 type ModuleMap map[string]*Module
 
+// identity maps instances of modules to their configured name. The map
+// is never modified once published, it is replaced as a whole by
+// SetIdentity, so it can be read while the configuration is reloaded.
+var identity atomic.Pointer[map[interface{}]string]
+
 // Identity is used to map instances of modules to their configured name.
-// E.g.: If you have 3 influx senders, the module can access
-// skogul.Identity[] to distinguish between them. This is meant for logging
-// and statistics. Note that this is independent of which type of module
-// we're dealing with, since they all have unique addresses, while they
-// don't have unique names (e.g.: You can have a receiver named "test" and
-// a sender named "test" at the same time - it will still work fine).
+// It is kept up to date by SetIdentity.
+//
+// Deprecated: The map is replaced when the configuration is reloaded, so
+// reading it from a running module is racy. Use IdentityOf instead.
 var Identity map[interface{}]string
 
+// IdentityOf returns the configured name of an instance of a module.
+// E.g.: If you have 3 influx senders, the module can use
+// skogul.IdentityOf(x) to distinguish between them. This is meant for
+// logging and statistics. Note that this is independent of which type of
+// module we're dealing with, since they all have unique addresses, while
+// they don't have unique names (e.g.: You can have a receiver named "test"
+// and a sender named "test" at the same time - it will still work fine).
+func IdentityOf(module interface{}) string {
+	m := identity.Load()
+	if m == nil {
+		return ""
+	}
+	return (*m)[module]
+}
+
+// SetIdentity replaces the names returned by IdentityOf. The map must not
+// be modified afterwards.
+func SetIdentity(m map[interface{}]string) {
+	identity.Store(&m)
+	Identity = m
+}
+
 // Lookup will return a module if the name exists AND it should be
 // autocreated. It is used during config loading to look up a module which
 // is subsequently allocated.
@@ -115,4 +115,53 @@ func init() {
 		Help:     "Parse a prometheus formatted document into a skogul container, one metric per line.",
 		AutoMake: true,
 	})
+	Auto.Add(skogul.Module{
+		Name:     "prometheus_remote_write",
+		Aliases:  []string{"remote_write", "prometheus_rw"},
+		Alloc:    func() interface{} { return &PrometheusRemoteWrite{} },
+		Help:     "Parse a snappy-compressed Prometheus remote write request, one metric per sample. Use with the HTTP receiver to accept remote_write from Prometheus.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "gnmi",
+		Aliases:  []string{},
+		Alloc:    func() interface{} { return &GNMI{} },
+		Help:     "Parse a gNMI SubscribeResponse, as passed on by the gnmi receiver. Updates are grouped into one metric per path, with path keys as metadata.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "mdt",
+		Aliases:  []string{"kvgpb", "cisco_mdt"},
+		Alloc:    func() interface{} { return &MDT{} },
+		Help:     "Parse Cisco model-driven telemetry in self-describing GPB (kvGPB) or JSON encoding, as passed on by the mdt_dialout receiver. Keys become metadata and content becomes data, with nested fields flattened.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "otlp",
+		Aliases:  []string{"opentelemetry"},
+		Alloc:    func() interface{} { return &OTLP{} },
+		Help:     "Parse OpenTelemetry OTLP metrics, protobuf or JSON. Use with the HTTP receiver on /v1/metrics for OTLP/HTTP, or with the otlp receiver for OTLP/gRPC.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "netflow",
+		Aliases:  []string{"ipfix"},
+		Alloc:    func() interface{} { return &NetFlow{} },
+		Help:     "Parse NetFlow v5, NetFlow v9 and IPFIX packets, one metric per flow record. Templates are kept per exporter, so use with the UDP receiver.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "sflow",
+		Aliases:  []string{},
+		Alloc:    func() interface{} { return &SFlow{} },
+		Help:     "Parse sFlow v5 datagrams, one metric per flow sample or counter sample. Use with the UDP receiver.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "syslog",
+		Aliases:  []string{"rfc5424", "rfc3164"},
+		Alloc:    func() interface{} { return &Syslog{} },
+		Help:     "Parse a single RFC5424 or RFC3164 syslog message. Header fields become metadata, and the message and structured data parameters become data. Use with the syslog receiver.",
+		AutoMake: true,
+	})
 }
@@ -0,0 +1,213 @@
+/*
+ * skogul, common code for flow parsers
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+)
+
+// flowType is how the value of a flow field is decoded.
+type flowType int
+
+const (
+	flowUint flowType = iota
+	flowIP
+	flowMAC
+	flowString
+)
+
+// flowElement is an IPFIX information element. NetFlow v9 uses the same
+// numbers for the fields they have in common.
+type flowElement struct {
+	name     string
+	typ      flowType
+	metadata bool // Stored as metadata instead of data
+}
+
+// flowElements are the IANA IPFIX information elements we know the names
+// of. Others are named by number, e.g. "ie42", or "ie9.42" for
+// enterprise-specific elements.
+var flowElements = map[uint16]flowElement{
+	1:   {"octetDeltaCount", flowUint, false},
+	2:   {"packetDeltaCount", flowUint, false},
+	3:   {"deltaFlowCount", flowUint, false},
+	4:   {"protocolIdentifier", flowUint, false},
+	5:   {"ipClassOfService", flowUint, false},
+	6:   {"tcpControlBits", flowUint, false},
+	7:   {"sourceTransportPort", flowUint, false},
+	8:   {"sourceIPv4Address", flowIP, false},
+	9:   {"sourceIPv4PrefixLength", flowUint, false},
+	10:  {"ingressInterface", flowUint, true},
+	11:  {"destinationTransportPort", flowUint, false},
+	12:  {"destinationIPv4Address", flowIP, false},
+	13:  {"destinationIPv4PrefixLength", flowUint, false},
+	14:  {"egressInterface", flowUint, true},
+	15:  {"ipNextHopIPv4Address", flowIP, false},
+	16:  {"bgpSourceAsNumber", flowUint, false},
+	17:  {"bgpDestinationAsNumber", flowUint, false},
+	18:  {"bgpNextHopIPv4Address", flowIP, false},
+	21:  {"flowEndSysUpTime", flowUint, false},
+	22:  {"flowStartSysUpTime", flowUint, false},
+	23:  {"postOctetDeltaCount", flowUint, false},
+	24:  {"postPacketDeltaCount", flowUint, false},
+	27:  {"sourceIPv6Address", flowIP, false},
+	28:  {"destinationIPv6Address", flowIP, false},
+	29:  {"sourceIPv6PrefixLength", flowUint, false},
+	30:  {"destinationIPv6PrefixLength", flowUint, false},
+	31:  {"flowLabelIPv6", flowUint, false},
+	32:  {"icmpTypeCodeIPv4", flowUint, false},
+	34:  {"samplingInterval", flowUint, false},
+	35:  {"samplingAlgorithm", flowUint, false},
+	38:  {"engineType", flowUint, true},
+	39:  {"engineId", flowUint, true},
+	52:  {"minimumTTL", flowUint, false},
+	53:  {"maximumTTL", flowUint, false},
+	56:  {"sourceMacAddress", flowMAC, false},
+	57:  {"postDestinationMacAddress", flowMAC, false},
+	58:  {"vlanId", flowUint, false},
+	59:  {"postVlanId", flowUint, false},
+	60:  {"ipVersion", flowUint, false},
+	61:  {"flowDirection", flowUint, false},
+	62:  {"ipNextHopIPv6Address", flowIP, false},
+	63:  {"bgpNextHopIPv6Address", flowIP, false},
+	80:  {"destinationMacAddress", flowMAC, false},
+	81:  {"postSourceMacAddress", flowMAC, false},
+	82:  {"interfaceName", flowString, true},
+	83:  {"interfaceDescription", flowString, true},
+	85:  {"octetTotalCount", flowUint, false},
+	86:  {"packetTotalCount", flowUint, false},
+	89:  {"forwardingStatus", flowUint, false},
+	136: {"flowEndReason", flowUint, false},
+	139: {"icmpTypeCodeIPv6", flowUint, false},
+	148: {"flowId", flowUint, false},
+	150: {"flowStartSeconds", flowUint, false},
+	151: {"flowEndSeconds", flowUint, false},
+	152: {"flowStartMilliseconds", flowUint, false},
+	153: {"flowEndMilliseconds", flowUint, false},
+	160: {"systemInitTimeMilliseconds", flowUint, false},
+	176: {"icmpTypeIPv4", flowUint, false},
+	177: {"icmpCodeIPv4", flowUint, false},
+	178: {"icmpTypeIPv6", flowUint, false},
+	179: {"icmpCodeIPv6", flowUint, false},
+	225: {"postNATSourceIPv4Address", flowIP, false},
+	226: {"postNATDestinationIPv4Address", flowIP, false},
+	227: {"postNAPTSourceTransportPort", flowUint, false},
+	228: {"postNAPTDestinationTransportPort", flowUint, false},
+	234: {"ingressVRFID", flowUint, true},
+	235: {"egressVRFID", flowUint, true},
+}
+
+// flowValue decodes the value of a field. Unsigned integers of up to 8
+// bytes are decoded as uint64, addresses as strings, and anything else we
+// don't know how to decode as a hex string.
+func flowValue(typ flowType, b []byte) interface{} {
+	switch {
+	case typ == flowIP && (len(b) == 4 || len(b) == 16):
+		return net.IP(b).String()
+	case typ == flowMAC && len(b) == 6:
+		return net.HardwareAddr(b).String()
+	case typ == flowString:
+		// Strings are padded with zeroes
+		for i, c := range b {
+			if c == 0 {
+				return string(b[:i])
+			}
+		}
+		return string(b)
+	case len(b) <= 8:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v
+	}
+	return hex.EncodeToString(b)
+}
+
+// flowField adds a field of a flow record to md or data.
+func flowField(md, data map[string]interface{}, enterprise uint32, id uint16, b []byte) {
+	if enterprise != 0 {
+		data[fmt.Sprintf("ie%d.%d", enterprise, id)] = flowValue(flowUint, b)
+		return
+	}
+	e, ok := flowElements[id]
+	if !ok {
+		data[fmt.Sprintf("ie%d", id)] = flowValue(flowUint, b)
+		return
+	}
+	if e.metadata {
+		md[e.name] = flowValue(e.typ, b)
+	} else {
+		data[e.name] = flowValue(e.typ, b)
+	}
+}
+
+// flowReader reads big-endian values from a buffer, keeping track of
+// whether it ran out of data, so callers can check once at the end.
+type flowReader struct {
+	b   []byte
+	err bool
+}
+
+// flowZero is returned for short reads of fixed size values, so they
+// decode as zero without allocating. It must not be modified.
+var flowZero [16]byte
+
+// bytes returns the next n bytes. If there aren't enough, it sets r.err
+// and returns zeros for reads of fixed size values, or nil for longer
+// reads. Lengths come from the packet, so never allocate based on them.
+func (r *flowReader) bytes(n int) []byte {
+	if n < 0 || len(r.b) < n {
+		r.err = true
+		r.b = nil
+		if n >= 0 && n <= len(flowZero) {
+			return flowZero[:n]
+		}
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *flowReader) u8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *flowReader) u16() uint16 {
+	return binary.BigEndian.Uint16(r.bytes(2))
+}
+
+func (r *flowReader) u32() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+func (r *flowReader) u64() uint64 {
+	return binary.BigEndian.Uint64(r.bytes(8))
+}
+
+func (r *flowReader) ip(n int) string {
+	return net.IP(r.bytes(n)).String()
+}
@@ -0,0 +1,198 @@
+/*
+ * skogul, gNMI parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/telenornms/skogul"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+/*
+GNMI parses a protobuf-encoded gNMI SubscribeResponse, as passed on by the
+gNMI receiver, into metrics.
+
+Each update of a notification is split into the path of the value and
+the name of the value, the last element of the path. Updates with the
+same path, after removing the keys, are collected into the same metric,
+with the value names as data fields. The path, without the value name,
+is stored in the "path"
+metadata field, and the keys of all path elements, such as the name of
+an interface, are stored as metadata fields of their own. The target and
+origin of the notification, if set, are stored as "target" and "origin".
+
+JSON values are decoded, and if the result is an object, its fields are
+added to the data directly, and the entire path of the update is used as
+the path of the metric.
+
+Responses without updates, such as sync responses and deletes, result in
+an empty container.
+*/
+type GNMI struct{}
+
+// Parse parses a SubscribeResponse.
+func (x GNMI) Parse(b []byte) (*skogul.Container, error) {
+	resp := gpb.SubscribeResponse{}
+	if err := proto.Unmarshal(b, protoadapt.MessageV2Of(&resp)); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal gNMI SubscribeResponse: %w", err)
+	}
+	container := skogul.Container{}
+	n := resp.GetUpdate()
+	if n == nil {
+		return &container, nil
+	}
+	t := time.Unix(0, n.Timestamp)
+	metrics := make(map[string]*skogul.Metric)
+	keys := []string{}
+	for _, u := range n.Update {
+		md := make(map[string]interface{})
+		if n.Prefix != nil && n.Prefix.Target != "" {
+			md["target"] = n.Prefix.Target
+		}
+		elems := gnmiElems(n.Prefix, md)
+		elems = append(elems, gnmiElems(u.Path, md)...)
+		if len(elems) == 0 {
+			continue
+		}
+		value, err := gnmiValue(u.Val)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode value of /%s: %w", strings.Join(elems, "/"), err)
+		}
+		obj, isObj := value.(map[string]interface{})
+		name := elems[len(elems)-1]
+		if isObj {
+			// The path is to the object itself, not a value in it
+			md["path"] = "/" + strings.Join(elems, "/")
+		} else {
+			md["path"] = "/" + strings.Join(elems[:len(elems)-1], "/")
+		}
+
+		key := gnmiKey(md)
+		m := metrics[key]
+		if m == nil {
+			m = &skogul.Metric{
+				Time:     &t,
+				Metadata: md,
+				Data:     make(map[string]interface{}),
+			}
+			metrics[key] = m
+			keys = append(keys, key)
+		}
+		if isObj {
+			for k, v := range obj {
+				m.Data[k] = v
+			}
+		} else {
+			m.Data[name] = value
+		}
+	}
+	for _, key := range keys {
+		container.Metrics = append(container.Metrics, metrics[key])
+	}
+	return &container, nil
+}
+
+// gnmiElems returns the names of the elements of p, and adds the keys
+// of the elements, as well as the origin, to md.
+func gnmiElems(p *gpb.Path, md map[string]interface{}) []string {
+	if p == nil {
+		return nil
+	}
+	if p.Origin != "" {
+		md["origin"] = p.Origin
+	}
+	elems := make([]string, 0, len(p.Elem)+len(p.Element))
+	for _, e := range p.Elem {
+		elems = append(elems, e.Name)
+		for k, v := range e.Key {
+			md[k] = v
+		}
+	}
+	// Element is deprecated, but still used by some implementations.
+	return append(elems, p.Element...)
+}
+
+// gnmiKey returns a string uniquely identifying the metadata.
+func gnmiKey(md map[string]interface{}) string {
+	fields := make([]string, 0, len(md))
+	for k, v := range md {
+		fields = append(fields, fmt.Sprintf("%s\xff%v", k, v))
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, "\xfe")
+}
+
+// gnmiValue decodes a TypedValue.
+func gnmiValue(v *gpb.TypedValue) (interface{}, error) {
+	switch val := v.GetValue().(type) {
+	case nil:
+		return nil, nil
+	case *gpb.TypedValue_StringVal:
+		return val.StringVal, nil
+	case *gpb.TypedValue_IntVal:
+		return val.IntVal, nil
+	case *gpb.TypedValue_UintVal:
+		return val.UintVal, nil
+	case *gpb.TypedValue_BoolVal:
+		return val.BoolVal, nil
+	case *gpb.TypedValue_BytesVal:
+		return val.BytesVal, nil
+	case *gpb.TypedValue_FloatVal:
+		return float64(val.FloatVal), nil
+	case *gpb.TypedValue_DecimalVal:
+		return float64(val.DecimalVal.Digits) / math.Pow10(int(val.DecimalVal.Precision)), nil
+	case *gpb.TypedValue_AsciiVal:
+		return val.AsciiVal, nil
+	case *gpb.TypedValue_LeaflistVal:
+		list := make([]interface{}, 0, len(val.LeaflistVal.Element))
+		for _, e := range val.LeaflistVal.Element {
+			d, err := gnmiValue(e)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, d)
+		}
+		return list, nil
+	case *gpb.TypedValue_JsonVal:
+		return gnmiJSON(val.JsonVal)
+	case *gpb.TypedValue_JsonIetfVal:
+		return gnmiJSON(val.JsonIetfVal)
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v.GetValue())
+}
+
+// gnmiJSON decodes a JSON value.
+func gnmiJSON(b []byte) (interface{}, error) {
+	var ret interface{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * skogul, gNMI parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/telenornms/skogul/parser"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func gnmiUpdate(val *gpb.TypedValue, elems ...*gpb.PathElem) *gpb.Update {
+	return &gpb.Update{Path: &gpb.Path{Elem: elems}, Val: val}
+}
+
+func TestGNMI(t *testing.T) {
+	intf := func(name string) *gpb.PathElem {
+		return &gpb.PathElem{Name: "interface", Key: map[string]string{"name": name}}
+	}
+	state := &gpb.PathElem{Name: "state"}
+	counters := &gpb.PathElem{Name: "counters"}
+	resp := &gpb.SubscribeResponse{Response: &gpb.SubscribeResponse_Update{Update: &gpb.Notification{
+		Timestamp: 1600000000123456789,
+		Prefix:    &gpb.Path{Target: "router1", Origin: "openconfig", Elem: []*gpb.PathElem{{Name: "interfaces"}}},
+		Update: []*gpb.Update{
+			gnmiUpdate(&gpb.TypedValue{Value: &gpb.TypedValue_UintVal{UintVal: 42}}, intf("eth0"), state, counters, &gpb.PathElem{Name: "in-octets"}),
+			gnmiUpdate(&gpb.TypedValue{Value: &gpb.TypedValue_UintVal{UintVal: 17}}, intf("eth0"), state, counters, &gpb.PathElem{Name: "out-octets"}),
+			gnmiUpdate(&gpb.TypedValue{Value: &gpb.TypedValue_UintVal{UintVal: 5}}, intf("eth1"), state, counters, &gpb.PathElem{Name: "in-octets"}),
+			gnmiUpdate(&gpb.TypedValue{Value: &gpb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"oper-status":"UP","mtu":1500}`)}}, intf("eth1"), state),
+		},
+	}}}
+	b, err := proto.Marshal(protoadapt.MessageV2Of(resp))
+	if err != nil {
+		t.Fatalf("Unable to marshal test data: %v", err)
+	}
+	c, err := parser.GNMI{}.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d: %v", len(c.Metrics), c)
+	}
+	m := c.Metrics[0]
+	if m.Metadata["path"] != "/interfaces/interface/state/counters" || m.Metadata["name"] != "eth0" || m.Metadata["target"] != "router1" || m.Metadata["origin"] != "openconfig" {
+		t.Errorf("Unexpected metadata: %v", m.Metadata)
+	}
+	if m.Data["in-octets"] != uint64(42) || m.Data["out-octets"] != uint64(17) {
+		t.Errorf("Unexpected data: %v", m.Data)
+	}
+	if m.Time.UnixNano() != 1600000000123456789 {
+		t.Errorf("Unexpected timestamp: %v", m.Time)
+	}
+	m = c.Metrics[2]
+	if m.Metadata["path"] != "/interfaces/interface/state" || m.Metadata["name"] != "eth1" {
+		t.Errorf("Unexpected metadata for JSON value: %v", m.Metadata)
+	}
+	if m.Data["oper-status"] != "UP" || m.Data["mtu"] != 1500.0 {
+		t.Errorf("Unexpected data for JSON value: %v", m.Data)
+	}
+
+	sync := &gpb.SubscribeResponse{Response: &gpb.SubscribeResponse_SyncResponse{SyncResponse: true}}
+	b, _ = proto.Marshal(protoadapt.MessageV2Of(sync))
+	c, err = parser.GNMI{}.Parse(b)
+	if err != nil || len(c.Metrics) != 0 {
+		t.Errorf("Expected empty container for sync response, got %v, %v", c, err)
+	}
+	if _, err := (parser.GNMI{}).Parse([]byte{0xff, 0xff}); err == nil {
+		t.Errorf("Expected error parsing garbage")
+	}
+}
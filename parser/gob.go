@@ -27,6 +27,8 @@ import (
 	"encoding/gob"
 
 	"github.com/telenornms/skogul"
+	// Registers the interface types used for nested data with gob.
+	_ "github.com/telenornms/skogul/encoder"
 )
 
 type GOB struct{}
@@ -0,0 +1,221 @@
+/*
+ * skogul, Cisco model-driven telemetry parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cisco-ie/nx-telemetry-proto/telemetry_bis"
+	"github.com/telenornms/skogul"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+/*
+MDT parses Cisco model-driven telemetry, as passed on by the mdt_dialout
+receiver, in either self-describing GPB (kvGPB) or JSON encoding. The
+encoding is detected automatically. The compact GPB encoding is not
+supported, since it requires the .proto file of every sensor path.
+
+Each row of the message becomes a metric. The fields under "keys" are
+stored as metadata and the fields under "content" as data. Nested
+fields are flattened, with the names joined by Separator, e.g.
+"statistics/packets-received". Repeated fields with the same name, such
+as leaf-lists, are collected in a list. The node ID, subscription and
+encoding path of the message are added as the metadata fields "node",
+"subscription" and "encoding_path".
+*/
+type MDT struct {
+	Separator string `doc:"Separator used when flattening nested fields. Defaults to \"/\"."`
+}
+
+// mdtJSON is the JSON encoding of a telemetry message. It mirrors the
+// Telemetry message of telemetry_bis.proto, with data_json instead of
+// data_gpbkv.
+type mdtJSON struct {
+	NodeID       string `json:"node_id_str"`
+	Subscription string `json:"subscription_id_str"`
+	EncodingPath string `json:"encoding_path"`
+	MsgTimestamp uint64 `json:"msg_timestamp"`
+	Rows         []struct {
+		Timestamp uint64                 `json:"timestamp"`
+		Keys      interface{}            `json:"keys"`
+		Content   map[string]interface{} `json:"content"`
+	} `json:"data_json"`
+}
+
+// Parse parses a telemetry message.
+func (x MDT) Parse(b []byte) (*skogul.Container, error) {
+	sep := x.Separator
+	if sep == "" {
+		sep = "/"
+	}
+	if len(b) > 0 && b[0] == '{' {
+		return x.parseJSON(b, sep)
+	}
+	t := telemetry_bis.Telemetry{}
+	if err := proto.Unmarshal(b, protoadapt.MessageV2Of(&t)); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal telemetry message: %w", err)
+	}
+	if t.DataGpb != nil && len(t.DataGpb.Row) > 0 {
+		return nil, fmt.Errorf("compact GPB encoding is not supported, use self-describing GPB (kvGPB) or JSON")
+	}
+	container := skogul.Container{}
+	for _, row := range t.DataGpbkv {
+		m := mdtMetric(t.GetNodeIdStr(), t.GetSubscriptionIdStr(), t.EncodingPath, row.Timestamp, t.MsgTimestamp)
+		for _, f := range row.Fields {
+			switch f.Name {
+			case "keys":
+				mdtFlattenFields(m.Metadata, "", sep, f.Fields)
+			case "content":
+				mdtFlattenFields(m.Data, "", sep, f.Fields)
+			default:
+				// Not the usual keys/content split, keep it all as data
+				mdtFlattenFields(m.Data, "", sep, []*telemetry_bis.TelemetryField{f})
+			}
+		}
+		container.Metrics = append(container.Metrics, m)
+	}
+	return &container, nil
+}
+
+// parseJSON parses a JSON-encoded telemetry message.
+func (x MDT) parseJSON(b []byte, sep string) (*skogul.Container, error) {
+	t := mdtJSON{}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal JSON telemetry message: %w", err)
+	}
+	container := skogul.Container{}
+	for _, row := range t.Rows {
+		m := mdtMetric(t.NodeID, t.Subscription, t.EncodingPath, row.Timestamp, t.MsgTimestamp)
+		// Keys are an object, but some versions wrap it in a list
+		switch keys := row.Keys.(type) {
+		case map[string]interface{}:
+			mdtFlattenJSON(m.Metadata, "", sep, keys)
+		case []interface{}:
+			for _, k := range keys {
+				if obj, ok := k.(map[string]interface{}); ok {
+					mdtFlattenJSON(m.Metadata, "", sep, obj)
+				}
+			}
+		}
+		mdtFlattenJSON(m.Data, "", sep, row.Content)
+		container.Metrics = append(container.Metrics, m)
+	}
+	return &container, nil
+}
+
+// mdtMetric creates a metric with the common metadata of a message. The
+// timestamps are in milliseconds, and the row timestamp is used if set.
+func mdtMetric(node, subscription, path string, ts, msgTs uint64) *skogul.Metric {
+	if ts == 0 {
+		ts = msgTs
+	}
+	t := time.UnixMilli(int64(ts))
+	md := make(map[string]interface{})
+	if node != "" {
+		md["node"] = node
+	}
+	if subscription != "" {
+		md["subscription"] = subscription
+	}
+	if path != "" {
+		md["encoding_path"] = path
+	}
+	return &skogul.Metric{
+		Time:     &t,
+		Metadata: md,
+		Data:     make(map[string]interface{}),
+	}
+}
+
+// mdtAdd adds a value to dst, turning it into a list if the name is
+// already present.
+func mdtAdd(dst map[string]interface{}, name string, v interface{}) {
+	old, ok := dst[name]
+	if !ok {
+		dst[name] = v
+		return
+	}
+	if list, ok := old.([]interface{}); ok {
+		dst[name] = append(list, v)
+	} else {
+		dst[name] = []interface{}{old, v}
+	}
+}
+
+// mdtFlattenFields adds the values of fields and their children to dst.
+func mdtFlattenFields(dst map[string]interface{}, prefix, sep string, fields []*telemetry_bis.TelemetryField) {
+	for _, f := range fields {
+		name := prefix + f.Name
+		if len(f.Fields) > 0 {
+			mdtFlattenFields(dst, name+sep, sep, f.Fields)
+		}
+		var v interface{}
+		switch val := f.ValueByType.(type) {
+		case nil:
+			continue
+		case *telemetry_bis.TelemetryField_BytesValue:
+			v = val.BytesValue
+		case *telemetry_bis.TelemetryField_StringValue:
+			v = val.StringValue
+		case *telemetry_bis.TelemetryField_BoolValue:
+			v = val.BoolValue
+		case *telemetry_bis.TelemetryField_Uint32Value:
+			v = uint64(val.Uint32Value)
+		case *telemetry_bis.TelemetryField_Uint64Value:
+			v = val.Uint64Value
+		case *telemetry_bis.TelemetryField_Sint32Value:
+			v = int64(val.Sint32Value)
+		case *telemetry_bis.TelemetryField_Sint64Value:
+			v = val.Sint64Value
+		case *telemetry_bis.TelemetryField_DoubleValue:
+			v = val.DoubleValue
+		case *telemetry_bis.TelemetryField_FloatValue:
+			v = float64(val.FloatValue)
+		}
+		mdtAdd(dst, name, v)
+	}
+}
+
+// mdtFlattenJSON adds the values of obj and nested objects to dst.
+func mdtFlattenJSON(dst map[string]interface{}, prefix, sep string, obj map[string]interface{}) {
+	for k, v := range obj {
+		name := prefix + k
+		switch val := v.(type) {
+		case map[string]interface{}:
+			mdtFlattenJSON(dst, name+sep, sep, val)
+		case []interface{}:
+			for _, e := range val {
+				if o, ok := e.(map[string]interface{}); ok {
+					mdtFlattenJSON(dst, name+sep, sep, o)
+				} else {
+					mdtAdd(dst, name, e)
+				}
+			}
+		default:
+			mdtAdd(dst, name, v)
+		}
+	}
+}
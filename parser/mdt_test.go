@@ -0,0 +1,143 @@
+/*
+ * skogul, Cisco model-driven telemetry parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/cisco-ie/nx-telemetry-proto/telemetry_bis"
+	"github.com/telenornms/skogul/parser"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func mdtField(name string, v interface{}, children ...*telemetry_bis.TelemetryField) *telemetry_bis.TelemetryField {
+	f := &telemetry_bis.TelemetryField{Name: name, Fields: children}
+	switch val := v.(type) {
+	case string:
+		f.ValueByType = &telemetry_bis.TelemetryField_StringValue{StringValue: val}
+	case uint64:
+		f.ValueByType = &telemetry_bis.TelemetryField_Uint64Value{Uint64Value: val}
+	case uint32:
+		f.ValueByType = &telemetry_bis.TelemetryField_Uint32Value{Uint32Value: val}
+	}
+	return f
+}
+
+func TestMDT_kvGPB(t *testing.T) {
+	msg := &telemetry_bis.Telemetry{
+		NodeId:       &telemetry_bis.Telemetry_NodeIdStr{NodeIdStr: "xr1"},
+		Subscription: &telemetry_bis.Telemetry_SubscriptionIdStr{SubscriptionIdStr: "ifstats"},
+		EncodingPath: "Cisco-IOS-XR-infra-statsd-oper:infra-statistics/interfaces/interface/latest/generic-counters",
+		MsgTimestamp: 1600000000000,
+		DataGpbkv: []*telemetry_bis.TelemetryField{
+			{
+				Timestamp: 1600000000123,
+				Fields: []*telemetry_bis.TelemetryField{
+					mdtField("keys", nil, mdtField("interface-name", "GigabitEthernet0/0/0/0")),
+					mdtField("content", nil,
+						mdtField("packets-received", uint64(100)),
+						mdtField("bytes-received", uint64(6400)),
+						mdtField("errors", nil, mdtField("input", uint32(1)), mdtField("output", uint32(2))),
+						mdtField("address", "10.0.0.1"),
+						mdtField("address", "10.0.0.2")),
+				},
+			},
+			{
+				Fields: []*telemetry_bis.TelemetryField{
+					mdtField("keys", nil, mdtField("interface-name", "GigabitEthernet0/0/0/1")),
+					mdtField("content", nil, mdtField("packets-received", uint64(7))),
+				},
+			},
+		},
+	}
+	b, err := proto.Marshal(protoadapt.MessageV2Of(msg))
+	if err != nil {
+		t.Fatalf("Unable to marshal test data: %v", err)
+	}
+	c, err := parser.MDT{}.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Time.UnixMilli() != 1600000000123 {
+		t.Errorf("Expected row timestamp, got %v", m.Time)
+	}
+	if m.Metadata["node"] != "xr1" || m.Metadata["subscription"] != "ifstats" || m.Metadata["encoding_path"] != msg.EncodingPath {
+		t.Errorf("Unexpected metadata: %v", m.Metadata)
+	}
+	if m.Metadata["interface-name"] != "GigabitEthernet0/0/0/0" {
+		t.Errorf("Expected key as metadata, got %v", m.Metadata)
+	}
+	if m.Data["packets-received"] != uint64(100) || m.Data["errors/input"] != uint64(1) || m.Data["errors/output"] != uint64(2) {
+		t.Errorf("Unexpected data: %v", m.Data)
+	}
+	if addr, ok := m.Data["address"].([]interface{}); !ok || len(addr) != 2 || addr[1] != "10.0.0.2" {
+		t.Errorf("Expected repeated field as list, got %v", m.Data["address"])
+	}
+	if c.Metrics[1].Time.UnixMilli() != 1600000000000 {
+		t.Errorf("Expected message timestamp, got %v", c.Metrics[1].Time)
+	}
+
+	sep := parser.MDT{Separator: "__"}
+	c, err = sep.Parse(b)
+	if err != nil || c.Metrics[0].Data["errors__input"] != uint64(1) {
+		t.Errorf("Expected custom separator to be used, got %v (err %v)", c.Metrics[0].Data, err)
+	}
+
+	gpb := &telemetry_bis.Telemetry{DataGpb: &telemetry_bis.TelemetryGPBTable{Row: []*telemetry_bis.TelemetryRowGPB{{Content: []byte{1}}}}}
+	b, _ = proto.Marshal(protoadapt.MessageV2Of(gpb))
+	if _, err := (parser.MDT{}).Parse(b); err == nil {
+		t.Errorf("Expected compact GPB to be rejected")
+	}
+}
+
+func TestMDT_json(t *testing.T) {
+	b := []byte(`{"node_id_str":"xr1","subscription_id_str":"ifstats","encoding_path":"openconfig-interfaces:interfaces/interface","msg_timestamp":1600000000000,
+		"data_json":[
+			{"timestamp":1600000000123,"keys":{"name":"Gi0/0/0/0"},"content":{"state":{"mtu":1514,"counters":{"in-octets":123}},"oper-status":"UP"}},
+			{"timestamp":1600000000124,"keys":[{"name":"Gi0/0/0/1"}],"content":{"state":{"mtu":9000}}}
+		]}`)
+	c, err := parser.MDT{}.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["name"] != "Gi0/0/0/0" || m.Metadata["node"] != "xr1" {
+		t.Errorf("Unexpected metadata: %v", m.Metadata)
+	}
+	if m.Data["state/mtu"] != 1514.0 || m.Data["state/counters/in-octets"] != 123.0 || m.Data["oper-status"] != "UP" {
+		t.Errorf("Unexpected data: %v", m.Data)
+	}
+	if c.Metrics[1].Metadata["name"] != "Gi0/0/0/1" {
+		t.Errorf("Expected keys in list to be used, got %v", c.Metrics[1].Metadata)
+	}
+	if _, err := (parser.MDT{}).Parse([]byte(`{"data_json":`)); err == nil {
+		t.Errorf("Expected error on invalid JSON")
+	}
+}
@@ -0,0 +1,394 @@
+/*
+ * skogul, NetFlow v5/v9 and IPFIX parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+NetFlow parses NetFlow v5, NetFlow v9 and IPFIX packets, as received by
+the UDP receiver. The version is detected from the packet. Each flow
+record becomes a metric.
+
+Fields are named after the IANA IPFIX information elements, e.g.
+"octetDeltaCount" and "sourceIPv4Address", for all versions. Fields we
+don't know the name of are named by number, e.g. "ie42". The address of
+the exporter, the version, the observation domain (the source ID for
+NetFlow v9) and interface information, such as "ingressInterface" and
+"egressInterface", are stored as metadata. Everything else is data.
+
+NetFlow v9 and IPFIX need templates to decode records. Templates are kept
+per exporter and observation domain, and records received before the
+template are dropped. Packets with only templates result in no metrics.
+Options records, e.g. with the sampling interval, are passed on too, with
+"recordType" set to "options" instead of "flow", and the scope fields as
+metadata.
+*/
+type NetFlow struct {
+	lock      sync.Mutex
+	templates map[flowTemplateKey]*flowTemplate
+	stats     netflowStats
+}
+
+type netflowStats struct {
+	Packets         uint64 // Packets received
+	Records         uint64 // Records decoded
+	Templates       uint64 // Templates received
+	MissingTemplate uint64 // Sets skipped because the template is unknown
+}
+
+// flowTemplateKey identifies a template of an exporter.
+type flowTemplateKey struct {
+	source  string
+	version uint16
+	domain  uint32
+	id      uint16
+}
+
+type flowTemplateField struct {
+	id         uint16
+	enterprise uint32
+	length     uint16 // flowVariableLength for variable length IPFIX fields
+	scope      bool
+}
+
+type flowTemplate struct {
+	fields  []flowTemplateField
+	options bool
+}
+
+const flowVariableLength = 65535
+
+// netflowScopes are the NetFlow v9 scope field types, which are not
+// information elements.
+var netflowScopes = map[uint16]string{
+	1: "scopeSystem",
+	2: "scopeInterface",
+	3: "scopeLineCard",
+	4: "scopeCache",
+	5: "scopeTemplate",
+}
+
+// Parse parses a packet without knowing the exporter. Templates are shared
+// by all exporters, so use ParseFrom when possible.
+func (n *NetFlow) Parse(b []byte) (*skogul.Container, error) {
+	return n.ParseFrom(b, "")
+}
+
+// ParseFrom parses a packet from the given exporter.
+func (n *NetFlow) ParseFrom(b []byte, source string) (*skogul.Container, error) {
+	atomic.AddUint64(&n.stats.Packets, 1)
+	if len(b) < 2 {
+		return nil, fmt.Errorf("packet too short")
+	}
+	container := skogul.Container{Metrics: []*skogul.Metric{}}
+	var err error
+	switch version := binary.BigEndian.Uint16(b); version {
+	case 5:
+		err = n.parseV5(&container, b, source)
+	case 9:
+		err = n.parseV9(&container, b, source)
+	case 10:
+		err = n.parseIPFIX(&container, b, source)
+	default:
+		return nil, fmt.Errorf("unsupported NetFlow version %d", version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&n.stats.Records, uint64(len(container.Metrics)))
+	return &container, nil
+}
+
+// metadata returns the metadata common to all records of a packet.
+func (n *NetFlow) metadata(source string, version uint16) map[string]interface{} {
+	md := map[string]interface{}{"version": uint64(version)}
+	if source != "" {
+		md["exporter"] = source
+	}
+	return md
+}
+
+// parseV5 parses a NetFlow v5 packet, which has a fixed format.
+func (n *NetFlow) parseV5(c *skogul.Container, b []byte, source string) error {
+	r := flowReader{b: b}
+	r.u16()
+	count := int(r.u16())
+	r.u32() // sysUptime
+	secs, nsecs := r.u32(), r.u32()
+	r.u32() // sequence
+	engineType, engineID := r.u8(), r.u8()
+	sampling := r.u16()
+	if r.err || len(r.b) < count*48 {
+		return fmt.Errorf("NetFlow v5 packet too short for %d records", count)
+	}
+	t := time.Unix(int64(secs), int64(nsecs))
+	for i := 0; i < count; i++ {
+		md := n.metadata(source, 5)
+		md["engineType"] = uint64(engineType)
+		md["engineId"] = uint64(engineID)
+		data := make(map[string]interface{})
+		data["sourceIPv4Address"] = r.ip(4)
+		data["destinationIPv4Address"] = r.ip(4)
+		data["ipNextHopIPv4Address"] = r.ip(4)
+		md["ingressInterface"] = uint64(r.u16())
+		md["egressInterface"] = uint64(r.u16())
+		data["packetDeltaCount"] = uint64(r.u32())
+		data["octetDeltaCount"] = uint64(r.u32())
+		data["flowStartSysUpTime"] = uint64(r.u32())
+		data["flowEndSysUpTime"] = uint64(r.u32())
+		data["sourceTransportPort"] = uint64(r.u16())
+		data["destinationTransportPort"] = uint64(r.u16())
+		r.u8()
+		data["tcpControlBits"] = uint64(r.u8())
+		data["protocolIdentifier"] = uint64(r.u8())
+		data["ipClassOfService"] = uint64(r.u8())
+		data["bgpSourceAsNumber"] = uint64(r.u16())
+		data["bgpDestinationAsNumber"] = uint64(r.u16())
+		data["sourceIPv4PrefixLength"] = uint64(r.u8())
+		data["destinationIPv4PrefixLength"] = uint64(r.u8())
+		r.u16()
+		if sampling&0x3fff != 0 {
+			data["samplingInterval"] = uint64(sampling & 0x3fff)
+		}
+		c.Metrics = append(c.Metrics, &skogul.Metric{Time: &t, Metadata: md, Data: data})
+	}
+	return nil
+}
+
+// parseV9 parses a NetFlow v9 packet.
+func (n *NetFlow) parseV9(c *skogul.Container, b []byte, source string) error {
+	r := flowReader{b: b}
+	r.u16()
+	r.u16() // count
+	r.u32() // sysUptime
+	secs := r.u32()
+	r.u32() // sequence
+	domain := r.u32()
+	if r.err {
+		return fmt.Errorf("NetFlow v9 header too short")
+	}
+	t := time.Unix(int64(secs), 0)
+	for len(r.b) >= 4 {
+		id, length := r.u16(), int(r.u16())
+		if length < 4 || length-4 > len(r.b) {
+			return fmt.Errorf("invalid NetFlow v9 flowset length %d", length)
+		}
+		set := flowReader{b: r.bytes(length - 4)}
+		key := flowTemplateKey{source: source, version: 9, domain: domain}
+		switch {
+		case id == 0:
+			for len(set.b) >= 4 {
+				key.id = set.u16()
+				tmpl := &flowTemplate{fields: make([]flowTemplateField, set.u16())}
+				for i := range tmpl.fields {
+					tmpl.fields[i] = flowTemplateField{id: set.u16(), length: set.u16()}
+				}
+				if set.err {
+					return fmt.Errorf("truncated NetFlow v9 template")
+				}
+				n.setTemplate(key, tmpl)
+			}
+		case id == 1:
+			for len(set.b) >= 6 {
+				key.id = set.u16()
+				scopeLen, optLen := int(set.u16()), int(set.u16())
+				if scopeLen+optLen == 0 {
+					break
+				}
+				tmpl := &flowTemplate{options: true, fields: make([]flowTemplateField, (scopeLen+optLen)/4)}
+				for i := range tmpl.fields {
+					tmpl.fields[i] = flowTemplateField{id: set.u16(), length: set.u16(), scope: i < scopeLen/4}
+				}
+				if set.err {
+					return fmt.Errorf("truncated NetFlow v9 options template")
+				}
+				n.setTemplate(key, tmpl)
+			}
+		case id >= 256:
+			key.id = id
+			if err := n.parseRecords(c, &set, key, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseIPFIX parses an IPFIX message.
+func (n *NetFlow) parseIPFIX(c *skogul.Container, b []byte, source string) error {
+	r := flowReader{b: b}
+	r.u16()
+	length := int(r.u16())
+	exportTime := r.u32()
+	r.u32() // sequence
+	domain := r.u32()
+	if r.err || length < 16 || length > len(b) {
+		return fmt.Errorf("invalid IPFIX message length %d", length)
+	}
+	r.b = b[16:length]
+	t := time.Unix(int64(exportTime), 0)
+	for len(r.b) >= 4 {
+		id, length := r.u16(), int(r.u16())
+		if length < 4 || length-4 > len(r.b) {
+			return fmt.Errorf("invalid IPFIX set length %d", length)
+		}
+		set := flowReader{b: r.bytes(length - 4)}
+		key := flowTemplateKey{source: source, version: 10, domain: domain}
+		switch {
+		case id == 2 || id == 3:
+			for len(set.b) >= 4 {
+				key.id = set.u16()
+				count := int(set.u16())
+				scopes := 0
+				if id == 3 && count > 0 {
+					scopes = int(set.u16())
+				}
+				if count == 0 {
+					// Template withdrawal
+					n.setTemplate(key, nil)
+					continue
+				}
+				tmpl := &flowTemplate{options: id == 3, fields: make([]flowTemplateField, count)}
+				for i := range tmpl.fields {
+					f := flowTemplateField{id: set.u16(), length: set.u16(), scope: i < scopes}
+					if f.id&0x8000 != 0 {
+						f.id &= 0x7fff
+						f.enterprise = set.u32()
+					}
+					tmpl.fields[i] = f
+				}
+				if set.err {
+					return fmt.Errorf("truncated IPFIX template")
+				}
+				n.setTemplate(key, tmpl)
+			}
+		case id >= 256:
+			key.id = id
+			if err := n.parseRecords(c, &set, key, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setTemplate stores a template, or removes it if tmpl is nil.
+func (n *NetFlow) setTemplate(key flowTemplateKey, tmpl *flowTemplate) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if tmpl == nil {
+		delete(n.templates, key)
+		return
+	}
+	if n.templates == nil {
+		n.templates = make(map[flowTemplateKey]*flowTemplate)
+	}
+	n.templates[key] = tmpl
+	atomic.AddUint64(&n.stats.Templates, 1)
+}
+
+// parseRecords decodes the records of a data set, using the template
+// identified by key. If the template is unknown, the set is skipped.
+func (n *NetFlow) parseRecords(c *skogul.Container, set *flowReader, key flowTemplateKey, t time.Time) error {
+	n.lock.Lock()
+	tmpl := n.templates[key]
+	n.lock.Unlock()
+	if tmpl == nil {
+		atomic.AddUint64(&n.stats.MissingTemplate, 1)
+		return nil
+	}
+	// The shortest possible record, to tell records from padding
+	min := 0
+	for _, f := range tmpl.fields {
+		if f.length == flowVariableLength {
+			min++
+		} else {
+			min += int(f.length)
+		}
+	}
+	if min == 0 {
+		return nil
+	}
+	for len(set.b) >= min {
+		md := n.metadata(key.source, key.version)
+		md["observationDomainId"] = uint64(key.domain)
+		md["recordType"] = "flow"
+		if tmpl.options {
+			md["recordType"] = "options"
+		}
+		data := make(map[string]interface{})
+		for _, f := range tmpl.fields {
+			length := int(f.length)
+			if f.length == flowVariableLength {
+				length = int(set.u8())
+				if length == 255 {
+					length = int(set.u16())
+				}
+			}
+			v := set.bytes(length)
+			if set.err {
+				return fmt.Errorf("truncated record for template %d", key.id)
+			}
+			switch {
+			case f.scope && key.version == 9:
+				name, ok := netflowScopes[f.id]
+				if !ok {
+					name = fmt.Sprintf("scope%d", f.id)
+				}
+				md[name] = flowValue(flowUint, v)
+			case f.scope:
+				flowField(md, md, f.enterprise, f.id, v)
+			default:
+				flowField(md, data, f.enterprise, f.id, v)
+			}
+		}
+		c.Metrics = append(c.Metrics, &skogul.Metric{Time: &t, Metadata: md, Data: data})
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the NetFlow parser.
+func (n *NetFlow) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "parser"
+	metric.Metadata["type"] = "netflow"
+	metric.Metadata["identity"] = skogul.IdentityOf(n)
+
+	metric.Data["packets"] = atomic.LoadUint64(&n.stats.Packets)
+	metric.Data["records"] = atomic.LoadUint64(&n.stats.Records)
+	metric.Data["templates"] = atomic.LoadUint64(&n.stats.Templates)
+	metric.Data["missing_template"] = atomic.LoadUint64(&n.stats.MissingTemplate)
+	return &metric
+}
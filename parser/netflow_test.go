@@ -0,0 +1,203 @@
+/*
+ * skogul, NetFlow parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+// flowPacket encodes the values big-endian, back to back. Slices of
+// values are encoded as a set, prefixed by id and length.
+func flowPacket(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		switch v := v.(type) {
+		case int:
+			panic("use sized integers")
+		case flowSet:
+			content := flowPacket(v.values...)
+			binary.Write(&buf, binary.BigEndian, v.id)
+			binary.Write(&buf, binary.BigEndian, uint16(len(content)+4))
+			buf.Write(content)
+		default:
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+type flowSet struct {
+	id     uint16
+	values []interface{}
+}
+
+func newFlowSet(id uint16, values ...interface{}) flowSet {
+	return flowSet{id, values}
+}
+
+func v9(sets ...interface{}) []byte {
+	header := []interface{}{uint16(9), uint16(len(sets)), uint32(1000), uint32(1700000000), uint32(1), uint32(7)}
+	return flowPacket(append(header, sets...)...)
+}
+
+func TestNetFlow_v5(t *testing.T) {
+	p := parser.NetFlow{}
+	record := []interface{}{
+		[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, []byte{10, 0, 0, 254},
+		uint16(3), uint16(4), uint32(10), uint32(1500), uint32(100), uint32(200),
+		uint16(1234), uint16(80), uint8(0), uint8(0x18), uint8(6), uint8(0),
+		uint16(65001), uint16(65002), uint8(24), uint8(16), uint16(0),
+	}
+	header := []interface{}{uint16(5), uint16(2), uint32(1000), uint32(1700000000), uint32(0), uint32(1), uint8(0), uint8(1), uint16(0x4000 | 100)}
+	b := flowPacket(append(append(header, record...), record...)...)
+	c, err := p.ParseFrom(b, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[1]
+	if m.Metadata["exporter"] != "192.0.2.1" || m.Metadata["ingressInterface"] != uint64(3) || m.Metadata["engineId"] != uint64(1) {
+		t.Errorf("unexpected metadata %v", m.Metadata)
+	}
+	if m.Data["sourceIPv4Address"] != "10.0.0.1" || m.Data["octetDeltaCount"] != uint64(1500) || m.Data["destinationTransportPort"] != uint64(80) {
+		t.Errorf("unexpected data %v", m.Data)
+	}
+	if m.Data["samplingInterval"] != uint64(100) {
+		t.Errorf("expected samplingInterval 100, got %v", m.Data["samplingInterval"])
+	}
+	if m.Time.Unix() != 1700000000 {
+		t.Errorf("unexpected time %v", m.Time)
+	}
+	if _, err := p.Parse(b[:50]); err == nil {
+		t.Errorf("Parse of truncated packet succeeded")
+	}
+}
+
+func TestNetFlow_v9(t *testing.T) {
+	p := parser.NetFlow{}
+	template := newFlowSet(0, uint16(256), uint16(3), uint16(8), uint16(4), uint16(10), uint16(2), uint16(1), uint16(4))
+	data := newFlowSet(256, []byte{10, 0, 0, 1}, uint16(5), uint32(1500), []byte{10, 0, 0, 2}, uint16(6), uint32(3000), uint16(0))
+
+	c, err := p.ParseFrom(v9(data), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	if len(c.Metrics) != 0 {
+		t.Errorf("expected no metrics without template, got %d", len(c.Metrics))
+	}
+
+	c, err = p.ParseFrom(v9(template, data), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, padding ignored, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[1]
+	if m.Metadata["ingressInterface"] != uint64(6) || m.Metadata["observationDomainId"] != uint64(7) || m.Metadata["recordType"] != "flow" {
+		t.Errorf("unexpected metadata %v", m.Metadata)
+	}
+	if m.Data["sourceIPv4Address"] != "10.0.0.2" || m.Data["octetDeltaCount"] != uint64(3000) {
+		t.Errorf("unexpected data %v", m.Data)
+	}
+
+	// Templates are per exporter
+	c, err = p.ParseFrom(v9(data), "192.0.2.2")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	if len(c.Metrics) != 0 {
+		t.Errorf("template of one exporter used for another")
+	}
+	c, err = p.ParseFrom(v9(data), "192.0.2.1")
+	if err != nil || len(c.Metrics) != 2 {
+		t.Errorf("cached template not used, got %v, %v", c, err)
+	}
+
+	options := newFlowSet(1, uint16(257), uint16(4), uint16(4), uint16(1), uint16(4), uint16(34), uint16(4))
+	optData := newFlowSet(257, uint32(42), uint32(1000))
+	c, err = p.ParseFrom(v9(options, optData), "192.0.2.1")
+	if err != nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 options record, got %v, %v", c, err)
+	}
+	m = c.Metrics[0]
+	if m.Metadata["recordType"] != "options" || m.Metadata["scopeSystem"] != uint64(42) || m.Data["samplingInterval"] != uint64(1000) {
+		t.Errorf("unexpected options record %v %v", m.Metadata, m.Data)
+	}
+
+	stats := p.GetStats()
+	if stats.Data["missing_template"] != uint64(2) || stats.Data["records"] != uint64(5) {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+}
+
+func TestNetFlow_ipfix(t *testing.T) {
+	p := parser.NetFlow{}
+	ipfix := func(sets ...interface{}) []byte {
+		content := flowPacket(sets...)
+		return flowPacket(append([]interface{}{uint16(10), uint16(len(content) + 16), uint32(1700000000), uint32(1), uint32(3)}, content)...)
+	}
+	// sourceIPv4Address, interfaceName (variable length), an
+	// enterprise-specific field and packetDeltaCount
+	template := newFlowSet(2, uint16(300), uint16(4),
+		uint16(8), uint16(4),
+		uint16(82), uint16(65535),
+		uint16(0x8000|1), uint16(2), uint32(9),
+		uint16(2), uint16(8))
+	data := newFlowSet(300,
+		[]byte{10, 0, 0, 1}, uint8(5), []byte("ge-0/"), uint16(17), uint64(99),
+		[]byte{10, 0, 0, 2}, uint8(255), uint16(3), []byte("et1"), uint16(18), uint64(100))
+	c, err := p.ParseFrom(ipfix(template, data), "2001:db8::1")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[1]
+	if m.Metadata["interfaceName"] != "et1" || m.Metadata["version"] != uint64(10) || m.Metadata["exporter"] != "2001:db8::1" {
+		t.Errorf("unexpected metadata %v", m.Metadata)
+	}
+	if m.Data["sourceIPv4Address"] != "10.0.0.2" || m.Data["ie9.1"] != uint64(18) || m.Data["packetDeltaCount"] != uint64(100) {
+		t.Errorf("unexpected data %v", m.Data)
+	}
+
+	// Withdrawal removes the template
+	withdraw := newFlowSet(2, uint16(300), uint16(0))
+	c, err = p.ParseFrom(ipfix(withdraw, data), "2001:db8::1")
+	if err != nil || len(c.Metrics) != 0 {
+		t.Errorf("withdrawn template used, got %v, %v", c, err)
+	}
+
+	if _, err := p.Parse([]byte{0, 10, 0, 100}); err == nil {
+		t.Errorf("Parse of invalid message succeeded")
+	}
+	if _, err := p.Parse([]byte{0, 8, 0, 0}); err == nil {
+		t.Errorf("Parse of unsupported version succeeded")
+	}
+}
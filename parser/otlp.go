@@ -0,0 +1,244 @@
+/*
+ * skogul, OpenTelemetry OTLP metrics parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/telenornms/skogul"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+OTLP parses an OpenTelemetry ExportMetricsServiceRequest, as sent to
+/v1/metrics by OTLP/HTTP exporters, or passed on by the otlp receiver.
+Both the protobuf and the JSON encoding are accepted, and which is used is
+detected automatically.
+
+Each data point becomes a metric. The attributes of the resource, the
+instrumentation scope and the data point are stored as metadata, in that
+order, so data point attributes take precedence. The name and version of
+the scope are stored as "otel.scope.name" and "otel.scope.version".
+
+The name of the metric is stored in the NameField metadata field, and the
+type in "type": gauge, sum, histogram or summary. The unit, if any, is
+stored in "unit". For sums and histograms, "temporality" is either delta
+or cumulative, and sums also have "monotonic". These fields are set after
+the attributes, so an attribute with the same name is ignored.
+
+Gauges and sums have a single data field, named by ValueField.
+Histograms have "count", "sum", "min" and "max", along with "buckets",
+which maps the upper bound of each bucket ("+Inf" for the last) to the
+number of values in that bucket. Summaries have "count", "sum" and
+"quantiles", which maps each quantile to its value.
+
+Exponential histograms and exemplars are ignored.
+*/
+type OTLP struct {
+	NameField  string `doc:"Metadata field to store the metric name in. Defaults to \"name\"."`
+	ValueField string `doc:"Data field to store the value of gauges and sums in. Defaults to \"value\"."`
+}
+
+// otlpTemporality maps aggregation temporality to what we use in metadata.
+var otlpTemporality = map[metricspb.AggregationTemporality]string{
+	metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:      "delta",
+	metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE: "cumulative",
+}
+
+// Parse parses an ExportMetricsServiceRequest.
+func (x OTLP) Parse(b []byte) (*skogul.Container, error) {
+	nameField := x.NameField
+	if nameField == "" {
+		nameField = "name"
+	}
+	valueField := x.ValueField
+	if valueField == "" {
+		valueField = "value"
+	}
+	req := colmetricspb.ExportMetricsServiceRequest{}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, &req); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal OTLP JSON: %w", err)
+		}
+	} else if err := proto.Unmarshal(b, &req); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal OTLP protobuf: %w", err)
+	}
+	container := skogul.Container{}
+	for _, rm := range req.ResourceMetrics {
+		resource := make(map[string]interface{})
+		otlpAttributes(resource, rm.GetResource().GetAttributes())
+		for _, sm := range rm.ScopeMetrics {
+			scope := make(map[string]interface{}, len(resource))
+			for k, v := range resource {
+				scope[k] = v
+			}
+			if s := sm.Scope; s != nil {
+				otlpAttributes(scope, s.Attributes)
+				if s.Name != "" {
+					scope["otel.scope.name"] = s.Name
+				}
+				if s.Version != "" {
+					scope["otel.scope.version"] = s.Version
+				}
+			}
+			for _, m := range sm.Metrics {
+				// base is set after the attributes, so they can't
+				// overwrite the name, type and such.
+				base := make(map[string]interface{}, 5)
+				base[nameField] = m.Name
+				if m.Unit != "" {
+					base["unit"] = m.Unit
+				}
+				metric := func(attrs []*commonpb.KeyValue, ts uint64, data map[string]interface{}) {
+					md := make(map[string]interface{}, len(scope)+len(attrs)+len(base))
+					for k, v := range scope {
+						md[k] = v
+					}
+					otlpAttributes(md, attrs)
+					for k, v := range base {
+						md[k] = v
+					}
+					t := time.Unix(0, int64(ts))
+					container.Metrics = append(container.Metrics, &skogul.Metric{
+						Time:     &t,
+						Metadata: md,
+						Data:     data,
+					})
+				}
+				switch d := m.Data.(type) {
+				case *metricspb.Metric_Gauge:
+					base["type"] = "gauge"
+					for _, dp := range d.Gauge.DataPoints {
+						metric(dp.Attributes, dp.TimeUnixNano, map[string]interface{}{valueField: otlpNumber(dp)})
+					}
+				case *metricspb.Metric_Sum:
+					base["type"] = "sum"
+					base["monotonic"] = d.Sum.IsMonotonic
+					if t, ok := otlpTemporality[d.Sum.AggregationTemporality]; ok {
+						base["temporality"] = t
+					}
+					for _, dp := range d.Sum.DataPoints {
+						metric(dp.Attributes, dp.TimeUnixNano, map[string]interface{}{valueField: otlpNumber(dp)})
+					}
+				case *metricspb.Metric_Histogram:
+					base["type"] = "histogram"
+					if t, ok := otlpTemporality[d.Histogram.AggregationTemporality]; ok {
+						base["temporality"] = t
+					}
+					for _, dp := range d.Histogram.DataPoints {
+						metric(dp.Attributes, dp.TimeUnixNano, otlpHistogram(dp))
+					}
+				case *metricspb.Metric_Summary:
+					base["type"] = "summary"
+					for _, dp := range d.Summary.DataPoints {
+						quantiles := make(map[string]interface{}, len(dp.QuantileValues))
+						for _, q := range dp.QuantileValues {
+							quantiles[strconv.FormatFloat(q.Quantile, 'g', -1, 64)] = q.Value
+						}
+						metric(dp.Attributes, dp.TimeUnixNano, map[string]interface{}{
+							"count":     dp.Count,
+							"sum":       dp.Sum,
+							"quantiles": quantiles,
+						})
+					}
+				}
+			}
+		}
+	}
+	return &container, nil
+}
+
+// otlpNumber returns the value of a number data point.
+func otlpNumber(dp *metricspb.NumberDataPoint) interface{} {
+	switch v := dp.Value.(type) {
+	case *metricspb.NumberDataPoint_AsInt:
+		return v.AsInt
+	case *metricspb.NumberDataPoint_AsDouble:
+		return v.AsDouble
+	}
+	return nil
+}
+
+// otlpHistogram returns the data fields of a histogram data point.
+func otlpHistogram(dp *metricspb.HistogramDataPoint) map[string]interface{} {
+	data := map[string]interface{}{"count": dp.Count}
+	if dp.Sum != nil {
+		data["sum"] = *dp.Sum
+	}
+	if dp.Min != nil {
+		data["min"] = *dp.Min
+	}
+	if dp.Max != nil {
+		data["max"] = *dp.Max
+	}
+	buckets := make(map[string]interface{}, len(dp.BucketCounts))
+	for i, n := range dp.BucketCounts {
+		bound := "+Inf"
+		if i < len(dp.ExplicitBounds) {
+			bound = strconv.FormatFloat(dp.ExplicitBounds[i], 'g', -1, 64)
+		}
+		buckets[bound] = n
+	}
+	data["buckets"] = buckets
+	return data
+}
+
+// otlpAttributes adds attributes to md.
+func otlpAttributes(md map[string]interface{}, attrs []*commonpb.KeyValue) {
+	for _, kv := range attrs {
+		md[kv.Key] = otlpValue(kv.Value)
+	}
+}
+
+// otlpValue converts an attribute value.
+func otlpValue(v *commonpb.AnyValue) interface{} {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return val.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return val.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return val.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return val.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		list := make([]interface{}, 0, len(val.ArrayValue.Values))
+		for _, e := range val.ArrayValue.Values {
+			list = append(list, otlpValue(e))
+		}
+		return list
+	case *commonpb.AnyValue_KvlistValue:
+		obj := make(map[string]interface{}, len(val.KvlistValue.Values))
+		otlpAttributes(obj, val.KvlistValue.Values)
+		return obj
+	}
+	return nil
+}
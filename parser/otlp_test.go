@@ -0,0 +1,130 @@
+/*
+ * skogul, OpenTelemetry OTLP metrics parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpString(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+func TestOTLP(t *testing.T) {
+	sum, min, max := 12.5, 0.1, 9.0
+	req := &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpString("service.name", "api"), otlpString("host", "resource")}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{
+			Scope: &commonpb.InstrumentationScope{Name: "lib", Version: "1.0"},
+			Metrics: []*metricspb.Metric{
+				{Name: "temp", Unit: "Cel", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+					{TimeUnixNano: 1600000000000000000, Attributes: []*commonpb.KeyValue{otlpString("host", "a"), otlpString("name", "evil"), otlpString("type", "evil")}, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5}},
+					{TimeUnixNano: 1600000000000000000, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 22}},
+				}}}},
+				{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					IsMonotonic:            true,
+					DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 42}}},
+				}}},
+				{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					DataPoints: []*metricspb.HistogramDataPoint{{
+						Count: 5, Sum: &sum, Min: &min, Max: &max,
+						ExplicitBounds: []float64{0.5, 1},
+						BucketCounts:   []uint64{1, 3, 1},
+					}},
+				}}},
+				{Name: "size", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{
+					Count: 2, Sum: 3,
+					QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.5, Value: 1.5}},
+				}}}}},
+			},
+		}},
+	}}}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("Unable to marshal test data: %v", err)
+	}
+	c, err := parser.OTLP{}.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(c.Metrics) != 5 {
+		t.Fatalf("Expected 5 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["name"] != "temp" || m.Metadata["type"] != "gauge" || m.Metadata["unit"] != "Cel" {
+		t.Errorf("Unexpected metadata: %v", m.Metadata)
+	}
+	if m.Metadata["service.name"] != "api" || m.Metadata["otel.scope.name"] != "lib" || m.Metadata["otel.scope.version"] != "1.0" {
+		t.Errorf("Expected resource and scope metadata, got %v", m.Metadata)
+	}
+	if m.Metadata["host"] != "a" || c.Metrics[1].Metadata["host"] != "resource" {
+		t.Errorf("Expected data point attribute to take precedence, got %v and %v", m.Metadata["host"], c.Metrics[1].Metadata["host"])
+	}
+	if m.Data["value"] != 21.5 || m.Time.UnixNano() != 1600000000000000000 {
+		t.Errorf("Unexpected data or time: %v %v", m.Data, m.Time)
+	}
+	m = c.Metrics[2]
+	if m.Data["value"] != int64(42) || m.Metadata["temporality"] != "cumulative" || m.Metadata["monotonic"] != true {
+		t.Errorf("Unexpected sum: %v %v", m.Metadata, m.Data)
+	}
+	m = c.Metrics[3]
+	buckets, _ := m.Data["buckets"].(map[string]interface{})
+	if m.Data["count"] != uint64(5) || m.Data["sum"] != 12.5 || m.Data["max"] != 9.0 || m.Metadata["temporality"] != "delta" {
+		t.Errorf("Unexpected histogram: %v %v", m.Metadata, m.Data)
+	}
+	if buckets["0.5"] != uint64(1) || buckets["1"] != uint64(3) || buckets["+Inf"] != uint64(1) {
+		t.Errorf("Unexpected buckets: %v", buckets)
+	}
+	m = c.Metrics[4]
+	if q, _ := m.Data["quantiles"].(map[string]interface{}); m.Metadata["type"] != "summary" || q["0.5"] != 1.5 {
+		t.Errorf("Unexpected summary: %v %v", m.Metadata, m.Data)
+	}
+}
+
+func TestOTLP_json(t *testing.T) {
+	b := []byte(`{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+		"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,
+		"dataPoints":[{"timeUnixNano":"1600000000000000000","asInt":"7","attributes":[{"key":"code","value":{"intValue":"200"}}]}]}}]}]}]}`)
+	c, err := parser.OTLP{NameField: "metric", ValueField: "v"}.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(c.Metrics) != 1 {
+		t.Fatalf("Expected 1 metric, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["metric"] != "requests" || m.Metadata["code"] != int64(200) || m.Data["v"] != int64(7) {
+		t.Errorf("Unexpected metric: %v %v", m.Metadata, m.Data)
+	}
+	if _, err := (parser.OTLP{}).Parse([]byte(`{"resourceMetrics":`)); err == nil {
+		t.Errorf("Expected error on invalid JSON")
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * skogul, prometheus remote write parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/telenornms/skogul"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the remote write protocol, see prompb/remote.proto and
+// prompb/types.proto in the Prometheus source. Only the fields we use are
+// listed, everything else is skipped.
+const (
+	rwWriteRequestTimeseries = 1
+	rwTimeSeriesLabels       = 1
+	rwTimeSeriesSamples      = 2
+	rwLabelName              = 1
+	rwLabelValue             = 2
+	rwSampleValue            = 1
+	rwSampleTimestamp        = 2
+)
+
+/*
+PrometheusRemoteWrite parses the body of a Prometheus remote write request,
+which is a snappy-compressed protocol buffer WriteRequest. Each sample
+becomes a separate metric, with the labels of the time series as metadata
+and the sample value as data. The metric name, the __name__ label, is
+stored as a regular metadata field, named by NameField.
+
+Exemplars, native histograms and metric metadata are ignored. Samples
+that are not finite numbers, including the stale markers Prometheus sends
+when a series disappears, are dropped, since they can't be represented in
+e.g. JSON.
+
+Requests are always snappy-compressed, so the parser decompresses them
+itself, and the HTTP receiver leaves the snappy Content-Encoding for it to
+handle. Requests larger than MaxSize when decompressed are rejected before
+decompressing them.
+
+Typically used with the HTTP receiver, by pointing a path at a handler
+using this parser, then configuring Prometheus with remote_write to that
+path.
+*/
+type PrometheusRemoteWrite struct {
+	NameField  string `doc:"Metadata field to store the metric name (the __name__ label) in. Defaults to \"name\"."`
+	ValueField string `doc:"Data field to store the sample value in. Defaults to \"value\"."`
+	MaxSize    int    `doc:"Maximum size of a decompressed request, in bytes. Defaults to 32MiB."`
+}
+
+// rwMaxSize is the default maximum size of a decompressed request, the
+// same as Prometheus uses.
+const rwMaxSize = 32 << 20
+
+// rwSeries is a single time series being decoded.
+type rwSeries struct {
+	labels  map[string]interface{}
+	samples []rwSample
+}
+
+type rwSample struct {
+	value     float64
+	timestamp int64
+}
+
+// Parse decompresses and decodes a remote write request.
+func (rw PrometheusRemoteWrite) Parse(b []byte) (*skogul.Container, error) {
+	nameField := rw.NameField
+	if nameField == "" {
+		nameField = "name"
+	}
+	valueField := rw.ValueField
+	if valueField == "" {
+		valueField = "value"
+	}
+	maxSize := rw.MaxSize
+	if maxSize == 0 {
+		maxSize = rwMaxSize
+	}
+	n, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress remote write request: %w", err)
+	}
+	if n > maxSize {
+		return nil, fmt.Errorf("remote write request is %d bytes decompressed, larger than %d", n, maxSize)
+	}
+	buf, err := snappy.Decode(nil, b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress remote write request: %w", err)
+	}
+	return rw.decode(buf, nameField, valueField)
+}
+
+// Compression tells receivers to leave snappy compression to the parser.
+func (rw PrometheusRemoteWrite) Compression() string {
+	return "snappy"
+}
+
+// decode decodes an uncompressed remote write request.
+func (rw PrometheusRemoteWrite) decode(buf []byte, nameField, valueField string) (*skogul.Container, error) {
+	container := skogul.Container{}
+	err := rwFields(buf, func(num protowire.Number, v []byte) error {
+		if num != rwWriteRequestTimeseries {
+			return nil
+		}
+		series, err := rwParseSeries(v)
+		if err != nil {
+			return err
+		}
+		if name, ok := series.labels["__name__"]; ok {
+			delete(series.labels, "__name__")
+			series.labels[nameField] = name
+		}
+		for _, s := range series.samples {
+			if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+				continue
+			}
+			md := make(map[string]interface{}, len(series.labels))
+			for k, v := range series.labels {
+				md[k] = v
+			}
+			t := time.UnixMilli(s.timestamp)
+			container.Metrics = append(container.Metrics, &skogul.Metric{
+				Time:     &t,
+				Metadata: md,
+				Data:     map[string]interface{}{valueField: s.value},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode remote write request: %w", err)
+	}
+	return &container, nil
+}
+
+// rwParseSeries decodes a TimeSeries message.
+func rwParseSeries(b []byte) (rwSeries, error) {
+	series := rwSeries{labels: make(map[string]interface{})}
+	err := rwFields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case rwTimeSeriesLabels:
+			var name, value string
+			err := rwFields(v, func(num protowire.Number, v []byte) error {
+				switch num {
+				case rwLabelName:
+					name = string(v)
+				case rwLabelValue:
+					value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			series.labels[name] = value
+		case rwTimeSeriesSamples:
+			var s rwSample
+			err := rwFields(v, func(num protowire.Number, v []byte) error {
+				switch num {
+				case rwSampleValue:
+					s.value = math.Float64frombits(rwFixed64(v))
+				case rwSampleTimestamp:
+					s.timestamp = int64(rwVarint(v))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			series.samples = append(series.samples, s)
+		}
+		return nil
+	})
+	return series, err
+}
+
+// rwFields calls fn for each field of the protobuf message in b. For
+// length-delimited fields, v is the content. For varint and fixed fields,
+// v is the raw encoding, to be decoded with rwVarint or rwFixed64.
+func rwFields(b []byte, fn func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rwVarint(v []byte) uint64 {
+	x, _ := protowire.ConsumeVarint(v)
+	return x
+}
+
+func rwFixed64(v []byte) uint64 {
+	x, _ := protowire.ConsumeFixed64(v)
+	return x
+}
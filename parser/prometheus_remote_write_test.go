@@ -0,0 +1,148 @@
+/*
+ * skogul, prometheus remote write parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"encoding/json"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/telenornms/skogul/parser"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// rwMessage appends a length-delimited field to b.
+func rwMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func rwLabel(name, value string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func rwSample(value float64, ts int64) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(ts))
+}
+
+func TestPrometheusRemoteWrite(t *testing.T) {
+	var series []byte
+	series = rwMessage(series, 1, rwLabel("__name__", "up"))
+	series = rwMessage(series, 1, rwLabel("job", "node"))
+	series = rwMessage(series, 2, rwSample(1, 1600000000000))
+	series = rwMessage(series, 2, rwSample(0.5, 1600000015000))
+	var req []byte
+	req = rwMessage(req, 1, series)
+	// An unknown field, which should be ignored
+	req = protowire.AppendTag(req, 15, protowire.VarintType)
+	req = protowire.AppendVarint(req, 42)
+
+	c, err := parser.PrometheusRemoteWrite{}.Parse(snappy.Encode(nil, req))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[1]
+	if m.Metadata["name"] != "up" || m.Metadata["job"] != "node" {
+		t.Errorf("Unexpected metadata: %v", m.Metadata)
+	}
+	if _, ok := m.Metadata["__name__"]; ok {
+		t.Errorf("__name__ should be renamed, got %v", m.Metadata)
+	}
+	if m.Data["value"] != 0.5 {
+		t.Errorf("Expected value 0.5, got %v", m.Data["value"])
+	}
+	if m.Time.UnixMilli() != 1600000015000 {
+		t.Errorf("Unexpected timestamp %v", m.Time)
+	}
+	m.Metadata["job"] = "changed"
+	if c.Metrics[0].Metadata["job"] != "node" {
+		t.Errorf("Metrics share metadata")
+	}
+
+	c, err = parser.PrometheusRemoteWrite{NameField: "metric", ValueField: "v"}.Parse(snappy.Encode(nil, req))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if c.Metrics[0].Metadata["metric"] != "up" || c.Metrics[0].Data["v"] != 1.0 {
+		t.Errorf("NameField/ValueField not respected: %v", c.Metrics[0])
+	}
+
+	if _, err = (parser.PrometheusRemoteWrite{}).Parse(req); err == nil {
+		t.Errorf("Parse() of uncompressed request succeeded")
+	}
+	if _, err = (parser.PrometheusRemoteWrite{MaxSize: len(req) - 1}).Parse(snappy.Encode(nil, req)); err == nil {
+		t.Errorf("Parse() of request larger than MaxSize succeeded")
+	}
+}
+
+func TestPrometheusRemoteWrite_nonFinite(t *testing.T) {
+	var series []byte
+	series = rwMessage(series, 1, rwLabel("__name__", "up"))
+	series = rwMessage(series, 2, rwSample(1, 1600000000000))
+	// Stale marker, as sent by Prometheus when a series disappears
+	series = rwMessage(series, 2, rwSample(math.Float64frombits(0x7ff0000000000002), 1600000015000))
+	series = rwMessage(series, 2, rwSample(math.NaN(), 1600000030000))
+	series = rwMessage(series, 2, rwSample(math.Inf(1), 1600000045000))
+	series = rwMessage(series, 2, rwSample(math.Inf(-1), 1600000060000))
+	series = rwMessage(series, 2, rwSample(2, 1600000075000))
+	c, err := parser.PrometheusRemoteWrite{}.Parse(snappy.Encode(nil, rwMessage(nil, 1, series)))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 || c.Metrics[0].Data["value"] != 1.0 || c.Metrics[1].Data["value"] != 2.0 {
+		t.Errorf("Expected only the finite samples, got %v", c.Metrics)
+	}
+	if _, err := json.Marshal(c); err != nil {
+		t.Errorf("Unable to encode parsed container as JSON: %v", err)
+	}
+}
+
+func TestPrometheusRemoteWrite_bad(t *testing.T) {
+	p := parser.PrometheusRemoteWrite{}
+	if _, err := p.Parse([]byte("not snappy at all")); err == nil {
+		t.Errorf("Expected error parsing uncompressed garbage")
+	}
+	// Claims to be 4GB decompressed
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := p.Parse([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0x00}); err == nil {
+		t.Errorf("Expected error parsing huge snappy length")
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("Parsing huge snappy length allocated %d bytes", alloc)
+	}
+	if _, err := p.Parse(snappy.Encode(nil, []byte{0x0a, 0xff})); err == nil {
+		t.Errorf("Expected error parsing truncated protobuf")
+	}
+}
@@ -287,7 +287,7 @@ func (x *ProtoBuf) GetStats() *skogul.Metric {
 	}
 	metric.Metadata["component"] = "parser"
 	metric.Metadata["type"] = "protobuf"
-	metric.Metadata["identity"] = skogul.Identity[x]
+	metric.Metadata["identity"] = skogul.IdentityOf(x)
 
 	// Ensure we init the stats struct in case we havent received a message yet.
 	x.once.Do(x.initStats)
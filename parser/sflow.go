@@ -0,0 +1,328 @@
+/*
+ * skogul, sFlow v5 parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/telenornms/skogul"
+)
+
+// sFlow sample and record formats, see https://sflow.org/sflow_version_5.txt
+const (
+	sflowFlowSample            = 1
+	sflowCounterSample         = 2
+	sflowExpandedFlowSample    = 3
+	sflowExpandedCounterSample = 4
+
+	sflowRawHeader       = 1
+	sflowEthernetFrame   = 2
+	sflowIPv4            = 3
+	sflowIPv6            = 4
+	sflowExtendedSwitch  = 1001
+	sflowExtendedRouter  = 1002
+	sflowGenericCounters = 1
+	sflowEthernetCounter = 2
+	sflowProcessor       = 1001
+)
+
+/*
+SFlow parses sFlow v5 datagrams, as received by the UDP receiver. Each
+flow sample and counter sample becomes a metric, with "sampleType" set to
+"flow" or "counter".
+
+The agent address, the address of the exporter, the sub-agent and the
+data source of the sample are stored as metadata, along with the input
+and output interfaces of flow samples and the ifIndex of counter samples.
+
+Flow samples have the sampling rate, sample pool and drops as data, and
+the fields of the flow records we know, named after the equivalent IPFIX
+information elements, e.g. "sourceIPv4Address" and "destinationTransportPort".
+Raw packet headers are decoded as Ethernet, with VLAN tags, IPv4 or IPv6,
+and TCP or UDP. Counter samples have the generic interface counters, the
+Ethernet interface counters and the processor counters as data, named as
+in the sFlow specification, e.g. "ifInOctets".
+
+Records of other formats, including all enterprise-specific formats, are
+skipped.
+*/
+type SFlow struct{}
+
+// ParseFrom parses a datagram from the given exporter.
+func (x SFlow) ParseFrom(b []byte, source string) (*skogul.Container, error) {
+	r := flowReader{b: b}
+	if v := r.u32(); v != 5 {
+		return nil, fmt.Errorf("unsupported sFlow version %d", v)
+	}
+	agent := x.address(&r)
+	subAgent := r.u32()
+	r.u32() // sequence
+	r.u32() // uptime
+	count := int(r.u32())
+	if r.err {
+		return nil, fmt.Errorf("sFlow datagram header too short")
+	}
+	now := skogul.Now()
+	container := skogul.Container{Metrics: []*skogul.Metric{}}
+	for i := 0; i < count; i++ {
+		format, length := r.u32(), int(r.u32())
+		if r.err || length > len(r.b) {
+			return nil, fmt.Errorf("sFlow sample %d truncated", i)
+		}
+		sample := flowReader{b: r.bytes(length)}
+		md := map[string]interface{}{"agent": agent, "subAgentId": uint64(subAgent)}
+		if source != "" {
+			md["exporter"] = source
+		}
+		data := make(map[string]interface{})
+		var err error
+		switch format {
+		case sflowFlowSample, sflowExpandedFlowSample:
+			md["sampleType"] = "flow"
+			err = x.flowSample(&sample, format == sflowExpandedFlowSample, md, data)
+		case sflowCounterSample, sflowExpandedCounterSample:
+			md["sampleType"] = "counter"
+			err = x.counterSample(&sample, format == sflowExpandedCounterSample, md, data)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		container.Metrics = append(container.Metrics, &skogul.Metric{Time: &now, Metadata: md, Data: data})
+	}
+	return &container, nil
+}
+
+// Parse parses a datagram without knowing the exporter.
+func (x SFlow) Parse(b []byte) (*skogul.Container, error) {
+	return x.ParseFrom(b, "")
+}
+
+// address reads an address, prefixed by its type.
+func (x SFlow) address(r *flowReader) string {
+	switch r.u32() {
+	case 1:
+		return r.ip(4)
+	case 2:
+		return r.ip(16)
+	}
+	return ""
+}
+
+// source stores the data source of a sample.
+func (x SFlow) source(md map[string]interface{}, typ, index uint32) {
+	md["sourceIdType"] = uint64(typ)
+	md["sourceIdIndex"] = uint64(index)
+}
+
+// flowSample decodes a flow sample.
+func (x SFlow) flowSample(r *flowReader, expanded bool, md, data map[string]interface{}) error {
+	r.u32() // sequence
+	if expanded {
+		x.source(md, r.u32(), r.u32())
+	} else {
+		id := r.u32()
+		x.source(md, id>>24, id&0xffffff)
+	}
+	data["samplingRate"] = uint64(r.u32())
+	data["samplePool"] = uint64(r.u32())
+	data["drops"] = uint64(r.u32())
+	if expanded {
+		r.u32() // input format
+		md["inputInterface"] = uint64(r.u32())
+		r.u32() // output format
+		md["outputInterface"] = uint64(r.u32())
+	} else {
+		md["inputInterface"] = uint64(r.u32() & 0x3fffffff)
+		md["outputInterface"] = uint64(r.u32() & 0x3fffffff)
+	}
+	count := int(r.u32())
+	for i := 0; i < count && !r.err; i++ {
+		format, length := r.u32(), int(r.u32())
+		if r.err || length > len(r.b) {
+			return fmt.Errorf("sFlow flow record %d truncated", format)
+		}
+		rec := flowReader{b: r.bytes(length)}
+		switch format {
+		case sflowRawHeader:
+			protocol := rec.u32()
+			data["frameLength"] = uint64(rec.u32())
+			rec.u32() // stripped
+			header := rec.bytes(int(rec.u32()))
+			if protocol == 1 && !rec.err {
+				x.ethernet(header, data)
+			}
+		case sflowEthernetFrame:
+			rec.u32() // length
+			data["sourceMacAddress"] = net.HardwareAddr(rec.bytes(8)[:6]).String()
+			data["destinationMacAddress"] = net.HardwareAddr(rec.bytes(8)[:6]).String()
+			data["ethernetType"] = uint64(rec.u32())
+		case sflowIPv4, sflowIPv6:
+			n := 4
+			if format == sflowIPv6 {
+				n = 16
+			}
+			data["ipTotalLength"] = uint64(rec.u32())
+			data["protocolIdentifier"] = uint64(rec.u32())
+			if n == 4 {
+				data["sourceIPv4Address"] = rec.ip(4)
+				data["destinationIPv4Address"] = rec.ip(4)
+			} else {
+				data["sourceIPv6Address"] = rec.ip(16)
+				data["destinationIPv6Address"] = rec.ip(16)
+			}
+			data["sourceTransportPort"] = uint64(rec.u32())
+			data["destinationTransportPort"] = uint64(rec.u32())
+			data["tcpControlBits"] = uint64(rec.u32())
+			data["ipClassOfService"] = uint64(rec.u32())
+		case sflowExtendedSwitch:
+			data["vlanId"] = uint64(rec.u32())
+			rec.u32() // priority
+			data["postVlanId"] = uint64(rec.u32())
+		case sflowExtendedRouter:
+			if hop := x.address(&rec); hop != "" {
+				data["ipNextHopAddress"] = hop
+			}
+			data["sourcePrefixLength"] = uint64(rec.u32())
+			data["destinationPrefixLength"] = uint64(rec.u32())
+		}
+		if rec.err {
+			return fmt.Errorf("sFlow flow record %d truncated", format)
+		}
+	}
+	if r.err {
+		return fmt.Errorf("sFlow flow sample truncated")
+	}
+	return nil
+}
+
+// ethernet decodes the start of a sampled Ethernet frame. It stops
+// quietly at the end of the header, since it's usually cut off.
+func (x SFlow) ethernet(b []byte, data map[string]interface{}) {
+	if len(b) < 14 {
+		return
+	}
+	data["destinationMacAddress"] = net.HardwareAddr(b[0:6]).String()
+	data["sourceMacAddress"] = net.HardwareAddr(b[6:12]).String()
+	etype := binary.BigEndian.Uint16(b[12:14])
+	b = b[14:]
+	for etype == 0x8100 && len(b) >= 4 {
+		data["vlanId"] = uint64(binary.BigEndian.Uint16(b) & 0xfff)
+		etype = binary.BigEndian.Uint16(b[2:4])
+		b = b[4:]
+	}
+	data["ethernetType"] = uint64(etype)
+	var proto uint8
+	switch {
+	case etype == 0x0800 && len(b) >= 20:
+		ihl := int(b[0]&0xf) * 4
+		data["ipVersion"] = uint64(4)
+		data["ipClassOfService"] = uint64(b[1])
+		data["ipTotalLength"] = uint64(binary.BigEndian.Uint16(b[2:4]))
+		data["ipTTL"] = uint64(b[8])
+		proto = b[9]
+		data["sourceIPv4Address"] = net.IP(b[12:16]).String()
+		data["destinationIPv4Address"] = net.IP(b[16:20]).String()
+		if ihl < 20 || len(b) < ihl {
+			return
+		}
+		b = b[ihl:]
+	case etype == 0x86dd && len(b) >= 40:
+		data["ipVersion"] = uint64(6)
+		data["ipClassOfService"] = uint64(binary.BigEndian.Uint16(b[0:2]) >> 4 & 0xff)
+		data["ipTotalLength"] = uint64(binary.BigEndian.Uint16(b[4:6])) + 40
+		proto = b[6]
+		data["ipTTL"] = uint64(b[7])
+		data["sourceIPv6Address"] = net.IP(b[8:24]).String()
+		data["destinationIPv6Address"] = net.IP(b[24:40]).String()
+		b = b[40:]
+	default:
+		return
+	}
+	data["protocolIdentifier"] = uint64(proto)
+	if (proto == 6 || proto == 17) && len(b) >= 4 {
+		data["sourceTransportPort"] = uint64(binary.BigEndian.Uint16(b[0:2]))
+		data["destinationTransportPort"] = uint64(binary.BigEndian.Uint16(b[2:4]))
+		if proto == 6 && len(b) >= 14 {
+			data["tcpControlBits"] = uint64(b[13])
+		}
+	}
+}
+
+// counterSample decodes a counter sample.
+func (x SFlow) counterSample(r *flowReader, expanded bool, md, data map[string]interface{}) error {
+	r.u32() // sequence
+	if expanded {
+		x.source(md, r.u32(), r.u32())
+	} else {
+		id := r.u32()
+		x.source(md, id>>24, id&0xffffff)
+	}
+	count := int(r.u32())
+	for i := 0; i < count && !r.err; i++ {
+		format, length := r.u32(), int(r.u32())
+		if r.err || length > len(r.b) {
+			return fmt.Errorf("sFlow counter record %d truncated", format)
+		}
+		rec := flowReader{b: r.bytes(length)}
+		switch format {
+		case sflowGenericCounters:
+			md["ifIndex"] = uint64(rec.u32())
+			data["ifType"] = uint64(rec.u32())
+			data["ifSpeed"] = rec.u64()
+			data["ifDirection"] = uint64(rec.u32())
+			data["ifStatus"] = uint64(rec.u32())
+			data["ifInOctets"] = rec.u64()
+			for _, f := range []string{"ifInUcastPkts", "ifInMulticastPkts", "ifInBroadcastPkts", "ifInDiscards", "ifInErrors", "ifInUnknownProtos"} {
+				data[f] = uint64(rec.u32())
+			}
+			data["ifOutOctets"] = rec.u64()
+			for _, f := range []string{"ifOutUcastPkts", "ifOutMulticastPkts", "ifOutBroadcastPkts", "ifOutDiscards", "ifOutErrors", "ifPromiscuousMode"} {
+				data[f] = uint64(rec.u32())
+			}
+		case sflowEthernetCounter:
+			for _, f := range []string{"dot3StatsAlignmentErrors", "dot3StatsFCSErrors", "dot3StatsSingleCollisionFrames",
+				"dot3StatsMultipleCollisionFrames", "dot3StatsSQETestErrors", "dot3StatsDeferredTransmissions",
+				"dot3StatsLateCollisions", "dot3StatsExcessiveCollisions", "dot3StatsInternalMacTransmitErrors",
+				"dot3StatsCarrierSenseErrors", "dot3StatsFrameTooLongs", "dot3StatsInternalMacReceiveErrors",
+				"dot3StatsSymbolErrors"} {
+				data[f] = uint64(rec.u32())
+			}
+		case sflowProcessor:
+			data["cpu5s"] = uint64(rec.u32())
+			data["cpu1m"] = uint64(rec.u32())
+			data["cpu5m"] = uint64(rec.u32())
+			data["totalMemory"] = rec.u64()
+			data["freeMemory"] = rec.u64()
+		}
+		if rec.err {
+			return fmt.Errorf("sFlow counter record %d truncated", format)
+		}
+	}
+	if r.err {
+		return fmt.Errorf("sFlow counter sample truncated")
+	}
+	return nil
+}
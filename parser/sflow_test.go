@@ -0,0 +1,123 @@
+/*
+ * skogul, sFlow parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+// sflowRecord encodes a sample or record, prefixed by format and length.
+func sflowRecord(format uint32, values ...interface{}) []byte {
+	content := flowPacket(values...)
+	return flowPacket(format, uint32(len(content)), content)
+}
+
+func TestSFlow(t *testing.T) {
+	// Ethernet, VLAN 10, IPv4, TCP from 10.0.0.1:1234 to 10.0.0.2:443
+	header := flowPacket(
+		[]byte{0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 1}, uint16(0x8100), uint16(10), uint16(0x0800),
+		uint8(0x45), uint8(0), uint16(60), uint32(0), uint8(64), uint8(6), uint16(0),
+		[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2},
+		uint16(1234), uint16(443), uint32(0), uint32(0), uint8(0x50), uint8(0x12))
+	raw := sflowRecord(1, uint32(1), uint32(78), uint32(4), uint32(len(header)), header, make([]byte, (4-len(header)%4)%4))
+	router := sflowRecord(1002, uint32(1), []byte{10, 0, 0, 254}, uint32(24), uint32(16))
+	unknown := sflowRecord(9<<12|1, uint32(0))
+	flow := sflowRecord(1, uint32(1), uint32(5), uint32(1024), uint32(100000), uint32(0), uint32(5), uint32(7), uint32(3), raw, router, unknown)
+
+	generic := sflowRecord(1, uint32(5), uint32(6), uint64(10000000000), uint32(1), uint32(3),
+		uint64(123456789), uint32(1), uint32(2), uint32(3), uint32(4), uint32(5), uint32(6),
+		uint64(987654321), uint32(7), uint32(8), uint32(9), uint32(10), uint32(11), uint32(0))
+	counter := sflowRecord(4, uint32(2), uint32(0), uint32(5), uint32(1), generic)
+
+	b := flowPacket(uint32(5), uint32(1), []byte{192, 0, 2, 1}, uint32(0), uint32(1), uint32(1000), uint32(3),
+		flow, counter, sflowRecord(9<<12|2))
+	p := parser.SFlow{}
+	c, err := p.ParseFrom(b, "192.0.2.100")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["sampleType"] != "flow" || m.Metadata["agent"] != "192.0.2.1" || m.Metadata["exporter"] != "192.0.2.100" ||
+		m.Metadata["outputInterface"] != uint64(7) || m.Metadata["sourceIdIndex"] != uint64(5) {
+		t.Errorf("unexpected flow sample metadata %v", m.Metadata)
+	}
+	expected := map[string]interface{}{
+		"samplingRate":             uint64(1024),
+		"frameLength":              uint64(78),
+		"vlanId":                   uint64(10),
+		"sourceMacAddress":         "00:00:00:00:00:01",
+		"sourceIPv4Address":        "10.0.0.1",
+		"destinationIPv4Address":   "10.0.0.2",
+		"protocolIdentifier":       uint64(6),
+		"destinationTransportPort": uint64(443),
+		"tcpControlBits":           uint64(0x12),
+		"ipNextHopAddress":         "10.0.0.254",
+	}
+	for k, v := range expected {
+		if m.Data[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, m.Data[k])
+		}
+	}
+	m = c.Metrics[1]
+	if m.Metadata["sampleType"] != "counter" || m.Metadata["ifIndex"] != uint64(5) {
+		t.Errorf("unexpected counter sample metadata %v", m.Metadata)
+	}
+	if m.Data["ifInOctets"] != uint64(123456789) || m.Data["ifOutOctets"] != uint64(987654321) || m.Data["ifOutErrors"] != uint64(11) {
+		t.Errorf("unexpected counter sample data %v", m.Data)
+	}
+
+	if _, err := p.Parse(b[:len(b)-20]); err == nil {
+		t.Errorf("Parse of truncated datagram succeeded")
+	}
+	if _, err := p.Parse(flowPacket(uint32(4))); err == nil {
+		t.Errorf("Parse of sFlow v4 succeeded")
+	}
+}
+
+// A length from the packet must never decide how much is allocated.
+func TestSFlowHugeLength(t *testing.T) {
+	p := parser.SFlow{}
+	header := []interface{}{uint32(5), uint32(1), []byte{192, 0, 2, 1}, uint32(0), uint32(1), uint32(1000), uint32(1)}
+	packets := [][]byte{
+		flowPacket(append(header, uint32(1), uint32(0xfffffff0), uint64(0))...),
+		flowPacket(append(header, sflowRecord(1, uint32(1), uint32(1), uint32(1), uint32(1), uint32(0), uint32(1), uint32(2),
+			uint32(1), uint32(1), uint32(0xfffffff0)))...),
+		flowPacket(append(header, sflowRecord(2, uint32(1), uint32(1), uint32(1), uint32(1), uint32(0xfffffff0)))...),
+	}
+	for i, b := range packets {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := p.Parse(b); err == nil {
+			t.Errorf("Parse of packet %d with huge length succeeded", i)
+		}
+		runtime.ReadMemStats(&after)
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+			t.Errorf("Parse of packet %d allocated %d bytes", i, alloc)
+		}
+	}
+}
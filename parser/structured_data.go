@@ -108,6 +108,9 @@ func (sd *StructuredData) parseStructuredData(data []byte) ([]*skogul.Metric, er
 			}
 
 			paramName := tagValue[0]
+			if len(tagValue[1]) < 2 || tagValue[1][0] != '"' || tagValue[1][len(tagValue[1])-1] != '"' {
+				return nil, fmt.Errorf("value of %s is not quoted", paramName)
+			}
 			paramValue := tagValue[1][1 : len(tagValue[1])-1] // remove leading and trailing "s
 
 			// @ToDo: Support multiple paramName with different paramValue
@@ -126,21 +129,18 @@ func (sd *StructuredData) parseStructuredData(data []byte) ([]*skogul.Metric, er
 
 // splitKeyValuePairs splits a section (tag key=value pairs or field key=value pairs)
 func splitKeyValuePairs(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
 	fieldWidth, newData := structuredDataParser(data, true, false)
 
-	returnChars := len(newData)
-
-	if atEOF {
-		// EOF, return with what we have left
-		return returnChars + 1, newData[:returnChars], nil
-	} else if returnChars == len(data) {
-		// 'Soft EOF', we don't actually have more data
-		// but we might have a separator char leftover.
-		return returnChars, newData[:returnChars], nil
+	if fieldWidth >= len(data) {
+		// The last pair, we don't have a separator after it
+		return len(data), newData, nil
 	}
 
-	// Skip the trailing comma between each key=value pair, but still advance counter
-	return fieldWidth, newData[:returnChars], nil
+	// Skip the trailing separator between each key=value pair, but still advance counter
+	return fieldWidth, newData, nil
 }
 
 // splitStructuredDataMatrics splits a byte-stream of structured data into a list
@@ -191,6 +191,17 @@ func structuredDataParser(bytes []byte, removeEscapedCharsFromResult, stopOnNewM
 			break
 		}
 
+		// Skip next char, also within quotes. Only ", \ and ] are
+		// escaped, other backslashes are part of the value.
+		if c == '\\' && tokens+1 < len(bytes) && strings.IndexByte(`"\]`, bytes[tokens+1]) >= 0 {
+			escape = true
+			if removeEscapedCharsFromResult {
+				escapeChars = append([]int{tokens}, escapeChars...)
+				escapeCharsWidth = append([]int{width}, escapeCharsWidth...)
+			}
+			continue
+		}
+
 		// If there is an open quote, continue until we find the closing quote
 		if openQuote {
 			if c == '"' {
@@ -208,16 +219,6 @@ func structuredDataParser(bytes []byte, removeEscapedCharsFromResult, stopOnNewM
 			continue
 		}
 
-		// Skip next char
-		if c == '\\' {
-			escape = true
-			if removeEscapedCharsFromResult {
-				escapeChars = append([]int{tokens}, escapeChars...)
-				escapeCharsWidth = append([]int{width}, escapeCharsWidth...)
-			}
-			continue
-		}
-
 		// Stop when we reach a space, unless we're
 		// instructed to only stop on new metrics,
 		// in which case we will keep going until
@@ -227,21 +228,21 @@ func structuredDataParser(bytes []byte, removeEscapedCharsFromResult, stopOnNewM
 		}
 	}
 
-	// Prepare the return value
+	// Prepare the return value. Escape characters are removed from a
+	// copy, since tokens is the number of bytes read from the input.
 	data = bytes[:tokens]
-
-	for i, escapedChar := range escapeChars {
-		if removeEscapedCharsFromResult {
-			data = []byte(fmt.Sprintf("%s%s", data[0:escapedChar], data[escapedChar+escapeCharsWidth[i]:tokens]))
+	if removeEscapedCharsFromResult && len(escapeChars) > 0 {
+		data = append([]byte{}, data...)
+		// escapeChars is in descending order, so the positions are
+		// still valid after removing the later ones.
+		for i, escapedChar := range escapeChars {
+			data = append(data[:escapedChar], data[escapedChar+escapeCharsWidth[i]:]...)
 		}
-		tokens += escapeCharsWidth[i]
 	}
 
-	// If we haven't skipped any chars, we need to tell the scanner to advance one position extra
-	// to skip over separator of the next key=value pair
-	if len(escapeChars) == 0 {
-		tokens += 1
-	}
+	// Tell the scanner to advance one position extra to skip over the
+	// separator of the next key=value pair
+	tokens += 1
 
 	skipLeadingChars := 0
 	// If the value starts with a [, we remove it from the output
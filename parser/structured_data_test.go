@@ -165,6 +165,22 @@ func TestStructuredDataParseNoContentResultsInOneMetric(t *testing.T) {
 	}
 }
 
+func TestStructuredDataParseEscapes(t *testing.T) {
+	b := []byte(`[id a="x\"\]\\y" b="C:\dir" c="3"]`)
+	p := parser.StructuredData{}
+
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Errorf("Failed to parse data: %v", err)
+		return
+	}
+	for k, v := range map[string]string{"a": `x"]\y`, "b": `C:\dir`, "c": "3"} {
+		if c.Metrics[0].Data[k] != v {
+			t.Errorf("Expected %s to be %q, got %q", k, v, c.Metrics[0].Data[k])
+		}
+	}
+}
+
 func TestStructuredDataOnDataset(t *testing.T) {
 	b, err := ioutil.ReadFile("./testdata/structured_data.txt")
 	if err != nil {
@@ -0,0 +1,240 @@
+/*
+ * skogul, syslog parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+/*
+Syslog parses a single syslog message, either RFC5424 or the older BSD
+format described in RFC3164, which is detected automatically. Use it with
+the syslog receiver, which splits the stream into messages.
+
+The header fields are stored as metadata: "facility" and "severity", by
+name (e.g. "local0" and "err"), "hostname", "app-name", "procid" and
+"msgid". Fields that are not present, or "-" (nil), are left out. For
+RFC3164, the tag is used as app-name, and the number in brackets after
+it, if any, as procid. If the parser knows where the message came from,
+the address is stored as "source".
+
+The message is stored as data, along with the parameters of the
+structured data. The SD-IDs of the structured data elements are stored
+in the SDIDField metadata field, separated by commas if there are more
+than one. If the same parameter name is used in more than one element,
+the parameter of the later element is named SD-ID.name, and so is a
+parameter named the same as MessageField. The structured data is parsed
+with the structured_data parser.
+
+RFC3164 timestamps don't have a year, so the current year is used,
+unless that puts the timestamp more than a day in the future, in which
+case the previous year is used. Messages without a timestamp get the
+current time.
+*/
+type Syslog struct {
+	MessageField string `doc:"Data field to store the message in. Defaults to \"message\"."`
+	SDIDField    string `doc:"Metadata field to store the SD-IDs of the structured data in. Defaults to \"sd-id\"."`
+}
+
+// syslogReader reads the space-separated fields of a syslog header.
+type syslogReader struct {
+	b []byte
+}
+
+// field returns the next field, up to the next space, and skips the
+// space.
+func (r *syslogReader) field() string {
+	i := bytes.IndexByte(r.b, ' ')
+	if i < 0 {
+		f := string(r.b)
+		r.b = nil
+		return f
+	}
+	f := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return f
+}
+
+// Parse parses a message without knowing where it came from.
+func (s Syslog) Parse(b []byte) (*skogul.Container, error) {
+	return s.ParseFrom(b, "")
+}
+
+// ParseFrom parses a message, storing the address it came from as
+// "source" metadata.
+func (s Syslog) ParseFrom(b []byte, source string) (*skogul.Container, error) {
+	msgField := s.MessageField
+	if msgField == "" {
+		msgField = "message"
+	}
+	sdField := s.SDIDField
+	if sdField == "" {
+		sdField = "sd-id"
+	}
+	b = bytes.TrimRight(b, "\r\n\x00")
+	if len(b) < 3 || b[0] != '<' {
+		return nil, fmt.Errorf("syslog message doesn't start with a priority")
+	}
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("invalid syslog priority")
+	}
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri > 191 {
+		return nil, fmt.Errorf("invalid syslog priority %q", b[1:end])
+	}
+	m := &skogul.Metric{
+		Metadata: map[string]interface{}{
+			"facility": syslogFacilities[pri/8],
+			"severity": syslogSeverities[pri%8],
+		},
+		Data: make(map[string]interface{}),
+	}
+	if source != "" {
+		m.Metadata["source"] = source
+	}
+	r := syslogReader{b: b[end+1:]}
+	if bytes.HasPrefix(r.b, []byte("1 ")) {
+		err = s.parse5424(m, &r, msgField, sdField)
+	} else {
+		s.parse3164(m, &r, msgField)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Time == nil {
+		now := skogul.Now()
+		m.Time = &now
+	}
+	return &skogul.Container{Metrics: []*skogul.Metric{m}}, nil
+}
+
+// parse5424 parses the rest of an RFC5424 message, after the priority.
+func (s Syslog) parse5424(m *skogul.Metric, r *syslogReader, msgField, sdField string) error {
+	r.field() // Version
+	if ts := r.field(); ts != "-" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return fmt.Errorf("invalid syslog timestamp %q: %w", ts, err)
+		}
+		m.Time = &t
+	}
+	for _, name := range []string{"hostname", "app-name", "procid", "msgid"} {
+		if f := r.field(); f != "-" && f != "" {
+			m.Metadata[name] = f
+		}
+	}
+	if len(r.b) > 0 && r.b[0] == '-' {
+		r.b = r.b[1:]
+		m.Data[msgField] = syslogMessage(r.b)
+		return nil
+	}
+	n := 0
+	for n < len(r.b) && r.b[n] == '[' {
+		width, _ := structuredDataParser(r.b[n:], false, true)
+		if n+width > len(r.b) || r.b[n+width-1] != ']' {
+			return fmt.Errorf("unterminated structured data")
+		}
+		n += width
+	}
+	if n == 0 {
+		return fmt.Errorf("invalid structured data")
+	}
+	sd := StructuredData{SDIDField: sdField}
+	c, err := sd.Parse(r.b[:n])
+	if err != nil {
+		return fmt.Errorf("invalid structured data: %w", err)
+	}
+	// Set the message first, so parameters with the same name are
+	// renamed instead of overwriting it.
+	m.Data[msgField] = syslogMessage(r.b[n:])
+	ids := []string{}
+	for _, e := range c.Metrics {
+		id, _ := e.Metadata[sdField].(string)
+		ids = append(ids, id)
+		for name, value := range e.Data {
+			if _, ok := m.Data[name]; ok {
+				name = id + "." + name
+			}
+			m.Data[name] = value
+		}
+	}
+	m.Metadata[sdField] = strings.Join(ids, ",")
+	return nil
+}
+
+// syslogMessage returns the MSG part of an RFC5424 message, without the
+// leading space and byte order mark.
+func syslogMessage(b []byte) string {
+	return string(bytes.TrimPrefix(bytes.TrimPrefix(b, []byte(" ")), []byte("\xef\xbb\xbf")))
+}
+
+// parse3164 parses the rest of a BSD syslog message, after the priority.
+// Anything that doesn't look like a header is treated as the message.
+func (s Syslog) parse3164(m *skogul.Metric, r *syslogReader, msgField string) {
+	if len(r.b) >= 16 && r.b[15] == ' ' {
+		now := skogul.Now()
+		t, err := time.ParseInLocation(time.Stamp, string(r.b[:15]), time.Local)
+		if err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.Sub(now) > 24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Time = &t
+			r.b = r.b[16:]
+			// The hostname is optional, but the tag can't contain
+			// spaces and ends with a colon or bracket
+			if i := bytes.IndexByte(r.b, ' '); i > 0 && !bytes.ContainsAny(r.b[:i], ":[") {
+				m.Metadata["hostname"] = r.field()
+			}
+		}
+	}
+	if i := bytes.IndexAny(r.b, ":[ "); i > 0 && i <= 48 && r.b[i] != ' ' {
+		m.Metadata["app-name"] = string(r.b[:i])
+		r.b = r.b[i:]
+		if r.b[0] == '[' {
+			if end := bytes.IndexByte(r.b, ']'); end > 0 {
+				m.Metadata["procid"] = string(r.b[1:end])
+				r.b = r.b[end+1:]
+			}
+		}
+		r.b = bytes.TrimPrefix(r.b, []byte(":"))
+		r.b = bytes.TrimPrefix(r.b, []byte(" "))
+	}
+	m.Data[msgField] = string(r.b)
+}
@@ -0,0 +1,142 @@
+/*
+ * skogul, syslog parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+func TestSyslog_rfc5424(t *testing.T) {
+	p := parser.Syslog{}
+	c, err := p.ParseFrom([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high" iut="4\"\]"] `+"\xef\xbb\xbf"+`An application event log entry...`+"\n"), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("ParseFrom failed: %v", err)
+	}
+	m := c.Metrics[0]
+	expected := map[string]interface{}{
+		"facility": "local4",
+		"severity": "notice",
+		"hostname": "mymachine.example.com",
+		"app-name": "evntslog",
+		"msgid":    "ID47",
+		"sd-id":    "exampleSDID@32473,examplePriority@32473",
+		"source":   "192.0.2.1",
+	}
+	for k, v := range expected {
+		if m.Metadata[k] != v {
+			t.Errorf("Expected metadata %s to be %v, got %v", k, v, m.Metadata[k])
+		}
+	}
+	if _, ok := m.Metadata["procid"]; ok {
+		t.Errorf("Nil procid included: %v", m.Metadata)
+	}
+	expected = map[string]interface{}{
+		"message":                   "An application event log entry...",
+		"iut":                       "3",
+		"eventID":                   "1011",
+		"class":                     "high",
+		"examplePriority@32473.iut": `4"]`,
+	}
+	for k, v := range expected {
+		if m.Data[k] != v {
+			t.Errorf("Expected data %s to be %v, got %v", k, v, m.Data[k])
+		}
+	}
+	if !m.Time.Equal(time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)) {
+		t.Errorf("Unexpected time %v", m.Time)
+	}
+
+	c, err = p.Parse([]byte(`<34>1 - - su - - - 'su root' failed`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	m = c.Metrics[0]
+	if m.Data["message"] != "'su root' failed" || m.Metadata["app-name"] != "su" || m.Metadata["severity"] != "crit" || m.Time == nil {
+		t.Errorf("Unexpected metric %v %v", m.Metadata, m.Data)
+	}
+	if _, ok := m.Metadata["hostname"]; ok {
+		t.Errorf("Nil hostname included: %v", m.Metadata)
+	}
+
+	c, err = p.Parse([]byte(`<34>1 - host app - - [id message="sd" path="C:\\dir"] the message`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	m = c.Metrics[0]
+	if m.Data["message"] != "the message" || m.Data["id.message"] != "sd" || m.Data["path"] != `C:\dir` {
+		t.Errorf("Unexpected data %v", m.Data)
+	}
+
+	for _, bad := range []string{
+		`<34>1 yesterday host app - - - msg`,
+		`<34>1 - host app - - [id a=] msg`,
+		`<34>1 - host app - - [id a="b msg`,
+		`<34>1 - host app - - [id a=b] msg`,
+		`<192>1 - - - - - - msg`,
+		`34 msg`,
+	} {
+		if _, err := p.Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse of %q succeeded", bad)
+		}
+	}
+}
+
+func TestSyslog_rfc3164(t *testing.T) {
+	p := parser.Syslog{MessageField: "msg"}
+	tests := []struct {
+		in       string
+		hostname string
+		app      string
+		procid   string
+		msg      string
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8", "mymachine", "su", "", "'su root' failed for lonvick on /dev/pts/8"},
+		{"<13>Feb  5 17:32:18 10.0.0.99 sshd[1234]: Accepted publickey", "10.0.0.99", "sshd", "1234", "Accepted publickey"},
+		{"<13>Feb  5 17:32:18 cron[99]: job done", "", "cron", "99", "job done"},
+		{"<13>Feb  5 17:32:18 host just a message", "host", "", "", "just a message"},
+		{"<13>no header at all", "", "", "", "no header at all"},
+	}
+	for _, test := range tests {
+		c, err := p.Parse([]byte(test.in))
+		if err != nil {
+			t.Errorf("Parse of %q failed: %v", test.in, err)
+			continue
+		}
+		m := c.Metrics[0]
+		for k, v := range map[string]string{"hostname": test.hostname, "app-name": test.app, "procid": test.procid} {
+			got, ok := m.Metadata[k]
+			if (v == "" && ok) || (v != "" && got != v) {
+				t.Errorf("%q: expected %s to be %q, got %v", test.in, k, v, got)
+			}
+		}
+		if m.Data["msg"] != test.msg {
+			t.Errorf("%q: expected message %q, got %q", test.in, test.msg, m.Data["msg"])
+		}
+	}
+	c, _ := p.Parse([]byte(tests[0].in))
+	if m := c.Metrics[0]; m.Time.Month() != time.October || m.Time.Day() != 11 || m.Time.Hour() != 22 || m.Time.After(time.Now().Add(25*time.Hour)) {
+		t.Errorf("Unexpected time %v", m.Time)
+	}
+}
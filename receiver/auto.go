@@ -39,7 +39,7 @@ func init() {
 		Name:    "http",
 		Aliases: []string{"https"},
 		Alloc:   func() interface{} { return &HTTP{} },
-		Help:    "Listen for metrics on HTTP or HTTPS. Optionally requiring authentication. Each request received is passed to a handler, and a single HTTP receiver can listen for multiple formats depending on URL used.",
+		Help:    "Listen for metrics on HTTP or HTTPS. Optionally requiring authentication. Each request received is passed to a handler, and a single HTTP receiver can listen for multiple formats depending on URL used. Request bodies compressed with gzip, deflate, zstd or snappy are decompressed based on Content-Encoding, and the parser can be selected by Content-Type.",
 		Extras:  []interface{}{HTTPAuth{}},
 	})
 	Auto.Add(skogul.Module{
@@ -108,11 +108,51 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "udp",
 		Alloc: func() interface{} { return &UDP{} },
-		Help:  "Accept UDP messages, one UDP message is one container. Combine with protobuf parser to receive Juniper telemetry.",
+		Help:  "Accept UDP messages, one UDP message is one container. Combine with protobuf parser to receive Juniper telemetry. With parsers that keep state per sender, such as netflow and sflow, messages that only update that state (e.g. templates) are dropped silently.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "kafka",
 		Alloc: func() interface{} { return &Kafka{} },
 		Help:  "Connect to a Kafka topic and consume messages.",
 	})
+	Auto.Add(skogul.Module{
+		Name:  "gnmi",
+		Alloc: func() interface{} { return &GNMI{} },
+		Help:  "Subscribe to telemetry from one or more devices using gNMI (dial-in). Combine with the gnmi parser.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "mdt_dialout",
+		Aliases: []string{"mdt", "grpc"},
+		Alloc:   func() interface{} { return &MDTDialout{} },
+		Help:    "Accept Cisco model-driven telemetry from devices using gRPC dial-out. Combine with the mdt parser.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "otlp",
+		Aliases: []string{"opentelemetry"},
+		Alloc:   func() interface{} { return &OTLP{} },
+		Help:    "Accept OpenTelemetry metrics using OTLP/gRPC. Combine with the otlp parser. For OTLP/HTTP, use the http receiver with the otlp parser.",
+	})
+	Auto.Add(skogul.Module{
+		Name:   "snmp",
+		Alloc:  func() interface{} { return &SNMP{} },
+		Help:   "Periodically poll devices using SNMP, getting scalar values and walking tables. Optionally names values using a user-supplied MIB mapping.",
+		Extras: []interface{}{SNMPUser{}},
+	})
+	Auto.Add(skogul.Module{
+		Name:   "snmptrap",
+		Alloc:  func() interface{} { return &SNMPTrap{} },
+		Help:   "Receive SNMP v1, v2c and v3 traps and informs, one metric per trap. Optionally names variables using a user-supplied MIB mapping.",
+		Extras: []interface{}{SNMPUser{}},
+	})
+	Auto.Add(skogul.Module{
+		Name:  "syslog",
+		Alloc: func() interface{} { return &Syslog{} },
+		Help:  "Receive syslog messages over UDP, TCP or TLS, with newline or octet-counting framing. Combine with the syslog parser.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "statsd",
+		Aliases: []string{"dogstatsd"},
+		Alloc:   func() interface{} { return &Statsd{} },
+		Help:    "Accept statsd and DogStatsD metrics over UDP, aggregate them and send the result once every flush interval. Supports counters, gauges, timers, histograms, distributions and sets.",
+	})
 }
@@ -0,0 +1,289 @@
+/*
+ * skogul, gNMI receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/telenornms/skogul"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+var gnmiLog = skogul.Logger("receiver", "gnmi")
+
+var gnmiModes = map[string]gpb.SubscriptionMode{
+	"sample":         gpb.SubscriptionMode_SAMPLE,
+	"on_change":      gpb.SubscriptionMode_ON_CHANGE,
+	"target_defined": gpb.SubscriptionMode_TARGET_DEFINED,
+}
+
+var gnmiEncodings = map[string]gpb.Encoding{
+	"json":      gpb.Encoding_JSON,
+	"json_ietf": gpb.Encoding_JSON_IETF,
+	"proto":     gpb.Encoding_PROTO,
+	"ascii":     gpb.Encoding_ASCII,
+}
+
+/*
+GNMI connects to one or more devices and subscribes to telemetry using
+gNMI (dial-in). Every response received is passed on to the handler as a
+protobuf-encoded gNMI SubscribeResponse, so the handler should use the
+gnmi parser. The address of the device is added as the target of the
+notification, unless the device sets a target itself.
+
+If the connection fails or is closed by the device, the receiver
+reconnects after RetryDelay.
+*/
+type GNMI struct {
+	Targets        []string          `doc:"Addresses of the devices to subscribe to." example:"[\"router1:57400\", \"router2:57400\"]"`
+	Paths          []string          `doc:"Paths to subscribe to. Keys can be specified in brackets, and * can be used as wildcard." example:"[\"/interfaces/interface[name=*]/state/counters\"]"`
+	Origin         string            `doc:"Origin of the paths, e.g. openconfig. Optional."`
+	Mode           string            `doc:"Subscription mode: sample, on_change or target_defined. Defaults to sample."`
+	SampleInterval skogul.Duration   `doc:"How often the device should send data in sample mode. Defaults to 10s."`
+	Encoding       string            `doc:"Encoding the device should use for values: json, json_ietf, proto or ascii. Defaults to proto."`
+	Username       skogul.Secret     `doc:"Username to authenticate with. Optional."`
+	Password       skogul.Secret     `doc:"Password to authenticate with. Optional."`
+	TLS            bool              `doc:"Connect using TLS."`
+	Insecure       bool              `doc:"Disable TLS certificate validation."`
+	RootCA         string            `doc:"Path to an alternate root CA used to verify device certificates. Leave blank to use system defaults."`
+	RetryDelay     skogul.Duration   `doc:"How long to wait before reconnecting after a failure. Defaults to 10s."`
+	Handler        skogul.HandlerRef `doc:"Handler used to parse, transform and send data. Should use the gnmi parser."`
+	ctx            context.Context
+	cancel         context.CancelFunc
+	once           sync.Once
+	done           chan struct{} // Closed when Start() has returned
+}
+
+func (g *GNMI) init() {
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.done = make(chan struct{})
+}
+
+// gnmiPath parses a path such as /interfaces/interface[name=ge-0/0/0]/state
+// into a gNMI path.
+func gnmiPath(s string) (*gpb.Path, error) {
+	path := &gpb.Path{}
+	s = strings.TrimPrefix(s, "/")
+	for len(s) > 0 {
+		end := strings.IndexAny(s, "/[")
+		if end == -1 {
+			end = len(s)
+		}
+		elem := &gpb.PathElem{Name: s[:end]}
+		if elem.Name == "" {
+			return nil, fmt.Errorf("empty element in path")
+		}
+		s = s[end:]
+		for strings.HasPrefix(s, "[") {
+			end = strings.Index(s, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("missing ] in path")
+			}
+			kv := strings.SplitN(s[1:end], "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("key %q is not on the form name=value", s[1:end])
+			}
+			if elem.Key == nil {
+				elem.Key = make(map[string]string)
+			}
+			elem.Key[kv[0]] = kv[1]
+			s = s[end+1:]
+		}
+		path.Elem = append(path.Elem, elem)
+		s = strings.TrimPrefix(s, "/")
+	}
+	return path, nil
+}
+
+// request builds the subscribe request from the configuration.
+func (g *GNMI) request() (*gpb.SubscribeRequest, error) {
+	list := &gpb.SubscriptionList{
+		Mode:     gpb.SubscriptionList_STREAM,
+		Encoding: gnmiEncodings[g.Encoding],
+	}
+	if g.Origin != "" {
+		list.Prefix = &gpb.Path{Origin: g.Origin}
+	}
+	for _, p := range g.Paths {
+		path, err := gnmiPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", p, err)
+		}
+		list.Subscription = append(list.Subscription, &gpb.Subscription{
+			Path:           path,
+			Mode:           gnmiModes[g.Mode],
+			SampleInterval: uint64(g.SampleInterval.Duration.Nanoseconds()),
+		})
+	}
+	return &gpb.SubscribeRequest{Request: &gpb.SubscribeRequest_Subscribe{Subscribe: list}}, nil
+}
+
+// Start subscribes to all targets. Only returns if the receiver is stopped
+// using Stop(), or the configuration is invalid.
+func (g *GNMI) Start() error {
+	g.once.Do(g.init)
+	defer close(g.done)
+	if g.Mode == "" {
+		g.Mode = "sample"
+	}
+	if g.Encoding == "" {
+		g.Encoding = "proto"
+	}
+	if g.SampleInterval.Duration == 0 {
+		g.SampleInterval.Duration = 10 * time.Second
+	}
+	if g.RetryDelay.Duration == 0 {
+		g.RetryDelay.Duration = 10 * time.Second
+	}
+	req, err := g.request()
+	if err != nil {
+		return err
+	}
+	creds := insecure.NewCredentials()
+	if g.TLS {
+		conf := tls.Config{InsecureSkipVerify: g.Insecure}
+		if g.RootCA != "" {
+			conf.RootCAs, err = loadClientCertificateCAs([]string{g.RootCA})
+			if err != nil {
+				return err
+			}
+		}
+		creds = credentials.NewTLS(&conf)
+	}
+	var wg sync.WaitGroup
+	for _, target := range g.Targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			for {
+				err := g.subscribe(target, creds, req)
+				if g.ctx.Err() != nil {
+					return
+				}
+				gnmiLog.WithError(err).WithField("target", target).Warnf("Subscription failed, retrying in %v", g.RetryDelay.Duration)
+				select {
+				case <-time.After(g.RetryDelay.Duration):
+				case <-g.ctx.Done():
+					return
+				}
+			}
+		}(target)
+	}
+	wg.Wait()
+	return nil
+}
+
+// subscribe connects to the target and passes on the responses, until
+// the subscription fails or the receiver is stopped.
+func (g *GNMI) subscribe(target string, creds credentials.TransportCredentials, req *gpb.SubscribeRequest) error {
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("unable to connect: %w", err)
+	}
+	defer conn.Close()
+	ctx := g.ctx
+	if g.Username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "username", g.Username.Expose(), "password", g.Password.Expose())
+	}
+	stream, err := gpb.NewGNMIClient(conn).Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to subscribe: %w", err)
+	}
+	if err := stream.Send(req); err != nil {
+		return fmt.Errorf("unable to send subscribe request: %w", err)
+	}
+	gnmiLog.WithField("target", target).Debug("Subscribed")
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if e := resp.GetError(); e != nil {
+			return fmt.Errorf("target returned error %d: %s", e.Code, e.Message)
+		}
+		n := resp.GetUpdate()
+		if n == nil || len(n.Update) == 0 {
+			continue
+		}
+		if n.Prefix == nil {
+			n.Prefix = &gpb.Path{}
+		}
+		if n.Prefix.Target == "" {
+			n.Prefix.Target = target
+		}
+		b, err := proto.Marshal(protoadapt.MessageV2Of(resp))
+		if err != nil {
+			gnmiLog.WithError(err).Warn("Unable to marshal gNMI response")
+			continue
+		}
+		if err := g.Handler.H.Handle(b); err != nil {
+			gnmiLog.WithError(err).WithField("target", target).Warn("Unable to handle gNMI response")
+		}
+	}
+}
+
+// Stop closes all subscriptions.
+func (g *GNMI) Stop() error {
+	g.once.Do(g.init)
+	g.cancel()
+	<-g.done
+	return nil
+}
+
+// Verify checks that the configuration is valid.
+func (g *GNMI) Verify() error {
+	if len(g.Targets) == 0 {
+		return skogul.MissingArgument("Targets")
+	}
+	if len(g.Paths) == 0 {
+		return skogul.MissingArgument("Paths")
+	}
+	if g.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	for _, p := range g.Paths {
+		if _, err := gnmiPath(p); err != nil {
+			return fmt.Errorf("invalid path %q: %w", p, err)
+		}
+	}
+	if _, ok := gnmiModes[g.Mode]; g.Mode != "" && !ok {
+		return fmt.Errorf("invalid Mode %q, must be sample, on_change or target_defined", g.Mode)
+	}
+	if _, ok := gnmiEncodings[g.Encoding]; g.Encoding != "" && !ok {
+		return fmt.Errorf("invalid Encoding %q, must be json, json_ietf, proto or ascii", g.Encoding)
+	}
+	if g.Password != "" && g.Username == "" {
+		return fmt.Errorf("Password specified without Username")
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+ * skogul, gNMI receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+	"github.com/telenornms/skogul/sender"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// gnmiTarget is a stand-in for a device, sending a single notification
+// for each path subscribed to.
+type gnmiTarget struct {
+	requests chan *gpb.SubscribeRequest
+}
+
+func (g *gnmiTarget) Capabilities(context.Context, *gpb.CapabilityRequest) (*gpb.CapabilityResponse, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (g *gnmiTarget) Get(context.Context, *gpb.GetRequest) (*gpb.GetResponse, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (g *gnmiTarget) Set(context.Context, *gpb.SetRequest) (*gpb.SetResponse, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (g *gnmiTarget) Subscribe(stream gpb.GNMI_SubscribeServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if len(md["username"]) != 1 || md["username"][0] != "user" {
+		return fmt.Errorf("missing username")
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	g.requests <- req
+	for _, sub := range req.GetSubscribe().Subscription {
+		err := stream.Send(&gpb.SubscribeResponse{Response: &gpb.SubscribeResponse_Update{Update: &gpb.Notification{
+			Timestamp: time.Now().UnixNano(),
+			Update: []*gpb.Update{{
+				Path: &gpb.Path{Elem: append(sub.Path.Elem, &gpb.PathElem{Name: "value"})},
+				Val:  &gpb.TypedValue{Value: &gpb.TypedValue_IntVal{IntVal: 1}},
+			}},
+		}}})
+		if err != nil {
+			return err
+		}
+	}
+	stream.Send(&gpb.SubscribeResponse{Response: &gpb.SubscribeResponse_SyncResponse{SyncResponse: true}})
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestGNMI(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:1379")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	target := &gnmiTarget{requests: make(chan *gpb.SubscribeRequest, 1)}
+	gpb.RegisterGNMIServer(srv, target)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	tst := &sender.Test{}
+	h := skogul.Handler{Sender: tst}
+	h.SetParser(parser.GNMI{})
+	rcv := &receiver.GNMI{
+		Targets:  []string{"localhost:1379"},
+		Paths:    []string{"/interfaces/interface[name=ge-0/0/0]/state/counters", "/system/state"},
+		Mode:     "on_change",
+		Username: "user",
+		Password: "pass",
+		Handler:  skogul.HandlerRef{H: &h},
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+
+	select {
+	case req := <-target.requests:
+		subs := req.GetSubscribe().Subscription
+		if len(subs) != 2 || subs[0].Mode != gpb.SubscriptionMode_ON_CHANGE {
+			t.Errorf("Unexpected subscriptions: %v", subs)
+		} else if key := subs[0].Path.Elem[1].Key["name"]; key != "ge-0/0/0" {
+			t.Errorf("Expected key name=ge-0/0/0, got %q", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No subscribe request received")
+	}
+	for i := 0; i < 100 && tst.Received() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tst.Received() != 2 {
+		t.Errorf("Expected 2 containers, got %d", tst.Received())
+	}
+
+	go rcv.Stop()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start() didn't return after Stop()")
+	}
+}
+
+func TestGNMI_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	ok := receiver.GNMI{Targets: []string{"a:1"}, Paths: []string{"/a/b[k=v]/c"}, Handler: h}
+	if err := ok.Verify(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for i, g := range []*receiver.GNMI{
+		{Paths: []string{"/a"}, Handler: h},
+		{Targets: []string{"a:1"}, Handler: h},
+		{Targets: []string{"a:1"}, Paths: []string{"/a/b[k=v"}, Handler: h},
+		{Targets: []string{"a:1"}, Paths: []string{"/a//b"}, Handler: h},
+		{Targets: []string{"a:1"}, Paths: []string{"/a"}, Handler: h, Mode: "poll"},
+		{Targets: []string{"a:1"}, Paths: []string{"/a"}, Handler: h, Encoding: "xml"},
+	} {
+		if err := g.Verify(); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
@@ -25,45 +25,117 @@
 package receiver
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
 	"sync/atomic"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
 	log "github.com/sirupsen/logrus"
 	"github.com/telenornms/skogul"
 )
 
+// httpMaxBodySize is the default maximum size of a request body.
+const httpMaxBodySize = 64 << 20
+
+// snappyMagic starts snappy data in the framed format, as opposed to the
+// block format used by e.g. Prometheus remote write.
+const snappyMagic = "\xff\x06\x00\x00sNaPpY"
+
 var httpLog = skogul.Logger("receiver", "http")
 
-// HTTPAuth contains ways to authenticate a HTTP request, e.g. Username/Password for Basic Auth.
+/*
+HTTPAuth contains ways to authenticate a HTTP request, e.g. Username/Password
+for Basic Auth.
+
+Tokens and JWTs are sent as "Authorization: Bearer <token>", while API keys
+are sent in the APIKeyHeader header. If the request has a bearer token,
+it is accepted if it matches one of the Tokens, or is a valid JWT signed by
+one of the JWTKeys. Claims of the JWT can be added to the metadata of all
+metrics of the request using JWTMetadata, overriding any such metadata
+sent by the client.
+
+Authenticated requests can be assigned a tenant, which the handler
+enforces, see skogul.Tenancy. The tenant is taken from the TenantTokens
+used, the JWTTenant claim, or Tenant, in that order.
+*/
 type HTTPAuth struct {
-	Username              string        `doc:"Username for basic authentication. No authentication is required if left blank."`
-	Password              skogul.Secret `doc:"Password for basic authentication."`
-	SANDNSName            string        `doc:"DNS name which has to be present in SAN extension of x509 certificate when using Client Certificate authentication"`
-	SkipCertificateVerify bool          `doc:"Skip verifying certificate. (default: false)"`
+	Username              string                     `doc:"Username for basic authentication. No authentication is required if left blank."`
+	Password              skogul.Secret              `doc:"Password for basic authentication."`
+	SANDNSName            string                     `doc:"DNS name which has to be present in SAN extension of x509 certificate when using Client Certificate authentication"`
+	SkipCertificateVerify bool                       `doc:"Skip verifying certificate. (default: false)"`
+	Tokens                []skogul.Secret            `doc:"Static bearer tokens to accept."`
+	APIKeys               []skogul.Secret            `doc:"API keys to accept, sent in the header named by APIKeyHeader."`
+	APIKeyHeader          string                     `doc:"Header to read API keys from. Defaults to X-API-Key."`
+	JWTKeys               string                     `doc:"Path to a JWKS file, or a PEM file with public keys or certificates, used to verify JWTs sent as bearer tokens. JWTs must be signed using RSA, ECDSA or Ed25519, and have an expiry time."`
+	JWTIssuer             string                     `doc:"Required issuer (iss claim) of JWTs. Optional."`
+	JWTAudience           string                     `doc:"Required audience (aud claim) of JWTs. Optional."`
+	JWTClaims             map[string]string          `doc:"Other claims JWTs must have, with the required value. If the claim is a list, it must contain the value." example:"{\"scope\": \"metrics:write\"}"`
+	JWTMetadata           map[string]string          `doc:"Claims of the JWT to add as metadata to every metric, mapped to the name of the metadata field. JWTs without these claims are rejected." example:"{\"sub\": \"client\"}"`
+	JWTTenant             string                     `doc:"Claim of the JWT holding the tenant of the request. JWTs without it are rejected."`
+	TenantTokens          map[string][]skogul.Secret `doc:"Bearer tokens or API keys to accept, by tenant. Requests using them are assigned that tenant." example:"{\"team-a\": [\"token-a\"], \"team-b\": [\"token-b1\", \"token-b2\"]}"`
+	Tenant                string                     `doc:"Tenant of requests authenticated by other means, e.g. basic authentication or one of Tokens."`
 	path                  string
+	once                  sync.Once
+	keys                  *jwtKeys
+	keysErr               error
 }
 
 /*
 HTTP accepts HTTP connections on the Address specified, and requires at
 least one handler to be set up, using Handle. This is done implicitly
 if the HTTP receiver is created using New()
+
+Request bodies compressed with gzip, deflate, zstd or snappy are
+decompressed according to the Content-Encoding header before they are
+passed on to the handler. Bodies larger than MaxBodySize, before or after
+decompression, are rejected. Parsers of formats that are always
+compressed, such as prometheus_remote_write, decompress the body
+themselves.
+
+Clients using different formats can share a path by setting Parsers for
+it, mapping each Content-Type to a parser. The parser can also be
+selected using a query parameter, named by ParserParam, whose value is
+looked up in the same map, e.g. /?format=influx. Requests that match
+neither are parsed by the parser of the handler. Either way, the
+transformers and sender of the handler are used.
 */
 type HTTP struct {
-	Address              string                        `doc:"Address to listen to." example:"[::1]:80 [2001:db8::1]:443"`
-	Handlers             map[string]*skogul.HandlerRef `doc:"Paths to handlers. Need at least one." example:"{\"/\": \"someHandler\" }"`
-	Auth                 map[string]*HTTPAuth          `doc:"A map corresponding to Handlers; specifying authentication for the given path, if required."`
-	Certfile             string                        `doc:"Path to certificate file for TLS. If left blank, un-encrypted HTTP is used."`
-	Keyfile              string                        `doc:"Path to key file for TLS."`
-	ClientCertificateCAs []string                      `doc:"Paths to files containing CAs which are accepted for Client Certificate authentication."`
-	Log204OK             bool                          `doc:"Log successful requests as well as failed. Failed requests are always logged as a warning.Successful requests are logged as info-level."`
+	Address              string                                  `doc:"Address to listen to." example:"[::1]:80 [2001:db8::1]:443"`
+	Handlers             map[string]*skogul.HandlerRef           `doc:"Paths to handlers. Need at least one." example:"{\"/\": \"someHandler\" }"`
+	Auth                 map[string]*HTTPAuth                    `doc:"A map corresponding to Handlers; specifying authentication for the given path, if required."`
+	Certfile             string                                  `doc:"Path to certificate file for TLS. If left blank, un-encrypted HTTP is used."`
+	Keyfile              string                                  `doc:"Path to key file for TLS."`
+	ClientCertificateCAs []string                                `doc:"Paths to files containing CAs which are accepted for Client Certificate authentication."`
+	Log204OK             bool                                    `doc:"Log successful requests as well as failed. Failed requests are always logged as a warning.Successful requests are logged as info-level."`
+	Tenants              map[string]string                       `doc:"Tenant of requests to each path, unless assigned by Auth. The handler enforces the tenant, see the tenancy handler option." example:"{\"/team-a\": \"team-a\"}"`
+	MaxBodySize          int64                                   `doc:"Maximum size of a request body in bytes, both as received and after decompression. Larger requests are rejected. Defaults to 64MiB."`
+	Parsers              map[string]map[string]*skogul.ParserRef `doc:"Per path, parsers to use instead of the parser of the handler, by Content-Type, ignoring parameters such as charset, or by the value of the ParserParam query parameter." example:"{\"/\": {\"application/json\": \"skogul\", \"text/plain\": \"influx\", \"influx\": \"influx\"}}"`
+	ParserParam          string                                  `doc:"Name of a query parameter used to select a parser from Parsers, e.g. format. Takes precedence over Content-Type. Requests asking for an unknown parser are rejected."`
 	stats                *httpStats
+	paths                map[string]*httpPathStats
+	server               *http.Server
+	lock                 sync.RWMutex // Protects server, mux and stopped
+	mux                  *http.ServeMux
+	stopped              bool
 }
 
 // httpStats contains the internal stats of the HTTP receiver.
@@ -75,14 +147,146 @@ type httpStats struct {
 	Sent          uint64 // Number of sent skogul.Containers
 }
 
+// httpPathStats contains the stats of a single path.
+type httpPathStats struct {
+	Received         uint64 // Number of requests to the path
+	TooLarge         uint64 // Requests rejected for exceeding MaxBodySize
+	DecompressFailed uint64 // Requests with invalid or unsupported Content-Encoding
+	AuthFailed       uint64 // Requests that failed authentication
+}
+
 // For each path we handle, we set up a receiver such as this
 // to simplify things.
 // FIXME: This should almost certianly have a more descriptive name to
 // avoid collisions and confusion.
 type receiver struct {
-	Handler  *skogul.Handler
-	settings *HTTP
-	auth     *HTTPAuth
+	Handler     *skogul.Handler
+	settings    *HTTP
+	auth        *HTTPAuth
+	log204OK    bool
+	maxBodySize int64
+	stats       *httpPathStats
+	parsers     map[string]skogul.Parser
+	parserParam string
+	tenant      string
+}
+
+var (
+	// errTooLarge is returned when a request body exceeds MaxBodySize.
+	errTooLarge = errors.New("request body too large")
+	// errUnsupportedEncoding is returned for unknown Content-Encodings.
+	errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+)
+
+// httpBodyReader records errors from reading the request body itself, to
+// tell them apart from errors decompressing it.
+type httpBodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *httpBodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// decompress returns a reader decompressing r, compressed with the given
+// encoding. Snappy is read in full, since the block format, unlike the
+// framed format, can't be streamed.
+func decompress(encoding string, r io.Reader, max int64) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// Should be zlib, but raw deflate is common as well.
+		br := bufio.NewReader(r)
+		head, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "zstd":
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(max)+1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case "snappy":
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(b, []byte(snappyMagic)) {
+			return io.NopCloser(snappy.NewReader(bytes.NewReader(b))), nil
+		}
+		n, err := snappy.DecodedLen(b)
+		if err != nil {
+			return nil, err
+		}
+		if int64(n) > max {
+			return nil, errTooLarge
+		}
+		b, err = snappy.Decode(nil, b)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+}
+
+// body reads the request body, decompressing it if needed. If the first
+// encoding applied is keep, it is left for the parser. Returns the HTTP
+// status code to use on failure.
+func (rcvr receiver) body(w http.ResponseWriter, r *http.Request, keep string) ([]byte, int, error) {
+	raw := &httpBodyReader{r: http.MaxBytesReader(w, r.Body, rcvr.maxBodySize)}
+	var reader io.Reader = raw
+	var decompressErr error
+	encodings := strings.Split(r.Header.Get("Content-Encoding"), ",")
+	// Encodings are listed in the order they were applied.
+	for i := len(encodings) - 1; i >= 0; i-- {
+		enc := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if enc == "" || enc == "identity" || (i == 0 && enc == keep) {
+			continue
+		}
+		dec, err := decompress(enc, reader, rcvr.maxBodySize)
+		if err != nil {
+			decompressErr = err
+			break
+		}
+		defer dec.Close()
+		reader = dec
+	}
+	var buf bytes.Buffer
+	if decompressErr == nil {
+		if r.ContentLength > 0 && r.ContentLength <= rcvr.maxBodySize {
+			buf.Grow(int(r.ContentLength))
+		}
+		_, decompressErr = buf.ReadFrom(io.LimitReader(reader, rcvr.maxBodySize+1))
+	}
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(raw.err, &maxErr), errors.Is(decompressErr, errTooLarge), int64(buf.Len()) > rcvr.maxBodySize:
+		atomic.AddUint64(&rcvr.stats.TooLarge, 1)
+		return nil, 413, fmt.Errorf("request body larger than %d bytes", rcvr.maxBodySize)
+	case raw.err != nil:
+		atomic.AddUint64(&rcvr.settings.stats.ReadFailed, 1)
+		return nil, 400, fmt.Errorf("read error on http body: %w", raw.err)
+	case decompressErr != nil:
+		atomic.AddUint64(&rcvr.stats.DecompressFailed, 1)
+		code := 400
+		if errors.Is(decompressErr, errUnsupportedEncoding) {
+			code = 415
+		}
+		return nil, code, fmt.Errorf("unable to decompress http body: %w", decompressErr)
+	}
+	return buf.Bytes(), 0, nil
 }
 
 // fallback is used to handle the / path if it isn't defined, mainly to
@@ -95,26 +299,181 @@ type httpReturn struct {
 	Message string
 }
 
-func (auth *HTTPAuth) auth(r *http.Request) error {
+// auth authenticates the request. Returns the metadata to add from
+// JWTMetadata, and the tenant of the request, if any.
+func (auth *HTTPAuth) auth(r *http.Request) (map[string]interface{}, string, error) {
+	md, tenant, err := auth.authenticate(r)
+	if err == nil && tenant == "" {
+		tenant = auth.Tenant
+	}
+	return md, tenant, err
+}
+
+func (auth *HTTPAuth) authenticate(r *http.Request) (map[string]interface{}, string, error) {
+	if token, ok := bearerToken(r); ok && (len(auth.Tokens) > 0 || len(auth.TenantTokens) > 0 || auth.JWTKeys != "") {
+		if tenant, ok := auth.tenantToken(token); ok {
+			return nil, tenant, nil
+		}
+		if secretMatch(auth.Tokens, token) {
+			return nil, "", nil
+		}
+		if auth.JWTKeys != "" {
+			return auth.jwt(token)
+		}
+		return nil, "", fmt.Errorf("Invalid token")
+	}
+
+	if len(auth.APIKeys) > 0 || len(auth.TenantTokens) > 0 {
+		if key := r.Header.Get(auth.apiKeyHeader()); key != "" {
+			if tenant, ok := auth.tenantToken(key); ok {
+				return nil, tenant, nil
+			}
+			if secretMatch(auth.APIKeys, key) {
+				return nil, "", nil
+			}
+			return nil, "", fmt.Errorf("Invalid API key")
+		}
+	}
+
 	if auth.Username != "" && auth.Password != "" {
 		username, pw, ok := r.BasicAuth()
 		success := ok && auth.Username == username && auth.Password.Expose() == pw
 		if !success {
-			return fmt.Errorf("Invalid credentials")
+			return nil, "", fmt.Errorf("Invalid credentials")
 		}
 
-		return nil
+		return nil, "", nil
 	}
 
 	if auth.SANDNSName != "" {
 		httpLog.Trace("Verifying request using client certificates")
 		if err := auth.verifyPeerCertificate(nil, r.TLS.VerifiedChains); err != nil {
-			return err
+			return nil, "", err
 		}
-		return nil
+		return nil, "", nil
+	}
+
+	return nil, "", fmt.Errorf("no matching authentication method")
+}
+
+// tenantToken returns the tenant of token, if it is one of TenantTokens.
+func (auth *HTTPAuth) tenantToken(token string) (string, bool) {
+	for tenant, tokens := range auth.TenantTokens {
+		if secretMatch(tokens, token) {
+			return tenant, true
+		}
+	}
+	return "", false
+}
+
+// bearerToken returns the bearer token of the request, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func (auth *HTTPAuth) apiKeyHeader() string {
+	if auth.APIKeyHeader == "" {
+		return "X-API-Key"
+	}
+	return auth.APIKeyHeader
+}
+
+// secretMatch checks if s is one of secrets, in constant time.
+func secretMatch(secrets []skogul.Secret, s string) bool {
+	match := 0
+	for _, secret := range secrets {
+		match |= subtle.ConstantTimeCompare([]byte(secret.Expose()), []byte(s))
+	}
+	return match == 1
+}
+
+// jwtMethods are the signing methods accepted for JWTs. Symmetric methods
+// are not accepted, since we only have public keys.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwt validates a JWT, and returns the metadata to add and the tenant
+// from its claims.
+func (auth *HTTPAuth) jwt(token string) (map[string]interface{}, string, error) {
+	auth.once.Do(func() {
+		auth.keys, auth.keysErr = loadJWTKeys(auth.JWTKeys)
+		if auth.keysErr != nil {
+			httpLog.WithError(auth.keysErr).Error("Unable to load JWT keys, rejecting all JWTs")
+		}
+	})
+	if auth.keysErr != nil {
+		return nil, "", fmt.Errorf("Invalid token")
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(jwtMethods), jwt.WithExpirationRequired()}
+	if auth.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(auth.JWTIssuer))
+	}
+	if auth.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(auth.JWTAudience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return auth.keys.lookup(kid), nil
+	}, opts...)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid token: %w", err)
+	}
+	for claim, want := range auth.JWTClaims {
+		if !jwtClaimMatch(claims[claim], want) {
+			return nil, "", fmt.Errorf("Invalid token: claim %s does not match", claim)
+		}
+	}
+	tenant := ""
+	if auth.JWTTenant != "" {
+		tenant, _ = claims[auth.JWTTenant].(string)
+		if tenant == "" {
+			return nil, "", fmt.Errorf("Invalid token: claim %s is missing", auth.JWTTenant)
+		}
+	}
+	if len(auth.JWTMetadata) == 0 {
+		return nil, tenant, nil
+	}
+	md := make(map[string]interface{}, len(auth.JWTMetadata))
+	for claim, field := range auth.JWTMetadata {
+		v, ok := claims[claim]
+		if !ok {
+			return nil, "", fmt.Errorf("Invalid token: claim %s is missing", claim)
+		}
+		md[field] = v
 	}
+	return md, tenant, nil
+}
 
-	return fmt.Errorf("no matching authentication method")
+// jwtClaimMatch checks if the claim is want, or is a list containing
+// want. Space-separated lists, as used by the scope claim, count as
+// lists.
+func jwtClaimMatch(claim interface{}, want string) bool {
+	switch c := claim.(type) {
+	case string:
+		if c == want {
+			return true
+		}
+		for _, s := range strings.Fields(c) {
+			if s == want {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range c {
+			if fmt.Sprint(v) == want {
+				return true
+			}
+		}
+	case nil:
+		return false
+	default:
+		return fmt.Sprint(c) == want
+	}
+	return false
 }
 
 func answer(w http.ResponseWriter, r *http.Request, code int, inerr error) {
@@ -134,27 +493,51 @@ func answer(w http.ResponseWriter, r *http.Request, code int, inerr error) {
 }
 
 func (rcvr receiver) handle(w http.ResponseWriter, r *http.Request) (int, error) {
+	var md map[string]interface{}
+	tenant := ""
 	if rcvr.auth != nil {
-		if err := rcvr.auth.auth(r); err != nil {
+		var err error
+		if md, tenant, err = rcvr.auth.auth(r); err != nil {
+			atomic.AddUint64(&rcvr.stats.AuthFailed, 1)
 			return 401, err
 		}
 	}
+	if tenant == "" {
+		tenant = rcvr.tenant
+	}
 
 	atomic.AddUint64(&rcvr.settings.stats.Received, 1)
-	if r.ContentLength == 0 {
-		atomic.AddUint64(&rcvr.settings.stats.NoData, 1)
-		return 400, fmt.Errorf("no body in HTTP request")
+	atomic.AddUint64(&rcvr.stats.Received, 1)
+	if r.ContentLength > rcvr.maxBodySize {
+		atomic.AddUint64(&rcvr.stats.TooLarge, 1)
+		return 413, fmt.Errorf("request body larger than %d bytes", rcvr.maxBodySize)
 	}
 
-	b := make([]byte, r.ContentLength)
-
-	if _, err := io.ReadFull(r.Body, b); err != nil {
-		atomic.AddUint64(&rcvr.settings.stats.ReadFailed, 1)
-		return 400, fmt.Errorf("read error on http body: %w", err)
+	p, err := rcvr.parser(r)
+	if err != nil {
+		return 415, err
+	}
+	keep := rcvr.Handler.Compression()
+	if p != nil {
+		keep = ""
+		if cp, ok := p.(skogul.CompressedParser); ok {
+			keep = cp.Compression()
+		}
 	}
 
-	if err := rcvr.Handler.Handle(b); err != nil {
+	b, code, err := rcvr.body(w, r, keep)
+	if err != nil {
+		return code, err
+	}
+	if len(b) == 0 {
+		atomic.AddUint64(&rcvr.settings.stats.NoData, 1)
+		return 400, fmt.Errorf("no body in HTTP request")
+	}
+	if err = rcvr.handleWith(p, b, md, tenant); err != nil {
 		atomic.AddUint64(&rcvr.settings.stats.HandlerErrors, 1)
+		if errors.Is(err, skogul.ErrQuotaExceeded) {
+			return 429, err
+		}
 		return 400, err
 	}
 
@@ -162,6 +545,59 @@ func (rcvr receiver) handle(w http.ResponseWriter, r *http.Request) (int, error)
 	return 204, nil
 }
 
+// parser returns the parser selected by the ParserParam query parameter or
+// the Content-Type of the request, or nil to use the parser of the
+// handler.
+func (rcvr receiver) parser(r *http.Request) (skogul.Parser, error) {
+	if len(rcvr.parsers) == 0 {
+		return nil, nil
+	}
+	if rcvr.parserParam != "" {
+		if name := r.URL.Query().Get(rcvr.parserParam); name != "" {
+			p := rcvr.parsers[strings.ToLower(name)]
+			if p == nil {
+				return nil, fmt.Errorf("unknown %s %q", rcvr.parserParam, name)
+			}
+			return p, nil
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil
+	}
+	return rcvr.parsers[mediaType], nil
+}
+
+// handleWith parses b with p, or the parser of the handler if p is nil,
+// adds md to the metadata of all metrics, then transforms and sends it
+// using the handler, as tenant, if set.
+func (rcvr receiver) handleWith(p skogul.Parser, b []byte, md map[string]interface{}, tenant string) error {
+	var c *skogul.Container
+	var err error
+	if p != nil {
+		c, err = p.Parse(b)
+		if err != nil {
+			err = fmt.Errorf("parsing failed: %w", err)
+		}
+	} else {
+		c, err = rcvr.Handler.Parse(b)
+	}
+	if err != nil {
+		return err
+	}
+	if len(md) > 0 {
+		for _, m := range c.Metrics {
+			if m.Metadata == nil {
+				m.Metadata = make(map[string]interface{}, len(md))
+			}
+			for k, v := range md {
+				m.Metadata[k] = v
+			}
+		}
+	}
+	return rcvr.Handler.TransformAndSendAs(c, tenant)
+}
+
 // Core HTTP handler
 func (rcvr receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	code, err := rcvr.handle(w, r)
@@ -171,7 +607,7 @@ func (rcvr receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"remoteAddress": r.RemoteAddr,
 			"requestUri":    r.RequestURI,
 			"ContentLength": r.ContentLength}).WithError(err).Warnf("HTTP request failed")
-	} else if rcvr.settings.Log204OK {
+	} else if rcvr.log204OK {
 		httpLog.WithFields(log.Fields{
 			"code":          code,
 			"remoteAddress": r.RemoteAddr,
@@ -216,11 +652,17 @@ func loadClientCertificateCAs(paths []string) (*x509.CertPool, error) {
 	return pool, nil
 }
 
-// Start never returns.
-func (htt *HTTP) Start() error {
-	server := http.Server{}
+// makeMux sets up a ServeMux for the configured Handlers. The stats of
+// each path are kept across reloads.
+func (htt *HTTP) makeMux() *http.ServeMux {
 	serveMux := http.NewServeMux()
-	server.Handler = serveMux
+	maxBodySize := htt.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = httpMaxBodySize
+	}
+	if htt.paths == nil {
+		htt.paths = make(map[string]*httpPathStats)
+	}
 	for idx, h := range htt.Handlers {
 		httpLog.WithFields(log.Fields{
 			"configuredHandler": idx,
@@ -228,7 +670,27 @@ func (htt *HTTP) Start() error {
 			"hasAuth":           htt.Auth[idx] != nil,
 		}).Debug("Adding handler")
 
-		serveMux.Handle(idx, receiver{Handler: h.H, settings: htt, auth: htt.Auth[idx]})
+		if htt.paths[idx] == nil {
+			htt.paths[idx] = &httpPathStats{}
+		}
+		var parsers map[string]skogul.Parser
+		if len(htt.Parsers[idx]) > 0 {
+			parsers = make(map[string]skogul.Parser)
+			for name, p := range htt.Parsers[idx] {
+				parsers[strings.ToLower(name)] = p.P
+			}
+		}
+		serveMux.Handle(idx, receiver{
+			Handler:     h.H,
+			settings:    htt,
+			auth:        htt.Auth[idx],
+			log204OK:    htt.Log204OK,
+			maxBodySize: maxBodySize,
+			stats:       htt.paths[idx],
+			parsers:     parsers,
+			parserParam: htt.ParserParam,
+			tenant:      htt.Tenants[idx],
+		})
 	}
 	if htt.Handlers["/"] == nil {
 		f := fallback{}
@@ -239,6 +701,22 @@ func (htt *HTTP) Start() error {
 		}
 		serveMux.Handle("/", f)
 	}
+	return serveMux
+}
+
+// serve passes the request on to the current ServeMux, which is replaced
+// if the configuration is reloaded.
+func (htt *HTTP) serve(w http.ResponseWriter, r *http.Request) {
+	htt.lock.RLock()
+	mux := htt.mux
+	htt.lock.RUnlock()
+	mux.ServeHTTP(w, r)
+}
+
+// Start only returns if the receiver is stopped using Stop().
+func (htt *HTTP) Start() error {
+	server := &http.Server{}
+	server.Handler = http.HandlerFunc(htt.serve)
 
 	if len(htt.ClientCertificateCAs) > 0 {
 		pool, err := loadClientCertificateCAs(htt.ClientCertificateCAs)
@@ -267,16 +745,73 @@ func (htt *HTTP) Start() error {
 	}
 
 	server.Addr = htt.Address
+	htt.lock.Lock()
+	if htt.stopped {
+		htt.lock.Unlock()
+		return nil
+	}
+	htt.server = server
+	htt.mux = htt.makeMux()
+	htt.lock.Unlock()
+	// If Stop() is called before we start listening, the server is
+	// already shut down, and ListenAndServe returns ErrServerClosed.
+	var err error
 	if htt.Certfile != "" {
 		httpLog.WithField("address", htt.Address).Info("Starting http receiver with TLS")
-		httpLog.Fatal(server.ListenAndServeTLS(htt.Certfile, htt.Keyfile))
+		err = server.ListenAndServeTLS(htt.Certfile, htt.Keyfile)
 	} else {
 		httpLog.WithField("address", htt.Address).Info("Starting INSECURE http receiver (no TLS)")
-		httpLog.Fatal(server.ListenAndServe())
+		err = server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
 	}
+	httpLog.Fatal(err)
 	return fmt.Errorf("unreachable")
 }
 
+// Stop closes the listener and waits for requests in progress to
+// complete. If the receiver hasn't started listening yet, it won't.
+func (htt *HTTP) Stop() error {
+	htt.lock.Lock()
+	htt.stopped = true
+	server := htt.server
+	htt.lock.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(context.Background())
+}
+
+// Reload adopts the Handlers, Auth, Log204OK, MaxBodySize, Parsers,
+// ParserParam and Tenants settings of the new
+// receiver, as long as the listener and TLS settings are unchanged,
+// allowing the receiver to keep running when the configuration is
+// reloaded.
+func (htt *HTTP) Reload(r skogul.Receiver) error {
+	n, ok := r.(*HTTP)
+	if !ok {
+		return fmt.Errorf("new receiver is not a HTTP receiver")
+	}
+	if n.Address != htt.Address || n.Certfile != htt.Certfile || n.Keyfile != htt.Keyfile || !reflect.DeepEqual(n.ClientCertificateCAs, htt.ClientCertificateCAs) {
+		return fmt.Errorf("listener or TLS settings changed")
+	}
+	htt.lock.Lock()
+	defer htt.lock.Unlock()
+	if htt.server == nil || htt.stopped {
+		return fmt.Errorf("receiver not running")
+	}
+	htt.Handlers = n.Handlers
+	htt.Auth = n.Auth
+	htt.Log204OK = n.Log204OK
+	htt.MaxBodySize = n.MaxBodySize
+	htt.Parsers = n.Parsers
+	htt.ParserParam = n.ParserParam
+	htt.Tenants = n.Tenants
+	htt.mux = htt.makeMux()
+	return nil
+}
+
 // verifyPeerCertificate verifies a client certificate presented to us
 // during TLS handshake by comparing its extensions (such as SAN) to
 // some expected value(s)
@@ -329,6 +864,28 @@ func (htt *HTTP) Verify() error {
 		httpLog.Warn("Missing listen address for http receiver, using Go default")
 	}
 
+	if htt.MaxBodySize < 0 {
+		return fmt.Errorf("MaxBodySize can't be negative")
+	}
+	for path := range htt.Tenants {
+		if htt.Handlers[path] == nil {
+			return fmt.Errorf("Tenant specified for path %s, which has no handler", path)
+		}
+	}
+	for path, parsers := range htt.Parsers {
+		if htt.Handlers[path] == nil {
+			return fmt.Errorf("Parsers specified for path %s, which has no handler", path)
+		}
+		for name := range parsers {
+			if name == "" {
+				return fmt.Errorf("empty Content-Type in Parsers for path %s", path)
+			}
+		}
+	}
+	if htt.ParserParam != "" && len(htt.Parsers) == 0 {
+		return fmt.Errorf("ParserParam specified without Parsers")
+	}
+
 	if htt.Certfile == "" && htt.Auth != nil {
 		httpLog.Warn("HTTP receiver configured with authentication but not with TLS! Auth will happen in the open!")
 	}
@@ -349,6 +906,22 @@ func (htt *HTTP) Verify() error {
 		if auth.SANDNSName != "" && cas == nil {
 			return fmt.Errorf("No Client Certificate CAs defined, but DNS Name for SAN specified. Specify ClientCertificateCAs configuration element.")
 		}
+		secrets := append(append([]skogul.Secret{}, auth.Tokens...), auth.APIKeys...)
+		for _, tokens := range auth.TenantTokens {
+			secrets = append(secrets, tokens...)
+		}
+		for _, secret := range secrets {
+			if secret == "" {
+				return fmt.Errorf("Empty token or API key specified.")
+			}
+		}
+		if auth.JWTKeys != "" {
+			if _, err := loadJWTKeys(auth.JWTKeys); err != nil {
+				return err
+			}
+		} else if auth.JWTIssuer != "" || auth.JWTAudience != "" || len(auth.JWTClaims) > 0 || len(auth.JWTMetadata) > 0 || auth.JWTTenant != "" {
+			return fmt.Errorf("JWT settings specified without JWTKeys.")
+		}
 	}
 
 	return nil
@@ -366,7 +939,7 @@ func (htt *HTTP) GetStats() *skogul.Metric {
 
 	metric.Metadata["component"] = "receiver"
 	metric.Metadata["type"] = "HTTP"
-	metric.Metadata["identity"] = skogul.Identity[htt]
+	metric.Metadata["identity"] = skogul.IdentityOf(htt)
 
 	metric.Data["received"] = htt.stats.Received
 	metric.Data["no_data"] = htt.stats.NoData
@@ -374,5 +947,32 @@ func (htt *HTTP) GetStats() *skogul.Metric {
 	metric.Data["handler_errors"] = htt.stats.HandlerErrors
 	metric.Data["sent"] = htt.stats.Sent
 
+	// Per-path stats, and the totals of all paths
+	paths := make(map[string]interface{})
+	var tooLarge, decompressFailed, authFailed uint64
+	htt.lock.RLock()
+	for path, s := range htt.paths {
+		p := httpPathStats{
+			Received:         atomic.LoadUint64(&s.Received),
+			TooLarge:         atomic.LoadUint64(&s.TooLarge),
+			DecompressFailed: atomic.LoadUint64(&s.DecompressFailed),
+			AuthFailed:       atomic.LoadUint64(&s.AuthFailed),
+		}
+		paths[path] = map[string]interface{}{
+			"received":          p.Received,
+			"too_large":         p.TooLarge,
+			"decompress_failed": p.DecompressFailed,
+			"auth_failed":       p.AuthFailed,
+		}
+		tooLarge += p.TooLarge
+		decompressFailed += p.DecompressFailed
+		authFailed += p.AuthFailed
+	}
+	htt.lock.RUnlock()
+	metric.Data["too_large"] = tooLarge
+	metric.Data["decompress_failed"] = decompressFailed
+	metric.Data["auth_failed"] = authFailed
+	metric.Data["paths"] = paths
+
 	return &metric
 }
@@ -25,12 +25,30 @@
 package receiver_test
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/config"
+	"github.com/telenornms/skogul/parser"
 	"github.com/telenornms/skogul/receiver"
 	"github.com/telenornms/skogul/sender"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -452,3 +470,499 @@ func BenchmarkHttp_ssl_batch_json(b *testing.B) {
 		sCommon.TestSync(b, sSSL, &validContainer, 100, 1)
 	}
 }
+
+func TestHttp_reload(t *testing.T) {
+	one := &(sender.Test{})
+	h := skogul.Handler{Sender: one}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.HTTP{Address: "localhost:1341", Handlers: map[string]*skogul.HandlerRef{"/a": {H: &h}}}
+	go rcv.Start()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	defer rcv.Stop()
+
+	post := func(path string) int {
+		t.Helper()
+		resp, err := http.Post("http://localhost:1341"+path, "application/json", bytes.NewReader(pJSON))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("/a"); code != 204 {
+		t.Errorf("POST to /a before reload returned %d, wanted 204", code)
+	}
+	if err := rcv.Reload(&receiver.HTTP{Address: "localhost:1342", Handlers: map[string]*skogul.HandlerRef{"/b": {H: &h}}}); err == nil {
+		t.Errorf("HTTP.Reload() with a new address succeeded")
+	}
+	if err := rcv.Reload(&receiver.HTTP{Address: "localhost:1341", Handlers: map[string]*skogul.HandlerRef{"/b": {H: &h}}}); err != nil {
+		t.Errorf("HTTP.Reload() failed: %v", err)
+	}
+	if code := post("/a"); code != 404 {
+		t.Errorf("POST to /a after reload returned %d, wanted 404", code)
+	}
+	if code := post("/b"); code != 204 {
+		t.Errorf("POST to /b after reload returned %d, wanted 204", code)
+	}
+}
+
+func TestHttp_body(t *testing.T) {
+	one := &(sender.Test{})
+	h := skogul.Handler{Sender: one}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.HTTP{Address: "localhost:1359", Handlers: map[string]*skogul.HandlerRef{"/": {H: &h}}, MaxBodySize: 1024}
+	go rcv.Start()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	defer rcv.Stop()
+
+	compress := func(w io.WriteCloser, buf *bytes.Buffer, b []byte) []byte {
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+	var gz, zl, fl, zs, sf bytes.Buffer
+	zw, _ := zstd.NewWriter(&zs)
+	fw, _ := flate.NewWriter(&fl, flate.BestCompression)
+	large := bytes.Repeat([]byte(" "), 2048)
+	post := func(encoding string, body io.Reader) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", "http://localhost:1359/", body)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	cases := []struct {
+		encoding string
+		body     io.Reader
+		code     int
+	}{
+		{"", bytes.NewReader(pJSON), 204},
+		// A reader of unknown length, sent chunked
+		{"", io.MultiReader(bytes.NewReader(pJSON)), 204},
+		{"gzip", bytes.NewReader(compress(gzip.NewWriter(&gz), &gz, pJSON)), 204},
+		{"deflate", bytes.NewReader(compress(zlib.NewWriter(&zl), &zl, pJSON)), 204},
+		{"deflate", bytes.NewReader(compress(fw, &fl, pJSON)), 204},
+		{"zstd", bytes.NewReader(compress(zw, &zs, pJSON)), 204},
+		{"snappy", bytes.NewReader(snappy.Encode(nil, pJSON)), 204},
+		{"snappy", bytes.NewReader(compress(snappy.NewBufferedWriter(&sf), &sf, pJSON)), 204},
+		{"", bytes.NewReader(nil), 400},
+		{"br", bytes.NewReader(pJSON), 415},
+		{"gzip", bytes.NewReader(pJSON), 400},
+		{"", bytes.NewReader(large), 413},
+		{"", io.MultiReader(bytes.NewReader(large)), 413},
+		{"snappy", bytes.NewReader(snappy.Encode(nil, large)), 413},
+	}
+	for i, c := range cases {
+		if code := post(c.encoding, c.body); code != c.code {
+			t.Errorf("case %d: POST with Content-Encoding %q returned %d, wanted %d", i, c.encoding, code, c.code)
+		}
+	}
+	gz.Reset()
+	if code := post("gzip", bytes.NewReader(compress(gzip.NewWriter(&gz), &gz, large))); code != 413 {
+		t.Errorf("POST of compressed body too large after decompression returned %d, wanted 413", code)
+	}
+	if got := one.Received(); got != 8 {
+		t.Errorf("expected 8 containers, got %d", got)
+	}
+
+	stats := rcv.GetStats()
+	if stats.Data["too_large"] != uint64(4) || stats.Data["decompress_failed"] != uint64(2) {
+		t.Errorf("unexpected stats: %v", stats.Data)
+	}
+	path := stats.Data["paths"].(map[string]interface{})["/"].(map[string]interface{})
+	if path["received"] != uint64(15) {
+		t.Errorf("expected 15 requests to /, got %v", path["received"])
+	}
+}
+
+func TestHttp_parsers(t *testing.T) {
+	conf, err := config.Bytes([]byte(`
+{
+	"receivers": {
+		"http": {
+			"type": "http",
+			"address": "localhost:1361",
+			"handlers": { "/": "common" },
+			"parsers": {
+				"/": {
+					"text/plain": "influxdb",
+					"influx": "influxdb",
+					"json": "skogul"
+				}
+			},
+			"parserparam": "format"
+		}
+	},
+	"handlers": {
+		"common": {
+			"parser": "skogul",
+			"transformers": [],
+			"sender": "common"
+		}
+	},
+	"senders": {
+		"common": {
+			"type": "test"
+		}
+	}
+}`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	one := conf.Senders["common"].Sender.(*sender.Test)
+	rcv := conf.Receivers["http"].Receiver.(*receiver.HTTP)
+	go rcv.Start()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	defer rcv.Stop()
+
+	influx := []byte("cpu,host=a usage=1.5 1600000000000000000\n")
+	cases := []struct {
+		query       string
+		contentType string
+		body        []byte
+		code        int
+	}{
+		{"", "application/json", pJSON, 204},
+		{"", "", pJSON, 204},
+		{"", "text/plain; charset=utf-8", influx, 204},
+		{"", "Text/Plain", influx, 204},
+		{"?format=influx", "application/json", influx, 204},
+		{"?format=JSON", "text/plain", pJSON, 204},
+		{"?format=xml", "", pJSON, 415},
+		{"", "text/plain", pJSON, 400},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("POST", "http://localhost:1361/"+c.query, bytes.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Errorf("case %d: POST %q with Content-Type %q returned %d, wanted %d", i, c.query, c.contentType, resp.StatusCode, c.code)
+		}
+	}
+	if got := one.Received(); got != 6 {
+		t.Errorf("expected 6 containers, got %d", got)
+	}
+
+	bad := receiver.HTTP{
+		Handlers: map[string]*skogul.HandlerRef{"/": {}},
+		Parsers:  map[string]map[string]*skogul.ParserRef{"/other": {"text/plain": {}}},
+	}
+	if err := bad.Verify(); err == nil {
+		t.Errorf("expected Verify with Parsers for unknown path to fail")
+	}
+}
+
+func TestHttp_tokenAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	dir := t.TempDir()
+	jwks := filepath.Join(dir, "jwks.json")
+	b64 := base64.RawURLEncoding.EncodeToString
+	err = os.WriteFile(jwks, []byte(fmt.Sprintf(`{"keys": [{"kty": "EC", "kid": "k1", "crv": "P-256", "x": %q, "y": %q}]}`,
+		b64(key.X.FillBytes(make([]byte, 32))), b64(key.Y.FillBytes(make([]byte, 32))))), 0644)
+	if err != nil {
+		t.Fatalf("unable to write JWKS: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pemFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("unable to write PEM: %v", err)
+	}
+
+	rec := &recorder{ch: make(chan *skogul.Container, 20)}
+	h := skogul.Handler{Sender: rec}
+	h.SetParser(parser.SkogulJSON{})
+	jwtAuth := receiver.HTTPAuth{
+		JWTKeys:     jwks,
+		JWTIssuer:   "https://idp.example.com",
+		JWTClaims:   map[string]string{"scope": "metrics:write"},
+		JWTMetadata: map[string]string{"tenant": "tenant"},
+	}
+	rcv := receiver.HTTP{
+		Address: "localhost:1363",
+		Handlers: map[string]*skogul.HandlerRef{
+			"/token": {H: &h},
+			"/jwt":   {H: &h},
+			"/pem":   {H: &h},
+		},
+		Auth: map[string]*receiver.HTTPAuth{
+			"/token": {Tokens: []skogul.Secret{"t1", "t2"}, APIKeys: []skogul.Secret{"k1"}},
+			"/jwt":   &jwtAuth,
+			"/pem":   {JWTKeys: pemFile},
+		},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	defer rcv.Stop()
+
+	sign := func(k *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+		t.Helper()
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		tok.Header["kid"] = "k1"
+		s, err := tok.SignedString(k)
+		if err != nil {
+			t.Fatalf("unable to sign token: %v", err)
+		}
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	good := jwt.MapClaims{"iss": "https://idp.example.com", "exp": exp, "scope": "metrics:read metrics:write", "tenant": "acme"}
+	claims := func(change map[string]interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{}
+		for k, v := range good {
+			c[k] = v
+		}
+		for k, v := range change {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	cases := []struct {
+		path   string
+		header string
+		value  string
+		code   int
+	}{
+		{"/token", "Authorization", "Bearer t2", 204},
+		{"/token", "Authorization", "bearer t1", 204},
+		{"/token", "X-API-Key", "k1", 204},
+		{"/token", "Authorization", "Bearer k1", 401},
+		{"/token", "X-API-Key", "t1", 401},
+		{"/token", "", "", 401},
+		{"/jwt", "Authorization", "Bearer " + sign(key, good), 204},
+		{"/jwt", "Authorization", "Bearer " + sign(other, good), 401},
+		{"/jwt", "Authorization", "Bearer " + sign(key, claims(map[string]interface{}{"iss": "https://evil.example.com"})), 401},
+		{"/jwt", "Authorization", "Bearer " + sign(key, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), 401},
+		{"/jwt", "Authorization", "Bearer " + sign(key, claims(map[string]interface{}{"exp": nil})), 401},
+		{"/jwt", "Authorization", "Bearer " + sign(key, claims(map[string]interface{}{"scope": "metrics:read"})), 401},
+		{"/jwt", "Authorization", "Bearer " + sign(key, claims(map[string]interface{}{"tenant": nil})), 401},
+		{"/jwt", "Authorization", "Bearer t1", 401},
+		{"/pem", "Authorization", "Bearer " + sign(key, jwt.MapClaims{"exp": exp}), 204},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("POST", "http://localhost:1363"+c.path, bytes.NewReader(pJSON))
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Errorf("case %d: POST to %s returned %d, wanted %d", i, c.path, resp.StatusCode, c.code)
+		}
+		if c.code != 204 {
+			continue
+		}
+		got := <-rec.ch
+		tenant, ok := got.Metrics[0].Metadata["tenant"]
+		if c.path == "/jwt" && tenant != "acme" {
+			t.Errorf("case %d: expected tenant acme from JWT, got %v", i, tenant)
+		} else if c.path != "/jwt" && ok {
+			t.Errorf("case %d: unexpected tenant %v", i, tenant)
+		}
+	}
+	stats := rcv.GetStats()
+	if stats.Data["auth_failed"] != uint64(10) {
+		t.Errorf("expected 10 failed authentications, got %v", stats.Data["auth_failed"])
+	}
+
+	bad := []*receiver.HTTPAuth{
+		{Tokens: []skogul.Secret{""}},
+		{JWTKeys: filepath.Join(dir, "missing.json")},
+		{JWTKeys: pemFile + "x"},
+		{JWTIssuer: "https://idp.example.com"},
+	}
+	for i, auth := range bad {
+		r := receiver.HTTP{Handlers: map[string]*skogul.HandlerRef{"/": {}}, Auth: map[string]*receiver.HTTPAuth{"/": auth}}
+		if err := r.Verify(); err == nil {
+			t.Errorf("expected Verify of auth config %d to fail", i)
+		}
+	}
+}
+
+func TestHttp_tenants(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pemFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("unable to write PEM: %v", err)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix(), "org": "c"})
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("unable to sign token: %v", err)
+	}
+
+	rec := &recorder{ch: make(chan *skogul.Container, 20)}
+	h := skogul.Handler{Sender: rec, Tenancy: &skogul.Tenancy{
+		Quotas: map[string]*skogul.TenantQuota{"d": {Rate: 0.001, Burst: 1}},
+	}}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.HTTP{
+		Address: "localhost:1365",
+		Handlers: map[string]*skogul.HandlerRef{
+			"/token": {H: &h},
+			"/jwt":   {H: &h},
+			"/d":     {H: &h},
+			"/open":  {H: &h},
+		},
+		Auth: map[string]*receiver.HTTPAuth{
+			"/token": {TenantTokens: map[string][]skogul.Secret{"a": {"ta"}, "b": {"tb1", "tb2"}}, Tokens: []skogul.Secret{"shared"}, Tenant: "x"},
+			"/jwt":   {JWTKeys: pemFile, JWTTenant: "org"},
+		},
+		Tenants: map[string]string{"/token": "y", "/d": "d"},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	defer rcv.Stop()
+
+	cases := []struct {
+		path   string
+		header string
+		value  string
+		code   int
+		tenant string
+	}{
+		{"/token", "Authorization", "Bearer ta", 204, "a"},
+		{"/token", "Authorization", "Bearer tb2", 204, "b"},
+		{"/token", "X-API-Key", "tb1", 204, "b"},
+		{"/token", "Authorization", "Bearer shared", 204, "x"},
+		{"/token", "Authorization", "Bearer tc", 401, ""},
+		{"/jwt", "Authorization", "Bearer " + signed, 204, "c"},
+		{"/d", "", "", 204, "d"},
+		{"/d", "", "", 429, ""},
+		{"/open", "", "", 400, ""},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("POST", "http://localhost:1365"+c.path, bytes.NewReader(pJSON))
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Errorf("case %d: POST to %s returned %d, wanted %d", i, c.path, resp.StatusCode, c.code)
+		}
+		if c.code != 204 {
+			continue
+		}
+		got := <-rec.ch
+		if tenant := got.Metrics[0].Metadata["tenant"]; tenant != c.tenant {
+			t.Errorf("case %d: expected tenant %s, got %v", i, c.tenant, tenant)
+		}
+	}
+
+	bad := []receiver.HTTP{
+		{Handlers: map[string]*skogul.HandlerRef{"/": {}}, Tenants: map[string]string{"/x": "a"}},
+		{Handlers: map[string]*skogul.HandlerRef{"/": {}}, Auth: map[string]*receiver.HTTPAuth{"/": {TenantTokens: map[string][]skogul.Secret{"a": {""}}}}},
+		{Handlers: map[string]*skogul.HandlerRef{"/": {}}, Auth: map[string]*receiver.HTTPAuth{"/": {JWTTenant: "org"}}},
+	}
+	for i := range bad {
+		if err := bad[i].Verify(); err == nil {
+			t.Errorf("expected Verify of config %d to fail", i)
+		}
+	}
+}
+
+// Remote write is snappy-compressed with Content-Encoding set, which the
+// parser decompresses itself.
+func TestHttp_remoteWrite(t *testing.T) {
+	conf, err := config.Bytes([]byte(`
+{
+	"receivers": {
+		"http": {
+			"type": "http",
+			"address": "localhost:1375",
+			"handlers": { "/": "rw" }
+		}
+	},
+	"handlers": {
+		"rw": {
+			"parser": "prometheus_remote_write",
+			"transformers": [],
+			"sender": "common"
+		}
+	},
+	"senders": {
+		"common": {
+			"type": "test"
+		}
+	}
+}`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	one := conf.Senders["common"].Sender.(*sender.Test)
+	rcv := conf.Receivers["http"].Receiver.(*receiver.HTTP)
+	go rcv.Start()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	defer rcv.Stop()
+
+	var label, sample, series, req []byte
+	label = protowire.AppendTag(label, 1, protowire.BytesType)
+	label = protowire.AppendString(label, "__name__")
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, "up")
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, 0x3ff0000000000000) // 1.0
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, 1600000000000)
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label)
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, series)
+
+	r, _ := http.NewRequest("POST", "http://localhost:1375/", bytes.NewReader(snappy.Encode(nil, req)))
+	r.Header.Set("Content-Encoding", "snappy")
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Errorf("POST of remote write request returned %d, wanted 204", resp.StatusCode)
+	}
+	if got := one.Received(); got != 1 {
+		t.Errorf("expected 1 container, got %d", got)
+	}
+}
+
+func TestHttp_stopBeforeStart(t *testing.T) {
+	testStopBeforeStart(t, &receiver.HTTP{Address: "localhost:1370"})
+}
@@ -0,0 +1,188 @@
+/*
+ * skogul, JWT key loading for the HTTP receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKeys are the keys used to verify JWTs, by key ID. Keys without an
+// ID, such as those loaded from PEM files, have an empty ID.
+type jwtKeys struct {
+	byID map[string]crypto.PublicKey
+	all  []crypto.PublicKey
+}
+
+// jwk is a single key of a JSON Web Key Set, see RFC 7517. Only the
+// fields needed for public RSA, EC and Ed25519 keys are decoded.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWTKeys loads public keys from a JWKS file, or a PEM file with one
+// or more public keys or certificates.
+func loadJWTKeys(path string) (*jwtKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWT keys: %w", err)
+	}
+	keys := &jwtKeys{byID: make(map[string]crypto.PublicKey)}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if json.Unmarshal(b, &set) == nil {
+		for i, k := range set.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			key, err := k.publicKey()
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %d in JWKS %s: %w", i, path, err)
+			}
+			keys.add(k.Kid, key)
+		}
+	} else {
+		for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+			var key crypto.PublicKey
+			switch block.Type {
+			case "PUBLIC KEY":
+				key, err = x509.ParsePKIXPublicKey(block.Bytes)
+			case "RSA PUBLIC KEY":
+				key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+			case "CERTIFICATE":
+				var cert *x509.Certificate
+				cert, err = x509.ParseCertificate(block.Bytes)
+				if err == nil {
+					key = cert.PublicKey
+				}
+			default:
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", block.Type, path, err)
+			}
+			keys.add("", key)
+		}
+	}
+	if len(keys.all) == 0 {
+		return nil, fmt.Errorf("no keys found in %s, must be a JWKS or PEM file", path)
+	}
+	return keys, nil
+}
+
+func (keys *jwtKeys) add(id string, key crypto.PublicKey) {
+	if id != "" {
+		keys.byID[id] = key
+	}
+	keys.all = append(keys.all, key)
+}
+
+// lookup returns the keys to try for a token with the given key ID.
+func (keys *jwtKeys) lookup(id string) jwt.VerificationKeySet {
+	if key, ok := keys.byID[id]; ok {
+		return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{key}}
+	}
+	set := jwt.VerificationKeySet{Keys: make([]jwt.VerificationKey, 0, len(keys.all))}
+	for _, key := range keys.all {
+		set.Keys = append(set.Keys, key)
+	}
+	return set
+}
+
+func jwkInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey returns the public key of the JWK.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := jwkInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := jwkInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := jwkInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := jwkInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if _, err := key.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid point: %w", err)
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -26,37 +26,101 @@ package receiver
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
+
 	kafka "github.com/segmentio/kafka-go"
 	kplain "github.com/segmentio/kafka-go/sasl/plain"
 	"github.com/telenornms/skogul"
-	"time"
 )
 
 var kafkaLog = skogul.Logger("receiver", "kafka")
 
 /*
-Kafka receiver is a MVP-variant, and further features are reasonable and expected, including but not limited to:
+Kafka receiver reads messages from one or more Kafka topics.
+
+Without a GroupID, a single topic is read from Topic, and where to start
+reading is decided by StartOffset every time Skogul starts.
+
+With a GroupID, the receiver joins a consumer group, which allows
+multiple Skogul instances to share the load, and reads from all topics
+listed in Topic and Topics. The offsets of messages that are successfully
+handled are committed to Kafka every CommitInterval, and when the receiver
+is stopped, so a restarted receiver continues where it left off. If
+Skogul crashes, messages handled since the last commit are read again. If the handler fails to transform or send a message, the
+same message is retried until it succeeds, so no messages are lost
+(at-least-once delivery). Messages that fail to parse are logged and
+skipped, since retrying them will never succeed.
+
+Further features are reasonable and expected, including but not limited
+to:
 
-- Authentication (coming before release)
-- Dynamic keys from metadata
 - Adjustment of various timeouts
 */
 type Kafka struct {
-	Topic    string            `doc:"Topic to read from."`
-	Brokers  []string          `doc:"Array of brokeraddresses."`
-	Handler  skogul.HandlerRef `doc:"Handler to use"`
-	TLS      bool              `doc:"Enable TLS, off by default."`
-	Username string            `doc:"Username for SASL auth."`
-	Password string            `doc:"Password for SASL auth."`
-	ClientID string            `doc:"ClientID to use - uses lower-case skogul by default."`
+	Topic          string            `doc:"Topic to read from."`
+	Topics         []string          `doc:"Additional topics to read from. Requires GroupID."`
+	Brokers        []string          `doc:"Array of brokeraddresses."`
+	Handler        skogul.HandlerRef `doc:"Handler to use"`
+	TLS            bool              `doc:"Enable TLS, off by default."`
+	Username       string            `doc:"Username for SASL auth."`
+	Password       string            `doc:"Password for SASL auth."`
+	ClientID       string            `doc:"ClientID to use - uses lower-case skogul by default."`
+	GroupID        string            `doc:"Consumer group to join. Enables committing offsets, multiple topics and sharing partitions between multiple receivers."`
+	StartOffset    string            `doc:"Where to start reading if no offset is committed for the consumer group, or always if no GroupID is set: earliest or latest. Defaults to latest."`
+	Metadata       bool              `doc:"Add the topic, partition, offset, key and headers of each message to the metadata of every metric parsed from it."`
+	MetadataPrefix string            `doc:"Prefix of the metadata field names added when Metadata is enabled. Headers are named by the prefix, \"header_\" and the header key. Defaults to \"kafka_\"."`
+	RetryDelay     skogul.Duration   `doc:"Initial delay before retrying a message the handler failed to send. Doubled for each failure, up to a minute. Defaults to 1s."`
+	CommitInterval skogul.Duration   `doc:"How often to commit the offsets of handled messages when using a GroupID. Defaults to 1s."`
+	ctx            context.Context
+	cancel         context.CancelFunc
+	once           sync.Once
+	done           chan struct{} // Closed when Start() has returned
+	lock           sync.Mutex    // Protects started and stopped
+	started        bool
+	stopped        bool
+}
+
+func (k *Kafka) init() {
+	k.ctx, k.cancel = context.WithCancel(context.Background())
+	k.done = make(chan struct{})
 }
 
-// Start the Kafka receiver and never return
+// topics returns all topics to read from.
+func (k *Kafka) topics() []string {
+	topics := make([]string, 0, len(k.Topics)+1)
+	if k.Topic != "" {
+		topics = append(topics, k.Topic)
+	}
+	return append(topics, k.Topics...)
+}
+
+// Start the Kafka receiver. Only returns if the receiver is stopped using
+// Stop().
 func (k *Kafka) Start() error {
+	k.once.Do(k.init)
+	k.lock.Lock()
+	if k.stopped {
+		k.lock.Unlock()
+		return nil
+	}
+	k.started = true
+	k.lock.Unlock()
+	defer close(k.done)
 	if k.ClientID == "" {
 		k.ClientID = "skogul"
 	}
+	if k.MetadataPrefix == "" {
+		k.MetadataPrefix = "kafka_"
+	}
+	if k.RetryDelay.Duration == 0 {
+		k.RetryDelay.Duration = time.Second
+	}
+	if k.CommitInterval.Duration == 0 {
+		k.CommitInterval.Duration = time.Second
+	}
 	dialer := kafka.Dialer{
 		Timeout:   10 * time.Second,
 		DualStack: true,
@@ -65,35 +129,168 @@ func (k *Kafka) Start() error {
 	if k.TLS {
 		dialer.TLS = &tls.Config{}
 	}
-	if (k.Username != "" && k.Password == "") || (k.Username == "" && k.Password != "") {
-		return fmt.Errorf("Provided just one of Username or Password for Kafka receiver, which makes no sense. Provide both or neither.")
-	}
 	if k.Username != "" && k.Password != "" {
 		if !k.TLS {
 			kafkaLog.Warnf("Using authentication and no encryption... are you sure this makes sense?")
 		}
-		// XXX: WARN IF NOT TLS
 		mechanism := kplain.Mechanism{
 			Username: k.Username,
 			Password: k.Password,
 		}
 		dialer.SASLMechanism = mechanism
 	}
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: k.Brokers,
-		Topic:   k.Topic,
-		Dialer:  &dialer,
-	})
-	r.SetOffset(kafka.LastOffset)
+	offset := kafka.LastOffset
+	if k.StartOffset == "earliest" {
+		offset = kafka.FirstOffset
+	}
+	conf := kafka.ReaderConfig{
+		Brokers:        k.Brokers,
+		Dialer:         &dialer,
+		GroupID:        k.GroupID,
+		StartOffset:    offset,
+		CommitInterval: k.CommitInterval.Duration,
+	}
+	if k.GroupID != "" {
+		conf.GroupTopics = k.topics()
+	} else {
+		conf.Topic = k.Topic
+	}
+	r := kafka.NewReader(conf)
+	defer r.Close()
+	if k.GroupID == "" {
+		r.SetOffset(offset)
+	}
 	for {
-		m, err := r.ReadMessage(context.Background())
+		m, err := r.FetchMessage(k.ctx)
+		if k.ctx.Err() != nil {
+			return nil
+		}
 		if err != nil {
 			kafkaLog.WithError(err).Warnf("Unable to read message. Sleeping for 1s and retrying.")
-			time.Sleep(time.Second)
+			k.sleep(time.Second)
 			continue
 		}
-		if err := k.Handler.H.Handle(m.Value); err != nil {
+		if !k.handle(&m) {
+			return nil
+		}
+		if k.GroupID == "" {
+			continue
+		}
+		// Only queues the offset, which the reader commits every
+		// CommitInterval and when it is closed. Not using k.ctx, so
+		// a message handled right before Stop() is still committed.
+		if err := r.CommitMessages(context.Background(), m); err != nil {
+			kafkaLog.WithError(err).Warn("Unable to commit offset, message might be handled twice")
+		}
+	}
+}
+
+// sleep sleeps for d, or until the receiver is stopped. Returns false if
+// the receiver is stopped.
+func (k *Kafka) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-k.ctx.Done():
+		return false
+	}
+}
+
+// handle parses the message and passes it on, retrying until it
+// succeeds. Returns false if the receiver is stopped before that.
+func (k *Kafka) handle(m *kafka.Message) bool {
+	c, err := k.Handler.H.Parse(m.Value)
+	if err != nil {
+		kafkaLog.WithError(err).WithField("topic", m.Topic).WithField("partition", m.Partition).WithField("offset", m.Offset).Warn("Unable to parse Kafka message, skipping it")
+		return true
+	}
+	if k.Metadata {
+		k.addMetadata(c, m)
+	}
+	delay := k.RetryDelay.Duration
+	for {
+		err := k.Handler.H.TransformAndSend(c)
+		if err == nil {
+			return true
+		}
+		if k.GroupID == "" {
 			kafkaLog.WithError(err).Warn("Unable to handle Kafka message")
+			return true
+		}
+		kafkaLog.WithError(err).WithField("delay", delay).Warn("Unable to handle Kafka message, retrying")
+		if !k.sleep(delay) {
+			return false
+		}
+		// Transformers might have modified the container, so
+		// start from scratch.
+		c, _ = k.Handler.H.Parse(m.Value)
+		if k.Metadata {
+			k.addMetadata(c, m)
+		}
+		delay *= 2
+		if delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+}
+
+// addMetadata adds information about the Kafka message to all metrics.
+func (k *Kafka) addMetadata(c *skogul.Container, m *kafka.Message) {
+	for _, metric := range c.Metrics {
+		if metric.Metadata == nil {
+			metric.Metadata = make(map[string]interface{})
+		}
+		metric.Metadata[k.MetadataPrefix+"topic"] = m.Topic
+		metric.Metadata[k.MetadataPrefix+"partition"] = m.Partition
+		metric.Metadata[k.MetadataPrefix+"offset"] = m.Offset
+		if m.Key != nil {
+			metric.Metadata[k.MetadataPrefix+"key"] = string(m.Key)
 		}
+		for _, h := range m.Headers {
+			metric.Metadata[k.MetadataPrefix+"header_"+h.Key] = string(h.Value)
+		}
+	}
+}
+
+// Stop stops reading messages, and waits for the message being handled,
+// if any, to be passed on and committed. A message that is being retried
+// is left uncommitted, and will be read again when the receiver is
+// started again. If the receiver hasn't started yet, it won't.
+func (k *Kafka) Stop() error {
+	k.once.Do(k.init)
+	k.lock.Lock()
+	k.stopped = true
+	started := k.started
+	k.lock.Unlock()
+	k.cancel()
+	if started {
+		<-k.done
+	}
+	return nil
+}
+
+// Verify checks that the configuration is valid.
+func (k *Kafka) Verify() error {
+	if len(k.Brokers) == 0 {
+		return skogul.MissingArgument("Brokers")
+	}
+	if k.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if k.Topic == "" && len(k.Topics) == 0 {
+		return skogul.MissingArgument("Topic")
+	}
+	if k.GroupID == "" && len(k.Topics) > 0 {
+		return errors.New("reading from multiple Topics requires a GroupID")
+	}
+	if k.CommitInterval.Duration < 0 {
+		return fmt.Errorf("CommitInterval can't be negative")
+	}
+	if k.StartOffset != "" && k.StartOffset != "earliest" && k.StartOffset != "latest" {
+		return fmt.Errorf("invalid StartOffset %q, must be earliest or latest", k.StartOffset)
+	}
+	if (k.Username != "" && k.Password == "") || (k.Username == "" && k.Password != "") {
+		return fmt.Errorf("Provided just one of Username or Password for Kafka receiver, which makes no sense. Provide both or neither.")
 	}
+	return nil
 }
@@ -0,0 +1,105 @@
+/*
+ * skogul, kafka receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/receiver"
+)
+
+func TestKafka_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	brokers := []string{"localhost:9092"}
+	cases := []struct {
+		k  *receiver.Kafka
+		ok bool
+	}{
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topic: "a"}, true},
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topic: "a", Topics: []string{"b"}, GroupID: "g", StartOffset: "earliest"}, true},
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topics: []string{"a", "b"}, GroupID: "g"}, true},
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topic: "a", Topics: []string{"b"}}, false},
+		{&receiver.Kafka{Brokers: brokers, Handler: h}, false},
+		{&receiver.Kafka{Handler: h, Topic: "a"}, false},
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topic: "a", StartOffset: "middle"}, false},
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topic: "a", Username: "u"}, false},
+		{&receiver.Kafka{Brokers: brokers, Handler: h, Topic: "a", GroupID: "g", CommitInterval: skogul.Duration{Duration: -time.Second}}, false},
+	}
+	for i, c := range cases {
+		err := c.k.Verify()
+		if c.ok && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("case %d: expected error, got none", i)
+		}
+	}
+}
+
+// TestKafka_stop checks that a consumer group receiver that is unable to
+// reach the brokers can still be stopped.
+func TestKafka_stop(t *testing.T) {
+	k := &receiver.Kafka{
+		Brokers: []string{"localhost:1"},
+		Topic:   "test",
+		GroupID: "test",
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- k.Start()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	go k.Stop()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start() didn't return after Stop()")
+	}
+}
+
+// TestKafka_stopBeforeStart checks that Stop() doesn't wait for a Start()
+// that never happens, and that a later Start() returns right away.
+func TestKafka_stopBeforeStart(t *testing.T) {
+	k := &receiver.Kafka{Brokers: []string{"localhost:1"}, Topic: "test"}
+	stopped := make(chan error)
+	go func() {
+		stopped <- k.Stop()
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Stop() before Start() didn't return")
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- k.Start()
+	}()
+	select {
+	case <-ret:
+	case <-time.After(time.Second):
+		t.Errorf("Start() after Stop() didn't return")
+	}
+}
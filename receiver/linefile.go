@@ -131,7 +131,7 @@ func (wf *WholeFile) Start() error {
 		err := wf.read()
 		if sleep {
 			if err != nil {
-				lfLog.WithError(err).Errorf("whole file reader %s", skogul.Identity[wf])
+				lfLog.WithError(err).Errorf("whole file reader %s", skogul.IdentityOf(wf))
 			}
 			time.Sleep(freq)
 		} else {
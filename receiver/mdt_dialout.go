@@ -0,0 +1,333 @@
+/*
+ * skogul, Cisco model-driven telemetry gRPC dial-out receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cisco-ie/nx-telemetry-proto/mdt_dialout"
+	"github.com/telenornms/skogul"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+var mdtLog = skogul.Logger("receiver", "mdt_dialout")
+
+/*
+MDTDialout accepts Cisco model-driven telemetry from devices configured
+for gRPC dial-out, using the MdtDialout service. Each telemetry message is
+passed on to the handler as is, so the handler should use the mdt parser,
+which supports self-describing GPB (kvGPB) and JSON encoding.
+
+Messages split in chunks by the device are reassembled before they are
+passed on. To protect against misbehaving devices, messages larger than
+MaxMessageSize are dropped, as are messages that would make the chunks
+buffered for all devices exceed MaxBuffered, and messages that are not
+completed within ChunkTimeout.
+
+TLS is configured like for the HTTP receiver. If ClientCertificateCAs
+is set, devices must present a certificate signed by one of them.
+*/
+type MDTDialout struct {
+	Address              string            `doc:"Address to listen to." example:"[::]:57500"`
+	Handler              skogul.HandlerRef `doc:"Handler used to parse, transform and send data. Should use the mdt parser."`
+	Certfile             string            `doc:"Path to certificate file for TLS. If left blank, un-encrypted gRPC is used."`
+	Keyfile              string            `doc:"Path to key file for TLS."`
+	ClientCertificateCAs []string          `doc:"Paths to files containing CAs which are accepted for Client Certificate authentication."`
+	SANDNSName           string            `doc:"DNS name which has to be present in SAN extension of the client certificate. Requires ClientCertificateCAs."`
+	MaxMessageSize       int64             `doc:"Maximum size of a message split in chunks, in bytes. Defaults to 16MiB."`
+	MaxBuffered          int64             `doc:"Maximum size of the chunks buffered for all devices, in bytes. Defaults to 256MiB."`
+	ChunkTimeout         skogul.Duration   `doc:"How long to wait for the remaining chunks of a message before dropping it. Defaults to 1m."`
+	lock                 sync.Mutex        // Protects server and stopped
+	server               *grpc.Server
+	stopped              bool
+	stats                mdtStats
+	buffered             int64 // Bytes buffered for all streams
+}
+
+const (
+	mdtMaxMessageSize = 16 << 20
+	mdtMaxBuffered    = 256 << 20
+	mdtChunkTimeout   = time.Minute
+)
+
+// mdtStats contains the internal stats of the MDT dial-out receiver.
+type mdtStats struct {
+	Streams       uint64 // Number of streams opened by devices
+	Received      uint64 // Number of complete telemetry messages received
+	Chunks        uint64 // Number of chunks of split messages received
+	Errors        uint64 // Number of errors reported by devices
+	HandlerErrors uint64 // Number of errors from upstream handlers
+	Sent          uint64 // Number of messages successfully handled
+	Dropped       uint64 // Number of split messages dropped due to limits
+}
+
+// mdtChunks is a message being reassembled.
+type mdtChunks struct {
+	data    []byte
+	total   int64
+	updated time.Time
+}
+
+// mdtServer implements the MdtDialout service.
+type mdtServer struct {
+	m *MDTDialout
+}
+
+// MdtDialout reads telemetry from a single device until it closes the
+// stream.
+func (s mdtServer) MdtDialout(stream mdt_dialout.GRPCMdtDialout_MdtDialoutServer) error {
+	m := s.m
+	atomic.AddUint64(&m.stats.Streams, 1)
+	remote := ""
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		remote = p.Addr.String()
+	}
+	log := mdtLog.WithField("remoteAddress", remote)
+	log.Debug("Device connected")
+	chunks := make(map[int64]*mdtChunks)
+	drop := func(id int64, reason string) {
+		if c := chunks[id]; c != nil {
+			atomic.AddInt64(&m.buffered, -int64(len(c.data)))
+			delete(chunks, id)
+		}
+		atomic.AddUint64(&m.stats.Dropped, 1)
+		log.WithField("reqId", id).Warnf("Dropping split message: %s", reason)
+	}
+	defer func() {
+		for _, c := range chunks {
+			atomic.AddInt64(&m.buffered, -int64(len(c.data)))
+		}
+	}()
+	for {
+		args, err := stream.Recv()
+		if err == io.EOF {
+			log.Debug("Device disconnected")
+			return nil
+		}
+		if err != nil {
+			log.WithError(err).Debug("Stream failed")
+			return err
+		}
+		if args.Errors != "" {
+			atomic.AddUint64(&m.stats.Errors, 1)
+			log.WithField("error", args.Errors).Warn("Device reported error")
+		}
+		now := time.Now()
+		for id, c := range chunks {
+			if now.Sub(c.updated) > m.ChunkTimeout.Duration {
+				drop(id, "timed out waiting for remaining chunks")
+			}
+		}
+		data := args.Data
+		if args.TotalSize > 0 {
+			atomic.AddUint64(&m.stats.Chunks, 1)
+			total := int64(args.TotalSize)
+			c := chunks[args.ReqId]
+			switch {
+			case total > m.MaxMessageSize:
+				drop(args.ReqId, fmt.Sprintf("total size %d exceeds MaxMessageSize", total))
+				continue
+			case c != nil && c.total != total:
+				drop(args.ReqId, "total size changed between chunks")
+				continue
+			case atomic.LoadInt64(&m.buffered)+int64(len(data)) > m.MaxBuffered:
+				drop(args.ReqId, "MaxBuffered exceeded")
+				continue
+			}
+			if c == nil {
+				c = &mdtChunks{total: total}
+				chunks[args.ReqId] = c
+			}
+			c.data = append(c.data, data...)
+			c.updated = now
+			atomic.AddInt64(&m.buffered, int64(len(data)))
+			if int64(len(c.data)) < total {
+				continue
+			}
+			atomic.AddInt64(&m.buffered, -int64(len(c.data)))
+			delete(chunks, args.ReqId)
+			if int64(len(c.data)) > total {
+				atomic.AddUint64(&m.stats.Dropped, 1)
+				log.WithField("reqId", args.ReqId).Warn("Dropping split message: chunks exceed total size")
+				continue
+			}
+			data = c.data
+		}
+		if len(data) == 0 {
+			continue
+		}
+		atomic.AddUint64(&m.stats.Received, 1)
+		if err := m.Handler.H.Handle(data); err != nil {
+			atomic.AddUint64(&m.stats.HandlerErrors, 1)
+			log.WithError(err).Warn("Unable to handle telemetry message")
+			continue
+		}
+		atomic.AddUint64(&m.stats.Sent, 1)
+	}
+}
+
+// serverTLSConfig sets up TLS for a gRPC server, with the same options as
+// the HTTP receiver. Returns nil if certfile is blank.
+func serverTLSConfig(certfile, keyfile string, clientCAs []string, sanDNSName string) (*tls.Config, error) {
+	if certfile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate: %w", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if len(clientCAs) > 0 {
+		conf.ClientCAs, err = loadClientCertificateCAs(clientCAs)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if sanDNSName != "" {
+			auth := HTTPAuth{SANDNSName: sanDNSName}
+			conf.VerifyPeerCertificate = auth.verifyPeerCertificate
+		}
+	}
+	return conf, nil
+}
+
+// verifyServerTLS checks the TLS options of a gRPC server.
+func verifyServerTLS(certfile, keyfile string, clientCAs []string, sanDNSName string) error {
+	if (certfile != "" && keyfile == "") || (certfile == "" && keyfile != "") {
+		return fmt.Errorf("Specify both Certfile AND Keyfile or none at all")
+	}
+	if len(clientCAs) > 0 && certfile == "" {
+		return fmt.Errorf("ClientCertificateCAs requires TLS, specify Certfile and Keyfile")
+	}
+	if sanDNSName != "" && len(clientCAs) == 0 {
+		return fmt.Errorf("SANDNSName requires ClientCertificateCAs")
+	}
+	if _, err := loadClientCertificateCAs(clientCAs); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Start only returns if the receiver is stopped using Stop().
+func (m *MDTDialout) Start() error {
+	if m.MaxMessageSize == 0 {
+		m.MaxMessageSize = mdtMaxMessageSize
+	}
+	if m.MaxBuffered == 0 {
+		m.MaxBuffered = mdtMaxBuffered
+	}
+	if m.ChunkTimeout.Duration == 0 {
+		m.ChunkTimeout.Duration = mdtChunkTimeout
+	}
+	conf, err := serverTLSConfig(m.Certfile, m.Keyfile, m.ClientCertificateCAs, m.SANDNSName)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.ServerOption{}
+	if conf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(conf)))
+	}
+	m.lock.Lock()
+	if m.stopped {
+		m.lock.Unlock()
+		return nil
+	}
+	ln, err := net.Listen("tcp", m.Address)
+	if err != nil {
+		m.lock.Unlock()
+		return fmt.Errorf("unable to listen on %s: %w", m.Address, err)
+	}
+	server := grpc.NewServer(opts...)
+	mdt_dialout.RegisterGRPCMdtDialoutServer(server, mdtServer{m: m})
+	m.server = server
+	m.lock.Unlock()
+	if conf != nil {
+		mdtLog.WithField("address", m.Address).Info("Starting MDT dial-out receiver with TLS")
+	} else {
+		mdtLog.WithField("address", m.Address).Info("Starting INSECURE MDT dial-out receiver (no TLS)")
+	}
+	// If Stop() is called before we start serving, Serve closes the
+	// listener and returns ErrServerStopped.
+	if err := server.Serve(ln); err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
+
+// Stop closes the listener and all streams. If the receiver hasn't
+// started listening yet, it won't.
+func (m *MDTDialout) Stop() error {
+	m.lock.Lock()
+	m.stopped = true
+	server := m.server
+	m.lock.Unlock()
+	if server != nil {
+		server.Stop()
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the MDT dial-out
+// receiver.
+func (m *MDTDialout) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "MDTDialout"
+	metric.Metadata["identity"] = skogul.IdentityOf(m)
+
+	metric.Data["streams"] = atomic.LoadUint64(&m.stats.Streams)
+	metric.Data["received"] = atomic.LoadUint64(&m.stats.Received)
+	metric.Data["chunks"] = atomic.LoadUint64(&m.stats.Chunks)
+	metric.Data["errors"] = atomic.LoadUint64(&m.stats.Errors)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&m.stats.HandlerErrors)
+	metric.Data["sent"] = atomic.LoadUint64(&m.stats.Sent)
+	metric.Data["dropped"] = atomic.LoadUint64(&m.stats.Dropped)
+	metric.Data["buffered"] = atomic.LoadInt64(&m.buffered)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (m *MDTDialout) Verify() error {
+	if m.Address == "" {
+		return skogul.MissingArgument("Address")
+	}
+	if m.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if m.MaxMessageSize < 0 || m.MaxBuffered < 0 || m.ChunkTimeout.Duration < 0 {
+		return fmt.Errorf("MaxMessageSize, MaxBuffered and ChunkTimeout can't be negative")
+	}
+	return verifyServerTLS(m.Certfile, m.Keyfile, m.ClientCertificateCAs, m.SANDNSName)
+}
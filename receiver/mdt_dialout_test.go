@@ -0,0 +1,184 @@
+/*
+ * skogul, Cisco model-driven telemetry gRPC dial-out receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/cisco-ie/nx-telemetry-proto/mdt_dialout"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+	"github.com/telenornms/skogul/sender"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestMDTDialout(t *testing.T) {
+	tst := &sender.Test{}
+	h := skogul.Handler{Sender: tst}
+	h.SetParser(parser.MDT{})
+	rcv := &receiver.MDTDialout{
+		Address:  "localhost:1389",
+		Certfile: "../docs/examples/basics/cacert-snakeoil.pem",
+		Keyfile:  "../docs/examples/basics/privkey-snakeoil.pem",
+		Handler:  skogul.HandlerRef{Name: "h", H: &h},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+
+	creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	conn, err := grpc.NewClient("localhost:1389", grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("Unable to create client: %v", err)
+	}
+	defer conn.Close()
+	var stream mdt_dialout.GRPCMdtDialout_MdtDialoutClient
+	for i := 0; i < 100; i++ {
+		stream, err = mdt_dialout.NewGRPCMdtDialoutClient(conn).MdtDialout(context.Background(), grpc.WaitForReady(true))
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Unable to open stream: %v", err)
+	}
+
+	msg := []byte(`{"node_id_str":"xr1","encoding_path":"a:b","data_json":[{"timestamp":1,"keys":{"name":"x"},"content":{"v":1}}]}`)
+	send := func(args *mdt_dialout.MdtDialoutArgs) {
+		t.Helper()
+		if err := stream.Send(args); err != nil {
+			t.Fatalf("Unable to send: %v", err)
+		}
+	}
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 1, Data: msg})
+	// Split message, interleaved with another
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 2, Data: msg[:10], TotalSize: int32(len(msg))})
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 3, Data: msg})
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 2, Data: msg[10:], TotalSize: int32(len(msg))})
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 4, Data: []byte("garbage")})
+	stream.CloseSend()
+
+	for i := 0; i < 100 && tst.Received() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tst.Received() != 3 {
+		t.Errorf("Expected 3 containers, got %d", tst.Received())
+	}
+	stats := rcv.GetStats()
+	if stats.Data["received"] != uint64(4) || stats.Data["chunks"] != uint64(2) || stats.Data["handler_errors"] != uint64(1) {
+		t.Errorf("Unexpected stats: %v", stats.Data)
+	}
+
+	rcv.Stop()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start() didn't return after Stop()")
+	}
+}
+
+func TestMDTDialout_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	for i, m := range []*receiver.MDTDialout{
+		{Handler: h},
+		{Address: ":1"},
+		{Address: ":1", Handler: h, Certfile: "x"},
+		{Address: ":1", Handler: h, ClientCertificateCAs: []string{"../docs/examples/basics/cacert-snakeoil.pem"}},
+		{Address: ":1", Handler: h, Certfile: "x", Keyfile: "y", SANDNSName: "a"},
+	} {
+		if err := m.Verify(); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestMDTDialout_limits(t *testing.T) {
+	tst := &sender.Test{}
+	h := skogul.Handler{Sender: tst}
+	h.SetParser(parser.MDT{})
+	rcv := &receiver.MDTDialout{
+		Address:        "localhost:1393",
+		Handler:        skogul.HandlerRef{Name: "h", H: &h},
+		MaxMessageSize: 200,
+		MaxBuffered:    150,
+		ChunkTimeout:   skogul.Duration{Duration: 100 * time.Millisecond},
+	}
+	go rcv.Start()
+	defer rcv.Stop()
+
+	conn, err := grpc.NewClient("localhost:1393", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Unable to create client: %v", err)
+	}
+	defer conn.Close()
+	stream, err := mdt_dialout.NewGRPCMdtDialoutClient(conn).MdtDialout(context.Background(), grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("Unable to open stream: %v", err)
+	}
+	msg := []byte(`{"node_id_str":"xr1","encoding_path":"a:b","data_json":[{"timestamp":1,"keys":{"name":"x"},"content":{"v":1}}]}`)
+	send := func(args *mdt_dialout.MdtDialoutArgs) {
+		t.Helper()
+		if err := stream.Send(args); err != nil {
+			t.Fatalf("Unable to send: %v", err)
+		}
+	}
+	// Too large
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 1, Data: msg[:10], TotalSize: 1 << 30})
+	// Never completed, so it times out
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 2, Data: msg[:100], TotalSize: int32(len(msg))})
+	// Would exceed MaxBuffered, since 2 is still buffered
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 3, Data: msg[:60], TotalSize: int32(len(msg))})
+	time.Sleep(200 * time.Millisecond)
+	// Complete messages still work, split or not
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 4, Data: msg[:60], TotalSize: int32(len(msg))})
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 4, Data: msg[60:], TotalSize: int32(len(msg))})
+	send(&mdt_dialout.MdtDialoutArgs{ReqId: 5, Data: msg})
+
+	for i := 0; i < 100 && tst.Received() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tst.Received() != 2 {
+		t.Errorf("Expected 2 containers, got %d", tst.Received())
+	}
+	stats := rcv.GetStats()
+	if stats.Data["dropped"] != uint64(3) || stats.Data["buffered"] != int64(0) {
+		t.Errorf("Unexpected stats: %v", stats.Data)
+	}
+}
+
+func TestMDTDialout_stopBeforeStart(t *testing.T) {
+	testStopBeforeStart(t, &receiver.MDTDialout{Address: "localhost:1372"})
+}
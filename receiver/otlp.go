@@ -0,0 +1,183 @@
+/*
+ * skogul, OpenTelemetry OTLP/gRPC receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telenornms/skogul"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var otlpLog = skogul.Logger("receiver", "otlp")
+
+/*
+OTLP accepts OpenTelemetry metrics using OTLP/gRPC, e.g. from the otlp
+exporter of an OpenTelemetry collector. Each export request is passed on
+to the handler protobuf-encoded, so the handler should use the otlp
+parser.
+
+For OTLP/HTTP, use the HTTP receiver instead, with the otlp parser on
+/v1/metrics.
+
+TLS is configured like for the HTTP receiver. If ClientCertificateCAs
+is set, clients must present a certificate signed by one of them.
+*/
+type OTLP struct {
+	Address              string            `doc:"Address to listen to. The standard port for OTLP/gRPC is 4317." example:"[::]:4317"`
+	Handler              skogul.HandlerRef `doc:"Handler used to parse, transform and send data. Should use the otlp parser."`
+	Certfile             string            `doc:"Path to certificate file for TLS. If left blank, un-encrypted gRPC is used."`
+	Keyfile              string            `doc:"Path to key file for TLS."`
+	ClientCertificateCAs []string          `doc:"Paths to files containing CAs which are accepted for Client Certificate authentication."`
+	SANDNSName           string            `doc:"DNS name which has to be present in SAN extension of the client certificate. Requires ClientCertificateCAs."`
+	lock                 sync.Mutex        // Protects server and stopped
+	server               *grpc.Server
+	stopped              bool
+	stats                otlpStats
+}
+
+// otlpStats contains the internal stats of the OTLP receiver.
+type otlpStats struct {
+	Received      uint64 // Number of export requests received
+	HandlerErrors uint64 // Number of errors from upstream handlers
+	Sent          uint64 // Number of export requests successfully handled
+}
+
+// otlpServer implements the OTLP MetricsService.
+type otlpServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	o *OTLP
+}
+
+// Export handles a single export request.
+func (s otlpServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	o := s.o
+	atomic.AddUint64(&o.stats.Received, 1)
+	b, err := proto.Marshal(req)
+	if err != nil {
+		atomic.AddUint64(&o.stats.HandlerErrors, 1)
+		return nil, status.Errorf(codes.Internal, "unable to marshal request: %v", err)
+	}
+	c, err := o.Handler.H.Parse(b)
+	if err != nil {
+		atomic.AddUint64(&o.stats.HandlerErrors, 1)
+		otlpLog.WithError(err).Warn("Unable to parse OTLP export request")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// Unavailable tells the client to retry, since this is most likely
+	// a temporary problem downstream.
+	if err := o.Handler.H.TransformAndSend(c); err != nil {
+		atomic.AddUint64(&o.stats.HandlerErrors, 1)
+		otlpLog.WithError(err).Warn("Unable to handle OTLP export request")
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	atomic.AddUint64(&o.stats.Sent, 1)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+// Start only returns if the receiver is stopped using Stop().
+func (o *OTLP) Start() error {
+	conf, err := serverTLSConfig(o.Certfile, o.Keyfile, o.ClientCertificateCAs, o.SANDNSName)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.ServerOption{}
+	if conf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(conf)))
+	}
+	o.lock.Lock()
+	if o.stopped {
+		o.lock.Unlock()
+		return nil
+	}
+	ln, err := net.Listen("tcp", o.Address)
+	if err != nil {
+		o.lock.Unlock()
+		return fmt.Errorf("unable to listen on %s: %w", o.Address, err)
+	}
+	server := grpc.NewServer(opts...)
+	colmetricspb.RegisterMetricsServiceServer(server, otlpServer{o: o})
+	o.server = server
+	o.lock.Unlock()
+	if conf != nil {
+		otlpLog.WithField("address", o.Address).Info("Starting OTLP receiver with TLS")
+	} else {
+		otlpLog.WithField("address", o.Address).Info("Starting INSECURE OTLP receiver (no TLS)")
+	}
+	// If Stop() is called before we start serving, Serve closes the
+	// listener and returns ErrServerStopped.
+	if err := server.Serve(ln); err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
+
+// Stop closes the listener, after waiting for requests in progress to
+// complete. If the receiver hasn't started listening yet, it won't.
+func (o *OTLP) Stop() error {
+	o.lock.Lock()
+	o.stopped = true
+	server := o.server
+	o.lock.Unlock()
+	if server != nil {
+		server.GracefulStop()
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the OTLP receiver.
+func (o *OTLP) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "OTLP"
+	metric.Metadata["identity"] = skogul.IdentityOf(o)
+
+	metric.Data["received"] = atomic.LoadUint64(&o.stats.Received)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&o.stats.HandlerErrors)
+	metric.Data["sent"] = atomic.LoadUint64(&o.stats.Sent)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (o *OTLP) Verify() error {
+	if o.Address == "" {
+		return skogul.MissingArgument("Address")
+	}
+	if o.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	return verifyServerTLS(o.Certfile, o.Keyfile, o.ClientCertificateCAs, o.SANDNSName)
+}
@@ -0,0 +1,153 @@
+/*
+ * skogul, OpenTelemetry OTLP/gRPC receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+	"github.com/telenornms/skogul/sender"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recorder keeps the last container received
+type recorder struct {
+	ch chan *skogul.Container
+}
+
+func (r *recorder) Send(c *skogul.Container) error {
+	r.ch <- c
+	return nil
+}
+
+func TestOTLP(t *testing.T) {
+	rec := &recorder{ch: make(chan *skogul.Container, 1)}
+	h := skogul.Handler{Sender: rec}
+	h.SetParser(parser.OTLP{})
+	rcv := &receiver.OTLP{
+		Address: "localhost:1399",
+		Handler: skogul.HandlerRef{Name: "h", H: &h},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+
+	s := &sender.OTLP{URL: "localhost:1399", Protocol: "grpc"}
+	now := time.Unix(1600000000, 0)
+	c := skogul.Container{Metrics: []*skogul.Metric{{
+		Time:     &now,
+		Metadata: map[string]interface{}{"name": "temp", "type": "gauge", "room": "a1"},
+		Data:     map[string]interface{}{"value": 21.5},
+	}}}
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = s.Send(&c); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Unable to send to OTLP receiver: %v", err)
+	}
+	select {
+	case got := <-rec.ch:
+		m := got.Metrics[0]
+		if m.Metadata["name"] != "temp" || m.Metadata["room"] != "a1" || m.Data["value"] != 21.5 || !m.Time.Equal(now) {
+			t.Errorf("Unexpected metric: %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No data received")
+	}
+	if st := rcv.GetStats(); st.Data["sent"] != uint64(1) {
+		t.Errorf("Unexpected stats: %v", st.Data)
+	}
+	s.Stop()
+
+	rcv.Stop()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start() didn't return after Stop()")
+	}
+}
+
+// TestOTLP_codes checks that parse errors are reported as permanent, and
+// errors further down as temporary, so the client retries.
+func TestOTLP_codes(t *testing.T) {
+	rec := &recorder{ch: make(chan *skogul.Container, 1)}
+	failing := skogul.Handler{Sender: &sender.ForwardAndFail{Next: skogul.SenderRef{S: rec}}}
+	failing.SetParser(parser.OTLP{})
+	broken := skogul.Handler{Sender: rec}
+	broken.SetParser(parser.SkogulJSON{})
+
+	now := time.Unix(1600000000, 0)
+	c := skogul.Container{Metrics: []*skogul.Metric{{
+		Time:     &now,
+		Metadata: map[string]interface{}{"name": "temp", "type": "gauge"},
+		Data:     map[string]interface{}{"value": 21.5},
+	}}}
+	for _, tc := range []struct {
+		h    *skogul.Handler
+		code codes.Code
+	}{
+		{&failing, codes.Unavailable},
+		{&broken, codes.InvalidArgument},
+	} {
+		rcv := &receiver.OTLP{Address: "localhost:1401", Handler: skogul.HandlerRef{Name: "h", H: tc.h}}
+		go rcv.Start()
+		s := &sender.OTLP{URL: "localhost:1401", Protocol: "grpc"}
+		var err error
+		// Connection errors are Unavailable too, so make sure the
+		// data got through.
+		for i := 0; i < 100; i++ {
+			err = s.Send(&c)
+			if status.Code(err) == tc.code && (tc.code != codes.Unavailable || len(rec.ch) > 0) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if status.Code(err) != tc.code {
+			t.Errorf("Expected %v, got %v", tc.code, err)
+		}
+		s.Stop()
+		rcv.Stop()
+		select {
+		case <-rec.ch:
+		default:
+		}
+	}
+}
+
+func TestOTLP_stopBeforeStart(t *testing.T) {
+	testStopBeforeStart(t, &receiver.OTLP{Address: "localhost:1371"})
+}
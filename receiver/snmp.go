@@ -0,0 +1,547 @@
+/*
+ * skogul, SNMP polling receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/telenornms/skogul"
+)
+
+var snmpLog = skogul.Logger("receiver", "snmp")
+
+var snmpVersions = map[string]gosnmp.SnmpVersion{
+	"1":  gosnmp.Version1,
+	"2c": gosnmp.Version2c,
+	"3":  gosnmp.Version3,
+}
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+// SNMPUser is an SNMPv3 user, used by both the snmp and snmptrap
+// receivers.
+type SNMPUser struct {
+	Username       string        `doc:"SNMPv3 username."`
+	AuthProtocol   string        `doc:"Authentication protocol: MD5, SHA, SHA224, SHA256, SHA384 or SHA512. Leave blank for no authentication."`
+	AuthPassphrase skogul.Secret `doc:"Authentication passphrase."`
+	PrivProtocol   string        `doc:"Privacy protocol: DES, AES, AES192, AES256, AES192C or AES256C. Leave blank for no privacy. Requires authentication."`
+	PrivPassphrase skogul.Secret `doc:"Privacy passphrase."`
+}
+
+// params returns the security parameters and message flags of the user.
+func (u SNMPUser) params() (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags) {
+	sp := &gosnmp.UsmSecurityParameters{UserName: u.Username}
+	flags := gosnmp.NoAuthNoPriv
+	if u.AuthProtocol != "" {
+		flags = gosnmp.AuthNoPriv
+		sp.AuthenticationProtocol = snmpAuthProtocols[u.AuthProtocol]
+		sp.AuthenticationPassphrase = u.AuthPassphrase.Expose()
+	}
+	if u.PrivProtocol != "" {
+		flags = gosnmp.AuthPriv
+		sp.PrivacyProtocol = snmpPrivProtocols[u.PrivProtocol]
+		sp.PrivacyPassphrase = u.PrivPassphrase.Expose()
+	}
+	return sp, flags
+}
+
+// verify checks that the protocols of the user are known.
+func (u SNMPUser) verify() error {
+	if u.Username == "" {
+		return skogul.MissingArgument("Username")
+	}
+	if _, ok := snmpAuthProtocols[u.AuthProtocol]; u.AuthProtocol != "" && !ok {
+		return fmt.Errorf("unknown AuthProtocol %q", u.AuthProtocol)
+	}
+	if _, ok := snmpPrivProtocols[u.PrivProtocol]; u.PrivProtocol != "" && !ok {
+		return fmt.Errorf("unknown PrivProtocol %q", u.PrivProtocol)
+	}
+	if u.PrivProtocol != "" && u.AuthProtocol == "" {
+		return fmt.Errorf("PrivProtocol requires AuthProtocol")
+	}
+	return nil
+}
+
+// snmpMIB translates between numeric OIDs and names, using mappings
+// supplied by the user, e.g. ".1.3.6.1.2.1.1.3" to "sysUpTime".
+type snmpMIB struct {
+	names map[string]string // OID to name
+	oids  map[string]string // Name to OID
+}
+
+// snmpOID returns the OID with a leading dot, as used by gosnmp.
+func snmpOID(oid string) string {
+	if strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
+}
+
+// newSNMPMIB builds the mappings from the MIB and MIBFile options. The
+// file is a JSON object mapping OIDs to names, like the MIB option.
+func newSNMPMIB(mib map[string]string, file string) (*snmpMIB, error) {
+	m := &snmpMIB{names: make(map[string]string), oids: make(map[string]string)}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read MIB file: %w", err)
+		}
+		fromFile := make(map[string]string)
+		if err := json.Unmarshal(b, &fromFile); err != nil {
+			return nil, fmt.Errorf("unable to parse MIB file %s: %w", file, err)
+		}
+		for oid, name := range fromFile {
+			m.add(oid, name)
+		}
+	}
+	for oid, name := range mib {
+		m.add(oid, name)
+	}
+	return m, nil
+}
+
+func (m *snmpMIB) add(oid, name string) {
+	oid = snmpOID(oid)
+	m.names[oid] = name
+	m.oids[name] = oid
+}
+
+// translate splits oid into the name of the longest known prefix of it,
+// and the rest, e.g. the index of a table row. If no prefix is known,
+// the OID itself is returned.
+func (m *snmpMIB) translate(oid string) (name string, index string) {
+	for prefix := oid; prefix != ""; {
+		if name, ok := m.names[prefix]; ok {
+			return name, strings.TrimPrefix(oid[len(prefix):], ".")
+		}
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return oid, ""
+}
+
+// name returns the translated name of oid, including the index.
+func (m *snmpMIB) name(oid string) string {
+	name, index := m.translate(oid)
+	if index == "" {
+		return name
+	}
+	return name + "." + index
+}
+
+// resolve returns the numeric OID of s, which is either numeric already
+// or a name known to the MIB, optionally followed by an index, e.g.
+// "sysUpTime.0".
+func (m *snmpMIB) resolve(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty OID")
+	}
+	if s[0] == '.' || unicode.IsDigit(rune(s[0])) {
+		return snmpOID(s), nil
+	}
+	name, index, _ := strings.Cut(s, ".")
+	oid, ok := m.oids[name]
+	if !ok {
+		return "", fmt.Errorf("unknown OID name %q", name)
+	}
+	if index != "" {
+		oid += "." + index
+	}
+	return oid, nil
+}
+
+// snmpValue converts the value of a variable binding to a type suitable
+// for a metric. The second return value is false for variables without
+// a value, e.g. noSuchObject.
+func snmpValue(pdu gosnmp.SnmpPDU, mib *snmpMIB) (interface{}, bool) {
+	switch pdu.Type {
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil, false
+	case gosnmp.Integer:
+		return gosnmp.ToBigInt(pdu.Value).Int64(), true
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32, gosnmp.Counter64:
+		return gosnmp.ToBigInt(pdu.Value).Uint64(), true
+	case gosnmp.OpaqueFloat:
+		f, _ := pdu.Value.(float32)
+		return float64(f), true
+	case gosnmp.OctetString:
+		b, _ := pdu.Value.([]byte)
+		if snmpPrintable(b) {
+			return string(b), true
+		}
+		return hex.EncodeToString(b), true
+	case gosnmp.ObjectIdentifier:
+		oid, _ := pdu.Value.(string)
+		return mib.name(oid), true
+	}
+	return pdu.Value, pdu.Value != nil
+}
+
+// snmpPrintable returns true if b is text, as opposed to e.g. a MAC
+// address.
+func snmpPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+SNMP polls devices using SNMP on an interval, similar to the SQL receiver.
+
+Each poll of a device results in one metric with the scalar values listed
+in OIDs, and one metric per row of each table listed in Tables. All
+metrics have the address of the device as "target" metadata. Table rows
+also have the name of the table as "table" and the row index as "index".
+
+OIDs can be given as numeric OIDs, or as names from the MIB mapping,
+which is also used to name the values. Values with OIDs that aren't
+known are named by their numeric OID. For table columns, the column OID
+is used, without the index.
+*/
+type SNMP struct {
+	Targets   []string          `doc:"Devices to poll, as host or host:port. The port defaults to 161." example:"[\"router1\", \"192.0.2.1:1161\"]"`
+	Version   string            `doc:"SNMP version: 1, 2c or 3. Defaults to 2c."`
+	Community skogul.Secret     `doc:"Community for SNMP v1 and v2c. Defaults to public."`
+	User      SNMPUser          `doc:"User for SNMPv3."`
+	OIDs      []string          `doc:"Scalar values to get, such as sysUpTime.0." example:"[\".1.3.6.1.2.1.1.3.0\", \"sysName.0\"]"`
+	Tables    []string          `doc:"Tables to walk, such as ifTable. The first sub-identifier after the table is the entry, the next is the column and the rest is the index of the row." example:"[\".1.3.6.1.2.1.2.2\", \"ifXTable\"]"`
+	MIB       map[string]string `doc:"Names of OIDs, used to name values and to look up names used in OIDs and Tables." example:"{\".1.3.6.1.2.1.2.2\": \"ifTable\", \".1.3.6.1.2.1.2.2.1.10\": \"ifInOctets\"}"`
+	MIBFile   string            `doc:"Path to a JSON file with names of OIDs, in the same format as MIB. Names in MIB take precedence."`
+	Interval  skogul.Duration   `doc:"How often to poll. Set to a negative value to poll just once. Defaults to 60s."`
+	Timeout   skogul.Duration   `doc:"How long to wait for a response from a device. Defaults to 5s."`
+	Retries   int               `doc:"How many times to retry a request before giving up."`
+	Handler   skogul.HandlerRef `doc:"Handler used to transform and send data."`
+	mib       *snmpMIB
+	oids      []string
+	tables    []string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	once      sync.Once
+	stats     snmpStats
+}
+
+type snmpStats struct {
+	Polls    uint64 // Devices polled
+	Errors   uint64 // Failed polls
+	Metrics  uint64 // Metrics produced
+	Failures uint64 // Containers the handler failed to send
+}
+
+func (s *SNMP) init() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+}
+
+// Start polls the devices until stopped with Stop(), or just once if
+// Interval is negative.
+func (s *SNMP) Start() error {
+	s.once.Do(s.init)
+	if s.Version == "" {
+		s.Version = "2c"
+	}
+	if s.Community == "" {
+		s.Community = "public"
+	}
+	if s.Interval.Duration == 0 {
+		s.Interval.Duration = 60 * time.Second
+	}
+	if s.Timeout.Duration == 0 {
+		s.Timeout.Duration = 5 * time.Second
+	}
+	var err error
+	if s.mib, err = newSNMPMIB(s.MIB, s.MIBFile); err != nil {
+		return err
+	}
+	if s.oids, err = s.resolve(s.OIDs); err != nil {
+		return err
+	}
+	if s.tables, err = s.resolve(s.Tables); err != nil {
+		return err
+	}
+	for {
+		s.poll()
+		if s.Interval.Duration < 0 {
+			return nil
+		}
+		select {
+		case <-time.After(s.Interval.Duration):
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *SNMP) resolve(names []string) ([]string, error) {
+	oids := make([]string, 0, len(names))
+	for _, n := range names {
+		oid, err := s.mib.resolve(n)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
+
+// poll polls all devices in parallel and sends the result as a single
+// container.
+func (s *SNMP) poll() {
+	now := time.Now()
+	results := make([][]*skogul.Metric, len(s.Targets))
+	var wg sync.WaitGroup
+	for i, target := range s.Targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			atomic.AddUint64(&s.stats.Polls, 1)
+			metrics, err := s.pollTarget(target, now)
+			if err != nil {
+				atomic.AddUint64(&s.stats.Errors, 1)
+				snmpLog.WithError(err).WithField("target", target).Warn("Polling failed")
+			}
+			results[i] = metrics
+		}(i, target)
+	}
+	wg.Wait()
+	c := skogul.Container{Metrics: []*skogul.Metric{}}
+	for _, metrics := range results {
+		c.Metrics = append(c.Metrics, metrics...)
+	}
+	if len(c.Metrics) == 0 {
+		return
+	}
+	atomic.AddUint64(&s.stats.Metrics, uint64(len(c.Metrics)))
+	if err := s.Handler.H.TransformAndSend(&c); err != nil {
+		atomic.AddUint64(&s.stats.Failures, 1)
+		snmpLog.WithError(err).Error("Failed to transform and send metrics")
+	}
+}
+
+// client returns an unconnected client for target.
+func (s *SNMP) client(target string) (*gosnmp.GoSNMP, error) {
+	host, port := target, uint16(161)
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		host, port = h, uint16(n)
+	}
+	g := &gosnmp.GoSNMP{
+		Context:   s.ctx,
+		Target:    host,
+		Port:      port,
+		Transport: "udp",
+		Community: s.Community.Expose(),
+		Version:   snmpVersions[s.Version],
+		Timeout:   s.Timeout.Duration,
+		Retries:   s.Retries,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	if g.Version == gosnmp.Version3 {
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.SecurityParameters, g.MsgFlags = s.User.params()
+	}
+	return g, nil
+}
+
+// pollTarget gets the scalars and walks the tables of a single device.
+// Metrics collected before an error are returned along with the error.
+func (s *SNMP) pollTarget(target string, now time.Time) ([]*skogul.Metric, error) {
+	g, err := s.client(target)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Connect(); err != nil {
+		return nil, fmt.Errorf("unable to connect: %w", err)
+	}
+	defer g.Conn.Close()
+	metrics := []*skogul.Metric{}
+	if len(s.oids) > 0 {
+		m := &skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{"target": target},
+			Data:     make(map[string]interface{}),
+		}
+		for i := 0; i < len(s.oids); i += g.MaxOids {
+			end := i + g.MaxOids
+			if end > len(s.oids) {
+				end = len(s.oids)
+			}
+			resp, err := g.Get(s.oids[i:end])
+			if err != nil {
+				return metrics, fmt.Errorf("get failed: %w", err)
+			}
+			for _, pdu := range resp.Variables {
+				if v, ok := snmpValue(pdu, s.mib); ok {
+					m.Data[s.mib.name(pdu.Name)] = v
+				}
+			}
+		}
+		if len(m.Data) > 0 {
+			metrics = append(metrics, m)
+		}
+	}
+	for _, table := range s.tables {
+		rows, err := s.walk(g, table, target, now)
+		metrics = append(metrics, rows...)
+		if err != nil {
+			return metrics, fmt.Errorf("walking %s failed: %w", s.mib.name(table), err)
+		}
+	}
+	return metrics, nil
+}
+
+// walk walks a table, returning one metric per row.
+func (s *SNMP) walk(g *gosnmp.GoSNMP, table string, target string, now time.Time) ([]*skogul.Metric, error) {
+	rows := make(map[string]*skogul.Metric)
+	metrics := []*skogul.Metric{}
+	tableName := s.mib.name(table)
+	fn := func(pdu gosnmp.SnmpPDU) error {
+		// entry.column.index
+		parts := strings.SplitN(strings.TrimPrefix(pdu.Name, table+"."), ".", 3)
+		if len(parts) != 3 {
+			return nil
+		}
+		v, ok := snmpValue(pdu, s.mib)
+		if !ok {
+			return nil
+		}
+		index := parts[2]
+		row := rows[index]
+		if row == nil {
+			row = &skogul.Metric{
+				Time:     &now,
+				Metadata: map[string]interface{}{"target": target, "table": tableName, "index": index},
+				Data:     make(map[string]interface{}),
+			}
+			rows[index] = row
+			metrics = append(metrics, row)
+		}
+		row.Data[s.mib.name(table+"."+parts[0]+"."+parts[1])] = v
+		return nil
+	}
+	var err error
+	if g.Version == gosnmp.Version1 {
+		err = g.Walk(table, fn)
+	} else {
+		err = g.BulkWalk(table, fn)
+	}
+	return metrics, err
+}
+
+// Stop stops polling. A poll in progress is aborted.
+func (s *SNMP) Stop() error {
+	s.once.Do(s.init)
+	s.cancel()
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the SNMP receiver.
+func (s *SNMP) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "SNMP"
+	metric.Metadata["identity"] = skogul.IdentityOf(s)
+
+	metric.Data["polls"] = atomic.LoadUint64(&s.stats.Polls)
+	metric.Data["errors"] = atomic.LoadUint64(&s.stats.Errors)
+	metric.Data["metrics"] = atomic.LoadUint64(&s.stats.Metrics)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&s.stats.Failures)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (s *SNMP) Verify() error {
+	if len(s.Targets) == 0 {
+		return skogul.MissingArgument("Targets")
+	}
+	if len(s.OIDs) == 0 && len(s.Tables) == 0 {
+		return skogul.MissingArgument("OIDs or Tables")
+	}
+	if s.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if _, ok := snmpVersions[s.Version]; s.Version != "" && !ok {
+		return fmt.Errorf("invalid Version %q, must be 1, 2c or 3", s.Version)
+	}
+	if s.Version == "3" {
+		if err := s.User.verify(); err != nil {
+			return fmt.Errorf("invalid User: %w", err)
+		}
+	}
+	if s.Timeout.Duration < 0 {
+		return fmt.Errorf("Timeout can't be negative")
+	}
+	mib, err := newSNMPMIB(s.MIB, s.MIBFile)
+	if err != nil {
+		return err
+	}
+	for _, oid := range append(append([]string{}, s.OIDs...), s.Tables...) {
+		if _, err := mib.resolve(oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+ * skogul, SNMP polling receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/receiver"
+)
+
+// snmpAgent is a minimal SNMP v2c agent, answering get, getnext and
+// getbulk requests from a fixed set of variables.
+type snmpAgent struct {
+	conn *net.UDPConn
+	oids []string
+	vars map[string]gosnmp.SnmpPDU
+}
+
+// snmpLess compares OIDs numerically, sub-identifier by sub-identifier.
+func snmpLess(a, b string) bool {
+	as, bs := strings.Split(strings.Trim(a, "."), "."), strings.Split(strings.Trim(b, "."), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func newSNMPAgent(t *testing.T, addr string, vars []gosnmp.SnmpPDU) *snmpAgent {
+	t.Helper()
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatalf("unable to resolve %s: %v", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		t.Fatalf("unable to listen on %s: %v", addr, err)
+	}
+	a := &snmpAgent{conn: conn, vars: make(map[string]gosnmp.SnmpPDU)}
+	for _, v := range vars {
+		a.oids = append(a.oids, v.Name)
+		a.vars[v.Name] = v
+	}
+	sort.Slice(a.oids, func(i, j int) bool { return snmpLess(a.oids[i], a.oids[j]) })
+	go a.serve()
+	return a
+}
+
+// next returns the variable following oid.
+func (a *snmpAgent) next(oid string) gosnmp.SnmpPDU {
+	for _, o := range a.oids {
+		if snmpLess(oid, o) {
+			return a.vars[o]
+		}
+	}
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+}
+
+func (a *snmpAgent) serve() {
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		resp := &gosnmp.SnmpPacket{
+			Version:   req.Version,
+			Community: req.Community,
+			PDUType:   gosnmp.GetResponse,
+			RequestID: req.RequestID,
+		}
+		for _, v := range req.Variables {
+			switch req.PDUType {
+			case gosnmp.GetRequest:
+				pdu, ok := a.vars[v.Name]
+				if !ok {
+					pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+				}
+				resp.Variables = append(resp.Variables, pdu)
+			case gosnmp.GetNextRequest:
+				resp.Variables = append(resp.Variables, a.next(v.Name))
+			case gosnmp.GetBulkRequest:
+				oid := v.Name
+				for i := uint32(0); i < req.MaxRepetitions; i++ {
+					pdu := a.next(oid)
+					resp.Variables = append(resp.Variables, pdu)
+					if pdu.Type == gosnmp.EndOfMibView {
+						break
+					}
+					oid = pdu.Name
+				}
+			}
+		}
+		b, err := resp.MarshalMsg()
+		if err != nil {
+			continue
+		}
+		a.conn.WriteToUDP(b, addr)
+	}
+}
+
+func TestSNMP(t *testing.T) {
+	agent := newSNMPAgent(t, "127.0.0.1:1409", []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(123456)},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("router1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: gosnmp.OctetString, Value: []byte("ge-0/0/0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: []byte("ge-0/0/1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.1", Type: gosnmp.OctetString, Value: []byte{0, 0x1b, 0x21, 0x3c, 0x4d, 0x5e}},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint(1000)},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.2", Type: gosnmp.Counter32, Value: uint(2000)},
+		{Name: ".1.3.6.1.2.1.4.1.0", Type: gosnmp.Integer, Value: 1},
+	})
+	defer agent.conn.Close()
+
+	rec := &recorder{ch: make(chan *skogul.Container, 1)}
+	h := skogul.Handler{Sender: rec}
+	rcv := &receiver.SNMP{
+		Targets: []string{"127.0.0.1:1409"},
+		OIDs:    []string{"sysUpTime.0", ".1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.6.0"},
+		Tables:  []string{"ifTable"},
+		MIB: map[string]string{
+			".1.3.6.1.2.1.1.3":      "sysUpTime",
+			"1.3.6.1.2.1.1.5":       "sysName",
+			".1.3.6.1.2.1.2.2":      "ifTable",
+			".1.3.6.1.2.1.2.2.1.2":  "ifDescr",
+			".1.3.6.1.2.1.2.2.1.10": "ifInOctets",
+		},
+		Interval: skogul.Duration{Duration: -1},
+		Timeout:  skogul.Duration{Duration: time.Second},
+		Handler:  skogul.HandlerRef{Name: "h", H: &h},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := rcv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	var c *skogul.Container
+	select {
+	case c = <-rec.ch:
+	default:
+		t.Fatalf("No data received")
+	}
+	if len(c.Metrics) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d: %v", len(c.Metrics), c.Metrics)
+	}
+	m := c.Metrics[0]
+	if m.Metadata["target"] != "127.0.0.1:1409" || m.Data["sysUpTime.0"] != uint64(123456) || m.Data["sysName.0"] != "router1" {
+		t.Errorf("Unexpected scalars: %v %v", m.Metadata, m.Data)
+	}
+	if _, ok := m.Data[".1.3.6.1.2.1.1.6.0"]; ok {
+		t.Errorf("Missing object included: %v", m.Data)
+	}
+	m = c.Metrics[1]
+	if m.Metadata["table"] != "ifTable" || m.Metadata["index"] != "1" {
+		t.Errorf("Unexpected row metadata: %v", m.Metadata)
+	}
+	if m.Data["ifDescr"] != "ge-0/0/0" || m.Data["ifInOctets"] != uint64(1000) || m.Data["ifTable.1.6"] != "001b213c4d5e" {
+		t.Errorf("Unexpected row data: %v", m.Data)
+	}
+	if c.Metrics[2].Data["ifInOctets"] != uint64(2000) {
+		t.Errorf("Unexpected row data: %v", c.Metrics[2].Data)
+	}
+	if st := rcv.GetStats(); st.Data["polls"] != uint64(1) || st.Data["errors"] != uint64(0) || st.Data["metrics"] != uint64(3) {
+		t.Errorf("Unexpected stats: %v", st.Data)
+	}
+}
+
+func TestSNMP_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	bad := []*receiver.SNMP{
+		{OIDs: []string{"1.3.6"}, Handler: h},
+		{Targets: []string{"a"}, Handler: h},
+		{Targets: []string{"a"}, OIDs: []string{"sysName.0"}, Handler: h},
+		{Targets: []string{"a"}, OIDs: []string{"1.3.6"}, Version: "2", Handler: h},
+		{Targets: []string{"a"}, OIDs: []string{"1.3.6"}, Version: "3", User: receiver.SNMPUser{Username: "u", PrivProtocol: "AES"}, Handler: h},
+	}
+	for i, rcv := range bad {
+		if err := rcv.Verify(); err == nil {
+			t.Errorf("Verify of bad config %d succeeded", i)
+		}
+	}
+}
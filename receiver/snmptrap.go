@@ -0,0 +1,220 @@
+/*
+ * skogul, SNMP trap receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/telenornms/skogul"
+)
+
+var snmptrapLog = skogul.Logger("receiver", "snmptrap")
+
+// OIDs of the variables every SNMPv2 trap starts with.
+const (
+	snmpSysUpTime  = ".1.3.6.1.2.1.1.3.0"
+	snmpTrapOID    = ".1.3.6.1.6.3.1.1.4.1.0"
+	snmpTrapPrefix = ".1.3.6.1.6.3.1.1.5" // Generic traps, e.g. linkDown
+)
+
+/*
+SNMPTrap receives SNMP traps and informs, version 1, 2c and 3, and turns
+each into a metric.
+
+The variable bindings of the trap are the data of the metric, named
+using the MIB mapping, if known, and by their numeric OID otherwise.
+
+The OID of the trap is stored as "trapOID" metadata, with the translated
+name as "trap". The address of the device that sent it is stored as
+"agent", the SNMP version as "version" and, for SNMPv3, the username as
+"user". SNMPv1 traps are converted to the SNMPv2 trap OID, as described
+in RFC 3584, and also have the "enterprise", "genericTrap" and
+"specificTrap" metadata fields. The uptime of the device is stored as
+"uptime" data.
+*/
+type SNMPTrap struct {
+	Address   string            `doc:"Address to listen to. Defaults to [::]:162." example:"[::]:162"`
+	Handler   skogul.HandlerRef `doc:"Handler used to transform and send data."`
+	Community skogul.Secret     `doc:"Only accept SNMP v1 and v2c traps with this community. Accepts any community if left blank."`
+	Users     []SNMPUser        `doc:"SNMPv3 users to accept traps from. SNMPv3 traps are only accepted if they can be authenticated and decrypted using one of them."`
+	MIB       map[string]string `doc:"Names of OIDs, used to name variables and traps." example:"{\".1.3.6.1.6.3.1.1.5.3\": \"linkDown\", \".1.3.6.1.2.1.2.2.1.1\": \"ifIndex\"}"`
+	MIBFile   string            `doc:"Path to a JSON file with names of OIDs, in the same format as MIB. Names in MIB take precedence."`
+	mib       *snmpMIB
+	lock      sync.Mutex
+	listener  *gosnmp.TrapListener
+	stats     snmptrapStats
+}
+
+type snmptrapStats struct {
+	Received      uint64 // Traps received
+	Rejected      uint64 // Traps with the wrong community
+	HandlerErrors uint64 // Traps the handler failed to send
+	Sent          uint64 // Traps successfully sent
+}
+
+// Start listens for traps. Only returns if the receiver is stopped using
+// Stop(), or the configuration is invalid.
+func (s *SNMPTrap) Start() error {
+	if s.Address == "" {
+		s.Address = "[::]:162"
+	}
+	var err error
+	if s.mib, err = newSNMPMIB(s.MIB, s.MIBFile); err != nil {
+		return err
+	}
+	params := &gosnmp.GoSNMP{Version: gosnmp.Version2c, Transport: "udp"}
+	if len(s.Users) > 0 {
+		// Only used to decide whether SNMPv3 traps can be authenticated;
+		// v1 and v2c traps are still accepted.
+		params.Version = gosnmp.Version3
+		params.TrapSecurityParametersTable = gosnmp.NewSnmpV3SecurityParametersTable(gosnmp.Logger{})
+		for _, u := range s.Users {
+			sp, _ := u.params()
+			if err := params.TrapSecurityParametersTable.Add(u.Username, sp); err != nil {
+				return fmt.Errorf("invalid SNMPv3 user %s: %w", u.Username, err)
+			}
+		}
+	}
+	listener := gosnmp.NewTrapListener()
+	listener.Params = params
+	listener.OnNewTrap = s.trap
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+	snmptrapLog.WithField("address", s.Address).Info("Listening for SNMP traps")
+	return listener.Listen(s.Address)
+}
+
+// trap handles a single trap.
+func (s *SNMPTrap) trap(p *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+	atomic.AddUint64(&s.stats.Received, 1)
+	if p.Version != gosnmp.Version3 && s.Community != "" && p.Community != s.Community.Expose() {
+		atomic.AddUint64(&s.stats.Rejected, 1)
+		snmptrapLog.WithField("agent", addr.IP.String()).Debug("Rejected trap with wrong community")
+		return
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{s.metric(p, addr)}}
+	if err := s.Handler.H.TransformAndSend(&c); err != nil {
+		atomic.AddUint64(&s.stats.HandlerErrors, 1)
+		snmptrapLog.WithError(err).WithField("agent", addr.IP.String()).Warn("Unable to handle trap")
+		return
+	}
+	atomic.AddUint64(&s.stats.Sent, 1)
+}
+
+// metric converts a trap to a metric.
+func (s *SNMPTrap) metric(p *gosnmp.SnmpPacket, addr *net.UDPAddr) *skogul.Metric {
+	now := skogul.Now()
+	md := map[string]interface{}{"agent": addr.IP.String()}
+	data := make(map[string]interface{})
+	switch p.Version {
+	case gosnmp.Version1:
+		md["version"] = "1"
+		if p.AgentAddress != "" && p.AgentAddress != "0.0.0.0" {
+			md["agent"] = p.AgentAddress
+		}
+		md["enterprise"] = s.mib.name(p.Enterprise)
+		md["genericTrap"] = int64(p.GenericTrap)
+		md["specificTrap"] = int64(p.SpecificTrap)
+		data["uptime"] = uint64(p.Timestamp)
+		if p.GenericTrap == 6 {
+			md["trapOID"] = fmt.Sprintf("%s.0.%d", snmpOID(p.Enterprise), p.SpecificTrap)
+		} else {
+			md["trapOID"] = fmt.Sprintf("%s.%d", snmpTrapPrefix, p.GenericTrap+1)
+		}
+	case gosnmp.Version2c:
+		md["version"] = "2c"
+	case gosnmp.Version3:
+		md["version"] = "3"
+		if sp, ok := p.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+			md["user"] = sp.UserName
+		}
+	}
+	for _, v := range p.Variables {
+		switch v.Name {
+		case snmpSysUpTime:
+			data["uptime"] = gosnmp.ToBigInt(v.Value).Uint64()
+			continue
+		case snmpTrapOID:
+			if oid, ok := v.Value.(string); ok {
+				md["trapOID"] = oid
+			}
+			continue
+		}
+		if value, ok := snmpValue(v, s.mib); ok {
+			data[s.mib.name(v.Name)] = value
+		}
+	}
+	if oid, ok := md["trapOID"].(string); ok {
+		md["trap"] = s.mib.name(oid)
+	}
+	return &skogul.Metric{Time: &now, Metadata: md, Data: data}
+}
+
+// Stop stops listening for traps.
+func (s *SNMPTrap) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the SNMP trap receiver.
+func (s *SNMPTrap) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "SNMPTrap"
+	metric.Metadata["identity"] = skogul.IdentityOf(s)
+
+	metric.Data["received"] = atomic.LoadUint64(&s.stats.Received)
+	metric.Data["rejected"] = atomic.LoadUint64(&s.stats.Rejected)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&s.stats.HandlerErrors)
+	metric.Data["sent"] = atomic.LoadUint64(&s.stats.Sent)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (s *SNMPTrap) Verify() error {
+	if s.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	for _, u := range s.Users {
+		if err := u.verify(); err != nil {
+			return fmt.Errorf("invalid user: %w", err)
+		}
+	}
+	if _, err := newSNMPMIB(s.MIB, s.MIBFile); err != nil {
+		return err
+	}
+	return nil
+}
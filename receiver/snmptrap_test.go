@@ -0,0 +1,154 @@
+/*
+ * skogul, SNMP trap receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/receiver"
+)
+
+func TestSNMPTrap(t *testing.T) {
+	rec := &recorder{ch: make(chan *skogul.Container, 1)}
+	h := skogul.Handler{Sender: rec}
+	user := receiver.SNMPUser{
+		Username:       "skogul",
+		AuthProtocol:   "SHA",
+		AuthPassphrase: "authpassword",
+		PrivProtocol:   "AES",
+		PrivPassphrase: "privpassword",
+	}
+	rcv := &receiver.SNMPTrap{
+		Address:   "127.0.0.1:1419",
+		Community: "secret",
+		Users:     []receiver.SNMPUser{user},
+		MIB: map[string]string{
+			".1.3.6.1.6.3.1.1.5.3": "linkDown",
+			".1.3.6.1.2.1.2.2.1.1": "ifIndex",
+			".1.3.6.1.4.1.2636":    "juniper",
+		},
+		Handler: skogul.HandlerRef{Name: "h", H: &h},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+
+	vars := []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.2.2.1.1.5", Type: gosnmp.Integer, Value: 5}}
+	send := func(g *gosnmp.GoSNMP, trap gosnmp.SnmpTrap) *skogul.Metric {
+		t.Helper()
+		g.Target, g.Port, g.Timeout = "127.0.0.1", 1419, time.Second
+		if err := g.Connect(); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer g.Conn.Close()
+		// The listener may not be ready for the first trap, and sending
+		// fails until then
+		for i := 0; i < 50; i++ {
+			g.SendTrap(trap)
+			select {
+			case c := <-rec.ch:
+				return c.Metrics[0]
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		t.Fatalf("No trap received")
+		return nil
+	}
+
+	v2 := gosnmp.SnmpTrap{Variables: append([]gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(4200)},
+		{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.3"},
+	}, vars...)}
+	m := send(&gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: "secret"}, v2)
+	if m.Metadata["version"] != "2c" || m.Metadata["agent"] != "127.0.0.1" || m.Metadata["trap"] != "linkDown" || m.Metadata["trapOID"] != ".1.3.6.1.6.3.1.1.5.3" {
+		t.Errorf("Unexpected v2c metadata: %v", m.Metadata)
+	}
+	if m.Data["uptime"] != uint64(4200) || m.Data["ifIndex.5"] != int64(5) {
+		t.Errorf("Unexpected v2c data: %v", m.Data)
+	}
+
+	m = send(&gosnmp.GoSNMP{Version: gosnmp.Version1, Community: "secret"}, gosnmp.SnmpTrap{
+		Variables:    vars,
+		Enterprise:   ".1.3.6.1.4.1.2636",
+		AgentAddress: "192.0.2.1",
+		GenericTrap:  6,
+		SpecificTrap: 2,
+		Timestamp:    300,
+	})
+	if m.Metadata["version"] != "1" || m.Metadata["agent"] != "192.0.2.1" || m.Metadata["trapOID"] != ".1.3.6.1.4.1.2636.0.2" ||
+		m.Metadata["trap"] != "juniper.0.2" || m.Metadata["specificTrap"] != int64(2) {
+		t.Errorf("Unexpected v1 metadata: %v", m.Metadata)
+	}
+	if m.Data["uptime"] != uint64(300) || m.Data["ifIndex.5"] != int64(5) {
+		t.Errorf("Unexpected v1 data: %v", m.Data)
+	}
+
+	sp := &gosnmp.UsmSecurityParameters{
+		UserName:                 "skogul",
+		AuthenticationProtocol:   gosnmp.SHA,
+		AuthenticationPassphrase: "authpassword",
+		PrivacyProtocol:          gosnmp.AES,
+		PrivacyPassphrase:        "privpassword",
+		AuthoritativeEngineID:    string([]byte{0x80, 0, 0, 0, 1, 2, 3, 4}),
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  1,
+	}
+	m = send(&gosnmp.GoSNMP{Version: gosnmp.Version3, SecurityModel: gosnmp.UserSecurityModel, MsgFlags: gosnmp.AuthPriv, SecurityParameters: sp}, v2)
+	if m.Metadata["version"] != "3" || m.Metadata["user"] != "skogul" || m.Metadata["trap"] != "linkDown" {
+		t.Errorf("Unexpected v3 metadata: %v", m.Metadata)
+	}
+
+	g := &gosnmp.GoSNMP{Target: "127.0.0.1", Port: 1419, Version: gosnmp.Version2c, Community: "wrong", Timeout: time.Second}
+	if err := g.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if _, err := g.SendTrap(v2); err != nil {
+		t.Fatalf("SendTrap failed: %v", err)
+	}
+	g.Conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case c := <-rec.ch:
+		t.Errorf("Trap with wrong community accepted: %v", c)
+	default:
+	}
+	if st := rcv.GetStats(); st.Data["rejected"] != uint64(1) || st.Data["sent"] != uint64(3) {
+		t.Errorf("Unexpected stats: %v", st.Data)
+	}
+
+	rcv.Stop()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start() didn't return after Stop()")
+	}
+}
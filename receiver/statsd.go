@@ -0,0 +1,465 @@
+/*
+ * skogul, statsd receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var statsdLog = skogul.Logger("receiver", "statsd")
+
+/*
+Statsd accepts statsd and DogStatsD metrics over UDP, aggregates them,
+and sends the aggregated values to the handler once every FlushInterval,
+as a single container. Like the UDP receiver, packets are read into a
+backlog and processed by a pool of worker threads.
+
+Each aggregated series becomes a metric, with the statsd name as "name"
+metadata, the statsd type as "type" metadata ("counter", "gauge",
+"timer" or "set"), and the DogStatsD tags as metadata. Tags without a
+value get an empty string as value.
+
+Counters have "count", the sum of the values adjusted for the sample
+rate, and "rate", the count per second. Gauges have "value". Gauges are
+kept across flushes, as with statsd, and sent every flush unless
+DeleteGauges is set. Timers, histograms and distributions are all
+treated as timers, and have "count", "rate", "sum", "min", "max",
+"mean", "median" and one field per percentile, e.g. "p90" or "p99_9".
+Sets have "count", the number of unique values.
+
+DogStatsD events and service checks are ignored.
+*/
+type Statsd struct {
+	Address       string            `doc:"Address to listen to. Defaults to [::]:8125." example:"[::]:8125"`
+	Handler       skogul.HandlerRef `doc:"Handler used to transform and send the aggregated metrics. The parser is not used."`
+	FlushInterval skogul.Duration   `doc:"How often to send the aggregated metrics. Defaults to 10s."`
+	Percentiles   []float64         `doc:"Percentiles to calculate for timers. Defaults to 90." example:"[50, 90, 99.9]"`
+	DeleteGauges  bool              `doc:"Only send gauges that have been updated since the last flush."`
+	Backlog       int               `doc:"Number of packets queued before the receiver starts blocking. Defaults to 100."`
+	Threads       int               `doc:"Number of worker go routines parsing packets. Defaults to the number of CPU threads."`
+	PacketSize    int               `doc:"Largest packet accepted. Defaults to 9000. Maximum 65535."`
+	Buffer        int               `doc:"Set kernel read buffer. Default is kernel-specific."`
+	ch            chan []byte
+	lock          sync.Mutex
+	series        map[string]*statsdSeries
+	ln            *net.UDPConn
+	workers       sync.WaitGroup
+	stop          chan struct{}
+	done          chan struct{} // Closed when Start() has flushed the last time
+	stats         statsdStats
+}
+
+type statsdStats struct {
+	Packets uint64 // Packets received
+	Lines   uint64 // Metric lines aggregated
+	Invalid uint64 // Lines that could not be parsed
+	Ignored uint64 // DogStatsD events and service checks
+	Flushes uint64 // Containers sent
+	Errors  uint64 // Containers the handler failed to send
+}
+
+// statsdSeries is the aggregated state of a single series.
+type statsdSeries struct {
+	name    string
+	typ     string
+	tags    map[string]string
+	updated bool
+	count   float64             // Counters and timers, adjusted for sample rate
+	value   float64             // Gauges
+	values  []float64           // Timers
+	set     map[string]struct{} // Sets
+}
+
+var statsdTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "timer",
+	"d":  "timer",
+	"s":  "set",
+}
+
+// statsdLine is a single parsed line, e.g. "api.requests:1|c|@0.5|#env:prod".
+type statsdLine struct {
+	name  string
+	value string
+	typ   string
+	rate  float64
+	tags  map[string]string
+}
+
+// parseStatsdLine parses a single line.
+func parseStatsdLine(line string) (statsdLine, error) {
+	l := statsdLine{rate: 1}
+	colon := strings.LastIndexByte(strings.SplitN(line, "|", 2)[0], ':')
+	if colon < 1 {
+		return l, fmt.Errorf("missing name or value")
+	}
+	l.name = line[:colon]
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 || fields[0] == "" {
+		return l, fmt.Errorf("missing value or type")
+	}
+	l.value = fields[0]
+	var ok bool
+	if l.typ, ok = statsdTypes[fields[1]]; !ok {
+		return l, fmt.Errorf("unknown type %q", fields[1])
+	}
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return l, fmt.Errorf("invalid sample rate %q", f)
+			}
+			l.rate = rate
+		case strings.HasPrefix(f, "#"):
+			l.tags = make(map[string]string)
+			for _, tag := range strings.Split(f[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				k, v, _ := strings.Cut(tag, ":")
+				l.tags[k] = v
+			}
+		}
+	}
+	return l, nil
+}
+
+// key identifies the series of a line.
+func (l statsdLine) key() string {
+	var b strings.Builder
+	b.WriteString(l.typ)
+	b.WriteByte('|')
+	b.WriteString(l.name)
+	tags := make([]string, 0, len(l.tags))
+	for k, v := range l.tags {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		b.WriteByte('|')
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// add aggregates a parsed line.
+func (s *Statsd) add(l statsdLine) error {
+	var v float64
+	if l.typ != "set" {
+		var err error
+		if v, err = strconv.ParseFloat(l.value, 64); err != nil {
+			return fmt.Errorf("invalid value %q", l.value)
+		}
+	}
+	key := l.key()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	series := s.series[key]
+	if series == nil {
+		series = &statsdSeries{name: l.name, typ: l.typ, tags: l.tags}
+		s.series[key] = series
+	}
+	series.updated = true
+	switch l.typ {
+	case "counter":
+		series.count += v / l.rate
+	case "gauge":
+		if l.value[0] == '+' || l.value[0] == '-' {
+			series.value += v
+		} else {
+			series.value = v
+		}
+	case "timer":
+		series.count += 1 / l.rate
+		series.values = append(series.values, v)
+	case "set":
+		if series.set == nil {
+			series.set = make(map[string]struct{})
+		}
+		series.set[l.value] = struct{}{}
+	}
+	return nil
+}
+
+// process is a worker, parsing and aggregating packets from the backlog.
+func (s *Statsd) process() {
+	defer s.workers.Done()
+	for packet := range s.ch {
+		atomic.AddUint64(&s.stats.Packets, 1)
+		for _, line := range bytes.Split(packet, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			if bytes.HasPrefix(line, []byte("_e{")) || bytes.HasPrefix(line, []byte("_sc|")) {
+				atomic.AddUint64(&s.stats.Ignored, 1)
+				continue
+			}
+			l, err := parseStatsdLine(string(line))
+			if err == nil {
+				err = s.add(l)
+			}
+			if err != nil {
+				atomic.AddUint64(&s.stats.Invalid, 1)
+				statsdLog.WithError(err).WithField("line", string(line)).Debug("Invalid statsd line")
+				continue
+			}
+			atomic.AddUint64(&s.stats.Lines, 1)
+		}
+	}
+}
+
+// percentileName returns the data field name of a percentile, e.g. p99_9.
+func percentileName(p float64) string {
+	return "p" + strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+}
+
+// flush sends the aggregated metrics and resets the series.
+func (s *Statsd) flush(now time.Time, interval time.Duration) {
+	s.lock.Lock()
+	c := skogul.Container{Metrics: []*skogul.Metric{}}
+	for key, series := range s.series {
+		if !series.updated {
+			if series.typ != "gauge" || s.DeleteGauges {
+				delete(s.series, key)
+				continue
+			}
+		}
+		md := make(map[string]interface{}, len(series.tags)+2)
+		for k, v := range series.tags {
+			md[k] = v
+		}
+		md["name"] = series.name
+		md["type"] = series.typ
+		data := make(map[string]interface{})
+		switch series.typ {
+		case "counter":
+			data["count"] = series.count
+			data["rate"] = series.count / interval.Seconds()
+		case "gauge":
+			data["value"] = series.value
+		case "timer":
+			values := series.values
+			sort.Float64s(values)
+			sum := 0.0
+			for _, v := range values {
+				sum += v
+			}
+			n := len(values)
+			data["count"] = series.count
+			data["rate"] = series.count / interval.Seconds()
+			data["sum"] = sum
+			data["min"] = values[0]
+			data["max"] = values[n-1]
+			data["mean"] = sum / float64(n)
+			if n%2 == 1 {
+				data["median"] = values[n/2]
+			} else {
+				data["median"] = (values[n/2-1] + values[n/2]) / 2
+			}
+			for _, p := range s.Percentiles {
+				// Nearest rank
+				rank := int(math.Ceil(p / 100 * float64(n)))
+				if rank < 1 {
+					rank = 1
+				}
+				data[percentileName(p)] = values[rank-1]
+			}
+		case "set":
+			data["count"] = uint64(len(series.set))
+		}
+		c.Metrics = append(c.Metrics, &skogul.Metric{Time: &now, Metadata: md, Data: data})
+		if series.typ == "gauge" {
+			series.updated = false
+		} else {
+			delete(s.series, key)
+		}
+	}
+	s.lock.Unlock()
+	if len(c.Metrics) == 0 {
+		return
+	}
+	atomic.AddUint64(&s.stats.Flushes, 1)
+	if err := s.Handler.H.TransformAndSend(&c); err != nil {
+		atomic.AddUint64(&s.stats.Errors, 1)
+		statsdLog.WithError(err).Error("Unable to send aggregated statsd metrics")
+	}
+}
+
+// flusher flushes every FlushInterval, and a final time when the receiver
+// is stopped.
+func (s *Statsd) flusher(workersDone chan struct{}) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.FlushInterval.Duration)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.flush(now, now.Sub(last))
+			last = now
+		case <-workersDone:
+			now := time.Now()
+			s.flush(now, now.Sub(last))
+			return
+		}
+	}
+}
+
+// Start listens for packets, and only returns if the receiver is stopped
+// using Stop().
+func (s *Statsd) Start() error {
+	if s.Address == "" {
+		s.Address = "[::]:8125"
+	}
+	if s.FlushInterval.Duration == 0 {
+		s.FlushInterval.Duration = 10 * time.Second
+	}
+	if s.Percentiles == nil {
+		s.Percentiles = []float64{90}
+	}
+	if s.Backlog == 0 {
+		s.Backlog = 100
+	}
+	if s.Threads == 0 {
+		s.Threads = runtime.NumCPU()
+	}
+	if s.PacketSize == 0 {
+		s.PacketSize = 9000
+	}
+	addr, err := net.ResolveUDPAddr("udp", s.Address)
+	if err != nil {
+		return fmt.Errorf("unable to resolve address %s: %w", s.Address, err)
+	}
+	ln, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	if s.Buffer > 0 {
+		ln.SetReadBuffer(s.Buffer)
+	}
+	s.lock.Lock()
+	s.ln = ln
+	s.series = make(map[string]*statsdSeries)
+	s.done = make(chan struct{})
+	s.lock.Unlock()
+
+	s.ch = make(chan []byte, s.Backlog)
+	workersDone := make(chan struct{})
+	s.workers.Add(s.Threads)
+	for i := 0; i < s.Threads; i++ {
+		go s.process()
+	}
+	go s.flusher(workersDone)
+	defer func() {
+		close(s.ch)
+		s.workers.Wait()
+		close(workersDone)
+		<-s.done
+	}()
+	statsdLog.WithField("address", s.Address).Info("Listening for statsd metrics")
+	for {
+		buf := make([]byte, s.PacketSize)
+		n, err := ln.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			statsdLog.WithError(err).Error("Unable to read statsd packet")
+			continue
+		}
+		s.ch <- buf[:n]
+	}
+}
+
+// Stop closes the socket, then waits for the packets already read to be
+// aggregated and flushed.
+func (s *Statsd) Stop() error {
+	s.lock.Lock()
+	ln, done := s.ln, s.done
+	s.lock.Unlock()
+	if ln == nil {
+		return nil
+	}
+	err := ln.Close()
+	<-done
+	return err
+}
+
+// GetStats prepares a skogul metric with stats for the statsd receiver.
+func (s *Statsd) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "statsd"
+	metric.Metadata["identity"] = skogul.IdentityOf(s)
+
+	metric.Data["packets"] = atomic.LoadUint64(&s.stats.Packets)
+	metric.Data["lines"] = atomic.LoadUint64(&s.stats.Lines)
+	metric.Data["invalid"] = atomic.LoadUint64(&s.stats.Invalid)
+	metric.Data["ignored"] = atomic.LoadUint64(&s.stats.Ignored)
+	metric.Data["flushes"] = atomic.LoadUint64(&s.stats.Flushes)
+	metric.Data["errors"] = atomic.LoadUint64(&s.stats.Errors)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (s *Statsd) Verify() error {
+	if s.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if s.FlushInterval.Duration < 0 {
+		return fmt.Errorf("FlushInterval can't be negative")
+	}
+	for _, p := range s.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("invalid percentile %v, must be above 0 and at most 100", p)
+		}
+	}
+	if s.PacketSize < 0 || s.PacketSize > UDP_MAX_READ_SIZE {
+		return fmt.Errorf("PacketSize must be between 0 and %d", UDP_MAX_READ_SIZE)
+	}
+	if s.Backlog < 0 || s.Threads < 0 {
+		return fmt.Errorf("Backlog and Threads can't be negative")
+	}
+	return nil
+}
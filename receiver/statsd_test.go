@@ -0,0 +1,161 @@
+/*
+ * skogul, statsd receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/receiver"
+)
+
+// statsdStat returns a counter from the stats of the receiver.
+func statsdStat(rcv *receiver.Statsd, name string) uint64 {
+	return rcv.GetStats().Data[name].(uint64)
+}
+
+func TestStatsd(t *testing.T) {
+	rec := &recorder{ch: make(chan *skogul.Container, 10)}
+	h := skogul.Handler{Sender: rec}
+	rcv := &receiver.Statsd{
+		Address:       "localhost:1449",
+		Handler:       skogul.HandlerRef{Name: "h", H: &h},
+		FlushInterval: skogul.Duration{Duration: time.Hour},
+		Percentiles:   []float64{50, 99.9},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+	conn, err := net.Dial("udp", "localhost:1449")
+	if err != nil {
+		t.Fatalf("Unable to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Events are ignored, so use one to wait for the receiver to be ready
+	for i := 0; statsdStat(rcv, "ignored") == 0; i++ {
+		if i == 100 {
+			t.Fatalf("Receiver never got the event")
+		}
+		conn.Write([]byte("_e{5,4}:title|text"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	packets := []string{
+		"requests:1|c|#env:prod,host:a\nrequests:2|c|@0.5|#host:a,env:prod",
+		"requests:1|c|#env:dev",
+		"temp:20|g\ntemp:+5|g\ntemp:-2|g",
+		"latency:10|ms\nlatency:20|ms\nlatency:30|h\nlatency:40|d",
+		"users:alice|s\nusers:bob|s\nusers:alice|s",
+		"broken\nbroken:1|x\nbroken:x|c\n_sc|check|0",
+	}
+	for _, p := range packets {
+		conn.Write([]byte(p))
+	}
+	for i := 0; statsdStat(rcv, "lines") < 13 || statsdStat(rcv, "invalid") < 3; i++ {
+		if i == 100 {
+			t.Fatalf("Receiver only got %d lines", statsdStat(rcv, "lines"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rcv.Stop()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start() didn't return after Stop()")
+	}
+
+	var c *skogul.Container
+	select {
+	case c = <-rec.ch:
+	default:
+		t.Fatalf("No container flushed on Stop()")
+	}
+	metrics := make(map[string]*skogul.Metric)
+	for _, m := range c.Metrics {
+		key := m.Metadata["name"].(string)
+		if env, ok := m.Metadata["env"]; ok {
+			key += "," + env.(string)
+		}
+		metrics[key] = m
+	}
+	if len(metrics) != 5 {
+		t.Fatalf("Expected 5 metrics, got %d: %v", len(metrics), metrics)
+	}
+	checks := []struct {
+		metric string
+		field  string
+		value  interface{}
+	}{
+		{"requests,prod", "count", 5.0},
+		{"requests,dev", "count", 1.0},
+		{"temp", "value", 23.0},
+		{"latency", "count", 4.0},
+		{"latency", "sum", 100.0},
+		{"latency", "min", 10.0},
+		{"latency", "max", 40.0},
+		{"latency", "mean", 25.0},
+		{"latency", "median", 25.0},
+		{"latency", "p50", 20.0},
+		{"latency", "p99_9", 40.0},
+		{"users", "count", uint64(2)},
+	}
+	for _, check := range checks {
+		m := metrics[check.metric]
+		if m.Data[check.field] != check.value {
+			t.Errorf("Expected %s %s to be %v, got %v", check.metric, check.field, check.value, m.Data[check.field])
+		}
+	}
+	if got := metrics["requests,prod"].Metadata["host"]; got != "a" {
+		t.Errorf("Expected host tag a, got %v", got)
+	}
+	if got := metrics["latency"].Metadata["type"]; got != "timer" {
+		t.Errorf("Expected type timer, got %v", got)
+	}
+	if got := statsdStat(rcv, "flushes"); got != 1 {
+		t.Errorf("Expected 1 flush, got %d", got)
+	}
+}
+
+func TestStatsdVerify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	bad := []*receiver.Statsd{
+		{},
+		{Handler: h, Percentiles: []float64{0}},
+		{Handler: h, Percentiles: []float64{101}},
+		{Handler: h, PacketSize: 70000},
+		{Handler: h, FlushInterval: skogul.Duration{Duration: -time.Second}},
+	}
+	for i, rcv := range bad {
+		if err := rcv.Verify(); err == nil {
+			t.Errorf("Verify of bad config %d succeeded", i)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * skogul, syslog receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telenornms/skogul"
+)
+
+var syslogLog = skogul.Logger("receiver", "syslog")
+
+/*
+Syslog receives syslog messages over UDP, TCP or TLS, and passes each
+message on to the handler, which should use the syslog parser. The
+address of the sender is passed on to the parser.
+
+Over UDP, each datagram is a message. Over TCP and TLS, messages are
+either separated by newlines, or prefixed by their length
+(octet-counting), as described in RFC6587 and RFC5425. By default, the
+framing is detected for each message: messages starting with a digit are
+octet-counted, since a syslog message always starts with "<".
+*/
+type Syslog struct {
+	Address              string            `doc:"Address to listen to. Defaults to [::]:514, or [::]:6514 for TLS." example:"[::]:514"`
+	Protocol             string            `doc:"Protocol to listen for: udp, tcp or tls. Defaults to udp."`
+	Framing              string            `doc:"How messages are separated over TCP and TLS: auto, newline or octet-counting. Defaults to auto."`
+	MaxMessageSize       int               `doc:"Largest message accepted, in bytes. Over TCP and TLS, the connection is closed if a message is larger. Defaults to 65536."`
+	Certfile             string            `doc:"Path to certificate file for TLS."`
+	Keyfile              string            `doc:"Path to key file for TLS."`
+	ClientCertificateCAs []string          `doc:"Paths to files containing CA certificates. If set, clients must present a certificate signed by one of them."`
+	SANDNSName           string            `doc:"DNS name which has to be present in SAN extension of client certificates."`
+	Handler              skogul.HandlerRef `doc:"Handler used to parse, transform and send data. Should use the syslog parser."`
+	lock                 sync.Mutex
+	conn                 *net.UDPConn
+	ln                   net.Listener
+	conns                map[net.Conn]bool
+	active               sync.WaitGroup
+	stats                syslogStats
+}
+
+type syslogStats struct {
+	Connections   uint64 // TCP and TLS connections accepted
+	Received      uint64 // Messages received
+	FramingErrors uint64 // Connections closed due to invalid framing
+	Errors        uint64 // Messages that failed to parse, transform or send
+	Sent          uint64 // Messages successfully sent
+}
+
+// syslogSplit splits a stream of messages, using the given framing.
+func syslogSplit(framing string, max int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		if framing == "octet-counting" || (framing == "auto" && data[0] >= '1' && data[0] <= '9') {
+			sp := bytes.IndexByte(data, ' ')
+			if sp < 0 {
+				if len(data) > 10 || atEOF {
+					return 0, nil, fmt.Errorf("invalid octet-counting frame")
+				}
+				return 0, nil, nil
+			}
+			n, err := strconv.Atoi(string(data[:sp]))
+			if err != nil || n <= 0 {
+				return 0, nil, fmt.Errorf("invalid octet-counting frame length %q", data[:sp])
+			}
+			if n > max {
+				return 0, nil, fmt.Errorf("message of %d bytes exceeds maximum message size", n)
+			}
+			if len(data) < sp+1+n {
+				if atEOF {
+					return 0, nil, io.ErrUnexpectedEOF
+				}
+				return 0, nil, nil
+			}
+			return sp + 1 + n, data[sp+1 : sp+1+n], nil
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// handle passes a single message on to the handler.
+func (s *Syslog) handle(msg []byte, source string) {
+	atomic.AddUint64(&s.stats.Received, 1)
+	if err := handleFrom(s.Handler.H, msg, source); err != nil {
+		atomic.AddUint64(&s.stats.Errors, 1)
+		syslogLog.WithError(err).WithField("source", source).Warn("Unable to handle syslog message")
+		return
+	}
+	atomic.AddUint64(&s.stats.Sent, 1)
+}
+
+// Start listens for messages. Only returns if the receiver is stopped
+// using Stop(), or it is unable to listen.
+func (s *Syslog) Start() error {
+	if s.Protocol == "" {
+		s.Protocol = "udp"
+	}
+	if s.Framing == "" {
+		s.Framing = "auto"
+	}
+	if s.MaxMessageSize == 0 {
+		s.MaxMessageSize = 65536
+	}
+	if s.Address == "" {
+		s.Address = "[::]:514"
+		if s.Protocol == "tls" {
+			s.Address = "[::]:6514"
+		}
+	}
+	if s.Protocol == "udp" {
+		return s.startUDP()
+	}
+	return s.startTCP()
+}
+
+func (s *Syslog) startUDP() error {
+	addr, err := net.ResolveUDPAddr("udp", s.Address)
+	if err != nil {
+		return fmt.Errorf("unable to resolve address %s: %w", s.Address, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.conn = conn
+	s.lock.Unlock()
+	syslogLog.WithField("address", s.Address).Info("Listening for syslog over UDP")
+	for {
+		buf := make([]byte, s.MaxMessageSize)
+		n, addr, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			syslogLog.WithError(err).Error("Unable to read syslog message")
+			continue
+		}
+		s.handle(buf[:n], addr.IP.String())
+	}
+}
+
+func (s *Syslog) startTCP() error {
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", s.Address, err)
+	}
+	if s.Protocol == "tls" {
+		conf, err := serverTLSConfig(s.Certfile, s.Keyfile, s.ClientCertificateCAs, s.SANDNSName)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, conf)
+	}
+	s.lock.Lock()
+	s.ln = ln
+	s.conns = make(map[net.Conn]bool)
+	s.lock.Unlock()
+	syslogLog.WithField("address", s.Address).Infof("Listening for syslog over %s", s.Protocol)
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			syslogLog.WithError(err).Error("Unable to accept connection")
+			continue
+		}
+		atomic.AddUint64(&s.stats.Connections, 1)
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.active.Add(1)
+		s.lock.Unlock()
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection reads messages from a TCP or TLS connection until it
+// is closed.
+func (s *Syslog) handleConnection(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		s.active.Done()
+	}()
+	source, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	scanner := bufio.NewScanner(conn)
+	// Room for the length prefix of octet-counted messages
+	scanner.Buffer(make([]byte, 4096), s.MaxMessageSize+16)
+	scanner.Split(syslogSplit(s.Framing, s.MaxMessageSize))
+	for scanner.Scan() {
+		msg := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(msg) == 0 {
+			continue
+		}
+		s.handle(msg, source)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		atomic.AddUint64(&s.stats.FramingErrors, 1)
+		syslogLog.WithError(err).WithField("source", source).Warn("Closing syslog connection")
+	}
+}
+
+// Stop closes the listener and all connections, then waits for the
+// messages already read to be handled.
+func (s *Syslog) Stop() error {
+	s.lock.Lock()
+	var err error
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.active.Wait()
+	return err
+}
+
+// GetStats prepares a skogul metric with stats for the syslog receiver.
+func (s *Syslog) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "syslog"
+	metric.Metadata["identity"] = skogul.IdentityOf(s)
+
+	metric.Data["connections"] = atomic.LoadUint64(&s.stats.Connections)
+	metric.Data["received"] = atomic.LoadUint64(&s.stats.Received)
+	metric.Data["framing_errors"] = atomic.LoadUint64(&s.stats.FramingErrors)
+	metric.Data["errors"] = atomic.LoadUint64(&s.stats.Errors)
+	metric.Data["sent"] = atomic.LoadUint64(&s.stats.Sent)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (s *Syslog) Verify() error {
+	if s.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	switch s.Protocol {
+	case "", "udp", "tcp":
+		if s.Certfile != "" || s.Keyfile != "" {
+			return fmt.Errorf("Certfile and Keyfile require Protocol tls")
+		}
+	case "tls":
+		if s.Certfile == "" {
+			return skogul.MissingArgument("Certfile")
+		}
+	default:
+		return fmt.Errorf("invalid Protocol %q, must be udp, tcp or tls", s.Protocol)
+	}
+	switch s.Framing {
+	case "", "auto", "newline", "octet-counting":
+	default:
+		return fmt.Errorf("invalid Framing %q, must be auto, newline or octet-counting", s.Framing)
+	}
+	if s.MaxMessageSize < 0 {
+		return fmt.Errorf("MaxMessageSize can't be negative")
+	}
+	return verifyServerTLS(s.Certfile, s.Keyfile, s.ClientCertificateCAs, s.SANDNSName)
+}
@@ -0,0 +1,171 @@
+/*
+ * skogul, syslog receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+)
+
+// startSyslog starts a syslog receiver, and returns a channel with the
+// messages received and a function to stop the receiver.
+func startSyslog(t *testing.T, rcv *receiver.Syslog) (chan *skogul.Container, func()) {
+	t.Helper()
+	rec := &recorder{ch: make(chan *skogul.Container, 10)}
+	h := skogul.Handler{Sender: rec}
+	h.SetParser(parser.Syslog{})
+	rcv.Handler = skogul.HandlerRef{Name: "h", H: &h}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+	return rec.ch, func() {
+		rcv.Stop()
+		select {
+		case err := <-ret:
+			if err != nil {
+				t.Errorf("Start() returned error after Stop(): %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Start() didn't return after Stop()")
+		}
+	}
+}
+
+func syslogDial(t *testing.T, network, addr string, conf *tls.Config) net.Conn {
+	t.Helper()
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conf != nil {
+			conn, err = tls.Dial(network, addr, conf)
+		} else {
+			conn, err = net.Dial(network, addr)
+		}
+		if err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Unable to connect to %s: %v", addr, err)
+	return nil
+}
+
+func syslogExpect(t *testing.T, ch chan *skogul.Container, msgs ...string) {
+	t.Helper()
+	for _, msg := range msgs {
+		select {
+		case c := <-ch:
+			if got := c.Metrics[0].Data["message"]; got != msg {
+				t.Errorf("Expected message %q, got %q", msg, got)
+			}
+			if c.Metrics[0].Metadata["source"] != "127.0.0.1" {
+				t.Errorf("Unexpected source: %v", c.Metrics[0].Metadata)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No message received, expected %q", msg)
+		}
+	}
+}
+
+func TestSyslog_udp(t *testing.T) {
+	rcv := &receiver.Syslog{Address: "127.0.0.1:1429"}
+	ch, stop := startSyslog(t, rcv)
+	defer stop()
+	conn := syslogDial(t, "udp", "127.0.0.1:1429", nil)
+	defer conn.Close()
+	// The receiver may not be listening yet
+	for i := 0; i < 50; i++ {
+		fmt.Fprint(conn, "<13>1 - host app - - - first")
+		select {
+		case c := <-ch:
+			ch <- c
+			i = 50
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	syslogExpect(t, ch, "first")
+}
+
+func TestSyslog_tcp(t *testing.T) {
+	rcv := &receiver.Syslog{Address: "127.0.0.1:1429", Protocol: "tcp", MaxMessageSize: 100}
+	ch, stop := startSyslog(t, rcv)
+	defer stop()
+	conn := syslogDial(t, "tcp", "127.0.0.1:1429", nil)
+	msg := "<13>1 - host app - - - octet\ncounted"
+	fmt.Fprintf(conn, "<13>Oct 11 22:14:15 host app: newline\r\n%d %s<13>1 - host app - - - last\n", len(msg), msg)
+	syslogExpect(t, ch, "newline", "octet\ncounted", "last")
+
+	// Too large, the connection is closed
+	fmt.Fprintf(conn, "1000 <13>1 - host app - - - too large")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Connection not closed after too large message")
+	}
+	conn.Close()
+	if st := rcv.GetStats(); st.Data["sent"] != uint64(3) || st.Data["framing_errors"] != uint64(1) {
+		t.Errorf("Unexpected stats: %v", st.Data)
+	}
+}
+
+func TestSyslog_tls(t *testing.T) {
+	rcv := &receiver.Syslog{
+		Address:  "127.0.0.1:1439",
+		Protocol: "tls",
+		Framing:  "octet-counting",
+		Certfile: "../docs/examples/basics/cacert-snakeoil.pem",
+		Keyfile:  "../docs/examples/basics/privkey-snakeoil.pem",
+	}
+	ch, stop := startSyslog(t, rcv)
+	defer stop()
+	conn := syslogDial(t, "tcp", "127.0.0.1:1439", &tls.Config{InsecureSkipVerify: true})
+	defer conn.Close()
+	msg := "<13>1 - host app - - - secure"
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	syslogExpect(t, ch, "secure")
+}
+
+func TestSyslog_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	bad := []*receiver.Syslog{
+		{},
+		{Handler: h, Protocol: "sctp"},
+		{Handler: h, Protocol: "tls"},
+		{Handler: h, Protocol: "tcp", Certfile: "cert.pem", Keyfile: "key.pem"},
+		{Handler: h, Protocol: "tcp", Framing: "nul"},
+	}
+	for i, rcv := range bad {
+		if err := rcv.Verify(); err == nil {
+			t.Errorf("Verify of bad config %d succeeded", i)
+		}
+	}
+}
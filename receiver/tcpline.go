@@ -25,8 +25,10 @@ package receiver
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/telenornms/skogul"
 )
@@ -49,10 +51,15 @@ skogul.senders.HTTP to forward over a more sensible channel.
 type TCPLine struct {
 	Address string            `doc:"Address and port to listen to." example:"[::1]:3306"`
 	Handler skogul.HandlerRef `doc:"Handler used to parse, transform and send data."`
+	ln      *net.TCPListener
+	lock    sync.Mutex
+	conns   map[*net.TCPConn]bool
+	active  sync.WaitGroup
+	stopped bool
 }
 
 /*
-Start the TCP line receiver and run forever.
+Start the TCP line receiver and run until stopped with Stop().
 
 We close the write-side of the connection leaving it to the other side to
 finish up. We should probably add a read-timeout in the future.
@@ -62,31 +69,72 @@ func (tl *TCPLine) Start() error {
 	if err != nil {
 		return fmt.Errorf("unable to resolve address %s: %w", tl.Address, err)
 	}
+	tl.lock.Lock()
+	if tl.stopped {
+		tl.lock.Unlock()
+		return nil
+	}
 	ln, err := net.ListenTCP("tcp", tcpip)
 	if err != nil {
+		tl.lock.Unlock()
 		return err
 	}
+	tl.ln = ln
+	tl.conns = make(map[*net.TCPConn]bool)
+	tl.lock.Unlock()
 	for {
 		conn, err := ln.AcceptTCP()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
 			tcpLog.WithError(err).Error("Unable to accept connection")
 			continue
 		}
+		tl.lock.Lock()
+		tl.conns[conn] = true
+		tl.active.Add(1)
+		tl.lock.Unlock()
 		go tl.handleConnection(conn)
 	}
 }
 
+// Stop closes the listener and the read side of all open connections,
+// then waits for the lines already read to be handled. If the receiver
+// hasn't started listening yet, it won't.
+func (tl *TCPLine) Stop() error {
+	tl.lock.Lock()
+	tl.stopped = true
+	if tl.ln == nil {
+		tl.lock.Unlock()
+		return nil
+	}
+	err := tl.ln.Close()
+	for conn := range tl.conns {
+		conn.CloseRead()
+	}
+	tl.lock.Unlock()
+	tl.active.Wait()
+	return err
+}
+
 func (tl *TCPLine) handleConnection(conn *net.TCPConn) {
 	scanner := bufio.NewScanner(conn)
 	conn.CloseWrite()
-	defer conn.CloseRead()
+	defer func() {
+		conn.CloseRead()
+		tl.lock.Lock()
+		delete(tl.conns, conn)
+		tl.lock.Unlock()
+		tl.active.Done()
+	}()
 	for scanner.Scan() {
 		bytes := scanner.Bytes()
 		if err := tl.Handler.H.Handle(bytes); err != nil {
 			tcpLog.WithError(err).Error("Unable to parse JSON")
 		}
 	}
-	if err := scanner.Err(); err != nil {
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
 		tcpLog.WithError(err).Error("Error reading line")
 		return
 	}
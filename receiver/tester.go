@@ -52,7 +52,7 @@ func (tst *Tester) generate(t time.Time) skogul.Container {
 		m := skogul.Metric{}
 		m.Time = &t
 		m.Metadata = map[string]interface{}{}
-		m.Metadata["id"] = skogul.Identity[tst]
+		m.Metadata["id"] = skogul.IdentityOf(tst)
 		m.Metadata["key1"] = i
 		m.Data = map[string]interface{}{}
 		for key := int64(0); key < tst.Values; key++ {
@@ -65,7 +65,7 @@ func (tst *Tester) generate(t time.Time) skogul.Container {
 
 // Start never returns.
 func (tst *Tester) Start() error {
-	tst.logger = skogul.Logger("receiver", "tester").WithField("name", skogul.Identity[tst])
+	tst.logger = skogul.Logger("receiver", "tester").WithField("name", skogul.IdentityOf(tst))
 	if tst.Threads == 0 {
 		tst.Threads = runtime.NumCPU()
 		tst.logger.WithField("threads", tst.Threads).Debug("No threads set, defaulting to runtime.NumCPU()")
@@ -24,6 +24,7 @@
 package receiver
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"runtime"
@@ -41,7 +42,11 @@ const (
 
 var udpLog = skogul.Logger("receiver", "udp")
 
-// UDP contains the configuration for the receiver
+// UDP contains the configuration for the receiver. The address of the
+// sender of each message is passed on to parsers that keep state per
+// sender, such as the netflow parser. Messages that such parsers turn
+// into no metrics, e.g. packets with only flow templates, are dropped
+// without counting as errors.
 type UDP struct {
 	Address      string            `doc:"Address and port to listen to." example:"[::1]:3306"`
 	Handler      skogul.HandlerRef `doc:"Handler used to parse, transform and send data."`
@@ -51,10 +56,15 @@ type UDP struct {
 	FailureLevel string            `doc:"Level to log receiver failures as. Error, Warning, Info, Debug, or Trace. (default: Error)"`
 	Buffer       int               `doc:"Set kernel read buffer. Default is kernel-specific. Bumping this will make it easier to handler bursty UDP traffic."`
 	EmitStats    skogul.Duration   `doc:"How often to emit internal skogul stats for this receiver. Default: 10s (from stats.DefaultInterval)"`
-	ch           chan []byte       // Used to pass messages from the accept/read-loop to the worker pool/threads.
+	ch           chan udpMessage   // Used to pass messages from the accept/read-loop to the worker pool/threads.
 	failureLevel logrus.Level
 	once         sync.Once
 	stats        *udpStats
+	lock         sync.Mutex // Protects ln, done and stopped
+	ln           *net.UDPConn
+	workers      sync.WaitGroup
+	done         chan struct{} // Closed when Start() has stopped reading and all workers are done.
+	stopped      bool
 }
 
 // udpStats is a type containing internal stats of the UDP receiver
@@ -64,6 +74,12 @@ type udpStats struct {
 	Sent     uint64 // number of successful elements encountered and passed on to the next chain.
 }
 
+// udpMessage is a single message and the address of the sender.
+type udpMessage struct {
+	data   []byte
+	source string
+}
+
 // process is the worker-thread responsible for handling individual
 // messages.
 func (ud *UDP) process() {
@@ -74,18 +90,34 @@ func (ud *UDP) process() {
 			ud.failureLevel = skogul.GetLogLevelFromString(ud.FailureLevel)
 		}
 	})
-	for {
-		bytes := <-ud.ch
+	defer ud.workers.Done()
+	for msg := range ud.ch {
 		atomic.AddUint64(&ud.stats.Received, 1)
-		if err := ud.Handler.H.Handle(bytes); err != nil {
+		if err := handleFrom(ud.Handler.H, msg.data, msg.source); err != nil {
 			atomic.AddUint64(&ud.stats.Errors, 1)
-			udpLog.WithError(err).Log(ud.failureLevel, "Unable to handle UDP message")
+			udpLog.WithError(err).WithField("source", msg.source).Log(ud.failureLevel, "Unable to handle UDP message")
 		} else {
 			atomic.AddUint64(&ud.stats.Sent, 1)
 		}
 	}
 }
 
+// handleFrom parses a single message, passing the address of the sender
+// on to the parser, and sends the result off. If the parser is a
+// SourceParser, messages that result in no metrics are dropped without
+// error, since they only carried state for the parser, such as flow
+// templates. For other parsers, they are errors as before.
+func handleFrom(h *skogul.Handler, b []byte, source string) error {
+	c, err := h.ParseFrom(b, source)
+	if err != nil {
+		return err
+	}
+	if len(c.Metrics) == 0 && h.HasSourceParser() {
+		return nil
+	}
+	return h.TransformAndSend(c)
+}
+
 // Verify verifies the configuration for the UDP receiver
 func (ud *UDP) Verify() error {
 	if ud.Handler.Name == "" {
@@ -102,7 +134,7 @@ func (ud *UDP) Verify() error {
 
 // Start boots up ud.Threads number of worker threads, then starts
 // listening for incoming UDP messages on the configured address. Start
-// never returns.
+// only returns if the receiver is stopped using Stop().
 func (ud *UDP) Start() error {
 	if ud.PacketSize == 0 {
 		ud.PacketSize = 9000
@@ -123,31 +155,63 @@ func (ud *UDP) Start() error {
 	ud.initStats()
 
 	udpLog.Tracef("Got backlog size of %d and number of threads %d", ud.Backlog, ud.Threads)
-	ud.ch = make(chan []byte, ud.Backlog)
+	ud.ch = make(chan udpMessage, ud.Backlog)
+	done := make(chan struct{})
+	defer close(done)
+	ud.workers.Add(ud.Threads)
 	for i := 0; i < ud.Threads; i++ {
 		go ud.process()
 	}
+	defer ud.workers.Wait()
+	defer close(ud.ch)
 
 	udpip, err := net.ResolveUDPAddr("udp", ud.Address)
 	if err != nil {
 		return fmt.Errorf("unable to resolve address %s: %w", ud.Address, err)
 	}
+	ud.lock.Lock()
+	if ud.stopped {
+		ud.lock.Unlock()
+		return nil
+	}
 	ln, err := net.ListenUDP("udp", udpip)
 	if err != nil {
+		ud.lock.Unlock()
 		return err
 	}
+	ud.ln = ln
+	ud.done = done
+	ud.lock.Unlock()
 	if ud.Buffer > 0 {
 		ln.SetReadBuffer(ud.Buffer)
 	}
 	for {
 		bytes := make([]byte, ud.PacketSize)
-		n, err := ln.Read(bytes)
+		n, addr, err := ln.ReadFromUDP(bytes)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil || n == 0 {
 			udpLog.WithError(err).WithField("bytes", n).Error("Unable to read UDP message")
 			continue
 		}
-		ud.ch <- bytes[0:n]
+		ud.ch <- udpMessage{data: bytes[0:n], source: addr.IP.String()}
+	}
+}
+
+// Stop closes the UDP socket and waits for the messages already read to
+// be handled. If the receiver hasn't started listening yet, it won't.
+func (ud *UDP) Stop() error {
+	ud.lock.Lock()
+	ud.stopped = true
+	ln, done := ud.ln, ud.done
+	ud.lock.Unlock()
+	if ln == nil {
+		return nil
 	}
+	err := ln.Close()
+	<-done
+	return err
 }
 
 // GetStats prepares a skogul metric with stats
@@ -164,7 +228,7 @@ func (ud *UDP) GetStats() *skogul.Metric {
 	}
 	metric.Metadata["component"] = "receiver"
 	metric.Metadata["type"] = "UDP"
-	metric.Metadata["identity"] = skogul.Identity[ud]
+	metric.Metadata["identity"] = skogul.IdentityOf(ud)
 
 	metric.Data["received"] = ud.stats.Received
 	metric.Data["errors"] = ud.stats.Errors
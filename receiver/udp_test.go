@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/config"
+	"github.com/telenornms/skogul/parser"
 	"github.com/telenornms/skogul/receiver"
 	"github.com/telenornms/skogul/sender"
 	"net"
@@ -256,3 +257,114 @@ func BenchmarkUDP_json_Threads100(b *testing.B) {
 		sCommon.TestSync(b, ds, &validContainer, 5, 100)
 	}
 }
+
+func TestUDPStop(t *testing.T) {
+	one := &(sender.Test{})
+	h := skogul.Handler{Sender: one}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.UDP{Address: "localhost:1989", Handler: skogul.HandlerRef{H: &h}}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+	time.Sleep(time.Duration(50 * time.Millisecond))
+
+	addr, _ := net.ResolveUDPAddr("udp", "localhost:1989")
+	u, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer u.Close()
+	for i := 0; i < 5; i++ {
+		sendUDP(u, pJSON)
+	}
+	// Stop() can only account for what has been read from the
+	// socket, so give the reader some slack.
+	time.Sleep(time.Duration(100 * time.Millisecond))
+	if err := rcv.Stop(); err != nil {
+		t.Errorf("UDP.Stop() failed: %v", err)
+	}
+	if one.Received() != 5 {
+		t.Errorf("UDP.Stop() returned before data was handled. Wanted 5 containers, got %d", one.Received())
+	}
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("UDP.Start() returned error after Stop(): %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("UDP.Start() didn't return after Stop()")
+	}
+}
+
+// testStopBeforeStart checks that Start() returns right away if Stop() is
+// called first, instead of starting to listen.
+func testStopBeforeStart(t *testing.T, rcv interface {
+	skogul.Receiver
+	skogul.Stopper
+}) {
+	t.Helper()
+	if err := rcv.Stop(); err != nil {
+		t.Errorf("Stop() before Start() failed: %v", err)
+	}
+	ret := make(chan error)
+	go func() {
+		ret <- rcv.Start()
+	}()
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Errorf("Start() after Stop() returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Start() after Stop() didn't return")
+		rcv.Stop()
+	}
+}
+
+func TestUDPStop_beforeStart(t *testing.T) {
+	h := skogul.Handler{Sender: &(sender.Test{})}
+	h.SetParser(parser.SkogulJSON{})
+	testStopBeforeStart(t, &receiver.UDP{Address: "localhost:1991", Handler: skogul.HandlerRef{H: &h}})
+}
+
+// stateParser is a SourceParser that only updates state, like a flow
+// parser receiving templates.
+type stateParser struct{}
+
+func (stateParser) Parse(b []byte) (*skogul.Container, error) {
+	return &skogul.Container{Metrics: []*skogul.Metric{}}, nil
+}
+
+func (p stateParser) ParseFrom(b []byte, source string) (*skogul.Container, error) {
+	return p.Parse(b)
+}
+
+func TestUDP_empty(t *testing.T) {
+	for _, tc := range []struct {
+		parser skogul.Parser
+		errors uint64
+	}{
+		{parser.SkogulJSON{}, 1},
+		{stateParser{}, 0},
+	} {
+		h := skogul.Handler{Sender: &(sender.Test{})}
+		h.SetParser(tc.parser)
+		rcv := receiver.UDP{Address: "localhost:1374", Handler: skogul.HandlerRef{H: &h}, Threads: 1}
+		go rcv.Start()
+		time.Sleep(50 * time.Millisecond)
+		conn, err := net.Dial("udp", "localhost:1374")
+		if err != nil {
+			t.Fatalf("Unable to connect to UDP receiver: %v", err)
+		}
+		conn.Write([]byte(`{"metrics":[]}`))
+		conn.Close()
+		time.Sleep(50 * time.Millisecond)
+		// Stop waits for the message to be handled.
+		rcv.Stop()
+		stats := rcv.GetStats()
+		if stats.Data["received"] != uint64(1) || stats.Data["errors"] != tc.errors {
+			t.Errorf("Expected 1 received and %d errors for %T, got %v", tc.errors, tc.parser, stats.Data)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+/*
+ * skogul, aggregate sender
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var aggLog = skogul.Logger("sender", "aggregate")
+
+var aggFunctions = map[string]bool{
+	"min":   true,
+	"max":   true,
+	"avg":   true,
+	"sum":   true,
+	"count": true,
+	"last":  true,
+}
+
+/*
+Aggregate downsamples metrics by grouping them over tumbling windows, and
+passes on a single metric per group and window when the window closes.
+
+Metrics are grouped by the values of the Keys metadata fields, and by
+the window their timestamp falls in. Windows are aligned to the Window
+duration, so with a window of 1m, the windows start at the start of
+every minute. For each group, the aggregate metric has the Keys as
+metadata, the start of the window as timestamp, and one data field per
+aggregated field and function, named field_function, e.g. "ifHCInOctets_max"
+or "ifHCInOctets_p95". Fields that are not numeric are ignored.
+
+A window is closed when Lateness has passed after its end, according to
+the local clock, so data that arrives a little late is still included.
+Metrics that arrive after their window is closed are dropped and counted
+as late. Metrics without a timestamp are placed in the current window.
+
+Like the batch sender, errors from the next sender can't be passed back,
+so they are logged instead.
+*/
+type Aggregate struct {
+	Next        skogul.SenderRef `doc:"Sender that receives the aggregated metrics."`
+	Keys        []string         `doc:"Metadata fields to group metrics by. Other metadata fields are dropped. If empty, all metrics in a window are aggregated together." example:"[\"host\", \"interface\"]"`
+	Fields      []string         `doc:"Data fields to aggregate. If empty, all numeric data fields are aggregated."`
+	Functions   []string         `doc:"Aggregate functions to calculate: min, max, avg, sum, count and last. Defaults to all of them."`
+	Percentiles []float64        `doc:"Percentiles to calculate, e.g. 95 for the field_p95. Requires keeping all values of a window in memory." example:"[50, 95]"`
+	Window      skogul.Duration  `doc:"Length of each window. Defaults to 1m."`
+	Lateness    skogul.Duration  `doc:"How long after a window ends to wait for late metrics before closing it. Defaults to 0."`
+	once        sync.Once
+	lock        sync.Mutex
+	windows     map[int64]map[string]*aggGroup // Keyed by window start, then group
+	closed      time.Time                      // Start of the newest closed window
+	functions   map[string]bool
+	stop        chan struct{}
+	done        chan struct{}
+	stats       aggStats
+}
+
+type aggStats struct {
+	Received   uint64 // Metrics received
+	Late       uint64 // Metrics dropped because their window was closed
+	Aggregated uint64 // Aggregated metrics sent
+	Errors     uint64 // Containers the next sender failed to send
+}
+
+// aggGroup is the state of a single group in a single window.
+type aggGroup struct {
+	metadata map[string]interface{}
+	fields   map[string]*aggField
+}
+
+// aggField is the aggregated state of a single data field.
+type aggField struct {
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+	last   float64
+	lastTs time.Time
+	values []float64
+}
+
+func (ag *Aggregate) init() {
+	if ag.Window.Duration == 0 {
+		ag.Window.Duration = time.Minute
+	}
+	if len(ag.Functions) == 0 {
+		ag.Functions = []string{"min", "max", "avg", "sum", "count", "last"}
+	}
+	ag.functions = make(map[string]bool)
+	for _, f := range ag.Functions {
+		ag.functions[f] = true
+	}
+	ag.windows = make(map[int64]map[string]*aggGroup)
+	ag.closed = time.Now().Add(-ag.Lateness.Duration).Truncate(ag.Window.Duration).Add(-ag.Window.Duration)
+	ag.stop = make(chan struct{})
+	ag.done = make(chan struct{})
+	go ag.run()
+}
+
+// run closes windows as they expire, until the sender is stopped.
+func (ag *Aggregate) run() {
+	defer close(ag.done)
+	for {
+		ag.lock.Lock()
+		// The oldest open window starts one Window after the newest
+		// closed one, and closes Lateness after it ends.
+		next := ag.closed.Add(2*ag.Window.Duration + ag.Lateness.Duration)
+		ag.lock.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			ag.close(time.Now())
+		case <-ag.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// groupKey returns the key of the group of a metric.
+func (ag *Aggregate) groupKey(m *skogul.Metric) string {
+	var key strings.Builder
+	for _, k := range ag.Keys {
+		fmt.Fprintf(&key, "%v\xff", m.Metadata[k])
+	}
+	return key.String()
+}
+
+// Send adds the metrics of the container to their windows. It only
+// returns an error if the sender is stopped.
+func (ag *Aggregate) Send(c *skogul.Container) error {
+	ag.once.Do(ag.init)
+	now := time.Now()
+	ag.lock.Lock()
+	defer ag.lock.Unlock()
+	if ag.windows == nil {
+		return fmt.Errorf("sender is stopped")
+	}
+	atomic.AddUint64(&ag.stats.Received, uint64(len(c.Metrics)))
+	for _, m := range c.Metrics {
+		ts := now
+		if m.Time != nil {
+			ts = *m.Time
+		}
+		start := ts.Truncate(ag.Window.Duration)
+		if !start.After(ag.closed) {
+			atomic.AddUint64(&ag.stats.Late, 1)
+			continue
+		}
+		window := ag.windows[start.UnixNano()]
+		if window == nil {
+			window = make(map[string]*aggGroup)
+			ag.windows[start.UnixNano()] = window
+		}
+		key := ag.groupKey(m)
+		group := window[key]
+		if group == nil {
+			group = &aggGroup{
+				metadata: make(map[string]interface{}, len(ag.Keys)),
+				fields:   make(map[string]*aggField),
+			}
+			for _, k := range ag.Keys {
+				if v, ok := m.Metadata[k]; ok {
+					group.metadata[k] = v
+				}
+			}
+			window[key] = group
+		}
+		if len(ag.Fields) == 0 {
+			for k, v := range m.Data {
+				group.add(k, v, ts, ag.Percentiles != nil)
+			}
+		} else {
+			for _, k := range ag.Fields {
+				group.add(k, m.Data[k], ts, ag.Percentiles != nil)
+			}
+		}
+	}
+	return nil
+}
+
+// add adds a value to a field of the group, if it is numeric.
+func (g *aggGroup) add(name string, v interface{}, ts time.Time, keep bool) {
+	value, ok := promValue(v)
+	if !ok {
+		return
+	}
+	f := g.fields[name]
+	if f == nil {
+		f = &aggField{min: value, max: value, last: value, lastTs: ts}
+		g.fields[name] = f
+	}
+	f.count++
+	f.sum += value
+	f.min = math.Min(f.min, value)
+	f.max = math.Max(f.max, value)
+	if !ts.Before(f.lastTs) {
+		f.last, f.lastTs = value, ts
+	}
+	if keep {
+		f.values = append(f.values, value)
+	}
+}
+
+// metric builds the aggregated metric of a group.
+func (ag *Aggregate) metric(g *aggGroup, start time.Time) *skogul.Metric {
+	data := make(map[string]interface{})
+	for name, f := range g.fields {
+		results := map[string]interface{}{
+			"min":   f.min,
+			"max":   f.max,
+			"avg":   f.sum / float64(f.count),
+			"sum":   f.sum,
+			"count": f.count,
+			"last":  f.last,
+		}
+		for fn, v := range results {
+			if ag.functions[fn] {
+				data[name+"_"+fn] = v
+			}
+		}
+		if len(ag.Percentiles) > 0 {
+			sort.Float64s(f.values)
+			for _, p := range ag.Percentiles {
+				// Nearest rank
+				rank := int(math.Ceil(p / 100 * float64(len(f.values))))
+				if rank < 1 {
+					rank = 1
+				}
+				pname := strings.ReplaceAll(fmt.Sprint(p), ".", "_")
+				data[name+"_p"+pname] = f.values[rank-1]
+			}
+		}
+	}
+	return &skogul.Metric{Time: &start, Metadata: g.metadata, Data: data}
+}
+
+// close closes all windows that have expired at now, and passes on the
+// aggregated metrics. If now is the zero time, all windows are closed.
+func (ag *Aggregate) close(now time.Time) {
+	c := skogul.Container{}
+	ag.lock.Lock()
+	if now.IsZero() {
+		for start, window := range ag.windows {
+			for _, g := range window {
+				c.Metrics = append(c.Metrics, ag.metric(g, time.Unix(0, start)))
+			}
+			delete(ag.windows, start)
+		}
+	} else if ag.windows != nil {
+		ag.closed = now.Add(-ag.Lateness.Duration).Truncate(ag.Window.Duration).Add(-ag.Window.Duration)
+		for start, window := range ag.windows {
+			t := time.Unix(0, start)
+			if t.After(ag.closed) {
+				continue
+			}
+			for _, g := range window {
+				c.Metrics = append(c.Metrics, ag.metric(g, t))
+			}
+			delete(ag.windows, start)
+		}
+	}
+	ag.lock.Unlock()
+	if len(c.Metrics) == 0 {
+		return
+	}
+	atomic.AddUint64(&ag.stats.Aggregated, uint64(len(c.Metrics)))
+	if err := ag.Next.S.Send(&c); err != nil {
+		atomic.AddUint64(&ag.stats.Errors, 1)
+		aggLog.WithError(err).Error("Unable to send aggregated metrics")
+	}
+}
+
+// Flush closes all open windows, including the current one, and passes on
+// the aggregated metrics. Metrics for windows closed this way are not
+// treated as late, so a window can be passed on more than once.
+func (ag *Aggregate) Flush() error {
+	ag.once.Do(ag.init)
+	ag.close(time.Time{})
+	return nil
+}
+
+// Stop stops closing windows. Anything not flushed is lost.
+func (ag *Aggregate) Stop() error {
+	ag.once.Do(ag.init)
+	ag.lock.Lock()
+	if ag.windows == nil {
+		ag.lock.Unlock()
+		return nil
+	}
+	ag.windows = nil
+	ag.lock.Unlock()
+	close(ag.stop)
+	<-ag.done
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the aggregate sender.
+func (ag *Aggregate) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "sender"
+	metric.Metadata["type"] = "aggregate"
+	metric.Metadata["identity"] = skogul.IdentityOf(ag)
+
+	metric.Data["received"] = atomic.LoadUint64(&ag.stats.Received)
+	metric.Data["late"] = atomic.LoadUint64(&ag.stats.Late)
+	metric.Data["aggregated"] = atomic.LoadUint64(&ag.stats.Aggregated)
+	metric.Data["errors"] = atomic.LoadUint64(&ag.stats.Errors)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (ag *Aggregate) Verify() error {
+	if ag.Next.Name == "" {
+		return skogul.MissingArgument("Next")
+	}
+	if ag.Window.Duration < 0 || ag.Lateness.Duration < 0 {
+		return fmt.Errorf("Window and Lateness can't be negative")
+	}
+	for _, f := range ag.Functions {
+		if !aggFunctions[f] {
+			return fmt.Errorf("unknown function %q, must be min, max, avg, sum, count or last", f)
+		}
+	}
+	for _, p := range ag.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("invalid percentile %v, must be above 0 and at most 100", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+/*
+ * skogul, aggregate sender tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/sender"
+)
+
+// aggRecorder passes the containers it receives on a channel.
+type aggRecorder struct {
+	ch chan *skogul.Container
+}
+
+func (r *aggRecorder) Send(c *skogul.Container) error {
+	r.ch <- c
+	return nil
+}
+
+func aggMetric(ts time.Time, host string, ifname string, in interface{}) *skogul.Metric {
+	return &skogul.Metric{
+		Time:     &ts,
+		Metadata: map[string]interface{}{"host": host, "interface": ifname, "description": "uplink"},
+		Data:     map[string]interface{}{"in": in, "out": 1, "state": "up"},
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	rec := &aggRecorder{ch: make(chan *skogul.Container, 10)}
+	ag := &sender.Aggregate{
+		Next:        skogul.SenderRef{Name: "rec", S: rec},
+		Keys:        []string{"host", "interface"},
+		Fields:      []string{"in"},
+		Percentiles: []float64{50, 99.9},
+		Window:      skogul.Duration{Duration: time.Hour},
+	}
+	if err := ag.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	start := time.Now().Truncate(time.Hour)
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		aggMetric(start.Add(3*time.Second), "r1", "et-0/0/0", 30),
+		aggMetric(start.Add(1*time.Second), "r1", "et-0/0/0", 10),
+		aggMetric(start.Add(2*time.Second), "r1", "et-0/0/0", 20.0),
+		aggMetric(start.Add(2*time.Second), "r1", "et-0/0/0", 40),
+		aggMetric(start, "r2", "et-0/0/0", uint64(5)),
+		aggMetric(start, "r2", "et-0/0/0", "not a number"),
+		aggMetric(start.Add(-2*time.Hour), "r1", "et-0/0/0", 1000),
+	}}
+	if err := ag.Send(&c); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	select {
+	case <-rec.ch:
+		t.Fatalf("Window passed on before it was closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ag.Flush()
+	var out *skogul.Container
+	select {
+	case out = <-rec.ch:
+	default:
+		t.Fatalf("Flush() didn't pass on anything")
+	}
+	if len(out.Metrics) != 2 {
+		t.Fatalf("Expected 2 aggregated metrics, got %d", len(out.Metrics))
+	}
+	var r1, r2 *skogul.Metric
+	for _, m := range out.Metrics {
+		if m.Metadata["host"] == "r1" {
+			r1 = m
+		} else {
+			r2 = m
+		}
+	}
+	if !r1.Time.Equal(start) {
+		t.Errorf("Expected window start %v as time, got %v", start, r1.Time)
+	}
+	if len(r1.Metadata) != 2 || r1.Metadata["interface"] != "et-0/0/0" {
+		t.Errorf("Expected only host and interface as metadata, got %v", r1.Metadata)
+	}
+	want := map[string]interface{}{
+		"in_min":   10.0,
+		"in_max":   40.0,
+		"in_avg":   25.0,
+		"in_sum":   100.0,
+		"in_count": uint64(4),
+		"in_last":  30.0,
+		"in_p50":   20.0,
+		"in_p99_9": 40.0,
+	}
+	if len(r1.Data) != len(want) {
+		t.Errorf("Expected %d data fields, got %v", len(want), r1.Data)
+	}
+	for k, v := range want {
+		if r1.Data[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, r1.Data[k])
+		}
+	}
+	if r2.Data["in_count"] != uint64(1) || r2.Data["in_sum"] != 5.0 {
+		t.Errorf("Expected a single value of 5 for r2, got %v", r2.Data)
+	}
+	stats := ag.GetStats()
+	if stats.Data["late"] != uint64(1) || stats.Data["received"] != uint64(7) || stats.Data["aggregated"] != uint64(2) {
+		t.Errorf("Unexpected stats: %v", stats.Data)
+	}
+
+	// Flushing again should be harmless
+	ag.Flush()
+	select {
+	case out = <-rec.ch:
+		t.Errorf("Second Flush() passed on %d metrics", len(out.Metrics))
+	default:
+	}
+	ag.Stop()
+	if err := ag.Send(&c); err == nil {
+		t.Errorf("Send() after Stop() succeeded")
+	}
+}
+
+func TestAggregateWindow(t *testing.T) {
+	rec := &aggRecorder{ch: make(chan *skogul.Container, 10)}
+	ag := &sender.Aggregate{
+		Next:      skogul.SenderRef{Name: "rec", S: rec},
+		Functions: []string{"sum"},
+		Window:    skogul.Duration{Duration: 100 * time.Millisecond},
+		Lateness:  skogul.Duration{Duration: 20 * time.Millisecond},
+	}
+	defer ag.Stop()
+	now := time.Now()
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		aggMetric(now, "r1", "et-0/0/0", 1),
+		aggMetric(now, "r2", "et-0/0/1", 2),
+	}}
+	ag.Send(&c)
+	var out *skogul.Container
+	select {
+	case out = <-rec.ch:
+	case <-time.After(time.Second):
+		t.Fatalf("Window was never closed")
+	}
+	if len(out.Metrics) != 1 {
+		t.Fatalf("Expected a single aggregated metric, got %d", len(out.Metrics))
+	}
+	m := out.Metrics[0]
+	if len(m.Metadata) != 0 {
+		t.Errorf("Expected no metadata without Keys, got %v", m.Metadata)
+	}
+	if m.Data["in_sum"] != 3.0 || m.Data["out_sum"] != 2.0 || len(m.Data) != 2 {
+		t.Errorf("Expected in_sum 3 and out_sum 2, got %v", m.Data)
+	}
+	if time.Since(now) < 20*time.Millisecond {
+		t.Errorf("Window closed before Lateness had passed")
+	}
+	ag.Send(&c)
+	if late := ag.GetStats().Data["late"]; late != uint64(2) {
+		t.Errorf("Expected 2 late metrics, got %v", late)
+	}
+}
+
+func TestAggregateVerify(t *testing.T) {
+	next := skogul.SenderRef{Name: "next"}
+	bad := []*sender.Aggregate{
+		{},
+		{Next: next, Functions: []string{"median"}},
+		{Next: next, Percentiles: []float64{0}},
+		{Next: next, Window: skogul.Duration{Duration: -time.Second}},
+	}
+	for i, ag := range bad {
+		if err := ag.Verify(); err == nil {
+			t.Errorf("Verify of bad config %d succeeded", i)
+		}
+	}
+}
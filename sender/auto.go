@@ -38,6 +38,12 @@ func init() {
 		Alloc:   func() interface{} { return &Backoff{} },
 		Help:    "Forwards data to the next sender, retrying after a delay upon failure. For each retry, the delay is doubled. Gives up after the set number of retries.",
 	})
+	Auto.Add(skogul.Module{
+		Name:    "aggregate",
+		Aliases: []string{"rollup"},
+		Alloc:   func() interface{} { return &Aggregate{} },
+		Help:    "Downsamples metrics by grouping them by a set of metadata fields over fixed time windows, and passes on min, max, average, sum, count, last value and percentiles of the data fields when each window closes. Metrics are placed in windows by their timestamp, and metrics arriving after their window is closed are dropped.",
+	})
 	Auto.Add(skogul.Module{
 		Name:    "batch",
 		Aliases: []string{"batcher"},
@@ -63,6 +69,12 @@ func init() {
 		Alloc:   func() interface{} { return &Detacher{} },
 		Help:    "Returns OK without waiting for the next sender to finish. The detached part is single-threaded.",
 	})
+	Auto.Add(skogul.Module{
+		Name:    "diskqueue",
+		Aliases: []string{"disk-queue", "spool"},
+		Alloc:   func() interface{} { return &DiskQueue{} },
+		Help:    "Stores data on disk and returns immediately, then forwards it to the next sender in the order it was received, retrying until it succeeds. Queued data survives restarts and crashes, allowing long down-stream outages to be ridden out without losing data. Size and age of the queue can be capped, in which case the oldest data is dropped. Errors from down-stream are not propagated upstream.",
+	})
 	Auto.Add(skogul.Module{
 		Name:    "dupe",
 		Aliases: []string{"dup", "duplicate"},
@@ -99,7 +111,7 @@ func init() {
 		Name:    "http",
 		Aliases: []string{"https"},
 		Alloc:   func() interface{} { return &HTTP{} },
-		Help:    "Sends Skogul-formatted JSON-data to a HTTP endpoint (e.g.: an other Skogul instance?). Highly useful in scenarios with multiple data collection methods spread over several servers.",
+		Help:    "Sends Skogul-formatted JSON-data to a HTTP endpoint (e.g.: an other Skogul instance?). Highly useful in scenarios with multiple data collection methods spread over several servers. Supports request compression, retries honouring Retry-After, and URLs and headers based on metadata, e.g. per tenant.",
 	})
 	Auto.Add(skogul.Module{
 		Name:    "influx",
@@ -128,6 +140,12 @@ func init() {
 		Alloc: func() interface{} { return &Nats{} },
 		Help:  "Publishes received metrics to a NATS server/cluster.",
 	})
+	Auto.Add(skogul.Module{
+		Name:    "prometheus",
+		Aliases: []string{"prom", "exporter"},
+		Alloc:   func() interface{} { return &Prometheus{} },
+		Help:    "Keeps the latest value of each series in memory and serves them over HTTP for Prometheus to scrape. Metadata is used as labels and numeric data fields as gauges or counters. Series not updated for a while are expired.",
+	})
 	Auto.Add(skogul.Module{
 		Name:  "sql",
 		Alloc: func() interface{} { return &SQL{} },
@@ -158,14 +176,20 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:   "switch",
 		Alloc:  func() interface{} { return &Switch{} },
-		Help:   "Sends data selectively based on metedata.",
+		Help:   "Sends data selectively based on metedata, or on expressions using both metadata and data.",
 		Extras: []interface{}{Match{}},
 	})
 	Auto.Add(skogul.Module{
 		Name:    "enrichmentupdater",
 		Aliases: []string{"eupdater"},
 		Alloc:   func() interface{} { return &EnrichmentUpdater{} },
-		Help:    "Updates the enrichment database of an enrichment transformer.",
+		Help:    "Updates the enrichment database of an enrichment transformer, such as enrich or prefix.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "otlp",
+		Aliases: []string{"opentelemetry"},
+		Alloc:   func() interface{} { return &OTLP{} },
+		Help:    "Sends metrics to an OpenTelemetry collector using OTLP/HTTP or OTLP/gRPC. The reverse of the otlp parser: metadata becomes attributes and numeric data fields become gauges or sums, with histograms and summaries passed through.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "test",
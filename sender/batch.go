@@ -72,6 +72,8 @@ type Batch struct {
 	cont      *skogul.Container       // Current container - used single threaded
 	out       chan *skogul.Container  // When Thershold/Timer is triggered, dump the container here
 	burner    *chan *skogul.Container // Or burn it. Points to "out" if no burner is configured.
+	flushch   chan chan struct{}      // Flush() requests, closed by run() when the current container is handed off
+	pending   sync.WaitGroup          // Containers handed off to flushers, but not yet sent
 }
 
 func (bat *Batch) setup() {
@@ -82,6 +84,7 @@ func (bat *Batch) setup() {
 		bat.Threshold = 10
 	}
 	bat.ch = make(chan *skogul.Container, 10)
+	bat.flushch = make(chan chan struct{})
 	if bat.Interval.Duration == 0 {
 		bat.Interval.Duration = time.Duration(1 * time.Second)
 	}
@@ -142,9 +145,10 @@ func (bat *Batch) flusher(ch chan *skogul.Container, sender skogul.Sender) {
 		c := <-ch
 		err := sender.Send(c)
 		if err != nil {
-			err = fmt.Errorf("Batch sender (%s) failed due to down stream error: %w", skogul.Identity[bat], err)
+			err = fmt.Errorf("Batch sender (%s) failed due to down stream error: %w", skogul.IdentityOf(bat), err)
 			batchLog.Error(err)
 		}
+		bat.pending.Done()
 	}
 }
 
@@ -153,6 +157,7 @@ func (bat *Batch) flusher(ch chan *skogul.Container, sender skogul.Sender) {
 // blocked, it will use an alternate channel. bat.burner will just point
 // back to bat.out if no burner is present, thus block.
 func (bat *Batch) flush() {
+	bat.pending.Add(1)
 	select {
 	case bat.out <- bat.cont:
 	default:
@@ -186,6 +191,28 @@ func (bat *Batch) run() {
 			if bat.cont != nil {
 				bat.flush()
 			}
+		case done := <-bat.flushch:
+			bat.drain()
+			if bat.cont != nil {
+				bat.flush()
+			}
+			close(done)
+		}
+	}
+}
+
+// drain adds any containers already waiting on the input channel to the
+// current batch, without blocking. Used when flushing.
+func (bat *Batch) drain() {
+	for {
+		select {
+		case c := <-bat.ch:
+			bat.add(c)
+			if len(bat.cont.Metrics) >= bat.Threshold {
+				bat.flush()
+			}
+		default:
+			return
 		}
 	}
 }
@@ -202,6 +229,20 @@ func (bat *Batch) Send(c *skogul.Container) error {
 	return nil
 }
 
+// Flush passes on the current batch regardless of size, and waits until
+// all batches handed off so far have been sent to the next sender (or
+// burner).
+func (bat *Batch) Flush() error {
+	bat.once.Do(func() {
+		bat.setup()
+	})
+	done := make(chan struct{})
+	bat.flushch <- done
+	<-done
+	bat.pending.Wait()
+	return nil
+}
+
 func (bat *Batch) Verify() error {
 	if bat.Next.Name == "" {
 		return skogul.MissingArgument("Next")
@@ -69,3 +69,25 @@ func TestBatch(t *testing.T) {
 	one.TestQuick(t, batch, &c, 0)
 	one.TestQuick(t, batch, &c, 1)
 }
+
+func TestBatchFlush(t *testing.T) {
+	c := skogul.Container{}
+	m := skogul.Metric{}
+	c.Metrics = []*skogul.Metric{&m}
+	one := &(sender.Test{})
+	batch := &(sender.Batch{Next: skogul.SenderRef{S: one}, Interval: skogul.Duration{Duration: time.Hour}})
+
+	// Flushing before anything is sent should be harmless
+	if err := batch.Flush(); err != nil {
+		t.Errorf("batch.Flush() failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		one.TestQuick(t, batch, &c, 0)
+	}
+	if err := batch.Flush(); err != nil {
+		t.Errorf("batch.Flush() failed: %v", err)
+	}
+	if one.Received() != 1 {
+		t.Errorf("batch.Flush() didn't pass data on. Expected %d, got %d", 1, one.Received())
+	}
+}
@@ -111,7 +111,7 @@ func (co *Counter) getIt() {
 			metric := skogul.Metric{}
 			metric.Metadata = make(map[string]interface{})
 			metric.Data = make(map[string]interface{})
-			metric.Metadata["skogul"] = skogul.Identity[co]
+			metric.Metadata["skogul"] = skogul.IdentityOf(co)
 			container.Metrics = []*skogul.Metric{&metric}
 			total.containers += current.containers
 			total.metrics += current.metrics
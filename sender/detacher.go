@@ -52,10 +52,11 @@ you want.
 The purpose is to smooth out reading.
 */
 type Detacher struct {
-	Next  skogul.SenderRef `doc:"Sender that receives the metrics."`
-	Depth int              `doc:"How many containers can be pending delivery before we start blocking. Defaults to 1000."`
-	ch    chan *skogul.Container
-	once  sync.Once
+	Next    skogul.SenderRef `doc:"Sender that receives the metrics."`
+	Depth   int              `doc:"How many containers can be pending delivery before we start blocking. Defaults to 1000."`
+	ch      chan *skogul.Container
+	once    sync.Once
+	pending sync.WaitGroup
 }
 
 // consume is the detached go routine that picks up containers and passes
@@ -63,6 +64,7 @@ type Detacher struct {
 func (de *Detacher) consume() {
 	for c := range de.ch {
 		de.Next.S.Send(c)
+		de.pending.Done()
 	}
 }
 
@@ -81,10 +83,20 @@ func (de *Detacher) Send(c *skogul.Container) error {
 	de.once.Do(func() {
 		de.doInit()
 	})
+	de.pending.Add(1)
 	de.ch <- c
 	return nil
 }
 
+// Flush waits until all queued containers have been passed on.
+func (de *Detacher) Flush() error {
+	de.once.Do(func() {
+		de.doInit()
+	})
+	de.pending.Wait()
+	return nil
+}
+
 /*
 Fanout sender implements a worker pool for passing data on. This SHOULD be
 unnecessary, as the receiver should ideally do this for us (e.g.: the
@@ -104,6 +116,7 @@ type Fanout struct {
 	Workers int              `doc:"Number of worker threads in use. To _fan_in_ you can set this to 1."`
 	once    sync.Once
 	workers chan chan *skogul.Container
+	pending sync.WaitGroup
 }
 
 func (fo *Fanout) doInit() {
@@ -124,11 +137,22 @@ func (fo *Fanout) Send(c *skogul.Container) error {
 	fo.once.Do(func() {
 		fo.doInit()
 	})
+	fo.pending.Add(1)
 	x := <-fo.workers
 	x <- c
 	return nil
 }
 
+// Flush waits until the workers have passed on all containers handed to
+// them.
+func (fo *Fanout) Flush() error {
+	fo.once.Do(func() {
+		fo.doInit()
+	})
+	fo.pending.Wait()
+	return nil
+}
+
 // worker makes a channel for work, makes that channel available on the
 // shared fo.workers channel, then reads from it.
 func (fo *Fanout) worker() {
@@ -137,5 +161,6 @@ func (fo *Fanout) worker() {
 		fo.workers <- c
 		con := <-c
 		fo.Next.S.Send(con)
+		fo.pending.Done()
 	}
 }
@@ -106,3 +106,23 @@ func TestFanout(t *testing.T) {
 		t.Errorf("Fanout: Expected 4 received events after timer(s) expired. Got %d", tst.Received())
 	}
 }
+
+func TestDetacherFlush(t *testing.T) {
+	c := skogul.Container{}
+	m := skogul.Metric{}
+
+	c.Metrics = []*skogul.Metric{&m}
+	tst := &(sender.Test{})
+	delay := &(sender.Sleeper{Base: skogul.Duration{Duration: time.Duration(50 * time.Millisecond)}, Next: skogul.SenderRef{S: tst}})
+	detach := &(sender.Detacher{Next: skogul.SenderRef{S: delay}})
+
+	for i := 0; i < 3; i++ {
+		detach.Send(&c)
+	}
+	if err := detach.Flush(); err != nil {
+		t.Errorf("detach.Flush() failed: %v", err)
+	}
+	if tst.Received() != 3 {
+		t.Errorf("detach.Flush() returned before data was passed on. Wanted %d containers, got %d", 3, tst.Received())
+	}
+}
@@ -0,0 +1,558 @@
+/*
+ * skogul, disk queue sender
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+	"github.com/telenornms/skogul/parser"
+)
+
+var dqLog = skogul.Logger("sender", "diskqueue")
+
+const (
+	dqHeaderSize = 8 // 4 bytes length, 4 bytes CRC32 of the payload
+	dqSuffix     = ".seg"
+	dqCursor     = "cursor"
+)
+
+/*
+DiskQueue stores containers on disk and returns immediately, then passes
+them on to the Next sender in a separate go routine, in the order they
+were received. If the Next sender fails, it is retried with an increasing
+delay between attempts, until it succeeds or MaxRetries is reached. This
+allows riding out long outages down stream without losing data or
+blocking the receivers. With MaxRetries set, a container the Next sender
+keeps rejecting is dropped instead of blocking the rest of the queue.
+
+Data is stored in segment files in Directory. Each record is the
+container as encoded by Encoder, with a small header containing length
+and checksum, so partially written records from a crash are detected and
+discarded on startup. Fully delivered segments are deleted. The position
+of the next record to deliver is stored in a separate cursor file, which
+means that after a crash, at most the container that was being delivered
+at the time is delivered twice.
+
+MaxSize and MaxAge limit how much data is kept. Both work on whole
+segments: when a limit is exceeded, the oldest segments are deleted,
+regardless of whether they have been delivered. The segment currently
+being written to is never deleted, so SegmentSize should be considerably
+smaller than MaxSize.
+
+Only one disk queue sender can use a directory at a time. Stopping the
+sender, e.g. when it is replaced on reload, stops the delivery and closes
+the files, so the replacement can take over the directory.
+*/
+type DiskQueue struct {
+	Next          skogul.SenderRef  `doc:"Sender that receives the queued containers."`
+	Directory     string            `doc:"Directory to store the queue in. Created if it doesn't exist."`
+	Encoder       skogul.EncoderRef `doc:"Encoder used to store containers. Must match Parser. Defaults to gob."`
+	Parser        skogul.ParserRef  `doc:"Parser used to read stored containers back. Must match Encoder. Defaults to gob."`
+	SegmentSize   int64             `doc:"Start a new segment file when the current one exceeds this size in bytes. Defaults to 16MiB."`
+	MaxSize       int64             `doc:"Maximum size of the queue in bytes. If exceeded, the oldest segments are deleted. Defaults to 0, meaning no limit."`
+	MaxAge        skogul.Duration   `doc:"Delete segments that have not been written to for this long, even if they are not delivered. Defaults to no limit."`
+	RetryDelay    skogul.Duration   `doc:"Initial delay before retrying when the Next sender fails. Doubled for each failure. Defaults to 1s."`
+	MaxRetryDelay skogul.Duration   `doc:"Maximum delay between retries. Defaults to 1m."`
+	MaxRetries    int               `doc:"Drop a container after this many failed retries, so one the Next sender keeps rejecting doesn't block the queue. Defaults to 0, retrying forever."`
+	Sync          bool              `doc:"Sync data to disk after every write. This protects against power loss, not just crashes, but is significantly slower."`
+	once          sync.Once
+	initErr       error
+	lock          sync.Mutex           // Protects the segment bookkeeping below, shared by Send() and the delivery go routine.
+	segments      []uint64             // Segment IDs on disk, oldest first. The last one is being written to.
+	sizes         map[uint64]int64     // Size of each segment.
+	mtimes        map[uint64]time.Time // Last write to each segment.
+	total         int64                // Total size of all segments.
+	w             *os.File             // Segment being written to.
+	wake          chan struct{}        // Signals the delivery go routine that new data is available.
+	cursor        *os.File             // Position of next record to deliver, only used by the delivery go routine.
+	readSeg       uint64               // Segment being delivered, only used by the delivery go routine.
+	readOff       int64                // Offset of next record to deliver, only used by the delivery go routine.
+	r             *os.File             // File handle for readSeg, only used by the delivery go routine.
+	quit          chan struct{}        // Closed by Stop() to end the delivery go routine.
+	done          chan struct{}        // Closed when the delivery go routine has ended.
+	stopped       bool                 // Set by Stop(), protected by lock.
+	stats         dqStats
+}
+
+// dqStats contains the internal stats of the disk queue sender.
+type dqStats struct {
+	Queued       uint64 // Containers written to disk.
+	Sent         uint64 // Containers successfully passed on.
+	Retries      uint64 // Failed attempts at passing on containers.
+	Corrupt      uint64 // Records that couldn't be read or parsed, and were skipped.
+	DroppedBytes uint64 // Bytes deleted due to MaxSize or MaxAge.
+	Dropped      uint64 // Containers dropped after MaxRetries failed retries.
+}
+
+func (dq *DiskQueue) segPath(id uint64) string {
+	return filepath.Join(dq.Directory, fmt.Sprintf("%016x%s", id, dqSuffix))
+}
+
+// init sets defaults, recovers existing segments and the cursor from
+// disk, starts a new segment and starts the delivery go routine.
+func (dq *DiskQueue) init() error {
+	if dq.Encoder.E == nil {
+		dq.Encoder.E = encoder.GOB{}
+	}
+	if dq.Parser.P == nil {
+		dq.Parser.P = parser.GOB{}
+	}
+	if dq.SegmentSize == 0 {
+		dq.SegmentSize = 16 * 1024 * 1024
+	}
+	if dq.RetryDelay.Duration == 0 {
+		dq.RetryDelay.Duration = time.Second
+	}
+	if dq.MaxRetryDelay.Duration == 0 {
+		dq.MaxRetryDelay.Duration = time.Minute
+	}
+	if err := os.MkdirAll(dq.Directory, 0750); err != nil {
+		return fmt.Errorf("unable to create queue directory: %w", err)
+	}
+	entries, err := os.ReadDir(dq.Directory)
+	if err != nil {
+		return fmt.Errorf("unable to read queue directory: %w", err)
+	}
+	dq.sizes = make(map[uint64]int64)
+	dq.mtimes = make(map[uint64]time.Time)
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), dqSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), dqSuffix), 16, 64)
+		if err != nil {
+			dqLog.WithField("file", e.Name()).Warn("Ignoring unknown file in queue directory")
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("unable to stat queue segment: %w", err)
+		}
+		dq.segments = append(dq.segments, id)
+		dq.sizes[id] = info.Size()
+		dq.mtimes[id] = info.ModTime()
+		dq.total += info.Size()
+	}
+	sort.Slice(dq.segments, func(i, j int) bool { return dq.segments[i] < dq.segments[j] })
+
+	dq.cursor, err = os.OpenFile(filepath.Join(dq.Directory, dqCursor), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to open queue cursor: %w", err)
+	}
+	pos := make([]byte, 16)
+	if n, _ := dq.cursor.ReadAt(pos, 0); n == len(pos) {
+		dq.readSeg = binary.BigEndian.Uint64(pos[0:8])
+		dq.readOff = int64(binary.BigEndian.Uint64(pos[8:16]))
+	}
+	// Segments before the cursor are delivered, but weren't deleted
+	// before we stopped.
+	for len(dq.segments) > 0 && dq.segments[0] < dq.readSeg {
+		dq.removeOldest()
+	}
+	if len(dq.segments) > 0 {
+		dqLog.WithField("segments", len(dq.segments)).WithField("bytes", dq.total).Info("Recovered queued data from disk")
+	}
+
+	// Always start a new segment, so we never append to a segment
+	// with a partially written record at the end.
+	next := uint64(1)
+	if len(dq.segments) > 0 {
+		next = dq.segments[len(dq.segments)-1] + 1
+	}
+	if err := dq.newSegment(next); err != nil {
+		return err
+	}
+	if dq.readSeg < dq.segments[0] {
+		dq.readSeg = dq.segments[0]
+		dq.readOff = 0
+	}
+	dq.wake = make(chan struct{}, 1)
+	dq.quit = make(chan struct{})
+	dq.done = make(chan struct{})
+	go dq.deliver()
+	return nil
+}
+
+// newSegment creates a new segment and starts writing to it. Must be
+// called with the lock held (or during init).
+func (dq *DiskQueue) newSegment(id uint64) error {
+	f, err := os.OpenFile(dq.segPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to create queue segment: %w", err)
+	}
+	if dq.w != nil {
+		dq.w.Close()
+	}
+	dq.w = f
+	dq.segments = append(dq.segments, id)
+	dq.sizes[id] = 0
+	dq.mtimes[id] = time.Now()
+	return nil
+}
+
+// removeOldest deletes the oldest segment. Must be called with the lock
+// held (or during init), and never when only the segment being written
+// to is left.
+func (dq *DiskQueue) removeOldest() {
+	id := dq.segments[0]
+	dq.segments = dq.segments[1:]
+	dq.total -= dq.sizes[id]
+	delete(dq.sizes, id)
+	delete(dq.mtimes, id)
+	if err := os.Remove(dq.segPath(id)); err != nil {
+		dqLog.WithError(err).WithField("segment", id).Warn("Unable to delete queue segment")
+	}
+}
+
+// enforceLimits deletes the oldest segments if MaxSize or MaxAge is
+// exceeded. Must be called with the lock held.
+func (dq *DiskQueue) enforceLimits() {
+	for len(dq.segments) > 1 {
+		id := dq.segments[0]
+		tooBig := dq.MaxSize > 0 && dq.total > dq.MaxSize
+		tooOld := dq.MaxAge.Duration > 0 && time.Since(dq.mtimes[id]) > dq.MaxAge.Duration
+		if !tooBig && !tooOld {
+			return
+		}
+		dqLog.WithField("segment", id).WithField("bytes", dq.sizes[id]).WithField("too_big", tooBig).WithField("too_old", tooOld).Warn("Queue limit exceeded, deleting oldest segment")
+		atomic.AddUint64(&dq.stats.DroppedBytes, uint64(dq.sizes[id]))
+		dq.removeOldest()
+	}
+}
+
+// Send writes the container to disk and returns.
+func (dq *DiskQueue) Send(c *skogul.Container) error {
+	dq.once.Do(func() {
+		dq.initErr = dq.init()
+	})
+	if dq.initErr != nil {
+		return fmt.Errorf("disk queue unusable: %w", dq.initErr)
+	}
+	b, err := dq.Encoder.E.Encode(c)
+	if err != nil {
+		return fmt.Errorf("unable to encode container: %w", err)
+	}
+	rec := make([]byte, dqHeaderSize+len(b))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(b)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(b))
+	copy(rec[dqHeaderSize:], b)
+
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	if dq.stopped {
+		return fmt.Errorf("disk queue is stopped")
+	}
+	id := dq.segments[len(dq.segments)-1]
+	if dq.sizes[id] >= dq.SegmentSize {
+		if err := dq.newSegment(id + 1); err != nil {
+			return err
+		}
+		id++
+	}
+	if n, err := dq.w.Write(rec); err != nil {
+		// Don't leave a partial record behind for the next write
+		// to be appended to.
+		dq.w.Truncate(dq.sizes[id])
+		dq.w.Seek(dq.sizes[id], 0)
+		return fmt.Errorf("unable to write to queue (wrote %d of %d bytes): %w", n, len(rec), err)
+	}
+	if dq.Sync {
+		if err := dq.w.Sync(); err != nil {
+			return fmt.Errorf("unable to sync queue to disk: %w", err)
+		}
+	}
+	dq.sizes[id] += int64(len(rec))
+	dq.total += int64(len(rec))
+	dq.mtimes[id] = time.Now()
+	atomic.AddUint64(&dq.stats.Queued, 1)
+	dq.enforceLimits()
+
+	select {
+	case dq.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// read returns the next record to deliver and its size on disk, blocking
+// until one is available. Corrupt records are skipped. Returns false if
+// the sender is stopped.
+func (dq *DiskQueue) read() ([]byte, int64, bool) {
+	for {
+		dq.lock.Lock()
+		if dq.readSeg < dq.segments[0] {
+			// Deleted due to limits
+			dq.readSeg = dq.segments[0]
+			dq.readOff = 0
+		}
+		size := dq.sizes[dq.readSeg]
+		last := dq.readSeg == dq.segments[len(dq.segments)-1]
+		dq.lock.Unlock()
+
+		if dq.readOff < size {
+			b, err := dq.readRecord(size)
+			if err == nil {
+				return b, int64(dqHeaderSize + len(b)), true
+			}
+			atomic.AddUint64(&dq.stats.Corrupt, 1)
+			dqLog.WithError(err).WithField("segment", dq.readSeg).WithField("offset", dq.readOff).Warn("Corrupt record in queue, skipping rest of segment")
+			dq.readOff = size
+			continue
+		}
+		if !last {
+			dq.lock.Lock()
+			if dq.segments[0] == dq.readSeg {
+				dq.removeOldest()
+			}
+			dq.readSeg = dq.segments[0]
+			dq.readOff = 0
+			dq.lock.Unlock()
+			if dq.r != nil {
+				dq.r.Close()
+				dq.r = nil
+			}
+			continue
+		}
+		select {
+		case <-dq.wake:
+		case <-dq.quit:
+			return nil, 0, false
+		}
+	}
+}
+
+// readRecord reads the record at the current read position.
+func (dq *DiskQueue) readRecord(size int64) ([]byte, error) {
+	if dq.r == nil || dq.r.Name() != dq.segPath(dq.readSeg) {
+		if dq.r != nil {
+			dq.r.Close()
+		}
+		var err error
+		dq.r, err = os.Open(dq.segPath(dq.readSeg))
+		if err != nil {
+			dq.r = nil
+			return nil, err
+		}
+	}
+	hdr := make([]byte, dqHeaderSize)
+	if dq.readOff+dqHeaderSize > size {
+		return nil, fmt.Errorf("truncated record header")
+	}
+	if _, err := dq.r.ReadAt(hdr, dq.readOff); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	if dq.readOff+dqHeaderSize+length > size {
+		return nil, fmt.Errorf("truncated record of %d bytes", length)
+	}
+	b := make([]byte, length)
+	if _, err := dq.r.ReadAt(b, dq.readOff+dqHeaderSize); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(b) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return b, nil
+}
+
+// commit moves the read position past the delivered record and stores
+// it in the cursor file.
+func (dq *DiskQueue) commit(n int64) {
+	dq.readOff += n
+	pos := make([]byte, 16)
+	binary.BigEndian.PutUint64(pos[0:8], dq.readSeg)
+	binary.BigEndian.PutUint64(pos[8:16], uint64(dq.readOff))
+	if _, err := dq.cursor.WriteAt(pos, 0); err != nil {
+		dqLog.WithError(err).Warn("Unable to update queue cursor, data might be delivered twice after a restart")
+	}
+}
+
+// deliver passes queued containers on to the Next sender, retrying
+// until it succeeds or MaxRetries is reached, until the sender is
+// stopped.
+func (dq *DiskQueue) deliver() {
+	defer close(dq.done)
+	for {
+		b, n, ok := dq.read()
+		if !ok {
+			return
+		}
+		c, err := dq.Parser.P.Parse(b)
+		if err != nil {
+			atomic.AddUint64(&dq.stats.Corrupt, 1)
+			dqLog.WithError(err).Error("Unable to parse queued container, skipping it")
+			dq.commit(n)
+			continue
+		}
+		delay := dq.RetryDelay.Duration
+		for retries := 0; ; retries++ {
+			err = dq.Next.S.Send(c)
+			if err == nil {
+				atomic.AddUint64(&dq.stats.Sent, 1)
+				break
+			}
+			if dq.MaxRetries > 0 && retries >= dq.MaxRetries {
+				atomic.AddUint64(&dq.stats.Dropped, 1)
+				dqLog.WithError(err).WithField("retries", retries).Error("Next sender failed, giving up and dropping the container")
+				break
+			}
+			atomic.AddUint64(&dq.stats.Retries, 1)
+			dqLog.WithError(err).WithField("delay", delay).Warn("Next sender failed, retrying")
+			// The container isn't committed, so it is delivered
+			// after a restart.
+			if !dq.wait(delay) {
+				return
+			}
+			delay *= 2
+			if delay > dq.MaxRetryDelay.Duration {
+				delay = dq.MaxRetryDelay.Duration
+			}
+		}
+		dq.commit(n)
+	}
+}
+
+// wait waits for d, returning false if the sender is stopped first.
+func (dq *DiskQueue) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-dq.quit:
+		return false
+	}
+}
+
+// Stop stops the delivery and closes the files, so another disk queue
+// sender can use the directory. Queued data stays on disk.
+func (dq *DiskQueue) Stop() error {
+	dq.once.Do(func() {
+		dq.initErr = fmt.Errorf("disk queue is stopped")
+	})
+	if dq.initErr != nil {
+		return nil
+	}
+	dq.lock.Lock()
+	if dq.stopped {
+		dq.lock.Unlock()
+		return nil
+	}
+	dq.stopped = true
+	dq.lock.Unlock()
+	close(dq.quit)
+	<-dq.done
+
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	if dq.r != nil {
+		dq.r.Close()
+		dq.r = nil
+	}
+	err := dq.w.Sync()
+	dq.w.Close()
+	if cerr := dq.cursor.Sync(); err == nil {
+		err = cerr
+	}
+	dq.cursor.Close()
+	return err
+}
+
+// Flush makes sure everything queued so far is on disk. It does not wait
+// for the data to be delivered, since that could take forever.
+func (dq *DiskQueue) Flush() error {
+	dq.once.Do(func() {
+		dq.initErr = dq.init()
+	})
+	if dq.initErr != nil {
+		return dq.initErr
+	}
+	dq.lock.Lock()
+	defer dq.lock.Unlock()
+	if dq.stopped {
+		return nil
+	}
+	if err := dq.w.Sync(); err != nil {
+		return err
+	}
+	return dq.cursor.Sync()
+}
+
+// Verify checks that the configuration is usable.
+func (dq *DiskQueue) Verify() error {
+	if dq.Next.Name == "" {
+		return skogul.MissingArgument("Next")
+	}
+	if dq.Directory == "" {
+		return skogul.MissingArgument("Directory")
+	}
+	if (dq.Encoder.Name == "") != (dq.Parser.Name == "") {
+		return fmt.Errorf("specify both Encoder and Parser, or neither")
+	}
+	if dq.SegmentSize < 0 || dq.MaxSize < 0 {
+		return fmt.Errorf("SegmentSize and MaxSize can't be negative")
+	}
+	if dq.MaxRetries < 0 {
+		return fmt.Errorf("MaxRetries can't be negative")
+	}
+	if dq.MaxSize > 0 && dq.SegmentSize > dq.MaxSize {
+		return fmt.Errorf("SegmentSize (%d) is larger than MaxSize (%d)", dq.SegmentSize, dq.MaxSize)
+	}
+	return nil
+}
+
+// GetStats exposes stats about the disk queue.
+func (dq *DiskQueue) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "sender"
+	metric.Metadata["type"] = "diskqueue"
+	metric.Metadata["identity"] = skogul.IdentityOf(dq)
+
+	metric.Data["queued"] = atomic.LoadUint64(&dq.stats.Queued)
+	metric.Data["sent"] = atomic.LoadUint64(&dq.stats.Sent)
+	metric.Data["retries"] = atomic.LoadUint64(&dq.stats.Retries)
+	metric.Data["corrupt"] = atomic.LoadUint64(&dq.stats.Corrupt)
+	metric.Data["dropped_bytes"] = atomic.LoadUint64(&dq.stats.DroppedBytes)
+	metric.Data["dropped"] = atomic.LoadUint64(&dq.stats.Dropped)
+	dq.lock.Lock()
+	metric.Data["bytes"] = dq.total
+	metric.Data["segments"] = len(dq.segments)
+	dq.lock.Unlock()
+	return &metric
+}
@@ -0,0 +1,215 @@
+/*
+ * skogul, disk queue tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/sender"
+)
+
+// dqRecorder records the "seq" field of the first metric of each
+// container it receives, failing the first fail times.
+type dqRecorder struct {
+	lock sync.Mutex
+	fail int
+	seen []int
+}
+
+func (r *dqRecorder) Send(c *skogul.Container) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		return fmt.Errorf("failing on purpose")
+	}
+	r.seen = append(r.seen, int(c.Metrics[0].Data["seq"].(int)))
+	return nil
+}
+
+func (r *dqRecorder) wait(t *testing.T, n int) []int {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		r.lock.Lock()
+		if len(r.seen) >= n {
+			seen := r.seen
+			r.lock.Unlock()
+			return seen
+		}
+		r.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	t.Fatalf("Wanted %d containers, got %d", n, len(r.seen))
+	return nil
+}
+
+func dqContainer(seq int) *skogul.Container {
+	now := time.Now()
+	m := skogul.Metric{
+		Time:     &now,
+		Metadata: map[string]interface{}{"key": "value"},
+		Data:     map[string]interface{}{"seq": seq, "nested": map[string]interface{}{"x": 1.5}},
+	}
+	return &skogul.Container{Metrics: []*skogul.Metric{&m}}
+}
+
+func TestDiskQueue(t *testing.T) {
+	rec := &dqRecorder{fail: 2}
+	dq := &sender.DiskQueue{
+		Next:        skogul.SenderRef{S: rec},
+		Directory:   t.TempDir(),
+		SegmentSize: 300,
+		RetryDelay:  skogul.Duration{Duration: time.Millisecond},
+	}
+	defer dq.Stop()
+	for i := 0; i < 10; i++ {
+		if err := dq.Send(dqContainer(i)); err != nil {
+			t.Fatalf("dq.Send() failed: %v", err)
+		}
+	}
+	seen := rec.wait(t, 10)
+	for i, v := range seen {
+		if v != i {
+			t.Fatalf("Containers delivered out of order: %v", seen)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	segs, _ := filepath.Glob(filepath.Join(dq.Directory, "*.seg"))
+	if len(segs) != 1 {
+		t.Errorf("Delivered segments not deleted, found %d segments", len(segs))
+	}
+	stats := dq.GetStats()
+	if stats.Data["retries"].(uint64) != 2 {
+		t.Errorf("Wanted 2 retries, got %v", stats.Data["retries"])
+	}
+}
+
+func TestDiskQueue_recover(t *testing.T) {
+	dir := t.TempDir()
+	down := &dqRecorder{fail: 1000000}
+	dq := &sender.DiskQueue{
+		Next:       skogul.SenderRef{S: down},
+		Directory:  dir,
+		RetryDelay: skogul.Duration{Duration: time.Hour},
+	}
+	for i := 0; i < 5; i++ {
+		if err := dq.Send(dqContainer(i)); err != nil {
+			t.Fatalf("dq.Send() failed: %v", err)
+		}
+	}
+	if err := dq.Flush(); err != nil {
+		t.Fatalf("dq.Flush() failed: %v", err)
+	}
+	// Stop has to interrupt the hour long retry wait and release the
+	// directory, as on reload.
+	stopped := make(chan error)
+	go func() { stopped <- dq.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("dq.Stop() failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("dq.Stop() didn't interrupt the retry wait")
+	}
+	if err := dq.Send(dqContainer(42)); err == nil {
+		t.Errorf("dq.Send() after Stop() succeeded")
+	}
+	// Simulate a crash in the middle of a write
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	f, err := os.OpenFile(segs[len(segs)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Unable to open segment: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	rec := &dqRecorder{}
+	dq2 := &sender.DiskQueue{
+		Next:      skogul.SenderRef{S: rec},
+		Directory: dir,
+	}
+	defer dq2.Stop()
+	dq2.Send(dqContainer(5))
+	seen := rec.wait(t, 6)
+	for i, v := range seen {
+		if v != i {
+			t.Fatalf("Containers delivered out of order after recovery: %v", seen)
+		}
+	}
+}
+
+func TestDiskQueue_maxSize(t *testing.T) {
+	down := &dqRecorder{fail: 1000000}
+	dq := &sender.DiskQueue{
+		Next:        skogul.SenderRef{S: down},
+		Directory:   t.TempDir(),
+		SegmentSize: 300,
+		MaxSize:     1000,
+		RetryDelay:  skogul.Duration{Duration: time.Hour},
+	}
+	defer dq.Stop()
+	for i := 0; i < 50; i++ {
+		if err := dq.Send(dqContainer(i)); err != nil {
+			t.Fatalf("dq.Send() failed: %v", err)
+		}
+	}
+	stats := dq.GetStats()
+	if stats.Data["bytes"].(int64) > 1000 {
+		t.Errorf("Queue grew past MaxSize: %d bytes", stats.Data["bytes"])
+	}
+	if stats.Data["dropped_bytes"].(uint64) == 0 {
+		t.Errorf("Expected data to be dropped")
+	}
+}
+
+func TestDiskQueue_maxRetries(t *testing.T) {
+	rec := &dqRecorder{fail: 3}
+	dq := &sender.DiskQueue{
+		Next:       skogul.SenderRef{S: rec},
+		Directory:  t.TempDir(),
+		RetryDelay: skogul.Duration{Duration: time.Millisecond},
+		MaxRetries: 2,
+	}
+	defer dq.Stop()
+	for i := 0; i < 3; i++ {
+		if err := dq.Send(dqContainer(i)); err != nil {
+			t.Fatalf("dq.Send() failed: %v", err)
+		}
+	}
+	seen := rec.wait(t, 2)
+	if len(seen) != 2 || seen[0] != 1 || seen[1] != 2 {
+		t.Errorf("Expected the first container to be dropped, got %v", seen)
+	}
+	stats := dq.GetStats()
+	if stats.Data["dropped"].(uint64) != 1 {
+		t.Errorf("Wanted 1 dropped container, got %v", stats.Data["dropped"])
+	}
+}
@@ -27,27 +27,32 @@ import (
 	"fmt"
 
 	"github.com/telenornms/skogul"
-	"github.com/telenornms/skogul/transformer"
 )
 
 var enrichLog = skogul.Logger("sender", "enrichment")
 
+// enrichmentUpdatable is implemented by transformers whose enrichment
+// can be updated, such as the enrich and prefix transformers.
+type enrichmentUpdatable interface {
+	Update(c *skogul.Container)
+}
+
 // EnrichmentUpdater sends any received container/metric to the
 // update-function of the provided transformer, allowing on-the-fly updates
 // to enrichment.
 type EnrichmentUpdater struct {
-	Enricher skogul.TransformerRef `doc:"The enrichment transformer to update."`
+	Enricher skogul.TransformerRef `doc:"The enrichment transformer to update, e.g. an enrich or prefix transformer."`
 }
 
 // Uses received metrics to update the enrichment transformer
 func (e *EnrichmentUpdater) Send(c *skogul.Container) error {
-	er, _ := e.Enricher.T.(*transformer.Enrich)
+	er, _ := e.Enricher.T.(enrichmentUpdatable)
 	er.Update(c)
 	return nil
 }
 
 func (e *EnrichmentUpdater) Verify() error {
-	_, ok := e.Enricher.T.(*transformer.Enrich)
+	_, ok := e.Enricher.T.(enrichmentUpdatable)
 	if !ok {
 		return fmt.Errorf("provided transformer in enrichmentupdater is not an enrichment transformer")
 	}
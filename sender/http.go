@@ -25,18 +25,25 @@ package sender
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	//"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/telenornms/skogul"
@@ -47,10 +54,25 @@ var httpLog = skogul.Logger("sender", "http")
 
 /*
 HTTP sender POSTs the Skogul JSON-encoded data to the provided URL.
+
+The URL and the values of Headers can refer to metadata of the metrics
+using ${metadata.KEY}, e.g. to use a different path for each tenant. The
+metrics of a container are then grouped by the resulting URL and headers,
+and sent in one request per group. Metrics missing any of the metadata
+fields are not sent. If the request for one group fails, the other groups
+are still sent, and the errors of all of them are returned. Other uses of
+$ in the URL and headers are left as they are.
+
+If Retries is set, failed requests are retried if the target answered
+with 429 Too Many Requests or a 5xx status code, or couldn't be reached at
+all. The delay starts at RetryDelay and doubles for each retry, unless the
+target says how long to wait using Retry-After. Stopping the sender
+interrupts the wait, failing the request.
 */
 type HTTP struct {
-	URL              string            `doc:"Fully qualified URL to send data to." example:"http://localhost:6081/ https://user:password@[::1]:6082/"`
-	Headers          map[string]string `doc:"HTTP headers to be added to every request"`
+	URL              string            `doc:"Fully qualified URL to send data to. Can refer to metadata using ${metadata.KEY}." example:"http://localhost:6081/ https://user:password@[::1]:6082/ http://localhost:6081/${metadata.tenant}/"`
+	Method           string            `doc:"HTTP method to use. Defaults to POST."`
+	Headers          map[string]string `doc:"HTTP headers to be added to every request. The values can refer to metadata using ${metadata.KEY}."`
 	Timeout          skogul.Duration   `doc:"HTTP timeout."`
 	Insecure         bool              `doc:"Disable TLS certificate validation."`
 	ConnsPerHost     int               `doc:"Max concurrent connections per host. Should reflect ulimit -n. Defaults to unlimited."`
@@ -59,11 +81,19 @@ type HTTP struct {
 	Certfile         string            `doc:"Path to certificate file for TLS Client Certificate."`
 	Keyfile          string            `doc:"Path to key file for TLS Client Certificate."`
 	Encoder          skogul.EncoderRef `doc:"Encoder to use. Defaults to JSON-encoding."`
+	Compression      string            `doc:"Compress request bodies using gzip or zstd, setting Content-Encoding accordingly. Defaults to no compression."`
+	Retries          int               `doc:"Number of times to retry a request that failed with 429, 5xx or a connection error. Defaults to 0, no retries."`
+	RetryDelay       skogul.Duration   `doc:"Initial delay before retrying a request. Doubles for each retry. Defaults to 1s."`
+	MaxRetryDelay    skogul.Duration   `doc:"Maximum delay before retrying a request, including delays requested with Retry-After. Defaults to 1m."`
 	ok               bool              // set to OK if init worked. FIXME: Should Verify() check if this is OK? I'm thinking yes.
 	stats            *httpStats
 	once             sync.Once
 	client           *http.Client
 	logger           *log.Entry
+	templated        bool
+	zstd             *zstd.Encoder
+	stopLock         sync.Mutex    // Protects stop
+	stop             chan struct{} // Closed by Stop()
 }
 
 type httpStats struct {
@@ -71,7 +101,17 @@ type httpStats struct {
 	Sent              uint64         // Metrics successfully sent.
 	Errors            uint64         // Generic error cases in the module, such as failing to initialize or marshal/unmarshal data.
 	RequestErrors     uint64         // Errors during requests, such as not being able to connect to a remote host.
+	Retries           uint64         // Requests retried.
 	HttpResponseError map[int]uint64 // Error response codes from HTTP requests. Basically anything != 2XX.
+	lock              sync.Mutex     // Protects HttpResponseError
+}
+
+// httpGroup is the metrics of a container going to the same URL, with
+// the same headers.
+type httpGroup struct {
+	url     string
+	headers map[string]string
+	metrics []*skogul.Metric
 }
 
 // getCertPool reads the file specified in f and returns a CertPool with
@@ -121,10 +161,19 @@ func (ht *HTTP) init() {
 	if ht.Encoder.Name == "" {
 		ht.Encoder.E = encoder.JSON{}
 	}
-	ht.logger = skogul.Logger("sender", "http").WithField("name", skogul.Identity[ht])
+	ht.logger = skogul.Logger("sender", "http").WithField("name", skogul.IdentityOf(ht))
 	if ht.Timeout.Duration == 0 {
 		ht.Timeout.Duration = 20 * time.Second
 	}
+	if ht.Method == "" {
+		ht.Method = "POST"
+	}
+	if ht.RetryDelay.Duration == 0 {
+		ht.RetryDelay.Duration = time.Second
+	}
+	if ht.MaxRetryDelay.Duration == 0 {
+		ht.MaxRetryDelay.Duration = time.Minute
+	}
 	if ht.Insecure {
 		ht.logger.Warning("Disabling certificate validation for HTTP sender - vulnerable to man-in-the-middle")
 	}
@@ -183,6 +232,23 @@ func (ht *HTTP) init() {
 	}
 	ht.Headers[http.CanonicalHeaderKey("content-type")] = contentTypeHeaderVal
 
+	if ht.Compression != "" {
+		ht.Headers[http.CanonicalHeaderKey("content-encoding")] = ht.Compression
+	}
+	if ht.Compression == "zstd" {
+		ht.zstd, err = zstd.NewWriter(nil)
+		if err != nil {
+			ht.logger.WithError(err).Error("Failed to initialize zstd encoder")
+			return
+		}
+	}
+	ht.templated = strings.Contains(ht.URL, httpVariable)
+	for _, val := range ht.Headers {
+		if strings.Contains(val, httpVariable) {
+			ht.templated = true
+		}
+	}
+
 	ht.client = &http.Client{Transport: &tran, Timeout: ht.Timeout.Duration}
 
 	ht.initStats()
@@ -206,43 +272,251 @@ func (ht *HTTP) initStats() {
 // reuse the HTTP sender options without having
 // to re-implement them.
 func (ht *HTTP) sendBytes(b []byte) error {
+	return ht.sendRequest(ht.URL, ht.Headers, b)
+}
+
+// compress compresses b according to Compression.
+func (ht *HTTP) compress(b []byte) ([]byte, error) {
+	switch ht.Compression {
+	case "gzip":
+		var buffer bytes.Buffer
+		w := gzip.NewWriter(&buffer)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case "zstd":
+		return ht.zstd.EncodeAll(b, make([]byte, 0, len(b)/2)), nil
+	}
+	return b, nil
+}
+
+// sendRequest sends b to target, retrying if needed.
+func (ht *HTTP) sendRequest(target string, headers map[string]string, b []byte) error {
 	if !ht.ok {
 		return fmt.Errorf("HTTP sender not in OK state")
 	}
 
-	var buffer bytes.Buffer
-	buffer.Write(b)
 	ht.once.Do(func() {
 		ht.init()
 	})
-	req, err := http.NewRequest("POST", ht.URL, &buffer)
+	b, err := ht.compress(b)
 	if err != nil {
 		atomic.AddUint64(&ht.stats.Errors, 1)
-		return fmt.Errorf("Failed to create a HTTP request (we are %s). Error: %w", skogul.Identity[ht], err)
+		return fmt.Errorf("Failed to compress HTTP request body (we are %s). Error: %w", skogul.IdentityOf(ht), err)
+	}
+	delay := ht.RetryDelay.Duration
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := ht.do(target, headers, b)
+		if err == nil {
+			atomic.AddUint64(&ht.stats.Sent, 1)
+			return nil
+		}
+		if retryAfter < 0 || attempt >= ht.Retries {
+			return err
+		}
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > ht.MaxRetryDelay.Duration {
+			wait = ht.MaxRetryDelay.Duration
+		}
+		atomic.AddUint64(&ht.stats.Retries, 1)
+		ht.logger.WithError(err).WithField("attempt", attempt+1).Debugf("Retrying request in %v", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ht.stopped():
+			timer.Stop()
+			return fmt.Errorf("sender stopped before retrying: %w", err)
+		}
+		delay *= 2
+	}
+}
+
+// stopped returns a channel that is closed when the sender is stopped.
+func (ht *HTTP) stopped() <-chan struct{} {
+	ht.stopLock.Lock()
+	defer ht.stopLock.Unlock()
+	if ht.stop == nil {
+		ht.stop = make(chan struct{})
+	}
+	return ht.stop
+}
+
+// Stop interrupts requests waiting to be retried, making them fail
+// instead. Requests are not retried after Stop.
+func (ht *HTTP) Stop() error {
+	ht.stopLock.Lock()
+	defer ht.stopLock.Unlock()
+	if ht.stop == nil {
+		ht.stop = make(chan struct{})
+	}
+	select {
+	case <-ht.stop:
+	default:
+		close(ht.stop)
+	}
+	return nil
+}
+
+// retryAfter returns how long the Retry-After header of resp says to wait,
+// or 0 if it isn't set.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
 	}
-	for header, value := range ht.Headers {
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// do sends a single request. If it fails, it also returns whether it
+// should be retried: -1 means no, otherwise it is how long the target
+// asked us to wait, or 0 if it didn't say.
+func (ht *HTTP) do(target string, headers map[string]string, b []byte) (time.Duration, error) {
+	req, err := http.NewRequest(ht.Method, target, bytes.NewReader(b))
+	if err != nil {
+		atomic.AddUint64(&ht.stats.Errors, 1)
+		return -1, fmt.Errorf("Failed to create a HTTP request (we are %s). Error: %w", skogul.IdentityOf(ht), err)
+	}
+	for header, value := range headers {
 		req.Header.Add(http.CanonicalHeaderKey(header), value)
 	}
 	resp, err := ht.client.Do(req)
 	if err != nil {
 		atomic.AddUint64(&ht.stats.RequestErrors, 1)
-		return fmt.Errorf("Unable to POST request (we are %s). Error: %w", skogul.Identity[ht], err)
-	}
-	if resp.ContentLength > 0 {
-		tmp := make([]byte, resp.ContentLength)
-		if n, err := io.ReadFull(resp.Body, tmp); err != nil {
-			atomic.AddUint64(&ht.stats.Errors, 1)
-			resp.Body.Close()
-			return fmt.Errorf("Failed to read HTTP response body, ContentLength said %d, got %d. Error: %w", resp.ContentLength, n, err)
-		}
+		return 0, fmt.Errorf("Unable to %s request (we are %s). Error: %w", ht.Method, skogul.IdentityOf(ht), err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		atomic.AddUint64(&ht.stats.Errors, 1)
+		return -1, fmt.Errorf("Failed to read HTTP response body. Error: %w", err)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		httpResponseCodeStats := ht.stats.HttpResponseError[resp.StatusCode]
-		atomic.AddUint64(&httpResponseCodeStats, 1)
-		return fmt.Errorf("non-OK status code from target: %d / %s", resp.StatusCode, resp.Status)
+		ht.stats.lock.Lock()
+		ht.stats.HttpResponseError[resp.StatusCode]++
+		ht.stats.lock.Unlock()
+		err := fmt.Errorf("non-OK status code from target: %d / %s", resp.StatusCode, resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return retryAfter(resp), err
+		}
+		return -1, err
 	}
-	atomic.AddUint64(&ht.stats.Sent, 1)
-	return nil
+	return 0, nil
+}
+
+// httpVariable is the start of a reference to metadata in the URL or
+// headers, ${metadata.KEY}.
+const httpVariable = "${metadata."
+
+// httpTemplate replaces each ${metadata.KEY} in s with what value returns
+// for KEY. Other uses of $ are left alone.
+func httpTemplate(s string, value func(key string) (string, error)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, httpVariable)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i+len(httpVariable):]
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", fmt.Errorf("missing } after %s", httpVariable)
+		}
+		if end == 0 {
+			return "", fmt.Errorf("empty metadata key in %s}", httpVariable)
+		}
+		v, err := value(s[:end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+		s = s[end+1:]
+	}
+}
+
+// httpExpand expands ${metadata.KEY} in s using the metadata of m, or of
+// the template of the container. Values are escaped for use in a URL path
+// if escape is set.
+func httpExpand(s string, m *skogul.Metric, template *skogul.Metric, escape bool) (string, error) {
+	return httpTemplate(s, func(key string) (string, error) {
+		v, ok := m.Metadata[key]
+		if !ok && template != nil {
+			v, ok = template.Metadata[key]
+		}
+		if !ok {
+			return "", fmt.Errorf("metadata field %q is missing", key)
+		}
+		if escape {
+			return url.PathEscape(fmt.Sprint(v)), nil
+		}
+		return fmt.Sprint(v), nil
+	})
+}
+
+// httpCheckTemplate checks that the references to metadata in s are
+// complete.
+func httpCheckTemplate(s string) error {
+	_, err := httpTemplate(s, func(key string) (string, error) {
+		return "", nil
+	})
+	return err
+}
+
+// groups splits the metrics of c by URL and headers.
+func (ht *HTTP) groups(c *skogul.Container) ([]*httpGroup, error) {
+	groups := []*httpGroup{}
+	byKey := make(map[string]*httpGroup)
+	var errs []error
+	for _, m := range c.Metrics {
+		u, err := httpExpand(ht.URL, m, c.Template, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var key strings.Builder
+		key.WriteString(u)
+		headers := make(map[string]string, len(ht.Headers))
+		names := make([]string, 0, len(ht.Headers))
+		for header := range ht.Headers {
+			names = append(names, header)
+		}
+		sort.Strings(names)
+		for _, header := range names {
+			headers[header], err = httpExpand(ht.Headers[header], m, c.Template, false)
+			if err != nil {
+				break
+			}
+			fmt.Fprintf(&key, "\xff%s\xff%s", header, headers[header])
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		g := byKey[key.String()]
+		if g == nil {
+			g = &httpGroup{url: u, headers: headers}
+			byKey[key.String()] = g
+			groups = append(groups, g)
+		}
+		g.metrics = append(g.metrics, m)
+	}
+	if len(errs) > 0 {
+		return groups, fmt.Errorf("unable to expand URL or headers for %d metrics, first error: %w", len(errs), errs[0])
+	}
+	return groups, nil
 }
 
 // Send POSTS data
@@ -262,14 +536,38 @@ func (ht *HTTP) Send(c *skogul.Container) error {
 		return fmt.Errorf("initialization failed")
 	}
 
+	if !ht.templated {
+		return ht.send(c, ht.URL, ht.Headers)
+	}
+	groups, expandErr := ht.groups(c)
+	if expandErr != nil {
+		atomic.AddUint64(&ht.stats.Errors, 1)
+	}
+	var errs []error
+	if expandErr != nil {
+		errs = append(errs, fmt.Errorf("HTTP sender (%s) %w", skogul.IdentityOf(ht), expandErr))
+	}
+	// Send the other groups even if one fails, so one target that is
+	// down doesn't affect the others.
+	for _, g := range groups {
+		sub := skogul.Container{Template: c.Template, Metrics: g.metrics}
+		if err := ht.send(&sub, g.url, g.headers); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send encodes and sends c to target.
+func (ht *HTTP) send(c *skogul.Container, target string, headers map[string]string) error {
 	b, err := ht.Encoder.E.Encode(c)
 	if err != nil {
 		atomic.AddUint64(&ht.stats.Errors, 1)
-		return fmt.Errorf("HTTP sender (%s) was unable to encode metric-data. Error: %w", skogul.Identity[ht], err)
+		return fmt.Errorf("HTTP sender (%s) was unable to encode metric-data. Error: %w", skogul.IdentityOf(ht), err)
 	}
-	err = ht.sendBytes(b)
+	err = ht.sendRequest(target, headers, b)
 	if err != nil {
-		return fmt.Errorf("HTTP sender (%s) was unable to send %d bytes. Container(%s).  Error: %w", skogul.Identity[ht], len(b), c.Describe(), err)
+		return fmt.Errorf("HTTP sender (%s) was unable to send %d bytes. Container(%s).  Error: %w", skogul.IdentityOf(ht), len(b), c.Describe(), err)
 	}
 	return nil
 }
@@ -286,6 +584,26 @@ func (ht *HTTP) Verify() error {
 	if (ht.Certfile != "" && ht.Keyfile == "") || (ht.Certfile == "" && ht.Keyfile != "") {
 		return fmt.Errorf("either provide BOTH Certfile AND Keyfile, or neither.")
 	}
+	if ht.Compression != "" && ht.Compression != "gzip" && ht.Compression != "zstd" {
+		return fmt.Errorf("invalid Compression %q, must be gzip or zstd", ht.Compression)
+	}
+	if ht.Method != "" && strings.ToUpper(ht.Method) != ht.Method {
+		return fmt.Errorf("invalid Method %q, must be upper case, e.g. POST or PUT", ht.Method)
+	}
+	if ht.Retries < 0 {
+		return fmt.Errorf("Retries can't be negative")
+	}
+	if ht.RetryDelay.Duration < 0 || ht.MaxRetryDelay.Duration < 0 {
+		return fmt.Errorf("RetryDelay and MaxRetryDelay can't be negative")
+	}
+	if err := httpCheckTemplate(ht.URL); err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	for header, val := range ht.Headers {
+		if err := httpCheckTemplate(val); err != nil {
+			return fmt.Errorf("invalid value of header %s: %w", header, err)
+		}
+	}
 	return nil
 }
 
@@ -300,7 +618,7 @@ func (ht *HTTP) GetStats() *skogul.Metric {
 	}
 	metric.Metadata["component"] = "sender"
 	metric.Metadata["type"] = "HTTP"
-	metric.Metadata["identity"] = skogul.Identity[ht]
+	metric.Metadata["identity"] = skogul.IdentityOf(ht)
 
 	if !ht.ok {
 		return &metric
@@ -309,8 +627,11 @@ func (ht *HTTP) GetStats() *skogul.Metric {
 	metric.Data["sent"] = ht.stats.Sent
 	metric.Data["errors"] = ht.stats.Errors
 	metric.Data["request_errors"] = ht.stats.RequestErrors
+	metric.Data["retries"] = atomic.LoadUint64(&ht.stats.Retries)
+	ht.stats.lock.Lock()
 	for key, val := range ht.stats.HttpResponseError {
 		metric.Data[fmt.Sprintf("http_response_%d", key)] = val
 	}
+	ht.stats.lock.Unlock()
 	return &metric
 }
@@ -27,34 +27,71 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
 	kafka "github.com/segmentio/kafka-go"
 	kplain "github.com/segmentio/kafka-go/sasl/plain"
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/encoder"
-	"sync"
 )
 
 var kafkaLog = skogul.Logger("sender", "kafka")
 
 /*
-Kafka sender is a MVP-variant, and further features are reasonable and expected, including but not limited to:
+Kafka sender writes data to a Kafka topic. By default, each metric is
+encoded and sent as a separate message, but the whole container can be
+sent as a single message instead.
+
+Messages can be given a key built from metadata fields, in which case
+the partition is chosen by hashing the key, so e.g. all metrics from the
+same device end up in the same partition, in order. Without a key,
+messages are spread evenly over all partitions.
+
+In async mode, Send() returns before the message is delivered, so
+delivery errors are only logged and counted in the stats. In sync mode,
+Send() waits for delivery and returns the error.
+
+Further features are reasonable and expected, including but not limited to:
 
-- Authentication (coming before release)
 - Better control of batching, probably
-- Dynamic keys from metadata
 - Adjustment of various timeouts
 */
 type Kafka struct {
-	Topic    string `doc:"Topic to write to."`
-	Sync     bool   `doc:"Synchronous or not. By default, the sender is async."`
-	Address  string `doc:"Address for the broker."`
-	ClientID string `doc:"ClientID to use - uses lower-case skogul by default."`
-	TLS      bool   `doc:"Enable TLS, off by default."`
-	Username string `doc:"Username for SASL auth."`
-	Password string `doc:"Password for SASL auth."`
-	Encoder  skogul.EncoderRef
-	w        *kafka.Writer
-	once     sync.Once
+	Topic        string            `doc:"Topic to write to."`
+	Sync         bool              `doc:"Synchronous or not. By default, the sender is async."`
+	Brokers      []string          `doc:"Addresses of the brokers. Only one needs to be available, the rest of the cluster is discovered from it."`
+	Address      string            `doc:"Address for the broker. Deprecated, use Brokers."`
+	ClientID     string            `doc:"ClientID to use - uses lower-case skogul by default."`
+	TLS          bool              `doc:"Enable TLS, off by default."`
+	Username     string            `doc:"Username for SASL auth."`
+	Password     string            `doc:"Password for SASL auth."`
+	Encoder      skogul.EncoderRef `doc:"Encoder to use. Defaults to JSON."`
+	Container    bool              `doc:"Send the entire container as a single message instead of one message per metric. Key and headers are then taken from the first metric."`
+	KeyFields    []string          `doc:"Metadata fields to build the message key from, separated by slashes. Messages with the same key are written to the same partition. Missing fields are left out, and metrics without any of them are sent without key."`
+	HeaderFields []string          `doc:"Metadata fields to add as message headers."`
+	Compression  string            `doc:"Compression to use: gzip, snappy, lz4 or zstd. Defaults to none."`
+	w            *kafka.Writer
+	once         sync.Once
+	stats        kafkaStats
+	lock         sync.Mutex
+	idle         *sync.Cond // Signalled when pending drops to 0
+	pending      int        // Messages written in async mode, not yet delivered
+}
+
+// kafkaStats contains the internal stats of the Kafka sender
+type kafkaStats struct {
+	Received uint64 // Containers received.
+	Sent     uint64 // Messages delivered.
+	Errors   uint64 // Messages not delivered, or not encoded.
+}
+
+var kafkaCompression = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
 }
 
 func (k *Kafka) init() {
@@ -71,11 +108,22 @@ func (k *Kafka) init() {
 	 * spot. So the effect here is that sync: true with batchsize: 1
 	 * means we get errors, but we never block longer than needed.
 	 */
+	brokers := k.Brokers
+	if k.Address != "" {
+		brokers = append([]string{k.Address}, brokers...)
+	}
 	k.w = &kafka.Writer{
-		Addr:      kafka.TCP(k.Address),
-		Topic:     k.Topic,
-		Async:     !k.Sync,
-		BatchSize: 1,
+		Addr:        kafka.TCP(brokers...),
+		Topic:       k.Topic,
+		Async:       !k.Sync,
+		BatchSize:   1,
+		Compression: kafkaCompression[k.Compression],
+		// Hash falls back to round robin for messages without key.
+		Balancer: &kafka.Hash{},
+	}
+	k.idle = sync.NewCond(&k.lock)
+	if !k.Sync {
+		k.w.Completion = k.completion
 	}
 	transport := kafka.Transport{}
 	if k.ClientID == "" {
@@ -110,21 +158,161 @@ func (k *Kafka) init() {
 	}
 }
 
+// completion is called by the writer when messages are delivered, or
+// delivery failed, in async mode.
+func (k *Kafka) completion(messages []kafka.Message, err error) {
+	k.track(-len(messages))
+	if err == nil {
+		atomic.AddUint64(&k.stats.Sent, uint64(len(messages)))
+		return
+	}
+	atomic.AddUint64(&k.stats.Errors, uint64(len(messages)))
+	kafkaLog.WithError(err).WithField("messages", len(messages)).Warn("Unable to deliver messages to Kafka")
+}
+
+// track adjusts the number of messages pending delivery by n.
+func (k *Kafka) track(n int) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.pending += n
+	if k.pending == 0 {
+		k.idle.Broadcast()
+	}
+}
+
+// message creates a message with key and headers from the metadata of m.
+func (k *Kafka) message(m *skogul.Metric, value []byte) kafka.Message {
+	km := kafka.Message{
+		Value: value,
+	}
+	key := make([]string, 0, len(k.KeyFields))
+	for _, f := range k.KeyFields {
+		if v, ok := m.Metadata[f]; ok {
+			key = append(key, fmt.Sprint(v))
+		}
+	}
+	if len(key) > 0 {
+		km.Key = []byte(strings.Join(key, "/"))
+	}
+	for _, f := range k.HeaderFields {
+		if v, ok := m.Metadata[f]; ok {
+			km.Headers = append(km.Headers, kafka.Header{Key: f, Value: []byte(fmt.Sprint(v))})
+		}
+	}
+	return km
+}
+
 func (k *Kafka) Send(c *skogul.Container) error {
 	k.once.Do(func() {
 		k.init()
 	})
-	messages := make([]kafka.Message, 0, len(c.Metrics))
-	for _, m := range c.Metrics {
-		b, err := k.Encoder.E.EncodeMetric(m)
+	atomic.AddUint64(&k.stats.Received, 1)
+	var messages []kafka.Message
+	if k.Container {
+		b, err := k.Encoder.E.Encode(c)
 		if err != nil {
-			return fmt.Errorf("couldn't encode metric: %w", err)
+			atomic.AddUint64(&k.stats.Errors, 1)
+			return fmt.Errorf("couldn't encode container: %w", err)
+		}
+		m := &skogul.Metric{}
+		if len(c.Metrics) > 0 {
+			m = c.Metrics[0]
 		}
-		km := kafka.Message{
-			Value: b,
+		messages = []kafka.Message{k.message(m, b)}
+	} else {
+		messages = make([]kafka.Message, 0, len(c.Metrics))
+		for _, m := range c.Metrics {
+			b, err := k.Encoder.E.EncodeMetric(m)
+			if err != nil {
+				atomic.AddUint64(&k.stats.Errors, 1)
+				return fmt.Errorf("couldn't encode metric: %w", err)
+			}
+			messages = append(messages, k.message(m, b))
 		}
-		messages = append(messages, km)
+	}
+	if !k.Sync {
+		k.track(len(messages))
 	}
 	err := k.w.WriteMessages(context.Background(), messages...)
-	return err
+	if !k.Sync {
+		if err != nil {
+			// Not queued, so completion won't be called
+			k.track(-len(messages))
+			atomic.AddUint64(&k.stats.Errors, uint64(len(messages)))
+		}
+		return err
+	}
+	if err != nil {
+		failed := len(messages)
+		if werr, ok := err.(kafka.WriteErrors); ok {
+			failed = werr.Count()
+		}
+		atomic.AddUint64(&k.stats.Errors, uint64(failed))
+		atomic.AddUint64(&k.stats.Sent, uint64(len(messages)-failed))
+		return fmt.Errorf("unable to deliver %d of %d messages to Kafka: %w", failed, len(messages), err)
+	}
+	atomic.AddUint64(&k.stats.Sent, uint64(len(messages)))
+	return nil
+}
+
+// Flush waits for messages written in async mode to be delivered, or
+// fail. The sender can still be used afterwards.
+func (k *Kafka) Flush() error {
+	k.once.Do(func() {
+		k.init()
+	})
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for k.pending > 0 {
+		k.idle.Wait()
+	}
+	return nil
+}
+
+// Stop closes the connections to the brokers, after delivering any
+// pending messages.
+func (k *Kafka) Stop() error {
+	k.once.Do(func() {
+		k.init()
+	})
+	return k.w.Close()
+}
+
+func (k *Kafka) Deprecated() error {
+	if k.Address != "" {
+		return fmt.Errorf("config option Address is replaced by option Brokers, Address will be removed in future versions.")
+	}
+	return nil
+}
+
+// Verify checks that the configuration options are set appropriately
+func (k *Kafka) Verify() error {
+	if k.Topic == "" {
+		return skogul.MissingArgument("Topic")
+	}
+	if k.Address == "" && len(k.Brokers) == 0 {
+		return skogul.MissingArgument("Brokers")
+	}
+	if _, ok := kafkaCompression[k.Compression]; k.Compression != "" && !ok {
+		return fmt.Errorf("unknown Compression %q, must be gzip, snappy, lz4 or zstd", k.Compression)
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats
+// for the Kafka sender.
+func (k *Kafka) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "sender"
+	metric.Metadata["type"] = "kafka"
+	metric.Metadata["identity"] = skogul.IdentityOf(k)
+	metric.Data["received"] = atomic.LoadUint64(&k.stats.Received)
+	metric.Data["sent"] = atomic.LoadUint64(&k.stats.Sent)
+	metric.Data["errors"] = atomic.LoadUint64(&k.stats.Errors)
+	return &metric
 }
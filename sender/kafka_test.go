@@ -0,0 +1,115 @@
+/*
+ * skogul, kafka sender tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+func TestKafkaMessage(t *testing.T) {
+	k := Kafka{
+		KeyFields:    []string{"site", "device"},
+		HeaderFields: []string{"device", "missing"},
+	}
+	m := skogul.Metric{
+		Metadata: map[string]interface{}{"site": "osl", "device": 42},
+	}
+	km := k.message(&m, []byte("value"))
+	if string(km.Key) != "osl/42" {
+		t.Errorf("Expected key osl/42, got %q", km.Key)
+	}
+	if len(km.Headers) != 1 || km.Headers[0].Key != "device" || string(km.Headers[0].Value) != "42" {
+		t.Errorf("Unexpected headers: %v", km.Headers)
+	}
+
+	k.KeyFields = []string{"missing", "device"}
+	if km = k.message(&m, []byte("value")); string(km.Key) != "42" {
+		t.Errorf("Expected key 42 with missing field left out, got %q", km.Key)
+	}
+	k.KeyFields = []string{"missing"}
+	if km = k.message(&m, []byte("value")); km.Key != nil {
+		t.Errorf("Expected no key without any key field, got %q", km.Key)
+	}
+
+	k = Kafka{}
+	km = k.message(&m, []byte("value"))
+	if km.Key != nil || km.Headers != nil {
+		t.Errorf("Expected neither key nor headers, got %v", km)
+	}
+}
+
+func TestKafkaVerify(t *testing.T) {
+	k := Kafka{Topic: "t", Brokers: []string{"a:9092", "b:9092"}, Compression: "zstd"}
+	if err := k.Verify(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	k.Compression = "bzip2"
+	if err := k.Verify(); err == nil {
+		t.Errorf("Expected error for invalid compression")
+	}
+	k = Kafka{Topic: "t"}
+	if err := k.Verify(); err == nil {
+		t.Errorf("Expected error for missing brokers")
+	}
+	k = Kafka{Topic: "t", Address: "a:9092"}
+	if err := k.Verify(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := k.Deprecated(); err == nil {
+		t.Errorf("Expected Address to be deprecated")
+	}
+}
+
+// TestKafkaFlush checks that Flush waits for pending messages, without
+// closing the sender.
+func TestKafkaFlush(t *testing.T) {
+	k := Kafka{Topic: "t", Brokers: []string{"localhost:1"}}
+	if err := k.Flush(); err != nil {
+		t.Errorf("Flush() without messages failed: %v", err)
+	}
+	now := time.Now()
+	c := skogul.Container{Metrics: []*skogul.Metric{{Time: &now, Data: map[string]interface{}{"x": 1}}}}
+	// The broker is unreachable, but the writer must not be closed
+	if err := k.Send(&c); errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Send() after Flush() failed: %v", err)
+	}
+	if err := k.Flush(); err != nil {
+		t.Errorf("Flush() failed: %v", err)
+	}
+	k.lock.Lock()
+	pending := k.pending
+	k.lock.Unlock()
+	if pending != 0 {
+		t.Errorf("Flush() returned with %d messages pending", pending)
+	}
+	if k.stats.Errors != 1 {
+		t.Errorf("Expected the undeliverable message to be counted as an error, got %d", k.stats.Errors)
+	}
+	if err := k.Stop(); err != nil {
+		t.Errorf("Stop() failed: %v", err)
+	}
+}
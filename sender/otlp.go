@@ -0,0 +1,554 @@
+/*
+ * skogul, OpenTelemetry OTLP metrics sender
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+var otlpLog = skogul.Logger("sender", "otlp")
+
+/*
+OTLP sends metrics to an OpenTelemetry collector, or anything else
+accepting OTLP, using either OTLP/HTTP with protobuf encoding or
+OTLP/gRPC.
+
+The mapping is the reverse of the otlp parser, so data parsed by it is
+sent on unchanged. The metadata fields "type", "unit", "temporality",
+"monotonic", "otel.scope.name" and "otel.scope.version" are used to
+describe the metric, the fields listed in ResourceFields become resource
+attributes, and the remaining metadata fields become data point
+attributes.
+
+Histograms and summaries, as produced by the otlp parser, are sent as
+such. For all other metrics, every numeric data field becomes a data
+point. The name of the OTLP metric is the value of the NameField metadata
+field, followed by a dot and the data field name, unless the data field
+is ValueField. If there is no NameField, just the data field name is
+used. Data points are gauges, unless the metric type is "sum" or the data
+field is listed in Counters, in which case they are sums.
+*/
+type OTLP struct {
+	URL            string            `doc:"Where to send data. For OTLP/HTTP, the full URL, normally ending in /v1/metrics. For OTLP/gRPC, host and port." example:"http://collector:4318/v1/metrics collector:4317"`
+	Protocol       string            `doc:"Protocol to use, http or grpc. Defaults to http."`
+	TLS            bool              `doc:"Use TLS for OTLP/gRPC. For OTLP/HTTP, TLS is used if the URL starts with https."`
+	Insecure       bool              `doc:"Disable TLS certificate validation."`
+	RootCA         string            `doc:"Path to an alternate root CA used to verify server certificates. Leave blank to use system defaults."`
+	Headers        map[string]string `doc:"HTTP headers, or gRPC metadata, added to every request, e.g. for authentication."`
+	Timeout        skogul.Duration   `doc:"Timeout for each request. Defaults to 10s."`
+	NameField      string            `doc:"Metadata field with the metric name. Defaults to \"name\"."`
+	ValueField     string            `doc:"Data field that is sent using just the name from NameField. Defaults to \"value\"."`
+	ResourceFields []string          `doc:"Metadata fields that are resource attributes, e.g. service.name or host.name. Other metadata fields become data point attributes."`
+	Counters       []string          `doc:"Data fields that are monotonic cumulative counters, sent as sums instead of gauges."`
+	once           sync.Once
+	client         *http.Client
+	conn           *grpc.ClientConn
+	grpcClient     colmetricspb.MetricsServiceClient
+	initErr        error
+	counters       map[string]bool
+	resource       map[string]bool
+	stats          otlpStats
+}
+
+type otlpStats struct {
+	Received   uint64 // Containers received
+	DataPoints uint64 // Data points sent
+	Skipped    uint64 // Data fields that couldn't be sent, e.g. because they aren't numeric
+	Errors     uint64 // Failed requests
+}
+
+// otlpDescription is the metadata describing a metric, as opposed to
+// attributes.
+var otlpDescription = map[string]bool{
+	"type":               true,
+	"unit":               true,
+	"temporality":        true,
+	"monotonic":          true,
+	"otel.scope.name":    true,
+	"otel.scope.version": true,
+}
+
+func (o *OTLP) init() {
+	if o.Protocol == "" {
+		o.Protocol = "http"
+	}
+	if o.NameField == "" {
+		o.NameField = "name"
+	}
+	if o.ValueField == "" {
+		o.ValueField = "value"
+	}
+	if o.Timeout.Duration == 0 {
+		o.Timeout.Duration = 10 * time.Second
+	}
+	o.counters = make(map[string]bool)
+	for _, f := range o.Counters {
+		o.counters[f] = true
+	}
+	o.resource = make(map[string]bool)
+	for _, f := range o.ResourceFields {
+		o.resource[f] = true
+	}
+	cp, err := getCertPool(o.RootCA)
+	if err != nil {
+		o.initErr = err
+		return
+	}
+	conf := &tls.Config{InsecureSkipVerify: o.Insecure, RootCAs: cp}
+	if o.Protocol == "grpc" {
+		creds := insecure.NewCredentials()
+		if o.TLS {
+			creds = credentials.NewTLS(conf)
+		}
+		o.conn, o.initErr = grpc.NewClient(o.URL, grpc.WithTransportCredentials(creds))
+		if o.initErr == nil {
+			o.grpcClient = colmetricspb.NewMetricsServiceClient(o.conn)
+		}
+		return
+	}
+	o.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: conf},
+		Timeout:   o.Timeout.Duration,
+	}
+}
+
+// otlpMetricKey identifies a metric within a scope.
+type otlpMetricKey struct {
+	name, kind, unit, temporality string
+	monotonic                     bool
+}
+
+// otlpBuilder collects data points into an export request.
+type otlpBuilder struct {
+	req       colmetricspb.ExportMetricsServiceRequest
+	scopes    map[string]*metricspb.ScopeMetrics
+	metrics   map[*metricspb.ScopeMetrics]map[otlpMetricKey]*metricspb.Metric
+	resources map[string]*metricspb.ResourceMetrics
+}
+
+// scope returns the scope metrics for the given resource attributes and
+// scope.
+func (b *otlpBuilder) scope(resource []*commonpb.KeyValue, name, version string) *metricspb.ScopeMetrics {
+	var key strings.Builder
+	for _, kv := range resource {
+		fmt.Fprintf(&key, "%s\xff%v\xfe", kv.Key, kv.Value)
+	}
+	rkey := key.String()
+	fmt.Fprintf(&key, "\xfd%s\xff%s", name, version)
+	if sm := b.scopes[key.String()]; sm != nil {
+		return sm
+	}
+	rm := b.resources[rkey]
+	if rm == nil {
+		rm = &metricspb.ResourceMetrics{Resource: &resourcepb.Resource{Attributes: resource}}
+		b.resources[rkey] = rm
+		b.req.ResourceMetrics = append(b.req.ResourceMetrics, rm)
+	}
+	sm := &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: name, Version: version}}
+	rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+	b.scopes[key.String()] = sm
+	b.metrics[sm] = make(map[otlpMetricKey]*metricspb.Metric)
+	return sm
+}
+
+// metric returns the metric with the given key in the scope, creating
+// it if needed.
+func (b *otlpBuilder) metric(sm *metricspb.ScopeMetrics, key otlpMetricKey) *metricspb.Metric {
+	if m := b.metrics[sm][key]; m != nil {
+		return m
+	}
+	m := &metricspb.Metric{Name: key.name, Unit: key.unit}
+	temporality := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	if key.temporality == "delta" {
+		temporality = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	}
+	switch key.kind {
+	case "sum":
+		m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{AggregationTemporality: temporality, IsMonotonic: key.monotonic}}
+	case "histogram":
+		m.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{AggregationTemporality: temporality}}
+	case "summary":
+		m.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+	default:
+		m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	}
+	sm.Metrics = append(sm.Metrics, m)
+	b.metrics[sm][key] = m
+	return m
+}
+
+// request converts the container to an export request.
+func (o *OTLP) request(c *skogul.Container) *colmetricspb.ExportMetricsServiceRequest {
+	b := otlpBuilder{
+		scopes:    make(map[string]*metricspb.ScopeMetrics),
+		metrics:   make(map[*metricspb.ScopeMetrics]map[otlpMetricKey]*metricspb.Metric),
+		resources: make(map[string]*metricspb.ResourceMetrics),
+	}
+	now := time.Now()
+	for _, m := range c.Metrics {
+		var resource, attrs []*commonpb.KeyValue
+		keys := make([]string, 0, len(m.Metadata))
+		for k := range m.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == o.NameField || otlpDescription[k] {
+				continue
+			}
+			kv := &commonpb.KeyValue{Key: k, Value: otlpAnyValue(m.Metadata[k])}
+			if o.resource[k] {
+				resource = append(resource, kv)
+			} else {
+				attrs = append(attrs, kv)
+			}
+		}
+		scopeName, _ := m.Metadata["otel.scope.name"].(string)
+		if scopeName == "" {
+			scopeName = "skogul"
+		}
+		scopeVersion, _ := m.Metadata["otel.scope.version"].(string)
+		sm := b.scope(resource, scopeName, scopeVersion)
+
+		ts := uint64(now.UnixNano())
+		if m.Time != nil {
+			ts = uint64(m.Time.UnixNano())
+		}
+		name := ""
+		if m.Metadata[o.NameField] != nil {
+			name = fmt.Sprint(m.Metadata[o.NameField])
+		}
+		key := otlpMetricKey{name: name}
+		key.kind, _ = m.Metadata["type"].(string)
+		key.unit, _ = m.Metadata["unit"].(string)
+		key.temporality, _ = m.Metadata["temporality"].(string)
+
+		switch key.kind {
+		case "histogram":
+			if dp := otlpHistogramPoint(m.Data); dp != nil && name != "" {
+				dp.Attributes, dp.TimeUnixNano = attrs, ts
+				h := b.metric(sm, key).GetHistogram()
+				h.DataPoints = append(h.DataPoints, dp)
+				atomic.AddUint64(&o.stats.DataPoints, 1)
+				continue
+			}
+		case "summary":
+			if dp := otlpSummaryPoint(m.Data); dp != nil && name != "" {
+				dp.Attributes, dp.TimeUnixNano = attrs, ts
+				s := b.metric(sm, key).GetSummary()
+				s.DataPoints = append(s.DataPoints, dp)
+				atomic.AddUint64(&o.stats.DataPoints, 1)
+				continue
+			}
+		}
+		monotonic, isBool := m.Metadata["monotonic"].(bool)
+		for field, v := range m.Data {
+			dp := &metricspb.NumberDataPoint{Attributes: attrs, TimeUnixNano: ts}
+			switch n := v.(type) {
+			case int:
+				dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(n)}
+			case int32:
+				dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(n)}
+			case int64:
+				dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: n}
+			case uint32:
+				dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(n)}
+			case uint64:
+				// OTLP integers are signed, so larger values
+				// are sent as doubles instead of wrapping.
+				if n > math.MaxInt64 {
+					dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: float64(n)}
+				} else {
+					dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(n)}
+				}
+			default:
+				f, ok := promValue(v)
+				if !ok {
+					atomic.AddUint64(&o.stats.Skipped, 1)
+					continue
+				}
+				dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: f}
+			}
+			fkey := key
+			switch {
+			case name == "":
+				fkey.name = field
+			case field != o.ValueField:
+				fkey.name = name + "." + field
+			}
+			if o.counters[field] {
+				fkey.kind = "sum"
+				fkey.monotonic = true
+			} else if fkey.kind == "sum" {
+				fkey.monotonic = monotonic || !isBool
+			} else {
+				fkey.kind = "gauge"
+			}
+			if fkey.kind == "gauge" {
+				fkey.temporality = ""
+				g := b.metric(sm, fkey).GetGauge()
+				g.DataPoints = append(g.DataPoints, dp)
+			} else {
+				s := b.metric(sm, fkey).GetSum()
+				s.DataPoints = append(s.DataPoints, dp)
+			}
+			atomic.AddUint64(&o.stats.DataPoints, 1)
+		}
+	}
+	return &b.req
+}
+
+// otlpFloat returns the float value of a data field, if numeric.
+func otlpFloat(data map[string]interface{}, field string) (float64, bool) {
+	return promValue(data[field])
+}
+
+// otlpHistogramPoint converts histogram data, as produced by the otlp
+// parser, to a data point. Returns nil if it doesn't look like a
+// histogram.
+func otlpHistogramPoint(data map[string]interface{}) *metricspb.HistogramDataPoint {
+	buckets, ok := data["buckets"].(map[string]interface{})
+	count, cok := otlpFloat(data, "count")
+	if !ok || !cok {
+		return nil
+	}
+	type bucket struct {
+		bound float64
+		count uint64
+	}
+	list := make([]bucket, 0, len(buckets))
+	for k, v := range buckets {
+		bound, err := strconv.ParseFloat(k, 64)
+		n, ok := promValue(v)
+		if err != nil || !ok {
+			return nil
+		}
+		list = append(list, bucket{bound, uint64(n)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].bound < list[j].bound })
+	dp := &metricspb.HistogramDataPoint{Count: uint64(count)}
+	for i, b := range list {
+		dp.BucketCounts = append(dp.BucketCounts, b.count)
+		// The last bucket has no upper bound
+		if i < len(list)-1 {
+			dp.ExplicitBounds = append(dp.ExplicitBounds, b.bound)
+		}
+	}
+	for field, p := range map[string]**float64{"sum": &dp.Sum, "min": &dp.Min, "max": &dp.Max} {
+		if f, ok := otlpFloat(data, field); ok {
+			*p = &f
+		}
+	}
+	return dp
+}
+
+// otlpSummaryPoint converts summary data, as produced by the otlp
+// parser, to a data point. Returns nil if it doesn't look like a summary.
+func otlpSummaryPoint(data map[string]interface{}) *metricspb.SummaryDataPoint {
+	quantiles, ok := data["quantiles"].(map[string]interface{})
+	count, cok := otlpFloat(data, "count")
+	if !ok || !cok {
+		return nil
+	}
+	dp := &metricspb.SummaryDataPoint{Count: uint64(count)}
+	dp.Sum, _ = otlpFloat(data, "sum")
+	for k, v := range quantiles {
+		q, err := strconv.ParseFloat(k, 64)
+		value, ok := promValue(v)
+		if err != nil || !ok {
+			return nil
+		}
+		dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{Quantile: q, Value: value})
+	}
+	sort.Slice(dp.QuantileValues, func(i, j int) bool { return dp.QuantileValues[i].Quantile < dp.QuantileValues[j].Quantile })
+	return dp
+}
+
+// otlpAnyValue converts a metadata value to an attribute value.
+func otlpAnyValue(v interface{}) *commonpb.AnyValue {
+	switch val := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: val}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: val}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(val)}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: val}}
+	case uint64:
+		if val > math.MaxInt64 {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: float64(val)}}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(val)}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: val}}
+	case []byte:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: val}}
+	case []interface{}:
+		list := &commonpb.ArrayValue{}
+		for _, e := range val {
+			list.Values = append(list.Values, otlpAnyValue(e))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: list}}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := &commonpb.KeyValueList{}
+		for _, k := range keys {
+			kvs.Values = append(kvs.Values, &commonpb.KeyValue{Key: k, Value: otlpAnyValue(val[k])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: kvs}}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+}
+
+// Send converts the container to an OTLP export request and sends it.
+func (o *OTLP) Send(c *skogul.Container) error {
+	o.once.Do(o.init)
+	if o.initErr != nil {
+		return fmt.Errorf("unable to initialize OTLP sender: %w", o.initErr)
+	}
+	atomic.AddUint64(&o.stats.Received, 1)
+	req := o.request(c)
+	if len(req.ResourceMetrics) == 0 {
+		return nil
+	}
+	var err error
+	if o.Protocol == "grpc" {
+		err = o.sendGRPC(req)
+	} else {
+		err = o.sendHTTP(req)
+	}
+	if err != nil {
+		atomic.AddUint64(&o.stats.Errors, 1)
+	}
+	return err
+}
+
+func (o *OTLP) sendGRPC(req *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.Timeout.Duration)
+	defer cancel()
+	for k, v := range o.Headers {
+		ctx = grpcmd.AppendToOutgoingContext(ctx, k, v)
+	}
+	resp, err := o.grpcClient.Export(ctx, req)
+	if err != nil {
+		return fmt.Errorf("OTLP export failed: %w", err)
+	}
+	if ps := resp.GetPartialSuccess(); ps != nil && ps.RejectedDataPoints > 0 {
+		otlpLog.WithField("rejected", ps.RejectedDataPoints).Warnf("Data points rejected: %s", ps.ErrorMessage)
+	}
+	return nil
+}
+
+func (o *OTLP) sendHTTP(req *colmetricspb.ExportMetricsServiceRequest) error {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("unable to marshal OTLP request: %w", err)
+	}
+	hreq, err := http.NewRequest("POST", o.URL, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range o.Headers {
+		hreq.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(hreq)
+	if err != nil {
+		return fmt.Errorf("OTLP export failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP export failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Stop closes the gRPC connection, if any.
+func (o *OTLP) Stop() error {
+	if o.conn == nil {
+		return nil
+	}
+	return o.conn.Close()
+}
+
+// GetStats returns the internal stats of the OTLP sender.
+func (o *OTLP) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "sender"
+	metric.Metadata["type"] = "OTLP"
+	metric.Metadata["identity"] = skogul.IdentityOf(o)
+	metric.Data["received"] = atomic.LoadUint64(&o.stats.Received)
+	metric.Data["datapoints"] = atomic.LoadUint64(&o.stats.DataPoints)
+	metric.Data["skipped"] = atomic.LoadUint64(&o.stats.Skipped)
+	metric.Data["errors"] = atomic.LoadUint64(&o.stats.Errors)
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (o *OTLP) Verify() error {
+	if o.URL == "" {
+		return skogul.MissingArgument("URL")
+	}
+	switch o.Protocol {
+	case "", "http", "grpc":
+	default:
+		return fmt.Errorf("invalid Protocol %q, must be http or grpc", o.Protocol)
+	}
+	if _, err := getCertPool(o.RootCA); err != nil {
+		return err
+	}
+	return nil
+}
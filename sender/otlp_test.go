@@ -0,0 +1,138 @@
+/*
+ * skogul, OpenTelemetry OTLP metrics sender tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender_test
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/sender"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLP(t *testing.T) {
+	reqs := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("Authorization") != "Bearer x" {
+			w.WriteHeader(400)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		req := &colmetricspb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(b, req); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		reqs <- req
+	}))
+	defer srv.Close()
+
+	s := &sender.OTLP{
+		URL:            srv.URL + "/v1/metrics",
+		Headers:        map[string]string{"Authorization": "Bearer x"},
+		ResourceFields: []string{"host.name"},
+		Counters:       []string{"packets"},
+	}
+	if err := s.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	now := time.Unix(1600000000, 0)
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host.name": "r1", "interface": "eth0"},
+			Data:     map[string]interface{}{"packets": 10, "mtu": 1500.0, "descr": "uplink", "octets": uint64(math.MaxUint64)},
+		},
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host.name": "r1", "name": "latency", "type": "histogram", "temporality": "delta"},
+			Data: map[string]interface{}{"count": uint64(3), "sum": 1.5, "buckets": map[string]interface{}{
+				"+Inf": uint64(1), "0.5": uint64(2),
+			}},
+		},
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host.name": "r2", "name": "requests", "type": "sum", "monotonic": false},
+			Data:     map[string]interface{}{"value": int64(-4)},
+		},
+	}}
+	if err := s.Send(&c); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	var req *colmetricspb.ExportMetricsServiceRequest
+	select {
+	case req = <-reqs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No request received")
+	}
+	if len(req.ResourceMetrics) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(req.ResourceMetrics))
+	}
+	rm := req.ResourceMetrics[0]
+	if attrs := rm.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "host.name" || attrs[0].Value.GetStringValue() != "r1" {
+		t.Errorf("Unexpected resource attributes: %v", attrs)
+	}
+	metrics := rm.ScopeMetrics[0].Metrics
+	if rm.ScopeMetrics[0].Scope.Name != "skogul" || len(metrics) != 4 {
+		t.Fatalf("Expected 4 metrics in the skogul scope, got %v", rm.ScopeMetrics[0])
+	}
+	byName := make(map[string]int)
+	for i, m := range metrics {
+		byName[m.Name] = i
+	}
+	if sum := metrics[byName["packets"]].GetSum(); sum == nil || !sum.IsMonotonic || sum.DataPoints[0].GetAsInt() != 10 {
+		t.Errorf("Expected packets as monotonic sum, got %v", metrics[byName["packets"]])
+	} else if attrs := sum.DataPoints[0].Attributes; len(attrs) != 1 || attrs[0].Key != "interface" {
+		t.Errorf("Unexpected data point attributes: %v", attrs)
+	}
+	if g := metrics[byName["mtu"]].GetGauge(); g == nil || g.DataPoints[0].GetAsDouble() != 1500 {
+		t.Errorf("Expected mtu as gauge, got %v", metrics[byName["mtu"]])
+	}
+	if g := metrics[byName["octets"]].GetGauge(); g == nil || g.DataPoints[0].GetAsDouble() != float64(math.MaxUint64) {
+		t.Errorf("Expected octets beyond int64 as double, got %v", metrics[byName["octets"]])
+	}
+	h := metrics[byName["latency"]].GetHistogram()
+	if h == nil || h.DataPoints[0].Count != 3 || len(h.DataPoints[0].ExplicitBounds) != 1 || h.DataPoints[0].BucketCounts[1] != 1 {
+		t.Errorf("Unexpected histogram: %v", metrics[byName["latency"]])
+	}
+	sum := req.ResourceMetrics[1].ScopeMetrics[0].Metrics[0]
+	if sum.Name != "requests" || sum.GetSum() == nil || sum.GetSum().IsMonotonic {
+		t.Errorf("Expected non-monotonic sum, got %v", sum)
+	}
+	if st := s.GetStats(); st.Data["datapoints"] != uint64(5) || st.Data["skipped"] != uint64(1) {
+		t.Errorf("Unexpected stats: %v", st.Data)
+	}
+
+	bad := &sender.OTLP{URL: srv.URL}
+	if err := bad.Send(&c); err == nil {
+		t.Errorf("Expected error when server rejects request")
+	}
+	if err := (&sender.OTLP{URL: "x", Protocol: "udp"}).Verify(); err == nil {
+		t.Errorf("Expected error on invalid protocol")
+	}
+}
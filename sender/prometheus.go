@@ -0,0 +1,362 @@
+/*
+ * skogul, prometheus exposition sender
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/telenornms/skogul"
+)
+
+var promLog = skogul.Logger("sender", "prometheus")
+
+/*
+Prometheus keeps the latest value of every series it receives in memory,
+and serves them on an HTTP endpoint for Prometheus to scrape, in the text
+exposition format.
+
+Every numeric data field of a metric becomes a series. The name of the
+series is the data field name, optionally prefixed by the value of the
+NameField metadata field, and then by Prefix. The remaining metadata
+fields are used as labels. Characters that are not allowed in names are
+replaced by underscores.
+
+Series that have not been updated for Expire are removed, so series that
+disappear don't linger forever.
+
+The HTTP server is started when Skogul starts, regardless of whether
+any data has been received, and stopped when the sender is replaced or
+Skogul shuts down.
+*/
+type Prometheus struct {
+	Address    string          `doc:"Address to serve metrics on. Defaults to :9291."`
+	Path       string          `doc:"Path to serve metrics on. Defaults to /metrics."`
+	Prefix     string          `doc:"Prefix added to all metric names, e.g. \"skogul\". Joined to the rest of the name by an underscore."`
+	NameField  string          `doc:"Metadata field whose value is used as part of the metric name, instead of as a label. Optional."`
+	ValueField string          `doc:"Data field that is exposed using just the name from Prefix and NameField, without the data field name. E.g. \"value\" for data from the Prometheus remote write parser. Optional."`
+	Counters   []string        `doc:"Data fields that are counters. All other fields are exposed as gauges."`
+	Expire     skogul.Duration `doc:"Remove series that have not been updated for this long. Defaults to 5m."`
+	Timestamps bool            `doc:"Include the timestamp of the metric in the exposition. Normally Prometheus uses the time of the scrape."`
+	once       sync.Once
+	lock       sync.Mutex
+	series     map[string]*promSeries
+	counters   map[string]bool
+	server     *http.Server
+	ln         net.Listener
+	stopped    bool
+	lastExpire time.Time
+}
+
+// promSeries is the latest value of a single series.
+type promSeries struct {
+	name    string
+	counter bool
+	labels  []*dto.LabelPair
+	value   float64
+	ts      time.Time
+	updated time.Time
+}
+
+// promName replaces characters not allowed in Prometheus metric and label
+// names with underscores.
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// promValue returns the numeric value of v, if any.
+func promValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// listen starts the HTTP server. Must be called with the lock held.
+func (p *Prometheus) listen() error {
+	ln, err := net.Listen("tcp", p.Address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", p.Address, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(p.Path, p.serve)
+	p.server = &http.Server{Handler: mux}
+	p.ln = ln
+	go func(server *http.Server) {
+		err := server.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			promLog.WithError(err).Error("HTTP server failed")
+		}
+	}(p.server)
+	promLog.WithField("address", p.Address).Info("Serving metrics for Prometheus")
+	return nil
+}
+
+func (p *Prometheus) init() {
+	if p.Address == "" {
+		p.Address = ":9291"
+	}
+	if p.Path == "" {
+		p.Path = "/metrics"
+	}
+	if p.Expire.Duration == 0 {
+		p.Expire.Duration = 5 * time.Minute
+	}
+	p.series = make(map[string]*promSeries)
+	p.counters = make(map[string]bool)
+	for _, f := range p.Counters {
+		p.counters[f] = true
+	}
+}
+
+// Open starts the HTTP server, unless it is already running.
+func (p *Prometheus) Open() error {
+	p.once.Do(p.init)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return fmt.Errorf("sender is stopped")
+	}
+	if p.server != nil {
+		return nil
+	}
+	return p.listen()
+}
+
+// Send updates the series with the data in the container.
+func (p *Prometheus) Send(c *skogul.Container) error {
+	p.once.Do(p.init)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return fmt.Errorf("sender is stopped")
+	}
+	now := time.Now()
+	for _, m := range c.Metrics {
+		base := []string{}
+		if p.Prefix != "" {
+			base = append(base, p.Prefix)
+		}
+		labels := make([]*dto.LabelPair, 0, len(m.Metadata))
+		for k, v := range m.Metadata {
+			if k == p.NameField {
+				base = append(base, fmt.Sprint(v))
+				continue
+			}
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				continue
+			}
+			name, value := promName(k), fmt.Sprint(v)
+			labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return *labels[i].Name < *labels[j].Name })
+		var key strings.Builder
+		for _, l := range labels {
+			fmt.Fprintf(&key, "\xff%s\xff%s", *l.Name, *l.Value)
+		}
+		ts := now
+		if m.Time != nil {
+			ts = *m.Time
+		}
+		for k, v := range m.Data {
+			value, ok := promValue(v)
+			if !ok {
+				continue
+			}
+			parts := base
+			if k != p.ValueField || len(parts) == 0 {
+				parts = append(parts[:len(parts):len(parts)], k)
+			}
+			name := promName(strings.Join(parts, "_"))
+			p.series[name+key.String()] = &promSeries{
+				name:    name,
+				counter: p.counters[k],
+				labels:  labels,
+				value:   value,
+				ts:      ts,
+				updated: now,
+			}
+		}
+	}
+	if now.Sub(p.lastExpire) > p.Expire.Duration {
+		p.expire(now)
+	}
+	return nil
+}
+
+// expire removes series that haven't been updated since Expire. Must be
+// called with the lock held.
+func (p *Prometheus) expire(now time.Time) {
+	p.lastExpire = now
+	for k, s := range p.series {
+		if now.Sub(s.updated) > p.Expire.Duration {
+			delete(p.series, k)
+		}
+	}
+}
+
+// families groups the current series into metric families, sorted by
+// name. If data fields of different types end up with the same name, the
+// type of the family is whichever is found first.
+func (p *Prometheus) families() []*dto.MetricFamily {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.expire(time.Now())
+	fams := make(map[string]*dto.MetricFamily)
+	for _, s := range p.series {
+		fam := fams[s.name]
+		if fam == nil {
+			name, typ := s.name, dto.MetricType_GAUGE
+			if s.counter {
+				typ = dto.MetricType_COUNTER
+			}
+			fam = &dto.MetricFamily{Name: &name, Type: &typ}
+			fams[s.name] = fam
+		}
+		value := s.value
+		metric := &dto.Metric{Label: s.labels}
+		if *fam.Type == dto.MetricType_COUNTER {
+			metric.Counter = &dto.Counter{Value: &value}
+		} else {
+			metric.Gauge = &dto.Gauge{Value: &value}
+		}
+		if p.Timestamps {
+			ms := s.ts.UnixMilli()
+			metric.TimestampMs = &ms
+		}
+		fam.Metric = append(fam.Metric, metric)
+	}
+	ret := make([]*dto.MetricFamily, 0, len(fams))
+	for _, fam := range fams {
+		sort.Slice(fam.Metric, func(i, j int) bool {
+			return promLabelsLess(fam.Metric[i].Label, fam.Metric[j].Label)
+		})
+		ret = append(ret, fam)
+	}
+	sort.Slice(ret, func(i, j int) bool { return *ret[i].Name < *ret[j].Name })
+	return ret
+}
+
+func promLabelsLess(a, b []*dto.LabelPair) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if *a[i].Name != *b[i].Name {
+			return *a[i].Name < *b[i].Name
+		}
+		if *a[i].Value != *b[i].Value {
+			return *a[i].Value < *b[i].Value
+		}
+	}
+	return len(a) < len(b)
+}
+
+// serve writes all current series in the text exposition format.
+func (p *Prometheus) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	for _, fam := range p.families() {
+		if _, err := expfmt.MetricFamilyToText(w, fam); err != nil {
+			promLog.WithError(err).Warn("Writing metrics to scraper failed")
+			return
+		}
+	}
+}
+
+// promStopTimeout is how long Stop waits for scrapes in progress before
+// closing their connections.
+const promStopTimeout = 5 * time.Second
+
+// Stop stops the HTTP server, so the address can be reused, e.g. by the
+// sender replacing this one when the configuration is reloaded.
+func (p *Prometheus) Stop() error {
+	p.lock.Lock()
+	p.stopped = true
+	server, ln := p.server, p.ln
+	p.server, p.ln = nil, nil
+	p.lock.Unlock()
+	if server == nil {
+		return nil
+	}
+	// Shutdown doesn't close the listener if Serve hasn't started using
+	// it yet, so close it here to release the address right away.
+	ln.Close()
+	// Scrapes in progress need the lock, so it must not be held here.
+	ctx, cancel := context.WithTimeout(context.Background(), promStopTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return err
+	}
+	return nil
+}
+
+// Verify checks that the configuration is valid.
+func (p *Prometheus) Verify() error {
+	if p.Expire.Duration < 0 {
+		return fmt.Errorf("Expire can't be negative")
+	}
+	if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("Path must start with /, got %q", p.Path)
+	}
+	if p.Address != "" {
+		if _, err := net.ResolveTCPAddr("tcp", p.Address); err != nil {
+			return fmt.Errorf("invalid Address %q: %w", p.Address, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+ * skogul, prometheus exposition sender tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/sender"
+)
+
+func scrape(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Scraping %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestPrometheus(t *testing.T) {
+	p := &sender.Prometheus{
+		Address:  "localhost:1369",
+		Prefix:   "skogul",
+		Counters: []string{"received"},
+		Expire:   skogul.Duration{Duration: 100 * time.Millisecond},
+	}
+	now := time.Now()
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"type": "http", "in-face": "ge-0/0/0"},
+			Data:     map[string]interface{}{"received": uint64(42), "load": 0.5, "state": "up"},
+		},
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"type": "udp"},
+			Data:     map[string]interface{}{"received": 7},
+		},
+	}}
+	if err := p.Open(); err != nil {
+		t.Fatalf("p.Open() failed: %v", err)
+	}
+	defer p.Stop()
+	// The endpoint is up before any data is received
+	if body := scrape(t, "http://localhost:1369/metrics"); body != "" {
+		t.Errorf("Expected empty exposition before data, got:\n%s", body)
+	}
+	if err := p.Send(&c); err != nil {
+		t.Fatalf("p.Send() failed: %v", err)
+	}
+
+	body := scrape(t, "http://localhost:1369/metrics")
+	for _, want := range []string{
+		"# TYPE skogul_received counter\n",
+		"# TYPE skogul_load gauge\n",
+		`skogul_received{in_face="ge-0/0/0",type="http"} 42` + "\n",
+		`skogul_received{type="udp"} 7` + "\n",
+		`skogul_load{in_face="ge-0/0/0",type="http"} 0.5` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Scrape didn't contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "state") {
+		t.Errorf("Non-numeric data was exposed:\n%s", body)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if body := scrape(t, "http://localhost:1369/metrics"); body != "" {
+		t.Errorf("Series not expired, got:\n%s", body)
+	}
+}
+
+func TestPrometheus_name(t *testing.T) {
+	p := &sender.Prometheus{
+		Address:    "localhost:1369",
+		Path:       "/foo",
+		NameField:  "name",
+		ValueField: "value",
+	}
+	now := time.Now()
+	c := skogul.Container{Metrics: []*skogul.Metric{{
+		Time:     &now,
+		Metadata: map[string]interface{}{"name": "up", "job": "node"},
+		Data:     map[string]interface{}{"value": 1, "other": 2},
+	}}}
+	if err := p.Open(); err != nil {
+		t.Fatalf("p.Open() failed: %v", err)
+	}
+	if err := p.Send(&c); err != nil {
+		t.Fatalf("p.Send() failed: %v", err)
+	}
+	body := scrape(t, "http://localhost:1369/foo")
+	if !strings.Contains(body, `up{job="node"} 1`) || !strings.Contains(body, `up_other{job="node"} 2`) {
+		t.Errorf("Unexpected exposition:\n%s", body)
+	}
+	// The address must be free again after stopping, as with a
+	// configuration reload.
+	if err := p.Stop(); err != nil {
+		t.Fatalf("p.Stop() failed: %v", err)
+	}
+	p2 := &sender.Prometheus{Address: "localhost:1369"}
+	if err := p2.Open(); err != nil {
+		t.Errorf("Address not released by Stop(): %v", err)
+	}
+	p2.Stop()
+	if err := p.Send(&c); err == nil {
+		t.Errorf("Send() to stopped sender succeeded")
+	}
+	if err := (&sender.Prometheus{Address: "localhost:http-x"}).Verify(); err == nil {
+		t.Errorf("Verify() accepted invalid address")
+	}
+}
+
+// Scrapes in progress must not keep Stop from returning. A large Send
+// holds the lock while Stop and then a scrape wait for it, so the scrape
+// is in progress when Stop gets the lock.
+func TestPrometheus_stopWhileScraping(t *testing.T) {
+	p := &sender.Prometheus{Address: "localhost:1376"}
+	now := time.Now()
+	c := skogul.Container{}
+	for i := 0; i < 200000; i++ {
+		c.Metrics = append(c.Metrics, &skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{"id": fmt.Sprint(i)},
+			Data:     map[string]interface{}{"value": i},
+		})
+	}
+	if err := p.Open(); err != nil {
+		t.Fatalf("p.Open() failed: %v", err)
+	}
+	go p.Send(&c)
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		if resp, err := http.Get("http://localhost:1376/metrics"); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Errorf("Stop() didn't return while a scrape was in progress")
+	}
+}
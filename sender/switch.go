@@ -24,6 +24,8 @@
 package sender
 
 import (
+	"fmt"
+
 	"github.com/telenornms/skogul"
 )
 
@@ -55,6 +57,14 @@ Example config:
 			}
 		],
 		"default": "log-no-customer"
+
+Instead of, or in addition to, conditions, a match can use an expression,
+which has access to both metadata and data:
+
+	{
+		"if": "metadata.router == \"foo\" && data.in_octets > 0",
+		"next": "customerExportA"
+	}
 */
 type Switch struct {
 	Default *skogul.SenderRef   `doc:"Default sender to use if no other match is made. If not specified, metrics are discarded."`
@@ -63,15 +73,30 @@ type Switch struct {
 }
 
 // Match describes a list of conditions that need to match for a sender to
-// receive metrics.
+// receive metrics. If both Conditions and If are set, both have to match.
 type Match struct {
 	Conditions []map[string]interface{} `doc:"Array of metadata headers and required values."`
+	If         *skogul.Expression       `doc:"Expression that must be true for a match. See the filter transformer for the syntax." example:"data.in_octets > 0 && metadata.router == \"foo\""`
 	Next       *skogul.SenderRef        `doc:"Sender to use in case of a match."`
 }
 
 var swLog = skogul.Logger("sender", "switch")
 
 func (cond Match) check(metric *skogul.Metric) bool {
+	if len(cond.Conditions) > 0 && !cond.checkConditions(metric) {
+		return false
+	}
+	if cond.If == nil {
+		return len(cond.Conditions) > 0
+	}
+	match, err := cond.If.Match(metric)
+	if err != nil {
+		swLog.WithError(err).Trace("Expression failed, treating it as false")
+	}
+	return match
+}
+
+func (cond Match) checkConditions(metric *skogul.Metric) bool {
 	for _, c := range cond.Conditions {
 		match := true
 		for key, value := range c {
@@ -121,3 +146,13 @@ func (sw *Switch) Send(c *skogul.Container) error {
 	}
 	return nil
 }
+
+// Verify checks that the expressions of the matches are conditions.
+func (sw *Switch) Verify() error {
+	for _, mp := range sw.Map {
+		if mp.If != nil && !mp.If.IsBool() {
+			return fmt.Errorf("expression %q doesn't return a boolean", mp.If.Source)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+ * skogul, switch sender tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/sender"
+)
+
+func TestSwitchIf(t *testing.T) {
+	a := &sender.Test{}
+	b := &sender.Test{}
+	def := &sender.Test{}
+	ifA, err := skogul.NewExpression(`data.in_octets > 100`)
+	if err != nil {
+		t.Fatalf("Compiling expression failed: %v", err)
+	}
+	ifB, _ := skogul.NewExpression(`metadata.interface.startsWith("ae")`)
+	sw := &sender.Switch{
+		Map: []sender.Match{
+			{If: ifA, Next: &skogul.SenderRef{S: a}},
+			{
+				Conditions: []map[string]interface{}{{"router": "foo"}},
+				If:         ifB,
+				Next:       &skogul.SenderRef{S: b},
+			},
+		},
+		Default: &skogul.SenderRef{S: def},
+	}
+	if err := sw.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	metric := func(router, iface string, in float64) *skogul.Metric {
+		return &skogul.Metric{
+			Metadata: map[string]interface{}{"router": router, "interface": iface},
+			Data:     map[string]interface{}{"in_octets": in},
+		}
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		metric("foo", "ae0", 1000),
+		metric("foo", "ae1", 10),
+		metric("bar", "ae0", 10),
+		metric("foo", "et-0/0/0", 10),
+	}}
+	if err := sw.Send(&c); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if a.Received() != 1 || b.Received() != 1 || def.Received() != 1 {
+		t.Errorf("Expected one container each, got %d, %d and %d", a.Received(), b.Received(), def.Received())
+	}
+
+	bad, _ := skogul.NewExpression(`size(metadata)`)
+	sw.Map[0].If = bad
+	if err := sw.Verify(); err == nil {
+		t.Errorf("Verify with non-boolean expression succeeded")
+	}
+}
@@ -0,0 +1,336 @@
+/*
+ * skogul, tenant isolation
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package skogul
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when the metrics of a tenant are rejected
+// because the tenant has exceeded its quota.
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+// tenancyLock protects Handler.tenancy.
+var tenancyLock sync.Mutex
+
+/*
+Tenancy isolates the metrics of tenants sharing a handler.
+
+Every metric handled belongs to a tenant, which is stored in the Field
+metadata field. The tenant is assigned by the receiver, e.g. based on the
+credentials or path used, or from the Field metadata field of the metrics
+as received, if FromMetadata is set. Metrics without a tenant get the
+Default tenant, or are rejected if there is none.
+
+The tenant field is set before the transformers of the handler run, and
+set again after they have run, so transformers can use it but not change
+it. A sender, e.g. the switch sender, can then safely route metrics based
+on their tenant.
+
+The metrics of each tenant are passed through the transformers and sent
+separately, and each tenant can be given a quota, limiting the number of
+metrics per second. If a tenant exceeds its quota, the metrics are
+rejected with ErrQuotaExceeded, which the HTTP receiver reports as 429
+Too Many Requests.
+*/
+type Tenancy struct {
+	Field        string                  `doc:"Metadata field holding the tenant. Defaults to \"tenant\"."`
+	FromMetadata bool                    `doc:"Use the tenant in the metadata of the metrics when the receiver doesn't assign one. Only safe if clients are trusted."`
+	Default      string                  `doc:"Tenant of metrics without one. If not set, such metrics are rejected."`
+	Tenants      []string                `doc:"Tenants to accept. If set, metrics of other tenants are rejected."`
+	Quotas       map[string]*TenantQuota `doc:"Quotas by tenant. The quota of \"*\" applies to each tenant without one of its own." example:"{\"*\": {\"rate\": 1000}, \"team-a\": {\"rate\": 5000, \"burst\": 10000}}"`
+	lock         sync.Mutex
+	tenants      map[string]*tenantState
+	unassigned   uint64
+	handler      *Handler // Set if created by the handler for TransformAndSendAs, and used as its identity
+}
+
+// TenantQuota limits the rate of metrics of a tenant, using a token
+// bucket: Burst metrics can be handled at once, and the bucket is refilled
+// at Rate metrics per second. A container with more metrics than Burst
+// is always rejected.
+type TenantQuota struct {
+	Rate  float64 `doc:"Metrics per second. Required."`
+	Burst float64 `doc:"Maximum number of metrics at once. Defaults to Rate."`
+}
+
+// tenantState is the quota state and stats of a single tenant.
+type tenantState struct {
+	tokens   float64 // Protected by Tenancy.lock
+	last     time.Time
+	Received uint64 // Metrics received
+	Sent     uint64 // Metrics sent successfully
+	Rejected uint64 // Metrics rejected due to the quota
+	Failed   uint64 // Metrics that failed transformation or sending
+}
+
+// tenantGroup is the metrics of a single tenant.
+type tenantGroup struct {
+	tenant string
+	c      *Container
+}
+
+func (t *Tenancy) field() string {
+	if t.Field == "" {
+		return "tenant"
+	}
+	return t.Field
+}
+
+// state returns the state of the tenant. Must be called with the lock
+// held.
+func (t *Tenancy) state(tenant string) *tenantState {
+	if t.tenants == nil {
+		t.tenants = make(map[string]*tenantState)
+	}
+	s := t.tenants[tenant]
+	if s == nil {
+		s = &tenantState{last: time.Now()}
+		if q := t.quota(tenant); q != nil {
+			s.tokens = q.burst()
+		}
+		t.tenants[tenant] = s
+	}
+	return s
+}
+
+func (t *Tenancy) quota(tenant string) *TenantQuota {
+	if q := t.Quotas[tenant]; q != nil {
+		return q
+	}
+	return t.Quotas["*"]
+}
+
+func (q *TenantQuota) burst() float64 {
+	if q.Burst == 0 {
+		return q.Rate
+	}
+	return q.Burst
+}
+
+// accepted checks if the tenant is one of Tenants.
+func (t *Tenancy) accepted(tenant string) bool {
+	if len(t.Tenants) == 0 {
+		return true
+	}
+	for _, x := range t.Tenants {
+		if x == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// split groups the metrics of c by tenant. If tenant is set, all metrics
+// belong to it. Metrics without an acceptable tenant are dropped, and
+// counted.
+func (t *Tenancy) split(c *Container, tenant string) []tenantGroup {
+	if tenant != "" {
+		if !t.accepted(tenant) {
+			atomic.AddUint64(&t.unassigned, uint64(len(c.Metrics)))
+			return nil
+		}
+		return []tenantGroup{{tenant, c}}
+	}
+	groups := []tenantGroup{}
+	index := make(map[string]int)
+	for _, m := range c.Metrics {
+		tenant := t.Default
+		if t.FromMetadata {
+			v, ok := m.Metadata[t.field()].(string)
+			if !ok && c.Template != nil {
+				v, ok = c.Template.Metadata[t.field()].(string)
+			}
+			if ok && v != "" {
+				tenant = v
+			}
+		}
+		if tenant == "" || !t.accepted(tenant) {
+			atomic.AddUint64(&t.unassigned, 1)
+			continue
+		}
+		i, ok := index[tenant]
+		if !ok {
+			i = len(groups)
+			index[tenant] = i
+			groups = append(groups, tenantGroup{tenant, &Container{Template: templateCopy(c.Template)}})
+		}
+		groups[i].c.Metrics = append(groups[i].c.Metrics, m)
+	}
+	return groups
+}
+
+// templateCopy copies the template of a container, so the tenant can be
+// set in the metadata of the template of each tenant separately.
+func templateCopy(t *Metric) *Metric {
+	if t == nil {
+		return nil
+	}
+	n := *t
+	if t.Metadata != nil {
+		n.Metadata = make(map[string]interface{}, len(t.Metadata))
+		for k, v := range t.Metadata {
+			n.Metadata[k] = v
+		}
+	}
+	return &n
+}
+
+// admit counts n metrics as received for the tenant, and checks them
+// against the quota of the tenant.
+func (t *Tenancy) admit(tenant string, n int) (*tenantState, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := t.state(tenant)
+	atomic.AddUint64(&s.Received, uint64(n))
+	q := t.quota(tenant)
+	if q == nil {
+		return s, true
+	}
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * q.Rate
+	if s.tokens > q.burst() {
+		s.tokens = q.burst()
+	}
+	s.last = now
+	if float64(n) > s.tokens {
+		atomic.AddUint64(&s.Rejected, uint64(n))
+		return s, false
+	}
+	s.tokens -= float64(n)
+	return s, true
+}
+
+// assign sets the tenant of all metrics of c, and of its template, if
+// it has metadata.
+func (t *Tenancy) assign(c *Container, tenant string) {
+	field := t.field()
+	for _, m := range c.Metrics {
+		if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+		}
+		m.Metadata[field] = tenant
+	}
+	if c.Template != nil && c.Template.Metadata != nil {
+		c.Template.Metadata[field] = tenant
+	}
+}
+
+// transformAndSend transforms and sends the metrics of c through the
+// handler, separately for each tenant.
+func (t *Tenancy) transformAndSend(h *Handler, c *Container, tenant string) error {
+	groups := t.split(c, tenant)
+	if len(groups) == 0 && len(c.Metrics) > 0 {
+		return fmt.Errorf("no metrics with a valid tenant")
+	}
+	var errs []error
+	for _, g := range groups {
+		s, ok := t.admit(g.tenant, len(g.c.Metrics))
+		if !ok {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", g.tenant, ErrQuotaExceeded))
+			continue
+		}
+		t.assign(g.c, g.tenant)
+		err := h.Transform(g.c)
+		if err != nil {
+			err = fmt.Errorf("transforming metrics failed: %w", err)
+		} else {
+			t.assign(g.c, g.tenant)
+			if err = h.Send(g.c); err != nil {
+				err = fmt.Errorf("sending metrics failed: %w", err)
+			}
+		}
+		if err != nil {
+			atomic.AddUint64(&s.Failed, uint64(len(g.c.Metrics)))
+			errs = append(errs, fmt.Errorf("tenant %s: %w", g.tenant, err))
+			continue
+		}
+		atomic.AddUint64(&s.Sent, uint64(len(g.c.Metrics)))
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	if len(errs) > 1 {
+		return fmt.Errorf("%d of %d tenants failed, first error: %w", len(errs), len(groups), errs[0])
+	}
+	return nil
+}
+
+// GetStats returns the stats of each tenant, and the number of metrics
+// rejected for lacking a valid tenant.
+func (t *Tenancy) GetStats() *Metric {
+	now := Now()
+	metric := Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "handler"
+	metric.Metadata["type"] = "tenancy"
+	if t.handler != nil {
+		metric.Metadata["identity"] = IdentityOf(t.handler)
+	} else {
+		metric.Metadata["identity"] = IdentityOf(t)
+	}
+
+	tenants := make(map[string]interface{})
+	t.lock.Lock()
+	for tenant, s := range t.tenants {
+		tenants[tenant] = map[string]interface{}{
+			"received": atomic.LoadUint64(&s.Received),
+			"sent":     atomic.LoadUint64(&s.Sent),
+			"rejected": atomic.LoadUint64(&s.Rejected),
+			"failed":   atomic.LoadUint64(&s.Failed),
+		}
+	}
+	t.lock.Unlock()
+	metric.Data["unassigned"] = atomic.LoadUint64(&t.unassigned)
+	metric.Data["tenants"] = tenants
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (t *Tenancy) Verify() error {
+	names := make([]string, 0, len(t.Quotas))
+	for tenant := range t.Quotas {
+		names = append(names, tenant)
+	}
+	sort.Strings(names)
+	for _, tenant := range names {
+		q := t.Quotas[tenant]
+		if q == nil || q.Rate <= 0 {
+			return fmt.Errorf("quota of tenant %s must have a positive Rate", tenant)
+		}
+		if q.Burst < 0 {
+			return fmt.Errorf("quota of tenant %s can't have a negative Burst", tenant)
+		}
+	}
+	if t.Default != "" && !t.accepted(t.Default) {
+		return fmt.Errorf("Default tenant %s is not one of Tenants", t.Default)
+	}
+	return nil
+}
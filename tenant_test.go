@@ -0,0 +1,208 @@
+/*
+ * skogul, tenant isolation tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package skogul_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+)
+
+// tenantSender keeps all metrics sent, by tenant
+type tenantSender struct {
+	metrics map[string][]*skogul.Metric
+}
+
+func (s *tenantSender) Send(c *skogul.Container) error {
+	for _, m := range c.Metrics {
+		tenant, _ := m.Metadata["tenant"].(string)
+		s.metrics[tenant] = append(s.metrics[tenant], m)
+	}
+	return nil
+}
+
+// tenantOverwriter tries to move every metric to another tenant
+type tenantOverwriter struct{}
+
+func (tenantOverwriter) Transform(c *skogul.Container) error {
+	for _, m := range c.Metrics {
+		m.Metadata["tenant"] = "evil"
+	}
+	return nil
+}
+
+func tenantContainer(tenants ...string) *skogul.Container {
+	c := skogul.Container{}
+	now := skogul.Now()
+	for _, tenant := range tenants {
+		m := skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{},
+			Data:     map[string]interface{}{"x": 1},
+		}
+		if tenant != "" {
+			m.Metadata["tenant"] = tenant
+		}
+		c.Metrics = append(c.Metrics, &m)
+	}
+	return &c
+}
+
+func TestTenancy(t *testing.T) {
+	s := &tenantSender{metrics: make(map[string][]*skogul.Metric)}
+	tenancy := &skogul.Tenancy{
+		FromMetadata: true,
+		Default:      "shared",
+		Tenants:      []string{"a", "b", "shared"},
+		Quotas:       map[string]*skogul.TenantQuota{"b": {Rate: 0.001, Burst: 2}},
+	}
+	h := skogul.Handler{Sender: s, Transformers: []skogul.Transformer{tenantOverwriter{}}, Tenancy: tenancy}
+	h.SetParser(parser.SkogulJSON{})
+	if err := h.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if err := h.TransformAndSend(tenantContainer("a", "b", "", "a")); err != nil {
+		t.Errorf("TransformAndSend failed: %v", err)
+	}
+	if len(s.metrics["a"]) != 2 || len(s.metrics["b"]) != 1 || len(s.metrics["shared"]) != 1 || len(s.metrics["evil"]) != 0 {
+		t.Errorf("Wrong metrics per tenant: %v", s.metrics)
+	}
+
+	// The receiver knows better than the client
+	if err := h.TransformAndSendAs(tenantContainer("b", "b"), "a"); err != nil {
+		t.Errorf("TransformAndSendAs failed: %v", err)
+	}
+	if len(s.metrics["a"]) != 4 || len(s.metrics["b"]) != 1 {
+		t.Errorf("Tenant of receiver not enforced: %v", s.metrics)
+	}
+
+	// b has 1 metric left of its burst, and isn't refilled in time
+	err := h.TransformAndSend(tenantContainer("b", "b", "a"))
+	if !errors.Is(err, skogul.ErrQuotaExceeded) {
+		t.Errorf("Expected quota to be exceeded, got %v", err)
+	}
+	if len(s.metrics["a"]) != 5 || len(s.metrics["b"]) != 1 {
+		t.Errorf("Wrong metrics after exceeding quota: %v", s.metrics)
+	}
+	if err := h.TransformAndSend(tenantContainer("b")); err != nil {
+		t.Errorf("Remaining quota not usable: %v", err)
+	}
+
+	if err := h.TransformAndSend(tenantContainer("c")); err == nil {
+		t.Errorf("Unknown tenant accepted")
+	}
+
+	stats := tenancy.GetStats()
+	if stats.Data["unassigned"] != uint64(1) {
+		t.Errorf("Expected 1 unassigned metric, got %v", stats.Data["unassigned"])
+	}
+	tenants := stats.Data["tenants"].(map[string]interface{})
+	b := tenants["b"].(map[string]interface{})
+	if b["received"] != uint64(4) || b["sent"] != uint64(2) || b["rejected"] != uint64(2) {
+		t.Errorf("Wrong stats for tenant b: %v", b)
+	}
+	a := tenants["a"].(map[string]interface{})
+	if a["sent"] != uint64(5) || a["rejected"] != uint64(0) {
+		t.Errorf("Wrong stats for tenant a: %v", a)
+	}
+}
+
+func TestTenancy_noTenancy(t *testing.T) {
+	s := &tenantSender{metrics: make(map[string][]*skogul.Metric)}
+	h := skogul.Handler{Sender: s, Transformers: []skogul.Transformer{tenantOverwriter{}}}
+	h.SetParser(parser.SkogulJSON{})
+	if h.Tenants() != nil {
+		t.Errorf("Handler without tenancy has tenants before any were assigned")
+	}
+
+	if err := h.TransformAndSendAs(tenantContainer("b", ""), "a"); err != nil {
+		t.Errorf("TransformAndSendAs failed: %v", err)
+	}
+	if len(s.metrics["a"]) != 2 {
+		t.Errorf("Tenant not enforced without tenancy: %v", s.metrics)
+	}
+	h.TransformAndSendAs(tenantContainer("b"), "a")
+	stats := h.Tenants().GetStats()
+	a := stats.Data["tenants"].(map[string]interface{})["a"].(map[string]interface{})
+	if a["sent"] != uint64(3) {
+		t.Errorf("Stats of assigned tenants not kept without tenancy: %v", a)
+	}
+	if err := h.TransformAndSend(tenantContainer("b")); err != nil {
+		t.Errorf("TransformAndSend failed: %v", err)
+	}
+	if len(s.metrics["evil"]) != 1 {
+		t.Errorf("Metrics without tenant altered: %v", s.metrics)
+	}
+
+	rejecting := skogul.Handler{Sender: s, Tenancy: &skogul.Tenancy{}}
+	rejecting.SetParser(parser.SkogulJSON{})
+	if err := rejecting.TransformAndSend(tenantContainer("b")); err == nil {
+		t.Errorf("Metrics without tenant accepted without Default or FromMetadata")
+	}
+}
+
+// templateSender keeps the tenant of the template of each container sent
+type templateSender struct {
+	tenants []string
+}
+
+func (s *templateSender) Send(c *skogul.Container) error {
+	s.tenants = append(s.tenants, c.Template.Metadata["tenant"].(string))
+	return nil
+}
+
+func TestTenancy_template(t *testing.T) {
+	s := &templateSender{}
+	h := skogul.Handler{Sender: s, Tenancy: &skogul.Tenancy{FromMetadata: true}}
+	h.SetParser(parser.SkogulJSON{})
+	c := tenantContainer("a", "b")
+	c.Template = &skogul.Metric{Metadata: map[string]interface{}{"tenant": "c"}}
+	if err := h.TransformAndSend(c); err != nil {
+		t.Fatalf("TransformAndSend failed: %v", err)
+	}
+	if len(s.tenants) != 2 || s.tenants[0] != "a" || s.tenants[1] != "b" {
+		t.Errorf("Tenants share a template: %v", s.tenants)
+	}
+	if c.Template.Metadata["tenant"] != "c" {
+		t.Errorf("Template of the original container altered: %v", c.Template.Metadata)
+	}
+}
+
+func TestTenancy_verify(t *testing.T) {
+	bad := []skogul.Tenancy{
+		{Quotas: map[string]*skogul.TenantQuota{"a": {}}},
+		{Quotas: map[string]*skogul.TenantQuota{"*": {Rate: 1, Burst: -1}}},
+		{Default: "c", Tenants: []string{"a", "b"}},
+	}
+	for i := range bad {
+		if err := bad[i].Verify(); err == nil {
+			t.Errorf("Verify of %d didn't fail", i)
+		}
+	}
+	good := skogul.Tenancy{Default: "a", Tenants: []string{"a"}, Quotas: map[string]*skogul.TenantQuota{"*": {Rate: 10}}}
+	if err := good.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
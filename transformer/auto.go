@@ -85,9 +85,28 @@ func init() {
 		Name:    "switch",
 		Aliases: []string{},
 		Alloc:   func() interface{} { return &Switch{} },
-		Help:    "Conditionally apply transformers.",
+		Help:    "Conditionally apply transformers, based on the value of a metadata field or an expression.",
 		Extras:  []interface{}{Case{}},
 	})
+	Auto.Add(skogul.Module{
+		Name:    "filter",
+		Aliases: []string{},
+		Alloc:   func() interface{} { return &Filter{} },
+		Help:    "Keep or drop metrics based on expressions or rules. Rules check a single metadata or data field for presence, equality, a regular expression match or a numeric range, and can be combined with all, any and not. Expressions are written in the Common Expression Language (CEL), with the metadata and data of the metric available as the maps metadata and data, and the timestamp as timestamp, e.g.: metadata.interface.matches(\"^ae[0-9]+$\") && data.in_octets > 1000. Use has(data.field) to check if a field exists, since reading a missing field fails. Numbers of different types can be compared, but arithmetic requires the same type, so use float literals with numbers from JSON, e.g. data.in_octets * 8.0. The same expressions can be used by the compute transformer and both the switch transformer and sender. The number of metrics dropped is reported in the stats.",
+		Extras:  []interface{}{FilterRule{}},
+	})
+	Auto.Add(skogul.Module{
+		Name:    "compute",
+		Aliases: []string{},
+		Alloc:   func() interface{} { return &Compute{} },
+		Help:    "Set data or metadata fields to the result of expressions, e.g. in_bps to data.in_octets_rate * 8.0. See the filter transformer for the expression syntax.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "rate",
+		Aliases: []string{"derivative"},
+		Alloc:   func() interface{} { return &Rate{} },
+		Help:    "Calculate per-second rates of counters, such as interface octet counters, from the difference between the current and previous value of each series. Handles counter wraps and resets.",
+	})
 	Auto.Add(skogul.Module{
 		Name:    "timestamp",
 		Aliases: []string{},
@@ -105,7 +124,14 @@ func init() {
 		Name:     "enrich",
 		Aliases:  []string{},
 		Alloc:    func() interface{} { return &Enrich{} },
-		Help:     "PROTOTYPE/ALPHA: Static enrichment. Use a json-structured source document to enrich incoming metrics with additional data. See the docs/examples/ directory for an actual example. This is NOT production ready, and should only be used if you are prepared to file bug reports and update your setup as the transformer matures.",
+		Help:     "PROTOTYPE/ALPHA: Static enrichment. Use a json-structured source document to enrich incoming metrics with additional data. The document can be provided by the enrichmentupdater sender, or loaded and periodically refreshed from a JSON or CSV file, an HTTP endpoint or an SQL query. See the docs/examples/ directory for an actual example. This is NOT production ready, and should only be used if you are prepared to file bug reports and update your setup as the transformer matures.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "prefix",
+		Aliases:  []string{"cidr"},
+		Alloc:    func() interface{} { return &Prefix{} },
+		Help:     "Enrich metrics based on IP addresses in metadata or data fields, by looking them up in a table of IP prefixes, using the most specific match. The table is loaded from a JSON or CSV file, and can be updated by the enrichmentupdater sender.",
 		AutoMake: true,
 	})
 	Auto.Add(skogul.Module{
@@ -0,0 +1,102 @@
+/*
+ * skogul, compute transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"fmt"
+
+	"github.com/telenornms/skogul"
+)
+
+var computeLog = skogul.Logger("transformer", "compute")
+
+/*
+Compute sets data and metadata fields to the result of expressions, see
+skogul.Expression for the syntax. E.g. to add the input rate in bits per
+second from a rate in octets per second:
+
+	"data": {
+		"in_bps": "data.in_octets_rate * 8.0"
+	}
+
+All expressions of a metric are evaluated before any of the fields are
+set, so an expression can't use the result of another. If evaluating an
+expression fails, e.g. because a field is missing, the field is left
+as it is.
+*/
+type Compute struct {
+	Data     map[string]*skogul.Expression `doc:"Data fields to set, and the expressions to set them to."`
+	Metadata map[string]*skogul.Expression `doc:"Metadata fields to set, and the expressions to set them to."`
+}
+
+// eval evaluates the expressions against m, and returns the results.
+func (co *Compute) eval(exprs map[string]*skogul.Expression, m *skogul.Metric) map[string]interface{} {
+	results := make(map[string]interface{}, len(exprs))
+	for key, e := range exprs {
+		value, err := e.Eval(m)
+		if err != nil {
+			computeLog.WithError(err).WithField("field", key).Trace("Expression failed, leaving field as is")
+			continue
+		}
+		results[key] = value
+	}
+	return results
+}
+
+// Transform evaluates the expressions and sets the fields.
+func (co *Compute) Transform(c *skogul.Container) error {
+	for _, m := range c.Metrics {
+		data := co.eval(co.Data, m)
+		metadata := co.eval(co.Metadata, m)
+		if m.Data == nil {
+			m.Data = make(map[string]interface{})
+		}
+		if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+		}
+		for k, v := range data {
+			m.Data[k] = v
+		}
+		for k, v := range metadata {
+			m.Metadata[k] = v
+		}
+	}
+	return nil
+}
+
+// Verify checks that there is something to compute.
+func (co *Compute) Verify() error {
+	if len(co.Data) == 0 && len(co.Metadata) == 0 {
+		return fmt.Errorf("neither Data nor Metadata is set")
+	}
+	for key, e := range co.Data {
+		if e == nil {
+			return fmt.Errorf("no expression for data field %q", key)
+		}
+	}
+	for key, e := range co.Metadata {
+		if e == nil {
+			return fmt.Errorf("no expression for metadata field %q", key)
+		}
+	}
+	return nil
+}
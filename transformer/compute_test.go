@@ -0,0 +1,69 @@
+/*
+ * skogul, compute transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	conf := testConfOk(t, `
+	{
+		"transformers": {
+			"compute": {
+				"type": "compute",
+				"data": {
+					"in_bps": "data.in_octets * 8.0",
+					"in_octets": "data.in_octets + 1.0"
+				},
+				"metadata": {
+					"name": "metadata.interface.upperAscii()",
+					"big": "data.in_octets > 3"
+				}
+			}
+		}
+	}`)
+	c := filterContainer()
+	if err := conf.Transformers["compute"].Transformer.Transform(c); err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	ae0 := c.Metrics[0]
+	if ae0.Data["in_bps"] != 24.0 || ae0.Data["in_octets"] != 4.0 {
+		t.Errorf("Expected in_bps 24 and in_octets 4, got %v", ae0.Data)
+	}
+	if ae0.Metadata["name"] != "AE0" || ae0.Metadata["big"] != false {
+		t.Errorf("Expected name AE0 and big false, got %v", ae0.Metadata)
+	}
+	if c.Metrics[2].Metadata["big"] != true {
+		t.Errorf("Expected big to be true for et-0/0/0, got %v", c.Metrics[2].Metadata)
+	}
+	lo0 := c.Metrics[3]
+	if _, ok := lo0.Data["in_bps"]; ok {
+		t.Errorf("Expected no in_bps without in_octets, got %v", lo0.Data)
+	}
+	if lo0.Metadata["name"] != "LO0" {
+		t.Errorf("Expected name LO0, got %v", lo0.Metadata)
+	}
+
+	testConfBad(t, `{"transformers": {"x": {"type": "compute"}}}`)
+	testConfBad(t, `{"transformers": {"x": {"type": "compute", "data": {"x": "data.y +"}}}}`)
+}
@@ -24,9 +24,25 @@
 package transformer
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"github.com/telenornms/skogul"
+	"io"
+	"math/bits"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql" // Imported for side effect/mysql support
+	_ "github.com/lib/pq"
+	"github.com/telenornms/skogul"
 )
 
 // keyType identifies the type the hash returns
@@ -36,14 +52,57 @@ type keyType string
 // metric's metadatafields on a hit.
 type entry map[string]interface{}
 
+// enrichWildcard is the key value that matches any value.
+const enrichWildcard = "*"
+
+// enrichRetry is how long to wait before retrying a failed load when
+// Refresh isn't set.
+const enrichRetry = time.Minute
+
 /*
 Enrich transformer adds additional metadatainformation to metrics. This
 is a work in progress, see #224 for on-going discussions.
+
+The enrichment database can be updated by the enrichmentupdater sender,
+or loaded from a file (Source), an HTTP endpoint (URL) or an SQL query
+(Query). These are loaded the first time the transformer is used, and
+then reloaded every Refresh, in the background. A reload replaces the
+entire database at once, so a metric is never enriched by a half-loaded
+database. If loading fails, the previous database is kept.
+
+Files and HTTP endpoints are either JSON, in the same format as the
+enrichmentupdater sender accepts, or CSV with a header row. For CSV and
+SQL, the columns named by Keys are matched against the metric, and the
+other columns are added to it.
+
+A key value of "*" in an entry matches any value, so an entry with "*"
+for all keys is a default for metrics that don't match anything else.
+Entries with fewer wildcards are preferred, and wildcards in later keys
+before wildcards in earlier keys.
 */
 type Enrich struct {
-	Keys  []string `doc:"Metadatafields to match, e.g.: sysName and ifName."`
-	lock  sync.RWMutex
-	store map[keyType]*entry
+	Keys    []string        `doc:"Metadatafields to match, e.g.: sysName and ifName."`
+	Source  string          `doc:"File to load the enrichment database from."`
+	URL     string          `doc:"HTTP(S) URL to load the enrichment database from."`
+	Format  string          `doc:"Format of Source or URL: json or csv. Defaults to csv if the file name ends in .csv or the Content-Type is text/csv, otherwise json."`
+	Driver  string          `doc:"Database driver for Query. Currently suported: mysql and postgres."`
+	ConnStr string          `doc:"Connection string to use for Query. For MySQL typically user:password@tcp(host:port)/database." example:"mysql: 'root:lol@/mydb' postgres: 'user=pqgotest dbname=pqgotest sslmode=verify-full'"`
+	Query   string          `doc:"SQL query returning the enrichment database, with one column per key and one per field to add."`
+	Refresh skogul.Duration `doc:"How often to reload the enrichment database from Source, URL or Query. If not set, it is only loaded once."`
+	Timeout skogul.Duration `doc:"Timeout for loading from URL or Query. Defaults to 10s."`
+	Target  string          `doc:"Where to add the enrichment fields: metadata or data. Defaults to metadata."`
+	lock    sync.RWMutex
+	store   map[keyType]*entry
+	masks   []uint // Wildcard masks used by entries, in the order to try them
+	loader  enrichLoader
+	stats   enrichStats
+}
+
+type enrichStats struct {
+	Hits       uint64 // Metrics enriched
+	Misses     uint64 // Metrics without a matching entry
+	Loads      uint64 // Successful loads
+	LoadErrors uint64 // Failed loads
 }
 
 var eLog = skogul.Logger("transformer", "enrich")
@@ -54,11 +113,62 @@ var eLog = skogul.Logger("transformer", "enrich")
 // XXX: This used to be a manually created hash, now we rely on the
 // underlying map[] implementation instead.
 func (e *Enrich) Hash(m skogul.Metric) keyType {
-	ret := ""
-	for _, meta := range e.Keys {
-		ret = ret + fmt.Sprintf("%v", m.Metadata[meta])
+	return e.hash(m.Metadata, 0)
+}
+
+// hash calculates the hash of the key fields of md, using the wildcard
+// instead of the value for the keys with bits set in mask, with the
+// first key as the most significant bit.
+func (e *Enrich) hash(md map[string]interface{}, mask uint) keyType {
+	var b strings.Builder
+	for i, meta := range e.Keys {
+		if mask&(1<<(len(e.Keys)-1-i)) != 0 {
+			b.WriteString(enrichWildcard)
+		} else {
+			fmt.Fprintf(&b, "%v", md[meta])
+		}
+		b.WriteByte(0xff)
+	}
+	return keyType(b.String())
+}
+
+// add adds the metrics of c to store, and returns masks with the
+// wildcard masks of the new entries added.
+func (e *Enrich) add(store map[keyType]*entry, c *skogul.Container, masks []uint) []uint {
+	set := make(map[uint]bool)
+	for _, mask := range masks {
+		set[mask] = true
+	}
+	for _, m := range c.Metrics {
+		mask := uint(0)
+		for i, k := range e.Keys {
+			if m.Metadata[k] == enrichWildcard {
+				mask |= 1 << (len(e.Keys) - 1 - i)
+			}
+		}
+		if mask != 0 {
+			set[mask] = true
+		}
+		h := e.hash(m.Metadata, 0)
+		en := entry(m.Data)
+		store[h] = &en
 	}
-	return keyType(ret)
+	if len(set) == len(masks) {
+		return masks
+	}
+	masks = make([]uint, 0, len(set))
+	for mask := range set {
+		masks = append(masks, mask)
+	}
+	// Fewest wildcards first, then wildcards in later keys first.
+	sort.Slice(masks, func(i, j int) bool {
+		ci, cj := bits.OnesCount(masks[i]), bits.OnesCount(masks[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return masks[i] < masks[j]
+	})
+	return masks
 }
 
 // Update uses the provided Container to update/bootstrap the enrichment
@@ -69,11 +179,7 @@ func (e *Enrich) Update(c *skogul.Container) {
 	if e.store == nil {
 		e.store = make(map[keyType]*entry)
 	}
-	for _, m := range c.Metrics {
-		h := e.Hash(*m)
-		en := entry(m.Data)
-		e.store[h] = &en
-	}
+	e.masks = e.add(e.store, c, e.masks)
 	e.lock.Unlock()
 }
 
@@ -82,6 +188,10 @@ func (e *Enrich) find(m skogul.Metric) *entry {
 		return nil
 	}
 	nm := e.store[e.Hash(m)]
+	// Only try the wildcard masks that are used by some entry.
+	for i := 0; nm == nil && i < len(e.masks); i++ {
+		nm = e.store[e.hash(m.Metadata, e.masks[i])]
+	}
 	if nm != nil {
 		eLog.Tracef("Enrichment hit")
 	} else {
@@ -93,16 +203,312 @@ func (e *Enrich) find(m skogul.Metric) *entry {
 // Transform looks up a metric in the stored enrichment database (loading
 // it on initial run) and adds metadata if a match is found.
 func (e *Enrich) Transform(c *skogul.Container) error {
+	if e.hasSource() {
+		e.loader.check(e.Refresh.Duration, e.reload)
+	}
 	e.lock.RLock()
 	for _, m := range c.Metrics {
 		hit := e.find(*m)
 		if hit == nil {
+			atomic.AddUint64(&e.stats.Misses, 1)
 			continue
 		}
+		atomic.AddUint64(&e.stats.Hits, 1)
+		target := m.Metadata
+		if e.Target == "data" {
+			if m.Data == nil {
+				m.Data = make(map[string]interface{})
+			}
+			target = m.Data
+		} else if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+			target = m.Metadata
+		}
 		for idx, field := range *hit {
-			m.Metadata[idx] = field
+			target[idx] = field
 		}
 	}
 	e.lock.RUnlock()
 	return nil
 }
+
+// hasSource returns true if the database is loaded from somewhere, not
+// just updated by the enrichmentupdater sender.
+func (e *Enrich) hasSource() bool {
+	return e.Source != "" || e.URL != "" || e.Query != ""
+}
+
+// reload loads the database and replaces the current one.
+func (e *Enrich) reload() error {
+	store, masks, err := e.load()
+	if err != nil {
+		atomic.AddUint64(&e.stats.LoadErrors, 1)
+		eLog.WithError(err).Error("Unable to load enrichment database")
+		return err
+	}
+	atomic.AddUint64(&e.stats.Loads, 1)
+	e.lock.Lock()
+	e.store = store
+	e.masks = masks
+	e.lock.Unlock()
+	eLog.WithField("entries", len(store)).Info("Loaded enrichment database")
+	return nil
+}
+
+// enrichLoader keeps track of loading and refreshing an enrichment
+// database.
+type enrichLoader struct {
+	lock     sync.Mutex
+	once     sync.Once
+	loading  bool
+	lastLoad time.Time // Time of the last load attempt
+	loaded   bool      // True if the last load attempt succeeded
+}
+
+// check loads the database using load the first time it is called, so
+// the first metrics are enriched. After that, it starts a reload in the
+// background when refresh has passed since the last attempt. Failed
+// loads are retried after enrichRetry at the latest.
+func (l *enrichLoader) check(refresh time.Duration, load func() error) {
+	l.once.Do(func() {
+		l.lock.Lock()
+		l.loading = true
+		l.lock.Unlock()
+		l.run(load)
+	})
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.loaded && (refresh == 0 || refresh > enrichRetry) {
+		refresh = enrichRetry
+	}
+	if l.loading || refresh == 0 || time.Since(l.lastLoad) < refresh {
+		return
+	}
+	l.loading = true
+	go l.run(load)
+}
+
+// run runs load and records the result. l.loading must be set by the
+// caller.
+func (l *enrichLoader) run(load func() error) {
+	err := load()
+	l.lock.Lock()
+	l.loading = false
+	l.lastLoad = time.Now()
+	l.loaded = err == nil
+	l.lock.Unlock()
+}
+
+// load reads the database from Source, URL or Query.
+func (e *Enrich) load() (map[keyType]*entry, []uint, error) {
+	var c *skogul.Container
+	var err error
+	switch {
+	case e.Source != "":
+		var b []byte
+		if b, err = os.ReadFile(e.Source); err == nil {
+			c, err = enrichDecode(b, e.Format, strings.HasSuffix(e.Source, ".csv"), e.Keys)
+		}
+	case e.URL != "":
+		c, err = e.fetch()
+	case e.Query != "":
+		c, err = e.query()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	store := make(map[keyType]*entry, len(c.Metrics))
+	masks := e.add(store, c, nil)
+	return store, masks, nil
+}
+
+// enrichDecode decodes a JSON container or a CSV file with keys as
+// metadata, depending on format, or csv if format isn't set.
+func enrichDecode(b []byte, format string, csv bool, keys []string) (*skogul.Container, error) {
+	if format == "csv" || (format == "" && csv) {
+		return enrichCSV(b, keys)
+	}
+	c := skogul.Container{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("unable to decode JSON: %w", err)
+	}
+	return &c, nil
+}
+
+// enrichCSV decodes a CSV file with a header row.
+func enrichCSV(b []byte, keys []string) (*skogul.Container, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV has no header")
+	}
+	if err := enrichColumns(records[0], keys); err != nil {
+		return nil, err
+	}
+	c := skogul.Container{}
+	for _, record := range records[1:] {
+		row := make([]interface{}, len(record))
+		for i, v := range record {
+			row[i] = v
+		}
+		c.Metrics = append(c.Metrics, enrichRow(records[0], row, keys))
+	}
+	return &c, nil
+}
+
+// enrichRow turns a row of columns into an entry, with the key columns as
+// metadata and the rest as data.
+func enrichRow(columns []string, values []interface{}, keys []string) *skogul.Metric {
+	m := skogul.Metric{
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	for i, name := range columns {
+		isKey := false
+		for _, k := range keys {
+			if k == name {
+				isKey = true
+				break
+			}
+		}
+		if isKey {
+			m.Metadata[name] = values[i]
+		} else {
+			m.Data[name] = values[i]
+		}
+	}
+	return &m
+}
+
+// enrichColumns checks that all keys have a column.
+func enrichColumns(columns []string, keys []string) error {
+	for _, k := range keys {
+		found := false
+		for _, name := range columns {
+			found = found || name == k
+		}
+		if !found {
+			return fmt.Errorf("no column for key %q", k)
+		}
+	}
+	return nil
+}
+
+func (e *Enrich) timeout() time.Duration {
+	if e.Timeout.Duration == 0 {
+		return 10 * time.Second
+	}
+	return e.Timeout.Duration
+}
+
+// fetch gets the database from URL.
+func (e *Enrich) fetch() (*skogul.Container, error) {
+	client := http.Client{Timeout: e.timeout()}
+	resp, err := client.Get(e.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", e.URL, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response from %s: %w", e.URL, err)
+	}
+	return enrichDecode(b, e.Format, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv"), e.Keys)
+}
+
+// query runs Query and returns the rows.
+func (e *Enrich) query() (*skogul.Container, error) {
+	db, err := sql.Open(e.Driver, e.ConnStr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't initialize SQL connection: %w", err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, e.Query)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't run query: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if err := enrichColumns(columns, e.Keys); err != nil {
+		return nil, err
+	}
+	c := skogul.Container{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		c.Metrics = append(c.Metrics, enrichRow(columns, values, e.Keys))
+	}
+	return &c, rows.Err()
+}
+
+// GetStats prepares a skogul metric with stats for the enrich transformer.
+func (e *Enrich) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "transformer"
+	metric.Metadata["type"] = "enrich"
+	metric.Metadata["identity"] = skogul.IdentityOf(e)
+
+	metric.Data["hits"] = atomic.LoadUint64(&e.stats.Hits)
+	metric.Data["misses"] = atomic.LoadUint64(&e.stats.Misses)
+	metric.Data["loads"] = atomic.LoadUint64(&e.stats.Loads)
+	metric.Data["load_errors"] = atomic.LoadUint64(&e.stats.LoadErrors)
+	e.lock.RLock()
+	metric.Data["entries"] = len(e.store)
+	e.lock.RUnlock()
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (e *Enrich) Verify() error {
+	sources := 0
+	for _, s := range []string{e.Source, e.URL, e.Query} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of Source, URL and Query can be set")
+	}
+	if e.Query != "" && (e.Driver == "" || e.ConnStr == "") {
+		return fmt.Errorf("Query requires Driver and ConnStr")
+	}
+	if e.Format != "" && e.Format != "json" && e.Format != "csv" {
+		return fmt.Errorf("invalid Format %q, must be json or csv", e.Format)
+	}
+	if e.Target != "" && e.Target != "metadata" && e.Target != "data" {
+		return fmt.Errorf("invalid Target %q, must be metadata or data", e.Target)
+	}
+	if e.Refresh.Duration < 0 || e.Timeout.Duration < 0 {
+		return fmt.Errorf("Refresh and Timeout can't be negative")
+	}
+	if len(e.Keys) > 16 {
+		return fmt.Errorf("too many Keys, at most 16 are supported")
+	}
+	return nil
+}
@@ -24,7 +24,14 @@
 package transformer_test
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/transformer"
@@ -56,3 +63,143 @@ func BenchmarkHash(b *testing.B) {
 		e.Hash(m)
 	}
 }
+
+func enrichMetric(md map[string]interface{}) *skogul.Container {
+	return &skogul.Container{Metrics: []*skogul.Metric{{
+		Metadata: md,
+		Data:     map[string]interface{}{"in_octets": 1},
+	}}}
+}
+
+func TestEnrichSourceJSON(t *testing.T) {
+	e := &transformer.Enrich{Keys: []string{"key1"}, Source: "../docs/examples/payloads/enrich.json"}
+	if err := e.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	c := enrichMetric(map[string]interface{}{"key1": 2})
+	e.Transform(c)
+	if c.Metrics[0].Metadata["customer"] != "nei" {
+		t.Errorf("Expected customer nei, got %v", c.Metrics[0].Metadata)
+	}
+	c = enrichMetric(map[string]interface{}{"key1": 5})
+	e.Transform(c)
+	if len(c.Metrics[0].Metadata) != 1 {
+		t.Errorf("Expected no enrichment, got %v", c.Metrics[0].Metadata)
+	}
+	stats := e.GetStats().Data
+	if stats["hits"] != uint64(1) || stats["misses"] != uint64(1) || stats["loads"] != uint64(1) || stats["entries"] != 3 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
+
+func TestEnrichSourceCSV(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "enrich.csv")
+	csv := `sysName,ifName,customer,site
+r1,ae0,alice,osl
+r1,*,internal,osl
+*,*,unknown,
+*,ae1,bob,
+`
+	if err := os.WriteFile(file, []byte(csv), 0644); err != nil {
+		t.Fatalf("Unable to write CSV: %v", err)
+	}
+	e := &transformer.Enrich{Keys: []string{"sysName", "ifName"}, Source: file, Target: "data"}
+	cases := []struct {
+		sysName  string
+		ifName   string
+		customer string
+	}{
+		{"r1", "ae0", "alice"},
+		{"r1", "ae1", "internal"},
+		{"r2", "ae1", "bob"},
+		{"r2", "ae2", "unknown"},
+	}
+	for _, tc := range cases {
+		c := enrichMetric(map[string]interface{}{"sysName": tc.sysName, "ifName": tc.ifName})
+		e.Transform(c)
+		if got := c.Metrics[0].Data["customer"]; got != tc.customer {
+			t.Errorf("Expected customer %s for %s %s, got %v", tc.customer, tc.sysName, tc.ifName, got)
+		}
+		if _, ok := c.Metrics[0].Metadata["customer"]; ok {
+			t.Errorf("Expected customer in data, not metadata")
+		}
+	}
+
+	bad := &transformer.Enrich{Keys: []string{"device"}, Source: file}
+	c := enrichMetric(map[string]interface{}{"device": "r1"})
+	bad.Transform(c)
+	if bad.GetStats().Data["load_errors"] != uint64(1) {
+		t.Errorf("Expected a load error when a key has no column")
+	}
+}
+
+func TestEnrichUpdateWildcard(t *testing.T) {
+	e := &transformer.Enrich{Keys: []string{"sysName", "ifName"}}
+	entry := func(sysName, ifName, customer string) *skogul.Container {
+		c := enrichMetric(map[string]interface{}{"sysName": sysName, "ifName": ifName})
+		c.Metrics[0].Data = map[string]interface{}{"customer": customer}
+		return c
+	}
+	e.Update(entry("r1", "ae0", "alice"))
+	e.Update(entry("*", "ae1", "bob"))
+	e.Update(entry("r1", "*", "internal"))
+	for _, tc := range []struct {
+		sysName  string
+		ifName   string
+		customer interface{}
+	}{
+		{"r1", "ae0", "alice"},
+		{"r1", "ae1", "internal"},
+		{"r2", "ae1", "bob"},
+		{"r2", "ae2", nil},
+	} {
+		c := enrichMetric(map[string]interface{}{"sysName": tc.sysName, "ifName": tc.ifName})
+		e.Transform(c)
+		if got := c.Metrics[0].Metadata["customer"]; got != tc.customer {
+			t.Errorf("Expected customer %v for %s %s, got %v", tc.customer, tc.sysName, tc.ifName, got)
+		}
+	}
+}
+
+func TestEnrichURLRefresh(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "text/csv")
+		fmt.Fprintf(w, "key1,version\n1,%d\n", n)
+	}))
+	defer srv.Close()
+	e := &transformer.Enrich{
+		Keys:    []string{"key1"},
+		URL:     srv.URL,
+		Refresh: skogul.Duration{Duration: 50 * time.Millisecond},
+	}
+	c := enrichMetric(map[string]interface{}{"key1": 1})
+	e.Transform(c)
+	if c.Metrics[0].Metadata["version"] != "1" {
+		t.Fatalf("Expected version 1 after initial load, got %v", c.Metrics[0].Metadata)
+	}
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		c = enrichMetric(map[string]interface{}{"key1": 1})
+		e.Transform(c)
+		if c.Metrics[0].Metadata["version"] != "1" {
+			return
+		}
+	}
+	t.Errorf("Enrichment database was never refreshed")
+}
+
+func TestEnrichVerify(t *testing.T) {
+	bad := []*transformer.Enrich{
+		{Source: "a.json", URL: "http://localhost/"},
+		{Query: "SELECT * FROM enrich"},
+		{Source: "a.json", Format: "xml"},
+		{Target: "both"},
+	}
+	for i, e := range bad {
+		if err := e.Verify(); err == nil {
+			t.Errorf("Verify of bad config %d succeeded", i)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * skogul, expression filter transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dolmen-go/jsonptr"
+	"github.com/telenornms/skogul"
+)
+
+var filterLog = skogul.Logger("transformer", "filter")
+
+/*
+Filter removes metrics from the container based on expressions or rules.
+If Keep or KeepWhen is set, only metrics where they are true are kept.
+If Drop or DropWhen is set, metrics where they are true are removed.
+When several are set, a metric is kept only if all the keep-conditions
+are true and none of the drop-conditions are.
+
+Keep and Drop are expressions, see skogul.Expression for the syntax. If
+evaluating an expression fails, e.g. because a field is missing, it is
+treated as false.
+
+KeepWhen and DropWhen are rules, see FilterRule, which cover the common
+cases without expressions.
+
+The number of metrics received, dropped and failed expressions are
+available through the stats.
+*/
+type Filter struct {
+	Keep     *skogul.Expression `doc:"Expression that must be true for a metric to be kept." example:"metadata.interface.startsWith(\"ae\") && has(data.in_octets)"`
+	Drop     *skogul.Expression `doc:"Expression that removes the metric if true." example:"data.in_octets == 0.0"`
+	KeepWhen *FilterRule        `doc:"Rule that must be true for a metric to be kept."`
+	DropWhen *FilterRule        `doc:"Rule that removes the metric if true."`
+	once     sync.Once
+	err      error
+	stats    filterStats
+}
+
+type filterStats struct {
+	Received uint64 // Metrics received
+	Dropped  uint64 // Metrics removed
+	Failed   uint64 // Expressions that failed, and were treated as false
+}
+
+/*
+FilterRule is a condition on a single metadata or data field, and/or a
+combination of other rules. Everything set in a rule must be true for
+the rule to be true, e.g. a rule with both gt and lt checks if a field
+is within a range.
+
+Fields are named like in the switch transformer: a plain name, or a JSON
+pointer if it starts with /, e.g. /interface/name. A rule on a field
+that doesn't exist is false, unless exists is false.
+*/
+type FilterRule struct {
+	Metadata string        `doc:"Metadata field to check."`
+	Data     string        `doc:"Data field to check, if Metadata isn't set."`
+	Exists   *bool         `doc:"If true, the field must exist. If false, the field must not exist."`
+	Is       interface{}   `doc:"Value the field must be equal to. Numbers are compared by value regardless of type."`
+	Matches  string        `doc:"Regular expression the field must match. Numbers and other values are matched on their text form." example:"^(ae|et-)"`
+	Gt       *float64      `doc:"The field must be a number greater than this."`
+	Ge       *float64      `doc:"The field must be a number greater than or equal to this."`
+	Lt       *float64      `doc:"The field must be a number less than this."`
+	Le       *float64      `doc:"The field must be a number less than or equal to this."`
+	All      []*FilterRule `doc:"Rules that must all be true."`
+	Any      []*FilterRule `doc:"Rules where at least one must be true."`
+	Not      *FilterRule   `doc:"Rule that must be false."`
+	re       *regexp.Regexp
+}
+
+// compile compiles the regular expressions of the rule and its sub-rules,
+// and checks that the rule makes sense.
+func (r *FilterRule) compile() error {
+	field := r.Metadata != "" || r.Data != ""
+	if r.Metadata != "" && r.Data != "" {
+		return fmt.Errorf("rule has both Metadata and Data")
+	}
+	if !field && (r.Exists != nil || r.Is != nil || r.Matches != "" || r.Gt != nil || r.Ge != nil || r.Lt != nil || r.Le != nil) {
+		return fmt.Errorf("rule checks a field, but neither Metadata nor Data is set")
+	}
+	if !field && r.All == nil && r.Any == nil && r.Not == nil {
+		return fmt.Errorf("empty rule")
+	}
+	if r.Matches != "" {
+		var err error
+		if r.re, err = regexp.Compile(r.Matches); err != nil {
+			return fmt.Errorf("invalid regular expression %q: %w", r.Matches, err)
+		}
+	}
+	for _, sub := range append(append([]*FilterRule{r.Not}, r.All...), r.Any...) {
+		if sub == nil {
+			continue
+		}
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterNumber returns the value of v if it is a number.
+func filterNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// get returns the value of the field of the rule.
+func (r *FilterRule) get(m *skogul.Metric) (interface{}, bool) {
+	fields, name := m.Metadata, r.Metadata
+	if name == "" {
+		fields, name = m.Data, r.Data
+	}
+	if name[0] == '/' {
+		v, err := jsonptr.Get(fields, name)
+		return v, err == nil
+	}
+	v, ok := fields[name]
+	return v, ok
+}
+
+// match checks the rule against the metric.
+func (r *FilterRule) match(m *skogul.Metric) bool {
+	if r.Metadata != "" || r.Data != "" {
+		v, found := r.get(m)
+		if r.Exists != nil && *r.Exists != found {
+			return false
+		}
+		if !found {
+			return r.Exists != nil
+		}
+		if r.Is != nil && !reflect.DeepEqual(v, r.Is) {
+			a, okA := filterNumber(v)
+			b, okB := filterNumber(r.Is)
+			if !okA || !okB || a != b {
+				return false
+			}
+		}
+		if r.re != nil {
+			s, ok := v.(string)
+			if !ok {
+				s = fmt.Sprint(v)
+			}
+			if !r.re.MatchString(s) {
+				return false
+			}
+		}
+		if r.Gt != nil || r.Ge != nil || r.Lt != nil || r.Le != nil {
+			n, ok := filterNumber(v)
+			if !ok ||
+				(r.Gt != nil && n <= *r.Gt) ||
+				(r.Ge != nil && n < *r.Ge) ||
+				(r.Lt != nil && n >= *r.Lt) ||
+				(r.Le != nil && n > *r.Le) {
+				return false
+			}
+		}
+	}
+	for _, sub := range r.All {
+		if !sub.match(m) {
+			return false
+		}
+	}
+	if len(r.Any) > 0 {
+		found := false
+		for _, sub := range r.Any {
+			if sub.match(m) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.Not == nil || !r.Not.match(m)
+}
+
+// exprMatch evaluates an expression, treating errors as false.
+func (f *Filter) exprMatch(e *skogul.Expression, m *skogul.Metric) bool {
+	match, err := e.Match(m)
+	if err != nil {
+		atomic.AddUint64(&f.stats.Failed, 1)
+		filterLog.WithError(err).Trace("Expression failed, treating it as false")
+		return false
+	}
+	return match
+}
+
+func (f *Filter) init() {
+	for _, r := range []*FilterRule{f.KeepWhen, f.DropWhen} {
+		if r == nil {
+			continue
+		}
+		if err := r.compile(); err != nil {
+			f.err = err
+			return
+		}
+	}
+}
+
+// keep checks if a metric should be kept.
+func (f *Filter) keep(m *skogul.Metric) bool {
+	return (f.Keep == nil || f.exprMatch(f.Keep, m)) &&
+		(f.KeepWhen == nil || f.KeepWhen.match(m)) &&
+		(f.Drop == nil || !f.exprMatch(f.Drop, m)) &&
+		(f.DropWhen == nil || !f.DropWhen.match(m))
+}
+
+// Transform removes the metrics that don't pass the filter.
+func (f *Filter) Transform(c *skogul.Container) error {
+	f.once.Do(f.init)
+	if f.err != nil {
+		return f.err
+	}
+	metrics := c.Metrics[:0]
+	for _, m := range c.Metrics {
+		if f.keep(m) {
+			metrics = append(metrics, m)
+		}
+	}
+	atomic.AddUint64(&f.stats.Received, uint64(len(c.Metrics)))
+	atomic.AddUint64(&f.stats.Dropped, uint64(len(c.Metrics)-len(metrics)))
+	c.Metrics = metrics
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the filter transformer.
+func (f *Filter) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "transformer"
+	metric.Metadata["type"] = "filter"
+	metric.Metadata["identity"] = skogul.IdentityOf(f)
+
+	metric.Data["received"] = atomic.LoadUint64(&f.stats.Received)
+	metric.Data["dropped"] = atomic.LoadUint64(&f.stats.Dropped)
+	metric.Data["failed"] = atomic.LoadUint64(&f.stats.Failed)
+	return &metric
+}
+
+// Verify checks that at least one condition is set, that the expressions
+// are conditions and that the rules are valid.
+func (f *Filter) Verify() error {
+	if f.Keep == nil && f.Drop == nil && f.KeepWhen == nil && f.DropWhen == nil {
+		return fmt.Errorf("neither Keep, Drop, KeepWhen nor DropWhen is set")
+	}
+	for _, e := range []*skogul.Expression{f.Keep, f.Drop} {
+		if e != nil && !e.IsBool() {
+			return fmt.Errorf("expression %q doesn't return a boolean", e.Source)
+		}
+	}
+	f.once.Do(f.init)
+	return f.err
+}
@@ -0,0 +1,178 @@
+/*
+ * skogul, filter transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/config"
+	"github.com/telenornms/skogul/transformer"
+)
+
+func filterContainer() *skogul.Container {
+	now := time.Now()
+	c := skogul.Container{}
+	for _, iface := range []string{"ae0", "ae1", "et-0/0/0", "lo0"} {
+		c.Metrics = append(c.Metrics, &skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{"interface": iface},
+			Data:     map[string]interface{}{"in_octets": float64(len(iface))},
+		})
+	}
+	delete(c.Metrics[3].Data, "in_octets")
+	return &c
+}
+
+func filterNames(c *skogul.Container) []string {
+	names := []string{}
+	for _, m := range c.Metrics {
+		names = append(names, m.Metadata["interface"].(string))
+	}
+	return names
+}
+
+func TestFilter(t *testing.T) {
+	conf := testConfOk(t, `
+	{
+		"transformers": {
+			"keep": {
+				"type": "filter",
+				"keep": "data.in_octets > 2"
+			},
+			"drop": {
+				"type": "filter",
+				"drop": "metadata.interface.startsWith(\"ae\")"
+			},
+			"both": {
+				"type": "filter",
+				"keep": "metadata.interface.matches(\"^(ae|et-)\")",
+				"drop": "metadata.interface == \"ae1\""
+			}
+		}
+	}`)
+	filterCheck(t, conf, map[string][]string{
+		// lo0 has no in_octets, so the expression fails
+		"keep": {"ae0", "ae1", "et-0/0/0"},
+		"drop": {"et-0/0/0", "lo0"},
+		"both": {"ae0", "et-0/0/0"},
+	})
+
+	testConfBad(t, `{"transformers": {"x": {"type": "filter"}}}`)
+	testConfBad(t, `{"transformers": {"x": {"type": "filter", "keep": "data.in_octets * 2.0"}}}`)
+	testConfBad(t, `{"transformers": {"x": {"type": "filter", "keep": "data.in_octets >"}}}`)
+}
+
+// filterCheck runs the named filters of conf on filterContainer(), and
+// checks the interfaces that are left.
+func filterCheck(t *testing.T, conf *config.Config, want map[string][]string) {
+	t.Helper()
+	for name, names := range want {
+		c := filterContainer()
+		if err := conf.Transformers[name].Transformer.Transform(c); err != nil {
+			t.Errorf("%s: Transform failed: %v", name, err)
+		}
+		got := filterNames(c)
+		if len(got) != len(names) {
+			t.Errorf("%s: expected %v, got %v", name, names, got)
+			continue
+		}
+		for i := range names {
+			if got[i] != names[i] {
+				t.Errorf("%s: expected %v, got %v", name, names, got)
+				break
+			}
+		}
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	conf := testConfOk(t, `
+	{
+		"transformers": {
+			"regex": {
+				"type": "filter",
+				"keepwhen": { "metadata": "interface", "matches": "^ae[0-9]$" }
+			},
+			"range": {
+				"type": "filter",
+				"keepwhen": { "data": "in_octets", "ge": 3, "lt": 9 }
+			},
+			"exists": {
+				"type": "filter",
+				"dropwhen": { "data": "in_octets", "exists": false }
+			},
+			"is": {
+				"type": "filter",
+				"dropwhen": { "data": "in_octets", "is": 3 }
+			},
+			"combined": {
+				"type": "filter",
+				"keepwhen": {
+					"any": [
+						{ "metadata": "interface", "is": "lo0" },
+						{ "all": [
+							{ "data": "in_octets", "gt": 2 },
+							{ "not": { "metadata": "interface", "matches": "1$" } }
+						]}
+					]
+				}
+			},
+			"mixed": {
+				"type": "filter",
+				"keep": "metadata.interface != \"ae0\"",
+				"dropwhen": { "data": "/in_octets", "le": 3 }
+			}
+		}
+	}`)
+	filterCheck(t, conf, map[string][]string{
+		"regex":    {"ae0", "ae1"},
+		"range":    {"ae0", "ae1", "et-0/0/0"},
+		"exists":   {"ae0", "ae1", "et-0/0/0"},
+		"is":       {"et-0/0/0", "lo0"},
+		"combined": {"ae0", "et-0/0/0", "lo0"},
+		"mixed":    {"et-0/0/0", "lo0"},
+	})
+
+	testConfBad(t, `{"transformers": {"x": {"type": "filter", "keepwhen": {"metadata": "x", "matches": "("}}}}`)
+	testConfBad(t, `{"transformers": {"x": {"type": "filter", "keepwhen": {"gt": 1}}}}`)
+	testConfBad(t, `{"transformers": {"x": {"type": "filter", "keepwhen": {"not": {}}}}}`)
+	testConfBad(t, `{"transformers": {"x": {"type": "filter", "keepwhen": {"metadata": "x", "data": "y"}}}}`)
+}
+
+func TestFilterStats(t *testing.T) {
+	keep, _ := skogul.NewExpression("data.in_octets > 3")
+	f := &transformer.Filter{Keep: keep}
+	if err := f.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	f.Transform(filterContainer())
+	f.Transform(filterContainer())
+	stats := f.GetStats()
+	want := map[string]uint64{"received": 8, "dropped": 6, "failed": 2}
+	for k, v := range want {
+		if stats.Data[k] != v {
+			t.Errorf("Expected %s to be %d, got %v", k, v, stats.Data[k])
+		}
+	}
+}
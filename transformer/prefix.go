@@ -0,0 +1,352 @@
+/*
+ * skogul, IP prefix enrichment transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telenornms/skogul"
+)
+
+var prefixLog = skogul.Logger("transformer", "prefix")
+
+/*
+Prefix enriches metrics based on IP addresses, by looking them up in a
+table of IP prefixes, e.g. to add the site, customer or AS of the source
+and destination of flows. The most specific prefix containing the
+address is used (longest prefix match).
+
+The table is loaded from Source, in the same formats as the enrich
+transformer: a JSON container with the prefix as metadata and the
+fields to add as data, or CSV with a header row. Like the enrich
+transformer, it is loaded the first time the transformer is used, and
+reloaded every Refresh. The table can also be updated by the
+enrichmentupdater sender, which adds to the current table.
+
+For each address field, the fields of the matching prefix are added with
+the configured prefix in front of the name, e.g. "src_" for the source
+address, along with the matching prefix itself, named by PrefixField.
+Addresses can be strings, optionally with a port, or byte slices.
+*/
+type Prefix struct {
+	Metadata    map[string]string `doc:"Metadata fields with IP addresses to look up, and the string to put in front of the names of the fields added for each." example:"{\"srcAddr\": \"src_\", \"dstAddr\": \"dst_\"}"`
+	Data        map[string]string `doc:"Data fields with IP addresses to look up, like Metadata."`
+	Source      string            `doc:"File to load the prefix table from."`
+	Format      string            `doc:"Format of Source: json or csv. Defaults to csv if the file name ends in .csv, otherwise json."`
+	PrefixField string            `doc:"Field or column holding the prefix in the prefix table, e.g. 192.0.2.0/24 or 2001:db8::/32. Defaults to prefix."`
+	Refresh     skogul.Duration   `doc:"How often to reload the prefix table from Source. If not set, it is only loaded once."`
+	Target      string            `doc:"Where to add the fields: metadata or data. Defaults to metadata."`
+	lock        sync.RWMutex
+	table       *prefixTable
+	loader      enrichLoader
+	stats       prefixStats
+}
+
+type prefixStats struct {
+	Hits       uint64 // Addresses found in the table
+	Misses     uint64 // Addresses not found in the table
+	Invalid    uint64 // Fields that weren't IP addresses
+	Loads      uint64 // Successful loads
+	LoadErrors uint64 // Failed loads
+}
+
+// prefixTable is a table of prefixes, with the lengths in use, longest
+// first, so a lookup only has to try those lengths.
+type prefixTable struct {
+	entries  map[netip.Prefix]entry
+	lengths4 []int
+	lengths6 []int
+}
+
+// newPrefixTable builds a table from entries.
+func newPrefixTable(entries map[netip.Prefix]entry) *prefixTable {
+	t := prefixTable{entries: entries}
+	seen := make(map[netip.Prefix]bool)
+	for p := range entries {
+		key := netip.PrefixFrom(netip.IPv6Unspecified(), p.Bits())
+		if p.Addr().Is4() {
+			key = netip.PrefixFrom(netip.IPv4Unspecified(), p.Bits())
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if p.Addr().Is4() {
+			t.lengths4 = append(t.lengths4, p.Bits())
+		} else {
+			t.lengths6 = append(t.lengths6, p.Bits())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths4)))
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths6)))
+	return &t
+}
+
+// lookup returns the longest prefix containing addr, if any.
+func (t *prefixTable) lookup(addr netip.Addr) (netip.Prefix, entry) {
+	lengths := t.lengths6
+	if addr.Is4() {
+		lengths = t.lengths4
+	}
+	for _, bits := range lengths {
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if e, ok := t.entries[p]; ok {
+			return p, e
+		}
+	}
+	return netip.Prefix{}, nil
+}
+
+// prefixAddr returns the IP address in v, if any.
+func prefixAddr(v interface{}) (netip.Addr, bool) {
+	var addr netip.Addr
+	switch a := v.(type) {
+	case string:
+		var err error
+		if addr, err = netip.ParseAddr(a); err != nil {
+			ap, err := netip.ParseAddrPort(a)
+			if err != nil {
+				return addr, false
+			}
+			addr = ap.Addr()
+		}
+	case net.IP:
+		var ok bool
+		if addr, ok = netip.AddrFromSlice(a); !ok {
+			return addr, false
+		}
+	case []byte:
+		var ok bool
+		if addr, ok = netip.AddrFromSlice(a); !ok {
+			return addr, false
+		}
+	default:
+		return addr, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// parsePrefix parses a prefix, or a single address as a prefix with only
+// that address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return p, err
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+func (p *Prefix) prefixField() string {
+	if p.PrefixField == "" {
+		return "prefix"
+	}
+	return p.PrefixField
+}
+
+// entries returns the prefixes of c, and the number of invalid ones,
+// which are skipped.
+func (p *Prefix) entries(c *skogul.Container, entries map[netip.Prefix]entry) int {
+	field := p.prefixField()
+	invalid := 0
+	for _, m := range c.Metrics {
+		s, _ := m.Metadata[field].(string)
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			invalid++
+			prefixLog.WithError(err).WithField("prefix", m.Metadata[field]).Debug("Invalid prefix")
+			continue
+		}
+		entries[prefix] = entry(m.Data)
+	}
+	return invalid
+}
+
+// Update adds the prefixes of c to the table. It is used by the
+// enrichmentupdater-sender.
+func (p *Prefix) Update(c *skogul.Container) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entries := make(map[netip.Prefix]entry)
+	if p.table != nil {
+		for k, v := range p.table.entries {
+			entries[k] = v
+		}
+	}
+	if invalid := p.entries(c, entries); invalid > 0 {
+		prefixLog.Warnf("Ignored %d invalid prefixes in update", invalid)
+	}
+	p.table = newPrefixTable(entries)
+}
+
+// reload loads the table from Source and replaces the current one.
+func (p *Prefix) reload() error {
+	err := p.load()
+	if err != nil {
+		atomic.AddUint64(&p.stats.LoadErrors, 1)
+		prefixLog.WithError(err).Error("Unable to load prefix table")
+		return err
+	}
+	atomic.AddUint64(&p.stats.Loads, 1)
+	return nil
+}
+
+func (p *Prefix) load() error {
+	b, err := os.ReadFile(p.Source)
+	if err != nil {
+		return err
+	}
+	c, err := enrichDecode(b, p.Format, strings.HasSuffix(p.Source, ".csv"), []string{p.prefixField()})
+	if err != nil {
+		return err
+	}
+	entries := make(map[netip.Prefix]entry, len(c.Metrics))
+	if invalid := p.entries(c, entries); invalid > 0 {
+		return fmt.Errorf("%d invalid prefixes in %s", invalid, p.Source)
+	}
+	table := newPrefixTable(entries)
+	p.lock.Lock()
+	p.table = table
+	p.lock.Unlock()
+	prefixLog.WithField("prefixes", len(entries)).Info("Loaded prefix table")
+	return nil
+}
+
+// enrich looks up the addresses in the fields of src, and adds the
+// fields of the matching prefixes to dst.
+func (p *Prefix) enrich(table *prefixTable, fields map[string]string, src map[string]interface{}, dst map[string]interface{}) {
+	for field, name := range fields {
+		v, ok := src[field]
+		if !ok {
+			continue
+		}
+		addr, ok := prefixAddr(v)
+		if !ok {
+			atomic.AddUint64(&p.stats.Invalid, 1)
+			continue
+		}
+		prefix, e := table.lookup(addr)
+		if e == nil {
+			atomic.AddUint64(&p.stats.Misses, 1)
+			continue
+		}
+		atomic.AddUint64(&p.stats.Hits, 1)
+		for k, v := range e {
+			dst[name+k] = v
+		}
+		dst[name+p.prefixField()] = prefix.String()
+	}
+}
+
+// Transform looks up the address fields of the metrics and adds the
+// fields of the matching prefixes.
+func (p *Prefix) Transform(c *skogul.Container) error {
+	if p.Source != "" {
+		p.loader.check(p.Refresh.Duration, p.reload)
+	}
+	p.lock.RLock()
+	table := p.table
+	p.lock.RUnlock()
+	if table == nil {
+		return nil
+	}
+	for _, m := range c.Metrics {
+		if p.Target == "data" {
+			if m.Data == nil {
+				m.Data = make(map[string]interface{})
+			}
+		} else if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+		}
+		dst := m.Metadata
+		if p.Target == "data" {
+			dst = m.Data
+		}
+		p.enrich(table, p.Metadata, m.Metadata, dst)
+		p.enrich(table, p.Data, m.Data, dst)
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the prefix transformer.
+func (p *Prefix) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "transformer"
+	metric.Metadata["type"] = "prefix"
+	metric.Metadata["identity"] = skogul.IdentityOf(p)
+
+	metric.Data["hits"] = atomic.LoadUint64(&p.stats.Hits)
+	metric.Data["misses"] = atomic.LoadUint64(&p.stats.Misses)
+	metric.Data["invalid"] = atomic.LoadUint64(&p.stats.Invalid)
+	metric.Data["loads"] = atomic.LoadUint64(&p.stats.Loads)
+	metric.Data["load_errors"] = atomic.LoadUint64(&p.stats.LoadErrors)
+	p.lock.RLock()
+	if p.table != nil {
+		metric.Data["prefixes"] = len(p.table.entries)
+	} else {
+		metric.Data["prefixes"] = 0
+	}
+	p.lock.RUnlock()
+	return &metric
+}
+
+// Verify checks that the configuration is valid.
+func (p *Prefix) Verify() error {
+	if len(p.Metadata) == 0 && len(p.Data) == 0 {
+		return fmt.Errorf("neither Metadata nor Data fields are set")
+	}
+	if p.Format != "" && p.Format != "json" && p.Format != "csv" {
+		return fmt.Errorf("invalid Format %q, must be json or csv", p.Format)
+	}
+	if p.Target != "" && p.Target != "metadata" && p.Target != "data" {
+		return fmt.Errorf("invalid Target %q, must be metadata or data", p.Target)
+	}
+	if p.Refresh.Duration < 0 {
+		return fmt.Errorf("Refresh can't be negative")
+	}
+	return nil
+}
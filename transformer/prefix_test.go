@@ -0,0 +1,182 @@
+/*
+ * skogul, prefix transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/transformer"
+)
+
+func TestPrefix(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "prefixes.csv")
+	err := os.WriteFile(source, []byte(`prefix,site,customer
+10.0.0.0/8,core,
+10.1.0.0/16,oslo,acme
+10.1.2.0/24,oslo-dc,acme
+192.0.2.1,lab,
+2001:db8::/32,core,
+2001:db8:1::/48,bergen,initech
+`), 0644)
+	if err != nil {
+		t.Fatalf("unable to write prefix table: %v", err)
+	}
+	p := transformer.Prefix{
+		Metadata: map[string]string{"src": "src_", "dst": "dst_"},
+		Data:     map[string]string{"peer": "peer_"},
+		Source:   source,
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	metric := skogul.Metric{
+		Metadata: map[string]interface{}{
+			"src": "10.1.2.3",
+			"dst": "[2001:db8:1::5]:443",
+		},
+		Data: map[string]interface{}{
+			"peer": net.ParseIP("192.0.2.1"),
+		},
+	}
+	other := skogul.Metric{
+		Metadata: map[string]interface{}{
+			"src": "::ffff:10.9.9.9",
+			"dst": "172.16.0.1",
+		},
+		Data: map[string]interface{}{
+			"peer": "not an address",
+		},
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{&metric, &other}}
+	if err := p.Transform(&c); err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	want := map[string]interface{}{
+		"src_site":       "oslo-dc",
+		"src_customer":   "acme",
+		"src_prefix":     "10.1.2.0/24",
+		"dst_site":       "bergen",
+		"dst_customer":   "initech",
+		"dst_prefix":     "2001:db8:1::/48",
+		"peer_site":      "lab",
+		"peer_customer":  "",
+		"peer_prefix":    "192.0.2.1/32",
+		"other_src_site": "core",
+	}
+	for k, v := range want {
+		m := metric.Metadata
+		if k == "other_src_site" {
+			m, k = other.Metadata, "src_site"
+		}
+		if m[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, m[k])
+		}
+	}
+	if _, ok := other.Metadata["dst_site"]; ok {
+		t.Errorf("expected no match for 172.16.0.1, got %v", other.Metadata["dst_site"])
+	}
+
+	// Updates add to the table loaded from the file
+	p.Update(&skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Metadata: map[string]interface{}{"prefix": "172.16.0.0/12"},
+			Data:     map[string]interface{}{"site": "private"},
+		},
+	}})
+	metric = skogul.Metric{Metadata: map[string]interface{}{"dst": "172.16.0.1", "src": "10.200.0.1"}}
+	c = skogul.Container{Metrics: []*skogul.Metric{&metric}}
+	if err := p.Transform(&c); err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if metric.Metadata["dst_site"] != "private" || metric.Metadata["src_site"] != "core" {
+		t.Errorf("unexpected metadata after update: %v", metric.Metadata)
+	}
+
+	stats := p.GetStats()
+	for k, v := range map[string]interface{}{"hits": uint64(6), "misses": uint64(1), "invalid": uint64(1), "loads": uint64(1), "prefixes": 7} {
+		if stats.Data[k] != v {
+			t.Errorf("expected stat %s to be %v, got %v", k, v, stats.Data[k])
+		}
+	}
+}
+
+func TestPrefixData(t *testing.T) {
+	p := transformer.Prefix{
+		Data:   map[string]string{"addr": ""},
+		Target: "data",
+	}
+	p.Update(&skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Metadata: map[string]interface{}{"prefix": "198.51.100.0/24"},
+			Data:     map[string]interface{}{"asn": 64500},
+		},
+		{
+			Metadata: map[string]interface{}{"prefix": "bogus"},
+			Data:     map[string]interface{}{"asn": 64501},
+		},
+	}})
+	metric := skogul.Metric{Data: map[string]interface{}{"addr": "198.51.100.20"}}
+	c := skogul.Container{Metrics: []*skogul.Metric{&metric}}
+	if err := p.Transform(&c); err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if metric.Data["asn"] != 64500 || metric.Data["prefix"] != "198.51.100.0/24" {
+		t.Errorf("unexpected data: %v", metric.Data)
+	}
+	if metric.Metadata != nil {
+		t.Errorf("expected no metadata, got %v", metric.Metadata)
+	}
+}
+
+func TestPrefixVerify(t *testing.T) {
+	bad := []*transformer.Prefix{
+		{},
+		{Metadata: map[string]string{"src": ""}, Format: "xml"},
+		{Metadata: map[string]string{"src": ""}, Target: "both"},
+	}
+	for i, p := range bad {
+		if err := p.Verify(); err == nil {
+			t.Errorf("expected Verify of config %d to fail", i)
+		}
+	}
+	testConfOk(t, `
+	{
+		"transformers": {
+			"ok": {
+				"type": "prefix",
+				"metadata": {"srcAddr": "src_"},
+				"source": "prefixes.csv"
+			}
+		},
+		"senders": {
+			"update": {
+				"type": "enrichmentupdater",
+				"enricher": "ok"
+			}
+		}
+	}`)
+}
@@ -0,0 +1,280 @@
+/*
+ * skogul, rate transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var rateLog = skogul.Logger("transformer", "rate")
+
+/*
+Rate turns counters, values that only ever increase, into per-second
+rates. It remembers the previous value of each field of each series, and
+when a new value arrives, the difference is divided by the time since the
+previous value, using the timestamps of the metrics. The rate is added as
+a new data field, e.g. ifHCInOctets_rate, or replaces the counter.
+
+A series is identified by the values of the Keys metadata fields, or by
+all metadata fields if Keys is empty. The first value of a series has
+no rate.
+
+If a counter decreases, it has either wrapped around or been reset, e.g.
+by a reboot. With Wrap set to 32 or 64, a decrease is treated as a wrap
+of a counter of that size if the result is less than half the range of
+the counter, and as a reset otherwise. With Wrap set to auto, the
+default, counters with values that fit in 32 bits are treated as 32-bit
+counters, and other counters as 64-bit counters. With Wrap set to none,
+all decreases are treated as resets. After a reset, the next value is
+treated as the first value of the series.
+
+Metrics that are older than the previous value of a series are ignored,
+and left as they are.
+*/
+type Rate struct {
+	Fields    []string        `doc:"Data fields containing counters to calculate rates for." example:"[\"ifHCInOctets\", \"ifHCOutOctets\"]"`
+	Keys      []string        `doc:"Metadata fields identifying a series, e.g. the device and interface name. Defaults to all metadata fields."`
+	Wrap      string          `doc:"How to handle counters that decrease: auto, 32, 64 or none. See the description above. Defaults to auto."`
+	Delta     bool            `doc:"Also add the difference from the previous value, as e.g. ifHCInOctets_delta."`
+	Replace   bool            `doc:"Replace the counter with its rate, instead of adding a new field. The rate of a first value is then removed."`
+	DropFirst bool            `doc:"Remove metrics where none of the fields have a rate, e.g. the first metric of a series, instead of passing them on."`
+	Expire    skogul.Duration `doc:"Forget series that haven't been updated for this long. Defaults to 1h."`
+	lock      sync.Mutex
+	series    map[string]*rateSeries
+	lastCheck time.Time
+}
+
+// rateSeries is the previous value of a single field of a series.
+type rateSeries struct {
+	value   uint64
+	fvalue  float64
+	integer bool
+	ts      time.Time // Timestamp of the metric
+	seen    time.Time // When the value was received, used for expiry
+}
+
+// rateCounter returns the value of a counter, as an unsigned integer if
+// it is a non-negative whole number, and as a float.
+func rateCounter(v interface{}) (uint64, float64, bool, bool) {
+	var f float64
+	switch n := v.(type) {
+	case uint64:
+		return n, float64(n), true, true
+	case uint32:
+		return uint64(n), float64(n), true, true
+	case uint:
+		return uint64(n), float64(n), true, true
+	case int64:
+		f = float64(n)
+		if n >= 0 {
+			return uint64(n), f, true, true
+		}
+	case int32:
+		f = float64(n)
+		if n >= 0 {
+			return uint64(n), f, true, true
+		}
+	case int:
+		f = float64(n)
+		if n >= 0 {
+			return uint64(n), f, true, true
+		}
+	case json.Number:
+		if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+			return u, float64(u), true, true
+		}
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return 0, 0, false, false
+		}
+	case float64:
+		f = n
+	case float32:
+		f = float64(n)
+	default:
+		return 0, 0, false, false
+	}
+	if f >= 0 && f < math.Exp2(64) && f == math.Trunc(f) {
+		return uint64(f), f, true, true
+	}
+	return 0, f, false, true
+}
+
+// delta returns the difference between the previous and current value
+// of a counter, and false if the counter was reset.
+func (r *Rate) delta(prev *rateSeries, value uint64, fvalue float64, integer bool) (interface{}, float64, bool) {
+	if !integer || !prev.integer {
+		if fvalue < prev.fvalue {
+			return nil, 0, false
+		}
+		return fvalue - prev.fvalue, fvalue - prev.fvalue, true
+	}
+	if value >= prev.value {
+		return value - prev.value, float64(value - prev.value), true
+	}
+	bits := r.Wrap
+	if bits == "auto" || bits == "" {
+		bits = "64"
+		if prev.value <= math.MaxUint32 {
+			bits = "32"
+		}
+	}
+	var d uint64
+	switch bits {
+	case "32":
+		if prev.value > math.MaxUint32 || value > math.MaxUint32 {
+			return nil, 0, false
+		}
+		d = (value - prev.value) & math.MaxUint32
+		if d > math.MaxUint32/2 {
+			return nil, 0, false
+		}
+	case "64":
+		d = value - prev.value
+		if d > math.MaxUint64/2 {
+			return nil, 0, false
+		}
+	default:
+		return nil, 0, false
+	}
+	return d, float64(d), true
+}
+
+// key returns the key identifying the series of a metric.
+func (r *Rate) key(m *skogul.Metric) string {
+	keys := r.Keys
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(m.Metadata))
+		for k := range m.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s\xff%v\xfe", k, m.Metadata[k])
+	}
+	return b.String()
+}
+
+// Transform calculates the rates of the fields, and updates the previous
+// values.
+func (r *Rate) Transform(c *skogul.Container) error {
+	now := time.Now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.series == nil {
+		r.series = make(map[string]*rateSeries)
+		r.lastCheck = now
+	}
+	metrics := c.Metrics[:0]
+	for _, m := range c.Metrics {
+		ts := now
+		if m.Time != nil {
+			ts = *m.Time
+		}
+		key := r.key(m)
+		found, rated := false, false
+		for _, field := range r.Fields {
+			value, fvalue, integer, ok := rateCounter(m.Data[field])
+			if !ok {
+				continue
+			}
+			found = true
+			prev := r.series[key+field]
+			if prev != nil && !ts.After(prev.ts) {
+				// Out of order, leave it alone
+				rated = true
+				continue
+			}
+			cur := &rateSeries{value: value, fvalue: fvalue, integer: integer, ts: ts, seen: now}
+			r.series[key+field] = cur
+			if r.Replace {
+				delete(m.Data, field)
+			}
+			if prev == nil {
+				continue
+			}
+			delta, fdelta, ok := r.delta(prev, value, fvalue, integer)
+			if !ok {
+				rateLog.WithField("field", field).Debugf("Counter reset from %v to %v", prev.fvalue, fvalue)
+				continue
+			}
+			rated = true
+			rate := fdelta / ts.Sub(prev.ts).Seconds()
+			if r.Replace {
+				m.Data[field] = rate
+			} else {
+				m.Data[field+"_rate"] = rate
+			}
+			if r.Delta {
+				m.Data[field+"_delta"] = delta
+			}
+		}
+		if found && !rated && r.DropFirst {
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	c.Metrics = metrics
+	if now.Sub(r.lastCheck) > r.expire() {
+		r.lastCheck = now
+		for k, s := range r.series {
+			if now.Sub(s.seen) > r.expire() {
+				delete(r.series, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rate) expire() time.Duration {
+	if r.Expire.Duration == 0 {
+		return time.Hour
+	}
+	return r.Expire.Duration
+}
+
+// Verify checks that the configuration is valid.
+func (r *Rate) Verify() error {
+	if len(r.Fields) == 0 {
+		return skogul.MissingArgument("Fields")
+	}
+	switch r.Wrap {
+	case "", "auto", "32", "64", "none":
+	default:
+		return fmt.Errorf("invalid Wrap %q, must be auto, 32, 64 or none", r.Wrap)
+	}
+	if r.Expire.Duration < 0 {
+		return fmt.Errorf("Expire can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * skogul, rate transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/transformer"
+)
+
+func rateContainer(ts time.Time, ifname string, in interface{}) *skogul.Container {
+	return &skogul.Container{Metrics: []*skogul.Metric{{
+		Time:     &ts,
+		Metadata: map[string]interface{}{"device": "r1", "interface": ifname},
+		Data:     map[string]interface{}{"in": in, "state": "up"},
+	}}}
+}
+
+func TestRate(t *testing.T) {
+	r := &transformer.Rate{Fields: []string{"in"}, Keys: []string{"device", "interface"}, Delta: true}
+	if err := r.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	start := time.Now()
+	steps := []struct {
+		offset time.Duration
+		ifname string
+		in     interface{}
+		rate   interface{}
+		delta  interface{}
+	}{
+		{0, "et-0/0/0", uint64(1000), nil, nil},
+		{10 * time.Second, "et-0/0/0", uint64(2000), 100.0, uint64(1000)},
+		{0, "et-0/0/1", 5.0, nil, nil},
+		{20 * time.Second, "et-0/0/0", json.Number("2500"), 50.0, uint64(500)},
+		{30 * time.Second, "et-0/0/0", 3000.0, 50.0, uint64(500)},
+		// Out of order, ignored
+		{25 * time.Second, "et-0/0/0", 2800, nil, nil},
+		// 32-bit wrap
+		{30 * time.Second, "et-0/0/1", uint32(math.MaxUint32 - 99), 143165573.0, uint64(math.MaxUint32 - 104)},
+		{40 * time.Second, "et-0/0/1", uint32(100), 20.0, uint64(200)},
+		// Reset
+		{40 * time.Second, "et-0/0/0", 10, nil, nil},
+		{50 * time.Second, "et-0/0/0", 110, 10.0, uint64(100)},
+		{50 * time.Second, "et-0/0/1", 1.5, nil, nil},
+		{60 * time.Second, "et-0/0/1", 2.5, 0.1, 1.0},
+	}
+	for i, step := range steps {
+		c := rateContainer(start.Add(step.offset), step.ifname, step.in)
+		if err := r.Transform(c); err != nil {
+			t.Fatalf("Transform failed: %v", err)
+		}
+		data := c.Metrics[0].Data
+		if data["in"] != step.in {
+			t.Errorf("step %d: counter changed from %v to %v", i, step.in, data["in"])
+		}
+		rate := data["in_rate"]
+		if f, ok := rate.(float64); ok {
+			rate = math.Round(f*10) / 10
+		}
+		if rate != step.rate {
+			t.Errorf("step %d: expected rate %v, got %v", i, step.rate, data["in_rate"])
+		}
+		if data["in_delta"] != step.delta {
+			t.Errorf("step %d: expected delta %v (%T), got %v (%T)", i, step.delta, step.delta, data["in_delta"], data["in_delta"])
+		}
+	}
+}
+
+func TestRateWrap64(t *testing.T) {
+	r := &transformer.Rate{Fields: []string{"in"}, Wrap: "64", Replace: true, DropFirst: true}
+	start := time.Now()
+	c := rateContainer(start, "et-0/0/0", uint64(math.MaxUint64-9))
+	r.Transform(c)
+	if len(c.Metrics) != 0 {
+		t.Fatalf("Expected first metric to be dropped, got %v", c.Metrics[0])
+	}
+	c = rateContainer(start.Add(time.Second), "et-0/0/0", uint64(10))
+	r.Transform(c)
+	if len(c.Metrics) != 1 || c.Metrics[0].Data["in"] != 20.0 {
+		t.Fatalf("Expected in to be replaced by rate 20, got %v", c.Metrics)
+	}
+	if _, ok := c.Metrics[0].Data["in_rate"]; ok {
+		t.Errorf("Expected no in_rate with Replace")
+	}
+
+	// With Wrap none, a decrease is always a reset
+	r = &transformer.Rate{Fields: []string{"in"}, Wrap: "none"}
+	r.Transform(rateContainer(start, "et-0/0/0", uint32(math.MaxUint32)))
+	c = rateContainer(start.Add(time.Second), "et-0/0/0", uint32(10))
+	r.Transform(c)
+	if rate, ok := c.Metrics[0].Data["in_rate"]; ok {
+		t.Errorf("Expected reset, got rate %v", rate)
+	}
+}
+
+func TestRateVerify(t *testing.T) {
+	bad := []*transformer.Rate{
+		{},
+		{Fields: []string{"in"}, Wrap: "16"},
+		{Fields: []string{"in"}, Expire: skogul.Duration{Duration: -time.Second}},
+	}
+	for i, r := range bad {
+		if err := r.Verify(); err == nil {
+			t.Errorf("Verify of bad config %d succeeded", i)
+		}
+	}
+}
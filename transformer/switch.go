@@ -32,9 +32,12 @@ import (
 )
 
 // Case requires the path to a field ("when") and a value ("is") to match
-// for the set of transformers to run
+// for the set of transformers to run, or alternatively an expression ("if")
+// to be true. With an expression, the transformers run once, on only the
+// metrics that match it.
 type Case struct {
 	When         string                   `doc:"Used as a conditional statement on a field"`
+	If           *skogul.Expression       `doc:"Expression that must be true for the transformers to run, instead of When. See the expression documentation of the filter transformer." example:"metadata.interface.startsWith(\"ae\")"`
 	Exists       bool                     `doc:"Used to check if the 'when' field exists"`
 	Is           interface{}              `doc:"Used for the specific value of the stated metadata field"`
 	Transformers []*skogul.TransformerRef `doc:"The transformers to run when the defined conditional is true"`
@@ -51,6 +54,11 @@ var switchLogger = skogul.Logger("transformer", "switch")
 func (sw *Switch) Transform(c *skogul.Container) error {
 	for _, cas := range sw.Cases {
 
+		if cas.If != nil {
+			cas.transformIf(c)
+			continue
+		}
+
 		field := cas.When
 		condition := cas.Is
 
@@ -88,11 +96,67 @@ func (sw *Switch) Transform(c *skogul.Container) error {
 	return nil
 }
 
+// transformIf runs the transformers of the case once, on a container with
+// only the metrics that match the expression. The resulting metrics are
+// put back in the place of the matching ones, or in the place of the
+// first of them if the transformers changed the number of metrics.
+func (cas *Case) transformIf(c *skogul.Container) {
+	matching := skogul.Container{Template: c.Template}
+	idx := []int{}
+	for i, metric := range c.Metrics {
+		match, err := cas.If.Match(metric)
+		if err != nil {
+			switchLogger.WithError(err).Trace("Expression failed, treating it as false")
+		}
+		if match {
+			matching.Metrics = append(matching.Metrics, metric)
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		return
+	}
+	for _, wantedTransformerName := range cas.Transformers {
+		wantedTransformerName.T.Transform(&matching)
+	}
+	if len(matching.Metrics) == len(idx) {
+		for i, metric := range matching.Metrics {
+			c.Metrics[idx[i]] = metric
+		}
+		return
+	}
+	metrics := make([]*skogul.Metric, 0, len(c.Metrics)-len(idx)+len(matching.Metrics))
+	next := 0
+	for i, metric := range c.Metrics {
+		if next < len(idx) && idx[next] == i {
+			if next == 0 {
+				metrics = append(metrics, matching.Metrics...)
+			}
+			next++
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	c.Metrics = metrics
+}
+
 func (sw *Switch) Verify() error {
 	for _, cas := range sw.Cases {
 		if len(cas.Transformers) == 0 {
 			return fmt.Errorf("No transformers defined for switch case '%s'", cas.When)
 		}
+		if cas.If != nil {
+			if cas.When != "" || cas.Exists || cas.Is != nil {
+				return fmt.Errorf("Case for '%s' configured with both If and When. Only one of these makes sense.", cas.If.Source)
+			}
+			if !cas.If.IsBool() {
+				return fmt.Errorf("Expression '%s' doesn't return a boolean", cas.If.Source)
+			}
+			continue
+		}
+		if cas.When == "" {
+			return fmt.Errorf("Case configured with neither When nor If")
+		}
 		if cas.Exists && cas.Is != nil {
 			return fmt.Errorf("Case for '%s' configured with both Exists and Is. Only one of these makes sense.", cas.When)
 		}
@@ -238,3 +238,107 @@ func TestSwitchCaseFieldExists(t *testing.T) {
 		t.Error("switch transformer did not remove field based on 'exists' option")
 	}
 }
+
+func TestSwitchCaseIf(t *testing.T) {
+	conf := testConfOk(t, `
+	{
+		"transformers": {
+			"switch": {
+				"type": "switch",
+				"cases": [
+					{
+						"if": "metadata.sensor == \"a\" && data.data == \"42\"",
+						"transformers": ["remove"]
+					},
+					{
+						"if": "metadata.sensor == \"b\"",
+						"transformers": ["removeother"]
+					}
+				]
+			},
+			"remove": {
+				"type": "data",
+				"remove": ["removable_field"]
+			},
+			"removeother": {
+				"type": "data",
+				"remove": ["bannable_field"]
+			}
+		}
+	}`)
+
+	container := generateContainer()
+	if err := conf.Transformers["switch"].Transformer.Transform(&container); err != nil {
+		t.Errorf("Switch transformer returned error %v", err)
+	}
+	if container.Metrics[0].Data["removable_field"] != nil {
+		t.Errorf("Expression case didn't run, 'removable_field' is '%v'", container.Metrics[0].Data["removable_field"])
+	}
+	if container.Metrics[0].Data["bannable_field"] == nil {
+		t.Errorf("Expression case ran even if it didn't match")
+	}
+
+	testConfBad(t, `
+	{
+		"transformers": {
+			"switch": {
+				"type": "switch",
+				"cases": [
+					{
+						"if": "metadata.sensor",
+						"when": "sensor",
+						"transformers": ["remove"]
+					}
+				]
+			},
+			"remove": {
+				"type": "data",
+				"remove": ["removable_field"]
+			}
+		}
+	}`)
+}
+
+func TestSwitchCaseIfMixed(t *testing.T) {
+	conf := testConfOk(t, `
+	{
+		"transformers": {
+			"switch": {
+				"type": "switch",
+				"cases": [
+					{
+						"if": "metadata.sensor == \"a\"",
+						"transformers": ["remove"]
+					}
+				]
+			},
+			"remove": {
+				"type": "data",
+				"remove": ["removable_field"]
+			}
+		}
+	}`)
+
+	container := skogul.Container{}
+	for _, md := range []string{testMetadataB, testMetadataA, testMetadataB, testMetadataA} {
+		metric := skogul.Metric{}
+		json.Unmarshal([]byte(md), &metric.Metadata)
+		json.Unmarshal([]byte(testData), &metric.Data)
+		container.Metrics = append(container.Metrics, &metric)
+	}
+	if err := conf.Transformers["switch"].Transformer.Transform(&container); err != nil {
+		t.Errorf("Switch transformer returned error %v", err)
+	}
+	if len(container.Metrics) != 4 {
+		t.Fatalf("Expected 4 metrics, got %d", len(container.Metrics))
+	}
+	for i, sensor := range []string{"b", "a", "b", "a"} {
+		m := container.Metrics[i]
+		if m.Metadata["sensor"] != sensor {
+			t.Errorf("Expected metric %d to have sensor %s, got %v", i, sensor, m.Metadata["sensor"])
+		}
+		if removed := m.Data["removable_field"] == nil; removed != (sensor == "a") {
+			t.Errorf("Metric %d with sensor %s has 'removable_field' '%v'", i, sensor, m.Data["removable_field"])
+		}
+	}
+}